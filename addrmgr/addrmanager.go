@@ -551,8 +551,8 @@ func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddress, erro
 		return nil, err
 	}
 
-	// Hard-coded stream 1 in here. In the future when we support streams,
-	// we will want to update this.
+	// The address is given stream 1. Callers that want a different stream
+	// should set it on the result.
 	return a.HostToNetAddress(host, uint16(port), 1, wire.SFNodeNetwork)
 }
 
@@ -723,6 +723,10 @@ func (a *AddrManager) HostToNetAddress(host string, port uint16, stream uint32,
 		}
 		prefix := []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
 		ip = net.IP(append(prefix, data...))
+	} else if strings.HasSuffix(host, ".onion") {
+		// Only version 2 onion addresses fit in the 16 bytes that the
+		// protocol allows for an ip address.
+		return nil, fmt.Errorf("unsupported onion address %s", host)
 	} else if ip = net.ParseIP(host); ip == nil {
 		ips, err := a.lookupFunc(host)
 		if err != nil {
@@ -988,6 +992,14 @@ func (a *AddrManager) AddLocalAddress(na *wire.NetAddress, priority AddressPrior
 	return nil
 }
 
+// RemoveLocalAddress removes na from the list of known local addresses.
+func (a *AddrManager) RemoveLocalAddress(na *wire.NetAddress) {
+	a.lamtx.Lock()
+	defer a.lamtx.Unlock()
+
+	delete(a.localAddresses, NetAddressKey(na))
+}
+
 // getReachabilityFrom returns the relative reachability of the provided local
 // address to the provided remote address.
 func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
@@ -1005,6 +1017,17 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 		return Unreachable
 	}
 
+	// Our onion address can be reached by anyone who uses tor, but any
+	// other reachable address is preferred for peers which are not
+	// themselves hidden services.
+	if IsOnionCatTor(localAddr) {
+		if IsOnionCatTor(remoteAddr) {
+			return Private
+		}
+
+		return Default
+	}
+
 	if IsOnionCatTor(remoteAddr) {
 		if IsOnionCatTor(localAddr) {
 			return Private
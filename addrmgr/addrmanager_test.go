@@ -484,6 +484,11 @@ func TestHostToNetAddress(t *testing.T) {
 			"89abcdefghijklmn.onion",
 			true,
 		},
+		{
+			// Version 3 onion addresses are too long to be represented.
+			"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion",
+			true,
+		},
 	}
 
 	for _, test := range tests {
@@ -688,7 +693,7 @@ func TestGetBestLocalAddress(t *testing.T) {
 			wire.NetAddress{IP: net.IPv4zero},
 			wire.NetAddress{IP: net.IPv4zero},
 			wire.NetAddress{IP: net.ParseIP("204.124.8.100")},
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")},
+			wire.NetAddress{IP: net.ParseIP("204.124.8.100")},
 		},
 		{
 			// Remote connection from private IPv4
@@ -706,15 +711,6 @@ func TestGetBestLocalAddress(t *testing.T) {
 			wire.NetAddress{IP: net.ParseIP("2001:470::1")},
 			wire.NetAddress{IP: net.ParseIP("2001:470::1")},
 		},
-		/* XXX
-		{
-			// Remote connection from Tor
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43::100")},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.ParseIP("204.124.8.100")},
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")},
-		},
-		*/
 	}
 
 	amgr := addrmgr.New("testgetbestlocaladdress", nil)
@@ -756,21 +752,48 @@ func TestGetBestLocalAddress(t *testing.T) {
 			continue
 		}
 	}
-	/*
-		// Add a tor generated IP address
-		localAddr = wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")}
-		amgr.AddLocalAddress(&localAddr, addrmgr.ManualPrio)
-
-		// Test against want3
-		for x, test := range tests {
-			got := amgr.GetBestLocalAddress(&test.remoteAddr)
-			if !test.want3.IP.Equal(got.IP) {
-				t.Errorf("TestGetBestLocalAddress test3 #%d failed for remote address %s: want %s got %s",
-					x, test.remoteAddr.IP, test.want3.IP, got.IP)
-				continue
-			}
+
+	// Add a tor generated IP address
+	onionAddr := wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")}
+	amgr.AddLocalAddress(&onionAddr, addrmgr.ManualPrio)
+
+	// Test against want3
+	for x, test := range tests {
+		got := amgr.GetBestLocalAddress(&test.remoteAddr)
+		if !test.want3.IP.Equal(got.IP) {
+			t.Errorf("TestGetBestLocalAddress test3 #%d failed for remote address %s: want %s got %s",
+				x, test.remoteAddr.IP, test.want3.IP, got.IP)
+			continue
 		}
-	*/
+	}
+
+	// Our onion address is advertised to remote connections from tor.
+	torAddr := wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43::100")}
+	got := amgr.GetBestLocalAddress(&torAddr)
+	if !onionAddr.IP.Equal(got.IP) {
+		t.Errorf("TestGetBestLocalAddress failed for remote address %s: want %s got %s",
+			torAddr.IP, onionAddr.IP, got.IP)
+	}
+
+	// When we only have an onion address, it is advertised to everyone
+	// who can reach it.
+	amgr = addrmgr.New("testgetbestlocaladdress", nil)
+	amgr.AddLocalAddress(&onionAddr, addrmgr.ManualPrio)
+	for _, remoteAddr := range []wire.NetAddress{torAddr, tests[0].remoteAddr, tests[2].remoteAddr} {
+		got := amgr.GetBestLocalAddress(&remoteAddr)
+		if !onionAddr.IP.Equal(got.IP) {
+			t.Errorf("TestGetBestLocalAddress failed for remote address %s: want %s got %s",
+				remoteAddr.IP, onionAddr.IP, got.IP)
+		}
+	}
+
+	// Once removed, it is no longer advertised.
+	amgr.RemoveLocalAddress(&onionAddr)
+	got = amgr.GetBestLocalAddress(&torAddr)
+	if !net.IPv4zero.Equal(got.IP) {
+		t.Errorf("TestGetBestLocalAddress failed for remote address %s after"+
+			" removing local address: want %s got %s", torAddr.IP, net.IPv4zero, got.IP)
+	}
 }
 
 func TestNetAddressKey(t *testing.T) {
@@ -823,7 +846,7 @@ func TestGetReachabilityFrom(t *testing.T) {
 		[]int{Unreachable, Unreachable, Ipv6Strong, Ipv6Weak, Ipv6Strong, Default},
 		[]int{Unreachable, Unreachable, Teredo, Teredo, Teredo, Default},
 		[]int{Unreachable, Unreachable, Ipv6Weak, Ipv6Weak, Ipv6Weak, Default},
-		[]int{Unreachable, Unreachable, Ipv6Strong, Ipv6Weak, Ipv6Strong, Private},
+		[]int{Unreachable, Default, Default, Default, Default, Private},
 	}
 
 	for i := 0; i < len(test_addresses); i++ {
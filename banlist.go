@@ -0,0 +1,99 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// banListFilename is the name of the file in the data directory in which
+	// the list of banned hosts is saved.
+	banListFilename = "bans.json"
+
+	// banListVersion is the version of the format of the ban list file.
+	banListVersion = 1
+)
+
+type serializedBan struct {
+	Host  string
+	Until int64
+}
+
+type serializedBanList struct {
+	Version int
+	Bans    []serializedBan
+}
+
+// loadBanList reads the list of banned hosts from the given file along with
+// the times at which their bans end. Bans which have already ended are
+// dropped. A missing file results in an empty list.
+func loadBanList(filePath string) (map[string]time.Time, error) {
+	banned := make(map[string]time.Time)
+
+	r, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return banned, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s error opening file: %v", filePath, err)
+	}
+	defer r.Close()
+
+	var sbl serializedBanList
+	err = json.NewDecoder(r).Decode(&sbl)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", filePath, err)
+	}
+
+	if sbl.Version != banListVersion {
+		return nil, fmt.Errorf("unknown version %v in serialized ban list",
+			sbl.Version)
+	}
+
+	now := time.Now()
+	for _, ban := range sbl.Bans {
+		banEnd := time.Unix(ban.Until, 0)
+		if now.Before(banEnd) {
+			banned[ban.Host] = banEnd
+		}
+	}
+
+	return banned, nil
+}
+
+// saveBanList writes the list of banned hosts to the given file. Bans which
+// have already ended are not saved.
+func saveBanList(filePath string, banned map[string]time.Time) error {
+	sbl := serializedBanList{
+		Version: banListVersion,
+		Bans:    make([]serializedBan, 0, len(banned)),
+	}
+
+	now := time.Now()
+	for host, banEnd := range banned {
+		if now.Before(banEnd) {
+			sbl.Bans = append(sbl.Bans, serializedBan{
+				Host:  host,
+				Until: banEnd.Unix(),
+			})
+		}
+	}
+
+	w, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %v", filePath, err)
+	}
+	defer w.Close()
+
+	err = json.NewEncoder(w).Encode(&sbl)
+	if err != nil {
+		return fmt.Errorf("failed to encode file %s: %v", filePath, err)
+	}
+	return nil
+}
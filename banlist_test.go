@@ -0,0 +1,63 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, banListFilename)
+
+	// A missing file is an empty list.
+	banned, err := loadBanList(file)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(banned) != 0 {
+		t.Errorf("expected empty ban list, got %v", banned)
+	}
+
+	until := time.Now().Add(time.Hour)
+	banned = map[string]time.Time{
+		"10.0.0.1": until,
+		"::1":      until,
+		"10.0.0.2": time.Now().Add(-time.Hour), // Already ended.
+	}
+	if err = saveBanList(file, banned); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	loaded, err := loadBanList(file)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Fatalf("expected 2 bans, got %v", loaded)
+	}
+	for _, host := range []string{"10.0.0.1", "::1"} {
+		if loaded[host].Unix() != until.Unix() {
+			t.Errorf("expected ban on %s until %v, got %v", host, until, loaded[host])
+		}
+	}
+
+	// A corrupt file is an error.
+	if err = ioutil.WriteFile(file, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadBanList(file); err == nil {
+		t.Error("expected error loading corrupt ban list")
+	}
+}
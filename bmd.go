@@ -9,6 +9,7 @@
 package main
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
@@ -18,6 +19,7 @@ import (
 	"runtime/pprof"
 
 	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmd/database/bdb"
 	_ "github.com/DanielKrawisz/bmd/database/memdb"
 	"github.com/DanielKrawisz/bmd/peer"
 	"github.com/DanielKrawisz/bmd/objmgr/stats"
@@ -96,6 +98,11 @@ func bmdMain() error {
 		}
 	}
 
+	// Report on the database upgrade and exit if requested.
+	if cfg.DbUpgradeDryRun {
+		return dbUpgradeDryRun()
+	}
+
 	// Load object database.
 	db, err := setupDB(cfg.DbType, cfg.objectDbPath(), dbStats)
 	if err != nil {
@@ -147,6 +154,38 @@ func main() {
 	}
 }
 
+// dbUpgradeDryRun prints the upgrades which would be made to the object
+// database without changing it.
+func dbUpgradeDryRun() error {
+	if cfg.DbType != "boltdb" {
+		fmt.Printf("Database type %s does not need to be upgraded.\n", cfg.DbType)
+		return nil
+	}
+
+	dbPath := cfg.objectDbPath()
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		fmt.Printf("No database at %s.\n", dbPath)
+		return nil
+	}
+
+	steps, err := bdb.DryRunUpgrade(dbPath)
+	if err != nil {
+		dbLog.Errorf("Database upgrade would fail: %v", err)
+		return err
+	}
+
+	if len(steps) == 0 {
+		fmt.Println("The database is up to date.")
+		return nil
+	}
+
+	fmt.Println("The following upgrades would be made to the database:")
+	for _, step := range steps {
+		fmt.Println("  " + step)
+	}
+	return nil
+}
+
 // setupDB loads (or creates when needed) the object database taking into
 // account the selected database backend.
 func setupDB(dbType, dbPath string, dbStats database.Stats) (*database.Db, error) {
@@ -0,0 +1,141 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// bmdb dumps the objects in a bmd object database to a file and loads them
+// from such a file into another database. This can be used to seed a new node
+// without waiting for it to sync with the network, or to move objects from
+// one database backend to another.
+//
+// Usage:
+//
+//	bmdb [options] dump <file>
+//	bmdb [options] load <file>
+//
+// bmd must not be running while its database is being used.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/DanielKrawisz/bmd/database"
+	_ "github.com/DanielKrawisz/bmd/database/bdb"
+	_ "github.com/DanielKrawisz/bmd/database/sqlite"
+	"github.com/btcsuite/btcutil"
+)
+
+var (
+	defaultDataDir = btcutil.AppDataDir("bmd", false)
+
+	dataDir = flag.String("datadir", defaultDataDir, "Directory of the bmd data")
+	dbType  = flag.String("dbtype", "boltdb", "Database backend to use {boltdb, sqlite}")
+	dbPath  = flag.String("db", "", "Path to the object database (default: the one bmd uses in the data directory)")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] {dump|load} <file>\n\n",
+		filepath.Base(os.Args[0]))
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  dump  Write all unexpired objects in the database to the file")
+	fmt.Fprintln(os.Stderr, "  load  Insert the objects in the file into the database, checking")
+	fmt.Fprintln(os.Stderr, "        their proof of work and expiration")
+	fmt.Fprintln(os.Stderr, "\nOptions:")
+	flag.PrintDefaults()
+}
+
+// dump writes the objects in the database to the file at path.
+func dump(db *database.Db, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	n, err := database.DumpObjects(db, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %d objects to %s.\n", n, path)
+	return nil
+}
+
+// load inserts the objects in the file at path into the database.
+func load(db *database.Db, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stats, err := database.LoadObjects(db, bufio.NewReader(f))
+	if stats != nil {
+		fmt.Printf("Inserted %d objects. Skipped %d already in the database, "+
+			"%d expired, and %d with insufficient proof of work.\n",
+			stats.Inserted, stats.Duplicate, stats.Expired, stats.InvalidPow)
+	}
+	return err
+}
+
+func realMain() error {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		usage()
+		return fmt.Errorf("expected a command and a file")
+	}
+
+	var command func(*database.Db, string) error
+	switch flag.Arg(0) {
+	case "dump":
+		command = dump
+	case "load":
+		command = load
+	default:
+		usage()
+		return fmt.Errorf("unknown command %s", flag.Arg(0))
+	}
+
+	path := *dbPath
+	if path == "" {
+		path = filepath.Join(*dataDir, "objects_"+*dbType)
+		if *dbType == "sqlite" {
+			path += ".db"
+		}
+	}
+
+	// Don't create an empty database when there is nothing to dump.
+	if flag.Arg(0) == "dump" {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+
+	db, err := database.OpenDB(*dbType, path)
+	if err != nil {
+		return fmt.Errorf("failed to open database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	return command(db, flag.Arg(1))
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// rpccert creates a certificate authority for the clients of bmd's RPC server
+// and issues client certificates signed by it. bmd requires clients to give
+// such a certificate when the authority's certificate is given with
+// --rpcclientca. The common name of a client certificate is the name of the
+// RPC user the client is authenticated as, so the user must also be given to
+// bmd, with - in place of a password if it is only to use the certificate.
+//
+// Usage:
+//
+//	rpccert [options] ca
+//	rpccert [options] client <name>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/rpc"
+	"github.com/btcsuite/btcutil"
+)
+
+var (
+	defaultDataDir = btcutil.AppDataDir("bmd", false)
+
+	dataDir  = flag.String("datadir", defaultDataDir, "Directory of the bmd data")
+	caCert   = flag.String("cacert", "", "Certificate of the authority (default: rpcca.cert in the data directory)")
+	caKey    = flag.String("cakey", "", "Key of the authority (default: rpcca.key in the data directory)")
+	outDir   = flag.String("out", ".", "Directory in which to write client certificates and keys")
+	validFor = flag.Duration("validfor", 365*24*time.Hour, "How long the certificate is valid")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] {ca|client <name>}\n\n",
+		filepath.Base(os.Args[0]))
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  ca             Create the certificate authority for RPC clients")
+	fmt.Fprintln(os.Stderr, "  client <name>  Issue a certificate for the RPC user with the name,")
+	fmt.Fprintln(os.Stderr, "                 written to <name>.cert and <name>.key")
+	fmt.Fprintln(os.Stderr, "\nOptions:")
+	flag.PrintDefaults()
+}
+
+// writePair writes a certificate and its key to the given files, neither of
+// which may exist already.
+func writePair(certFile, keyFile string, cert, key []byte) error {
+	if rpc.FileExists(certFile) || rpc.FileExists(keyFile) {
+		return fmt.Errorf("%s or %s already exists", certFile, keyFile)
+	}
+
+	if err := ioutil.WriteFile(certFile, cert, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		os.Remove(certFile)
+		return err
+	}
+
+	fmt.Printf("Wrote %s and %s.\n", certFile, keyFile)
+	return nil
+}
+
+func realMain() error {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *caCert == "" {
+		*caCert = filepath.Join(*dataDir, "rpcca.cert")
+	}
+	if *caKey == "" {
+		*caKey = filepath.Join(*dataDir, "rpcca.key")
+	}
+	validUntil := time.Now().Add(*validFor)
+
+	switch {
+	case flag.NArg() == 1 && flag.Arg(0) == "ca":
+		cert, key, err := rpc.NewCA("bmd RPC client authority", validUntil)
+		if err != nil {
+			return err
+		}
+		return writePair(*caCert, *caKey, cert, key)
+
+	case flag.NArg() == 2 && flag.Arg(0) == "client":
+		name := flag.Arg(1)
+
+		// The name is used for the names of the files written, so it must
+		// not lead outside of the output directory.
+		if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+			return fmt.Errorf("invalid client name %q", name)
+		}
+
+		ca, err := ioutil.ReadFile(*caCert)
+		if err != nil {
+			return err
+		}
+		signer, err := ioutil.ReadFile(*caKey)
+		if err != nil {
+			return err
+		}
+
+		cert, key, err := rpc.NewClientCert(ca, signer, name, validUntil)
+		if err != nil {
+			return err
+		}
+		return writePair(filepath.Join(*outDir, name+".cert"),
+			filepath.Join(*outDir, name+".key"), cert, key)
+	}
+
+	usage()
+	return fmt.Errorf("expected ca or client <name>")
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
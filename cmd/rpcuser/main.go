@@ -0,0 +1,89 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// rpcuser creates an entry for an RPC user of bmd, with the password salted
+// and hashed, which may be given to bmd with --rpcuserentry or added as a line
+// to the file given with --rpcusersfile. The password is read from the first
+// line of standard input, unless the user is to authenticate only with a
+// client certificate issued by rpccert.
+//
+// Usage:
+//
+//	rpcuser [options] <name>
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DanielKrawisz/bmd/rpc"
+)
+
+var (
+	permissions = flag.String("perm", "read", "Comma separated list of methods and groups of methods {admin, send, read, pow, peers} the user may call")
+	noPassword  = flag.Bool("nopass", false, "Create a user without a password, who can only authenticate with a client certificate")
+	sendLimit   = flag.Uint("sendlimit", 0, "Number of objects per minute the user may send (default: no limit)")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] <name>\n\n",
+		filepath.Base(os.Args[0]))
+	fmt.Fprintln(os.Stderr, "The password is read from the first line of standard input unless -nopass is given.")
+	fmt.Fprintln(os.Stderr, "\nOptions:")
+	flag.PrintDefaults()
+}
+
+func realMain() error {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		return fmt.Errorf("expected a user name")
+	}
+	name := flag.Arg(0)
+	if name == "" || strings.Contains(name, ":") {
+		return fmt.Errorf("user name must not be empty or contain ':'")
+	}
+
+	hash := rpc.NoPassword
+	if !*noPassword {
+		pass, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && pass == "" {
+			return fmt.Errorf("failed to read password: %v", err)
+		}
+		pass = strings.TrimRight(pass, "\r\n")
+		if pass == "" {
+			return fmt.Errorf("password must not be empty")
+		}
+
+		if hash, err = rpc.HashPassword(pass); err != nil {
+			return err
+		}
+	}
+
+	entry := fmt.Sprintf("%s:%s:%s", name, hash, *permissions)
+	if *sendLimit > 0 {
+		entry += fmt.Sprintf(":%d", *sendLimit)
+	}
+
+	// Make sure that bmd will accept the entry.
+	if _, err := rpc.ParseUser(entry); err != nil {
+		return err
+	}
+
+	fmt.Println(entry)
+	return nil
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -22,28 +23,42 @@ import (
 	"github.com/DanielKrawisz/bmd/database"
 	_ "github.com/DanielKrawisz/bmd/database/bdb"
 	_ "github.com/DanielKrawisz/bmd/database/memdb"
+	_ "github.com/DanielKrawisz/bmd/database/sqlite"
+	"github.com/DanielKrawisz/bmd/objmgr"
 	"github.com/DanielKrawisz/bmd/rpc"
+	"github.com/DanielKrawisz/bmutil/pow"
+	"github.com/DanielKrawisz/bmutil/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/go-socks/socks"
 	flags "github.com/jessevdk/go-flags"
 )
 
 const (
-	defaultConfigFilename  = "bmd.conf"
-	defaultLogLevel        = "info"
-	defaultLogDirname      = "logs"
-	defaultLogFilename     = "bmd.log"
-	defaultMaxPeers        = 125
-	defaultBanDuration     = time.Hour * 24
-	defaultMaxRPCClients   = 25
-	defaultDbType          = "boltdb"
-	defaultPort            = 8443
-	defaultRPCPort         = 8442
-	defaultMaxUpPerPeer    = 2 * 1024 * 1024 // 2MBps
-	defaultMaxDownPerPeer  = 2 * 1024 * 1024 // 2MBps
-	defaultMaxOutbound     = 10
-	defaultRequestTimeout  = time.Minute * 3
-	defaultCleanupInterval = time.Hour
+	defaultConfigFilename = "bmd.conf"
+	defaultLogLevel       = "info"
+	defaultLogDirname     = "logs"
+	defaultLogFilename    = "bmd.log"
+	defaultMaxPeers       = 125
+	defaultBanDuration    = time.Hour * 24
+	defaultBanThreshold   = 100
+	defaultMaxRPCClients  = 25
+	defaultDbType         = "boltdb"
+	defaultPort           = 8443
+	defaultRPCPort        = 8442
+	defaultMaxUpPerPeer   = 2 * 1024 * 1024 // 2MBps
+	defaultMaxDownPerPeer = 2 * 1024 * 1024 // 2MBps
+	defaultMaxOutbound    = 10
+	defaultRequestTimeout = time.Minute * 3
+	defaultStream         = 1
+	defaultMaxObjectSize  = 1 << 18 // 256KB, the largest object allowed by the protocol
+	defaultMaxObjectTTL   = time.Hour * (28*24 + 3)
+	defaultStreamBuffer   = 1000
+	defaultLaggards       = laggardsDrop
+	defaultPowWorkers     = 1
+	defaultPowQueue       = 100
+	defaultOutboxConfirms = 2
+	defaultOutboxAnnounce = 10 * time.Minute
+	outboxFilename        = "outbox.json"
 )
 
 var (
@@ -138,10 +153,17 @@ type Config struct {
 	RPCPass         string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
 	RPCLimitUser    string        `long:"rpclimituser" description:"Username for limited RPC connections"`
 	RPCLimitPass    string        `long:"rpclimitpass" default-mask:"-" description:"Password for limited RPC connections"`
+	RPCUserEntries  []string      `long:"rpcuserentry" description:"Add an RPC user given as name:hash:permissions[:sendlimit] -- Permissions are a comma separated list of methods and the groups admin, send, read, pow and peers, and sendlimit is the number of objects per minute the user may send. Use rpcuser to create an entry"`
+	RPCUsersFile    string        `long:"rpcusersfile" description:"File of RPC users with one entry per line, given as for --rpcuserentry"`
 	RPCListeners    []string      `long:"rpclisten" description:"Add an interface/port to listen for RPC connections (default port: 8442)"`
 	RPCCert         string        `long:"rpccert" description:"File containing the certificate file"`
 	RPCKey          string        `long:"rpckey" description:"File containing the certificate key"`
+	RPCClientCA     string        `long:"rpcclientca" description:"File containing the certificate authority which must have signed the certificates of RPC clients -- The common name of a client's certificate is taken as its RPC user. Use rpccert to create the authority and issue client certificates"`
 	RPCMaxClients   int           `long:"rpcmaxclients" description:"Max number of RPC clients"`
+	RPCStreamBuffer int           `long:"rpcstreambuffer" description:"Number of objects buffered for each stream of objects to an RPC client"`
+	RPCLaggards     string        `long:"rpclaggards" description:"What to do with an RPC client which falls behind a stream of objects: {drop, disconnect} -- drop frees the objects buffered for the client, which are sent again once it catches up; disconnect closes the stream, and the client may resume it from the counter of the last object it received"`
+	PowWorkers      int           `long:"powworkers" description:"Number of objects whose proof of work may be done at once for RPC clients with DoPow -- 0 disables DoPow"`
+	PowQueue        int           `long:"powqueue" description:"Number of objects which may wait for their proof of work to be done for RPC clients"`
 	EnableRPC       bool          `long:"rpc" description:"Enable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass or rpclimituser/rpclimitpass is specified"`
 	DisableTLS      bool          `long:"notls" description:"Disable TLS for the RPC server -- NOTE: This is only allowed if the RPC server is bound to localhost"`
 	DisableDNSSeed  bool          `long:"nodnsseed" description:"Disable DNS seeding for peers"`
@@ -154,18 +176,39 @@ type Config struct {
 	OnionProxyPass  string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
 	NoOnion         bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
 	TorIsolation    bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection."`
-	DbType          string        `long:"dbtype" description:"Database backend to use. Options: {memdb (for testing), boltdb}"`
+	TorControl      string        `long:"torcontrol" description:"Create a hidden service for our listener through the Tor control port and advertise it to peers (eg. 127.0.0.1:9051)"`
+	TorControlPass  string        `long:"torcontrolpass" default-mask:"-" description:"Password for the Tor control port -- if not given, cookie authentication is used"`
+	ExternalOnion   string        `long:"externalonion" description:"Tor hidden service address which forwards to our listener, to advertise to peers (eg. abcdefghijklmnop.onion)"`
+	DbType          string        `long:"dbtype" description:"Database backend to use. Options: {memdb (for testing), boltdb, sqlite}"`
+	DbUpgradeDryRun bool          `long:"dbupgrade-dryrun" description:"Show which upgrades would be made to the database and exit without changing it"`
 	Profile         string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	CPUProfile      string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
 	DebugLevel      string        `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
-	Upnp            bool          `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
+	Upnp            bool          `long:"upnp" description:"Use UPnP, PCP or NAT-PMP to map our listening port outside of NAT"`
 	MaxUpPerPeer    Filesize      `long:"maxupload" description:"Maximum upload rate for any peer. Valid units are {B, K, M, G} bytes/sec"`
 	MaxDownPerPeer  Filesize      `long:"maxdownload" description:"Maximum download rate for any peer. Valid units are {B, K, M, G} bytes/sec"`
+	MaxUpTotal      Filesize      `long:"maxtotalupload" description:"Maximum upload rate for all peers together. Valid units are {B, K, M, G} bytes/sec (default: no limit)"`
+	MaxDownTotal    Filesize      `long:"maxtotaldownload" description:"Maximum download rate for all peers together. Valid units are {B, K, M, G} bytes/sec (default: no limit)"`
+	UploadQuota     Filesize      `long:"uploadquota" description:"Maximum number of bytes to upload to peers each calendar month (UTC). Once it is nearly used up, objects are no longer sent to inbound peers. Valid units are {B, K, M, G} bytes (default: no limit)"`
 	MaxOutbound     int           `long:"maxoutbound" description:"The maximum number of outbound peers that bmd will try to maintain"`
 	RequestExpire   time.Duration `long:"requestexpire" description:"Duration after which an object request to a peer must expire. Valid time units are {s, m, h}. Minimum 10 seconds"`
-	CleanupInterval time.Duration `long:"cleanupinterval" description:"Time interval after which expired objects are removed. Valid time units are {s, m, h}. Minimum 20 minutes"`
+	VerifyWorkers   int           `long:"verifyworkers" description:"Number of objects received from peers whose proof of work may be checked at once (default: number of CPUs)"`
+	Streams         []uint32      `long:"stream" description:"Add a stream to participate in. The first stream given is used for the addresses we advertise (default: 1)"`
+	DisableBanning  bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
+	BanDuration     time.Duration `long:"banduration" description:"How long to ban misbehaving peers. Valid time units are {s, m, h}. Minimum 1 second"`
+	BanThreshold    uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
+	MaxObjectSize   Filesize      `long:"maxobjectsize" description:"Largest object to accept. Valid units are {B, K, M, G} bytes"`
+	MaxObjectTTL    time.Duration `long:"maxobjectttl" description:"Furthest in the future an accepted object may expire. Valid time units are {s, m, h}"`
+	AcceptTypes     []uint32      `long:"acceptobjecttype" description:"Add an object type to accept. If given, only objects of these types are accepted (default: all)"`
+	RejectTypes     []uint32      `long:"rejectobjecttype" description:"Add an object type to reject"`
+	LocalTrials     uint64        `long:"localnoncetrials" description:"Nonce trials per byte required of objects submitted over RPC. May not be less than the network default (default: network default)"`
+	LocalExtraBytes uint64        `long:"localextrabytes" description:"Extra bytes required of objects submitted over RPC. May not be less than the network default (default: network default)"`
+	OutboxConfirms  int           `long:"outboxconfirmations" description:"Number of peers which must request an object submitted over RPC from bmd, or advertise it to bmd, before bmd stops announcing it"`
+	OutboxAnnounce  time.Duration `long:"outboxreannounce" description:"How often an object submitted over RPC is announced again until enough peers have requested or advertised it. Valid time units are {s, m, h}"`
+	MetricsListen   string        `long:"metricslisten" description:"Serve Prometheus metrics over HTTP on the given interface/port (eg. 127.0.0.1:8446)"`
 	onionlookup     func(string) ([]net.IP, error)
 	lookup          func(string) ([]net.IP, error)
+	rpcUsers        []*rpc.User
 	oniondial       func(string, string) (net.Conn, error)
 	dial            func(string, string) (net.Conn, error)
 	dnsSeeds        []string
@@ -174,6 +217,10 @@ type Config struct {
 	ObjectStats   bool `long:"objectstats" hidden:"true"`
 	UpToDateTimer bool `long:"uptodatetimer" hidden:"true"`
 	DeleteDb      bool `long:"deletedb" hidden:"true"`
+
+	// Deprecated options which are still accepted so that old config files
+	// can be read, but which no longer do anything.
+	CleanupInterval time.Duration `long:"cleanupinterval" hidden:"true"`
 }
 
 // RPCConfig returns an rpc.Config type constructed from the Config.
@@ -182,15 +229,73 @@ func (cfg *Config) RPCConfig() *rpc.Config {
 		DisableTLS: cfg.DisableTLS,
 		Key:        cfg.RPCKey,
 		Cert:       cfg.RPCCert,
+		ClientCA:   cfg.RPCClientCA,
 		User:       cfg.RPCUser,
 		Pass:       cfg.RPCPass,
 		LimitUser:  cfg.RPCLimitUser,
 		LimitPass:  cfg.RPCLimitPass,
+		Users:      cfg.rpcUsers,
 		MaxClients: uint32(cfg.RPCMaxClients),
 		Listeners:  cfg.RPCListeners,
 	}
 }
 
+// PolicyConfig returns an objmgr.PolicyConfig constructed from the Config.
+func (cfg *Config) PolicyConfig() *objmgr.PolicyConfig {
+	pc := &objmgr.PolicyConfig{
+		Streams: cfg.Streams,
+		MaxSize: int(cfg.MaxObjectSize),
+		MaxTTL:  cfg.MaxObjectTTL,
+	}
+
+	for _, t := range cfg.AcceptTypes {
+		pc.AllowTypes = append(pc.AllowTypes, wire.ObjectType(t))
+	}
+	for _, t := range cfg.RejectTypes {
+		pc.DenyTypes = append(pc.DenyTypes, wire.ObjectType(t))
+	}
+
+	if cfg.LocalTrials != 0 || cfg.LocalExtraBytes != 0 {
+		localPow := cfg.localPow()
+		pc.LocalPow = &localPow
+	}
+
+	return pc
+}
+
+// OutboxConfig returns an objmgr.OutboxConfig constructed from the Config.
+func (cfg *Config) OutboxConfig() *objmgr.OutboxConfig {
+	return &objmgr.OutboxConfig{
+		File:          filepath.Join(cfg.DataDir, outboxFilename),
+		Confirmations: cfg.OutboxConfirms,
+		Reannounce:    cfg.OutboxAnnounce,
+	}
+}
+
+// localPow returns the proof of work required of objects submitted over RPC.
+func (cfg *Config) localPow() pow.Data {
+	localPow := pow.Default
+	if cfg.LocalTrials != 0 {
+		localPow.NonceTrialsPerByte = cfg.LocalTrials
+	}
+	if cfg.LocalExtraBytes != 0 {
+		localPow.ExtraBytes = cfg.LocalExtraBytes
+	}
+	return localPow
+}
+
+// torOnly returns whether all outgoing connections are made through tor. A
+// proxy is assumed to be tor unless --noonion is given.
+func (cfg *Config) torOnly() bool {
+	return cfg.Proxy != "" && !cfg.NoOnion
+}
+
+// onionReachable returns whether we are able to connect to tor hidden
+// services.
+func (cfg *Config) onionReachable() bool {
+	return !cfg.NoOnion && (cfg.Proxy != "" || cfg.OnionProxy != "")
+}
+
 // objectDbPath returns the path to the object database given a database type.
 func (cfg *Config) objectDbPath() string {
 	// The database name is based on the database type.
@@ -279,15 +384,97 @@ func (cfg *Config) Validate(appName string) error {
 		return err
 	}
 
-	// Don't allow cleanup times that are too short.
-	if cfg.CleanupInterval < time.Duration(20*time.Minute) {
-		str := "%s: The cleanupinterval option may not be less than 20m -- parsed [%v]"
-		err := fmt.Errorf(str, funcName, cfg.RequestExpire)
+	// Expired objects are now removed as they expire.
+	if cfg.CleanupInterval != 0 {
+		bmdLog.Warn("The cleanupinterval option is deprecated and ignored " +
+			"-- expired objects are removed as soon as they expire")
+	}
+
+	// Don't allow ban durations that are too short.
+	if cfg.BanDuration < time.Second {
+		str := "%s: The banduration option may not be less than 1s -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.BanDuration)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	// Objects have to fit in a message.
+	if cfg.MaxObjectSize <= 0 {
+		str := "%s: The maxobjectsize option must be positive -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.MaxObjectSize)
 		fmt.Fprintln(os.Stderr, err)
 		fmt.Fprintln(os.Stderr, usageMessage)
 		return err
 	}
 
+	if cfg.MaxObjectTTL < 0 {
+		str := "%s: The maxobjectttl option may not be negative -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.MaxObjectTTL)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	// Objects which require less than the network default would not be
+	// relayed by peers.
+	if cfg.LocalTrials != 0 && cfg.LocalTrials < pow.Default.NonceTrialsPerByte {
+		str := "%s: The localnoncetrials option may not be less than %d -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, pow.Default.NonceTrialsPerByte, cfg.LocalTrials)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.LocalExtraBytes != 0 && cfg.LocalExtraBytes < pow.Default.ExtraBytes {
+		str := "%s: The localextrabytes option may not be less than %d -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, pow.Default.ExtraBytes, cfg.LocalExtraBytes)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.OutboxConfirms < 1 {
+		str := "%s: The outboxconfirmations option may not be less than 1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.OutboxConfirms)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.OutboxAnnounce < time.Minute {
+		str := "%s: The outboxreannounce option may not be less than 1m -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.OutboxAnnounce)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	// Participate in stream 1 unless told otherwise.
+	if len(cfg.Streams) == 0 {
+		cfg.Streams = []uint32{defaultStream}
+	}
+
+	// Stream numbers start at 1 and may not be repeated.
+	seenStreams := make(map[uint32]struct{}, len(cfg.Streams))
+	for _, stream := range cfg.Streams {
+		if stream == 0 {
+			str := "%s: The stream option may not be 0"
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return err
+		}
+		if _, ok := seenStreams[stream]; ok {
+			str := "%s: Stream %d was specified more than once"
+			err := fmt.Errorf(str, funcName, stream)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return err
+		}
+		seenStreams[stream] = struct{}{}
+	}
+
 	// --addPeer and --connect do not mix.
 	if len(cfg.AddPeers) > 0 && len(cfg.ConnectPeers) > 0 {
 		str := "%s: the --addpeer and --connect options can not be " +
@@ -301,10 +488,17 @@ func (cfg *Config) Validate(appName string) error {
 	// Attach the default initial nodes.
 	cfg.AddPeers = append(cfg.AddPeers, defaultInitialNodes...)
 
-	// --proxy or --connect without --listen disables listening.
+	// --proxy or --connect without --listen disables listening, unless we
+	// have a hidden service, in which case we only listen for tor locally.
 	if (cfg.Proxy != "" || len(cfg.ConnectPeers) > 0) &&
 		len(cfg.Listeners) == 0 {
-		cfg.DisableListen = true
+		if cfg.ExternalOnion != "" || cfg.TorControl != "" {
+			cfg.Listeners = []string{
+				net.JoinHostPort("127.0.0.1", strconv.Itoa(defaultPort)),
+			}
+		} else {
+			cfg.DisableListen = true
+		}
 	}
 
 	// Connect means no DNS seeding.
@@ -341,9 +535,32 @@ func (cfg *Config) Validate(appName string) error {
 		return err
 	}
 
+	// Parse the RPC users given with their permissions.
+	cfg.rpcUsers = nil
+	for _, entry := range cfg.RPCUserEntries {
+		user, err := rpc.ParseUser(entry)
+		if err != nil {
+			err = fmt.Errorf("%s: invalid --rpcuserentry: %v", funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return err
+		}
+		cfg.rpcUsers = append(cfg.rpcUsers, user)
+	}
+	if cfg.RPCUsersFile != "" {
+		users, err := rpc.ReadUsers(cleanAndExpandPath(cfg.RPCUsersFile))
+		if err != nil {
+			err = fmt.Errorf("%s: failed to read RPC users: %v", funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+		cfg.rpcUsers = append(cfg.rpcUsers, users...)
+	}
+
 	// The RPC server is disabled if no username or password is provided.
 	if (cfg.RPCUser == "" || cfg.RPCPass == "") &&
-		(cfg.RPCLimitUser == "" || cfg.RPCLimitPass == "") && cfg.EnableRPC {
+		(cfg.RPCLimitUser == "" || cfg.RPCLimitPass == "") &&
+		len(cfg.rpcUsers) == 0 && cfg.EnableRPC {
 		return errors.New("Must enable rpc in order to set username and password.")
 	}
 
@@ -368,6 +585,71 @@ func (cfg *Config) Validate(appName string) error {
 	// duplicate addresses.
 	cfg.RPCListeners = normalizeAddresses(cfg.RPCListeners, defaultRPCPort)
 
+	if cfg.RPCStreamBuffer < 1 {
+		str := "%s: The rpcstreambuffer option may not be less than 1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.RPCStreamBuffer)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.RPCLaggards != laggardsDrop && cfg.RPCLaggards != laggardsDisconnect {
+		str := "%s: The rpclaggards option must be %s or %s -- parsed [%s]"
+		err := fmt.Errorf(str, funcName, laggardsDrop, laggardsDisconnect,
+			cfg.RPCLaggards)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.MaxUpTotal < 0 {
+		str := "%s: The maxtotalupload option may not be less than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxUpTotal)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.MaxDownTotal < 0 {
+		str := "%s: The maxtotaldownload option may not be less than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxDownTotal)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.UploadQuota < 0 {
+		str := "%s: The uploadquota option may not be less than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.UploadQuota)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.VerifyWorkers < 1 {
+		str := "%s: The verifyworkers option may not be less than 1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.VerifyWorkers)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.PowWorkers < 0 {
+		str := "%s: The powworkers option may not be less than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.PowWorkers)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
+	if cfg.PowQueue < 1 {
+		str := "%s: The powqueue option may not be less than 1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.PowQueue)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return err
+	}
+
 	// Only allow TLS to be disabled if the RPC is bound to localhost
 	// addresses.
 	if cfg.EnableRPC && cfg.DisableTLS {
@@ -398,11 +680,60 @@ func (cfg *Config) Validate(appName string) error {
 		}
 	}
 
+	// Client certificates can only be required over TLS.
+	if cfg.RPCClientCA != "" {
+		if cfg.DisableTLS {
+			str := "%s: the --rpcclientca option may not be used " +
+				"with --notls"
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return err
+		}
+		cfg.RPCClientCA = cleanAndExpandPath(cfg.RPCClientCA)
+	}
+
 	// Add default port to all added peer addresses if needed and remove
 	// duplicate addresses.
 	cfg.AddPeers = normalizeAddresses(cfg.AddPeers, defaultPort)
 	cfg.ConnectPeers = normalizeAddresses(cfg.ConnectPeers, defaultPort)
 
+	// The hidden service address must be a version 2 onion address, which is
+	// the only kind that can be sent to peers.
+	if cfg.ExternalOnion != "" {
+		cfg.ExternalOnion = normalizeAddress(cfg.ExternalOnion, defaultPort)
+		host, _, err := net.SplitHostPort(cfg.ExternalOnion)
+		if err == nil && (len(host) != 22 || !strings.HasSuffix(host, ".onion")) {
+			err = errors.New("not a version 2 onion address")
+		}
+		if err == nil && cfg.DisableListen {
+			err = errors.New("cannot advertise a hidden service with --nolisten")
+		}
+		if err != nil {
+			str := "%s: External onion address '%s' is invalid: %v"
+			err := fmt.Errorf(str, funcName, cfg.ExternalOnion, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return err
+		}
+	}
+
+	// The tor control port is only used to create a hidden service for
+	// our listener.
+	if cfg.TorControl != "" {
+		_, _, err := net.SplitHostPort(cfg.TorControl)
+		if err == nil && cfg.DisableListen {
+			err = errors.New("cannot create a hidden service with --nolisten")
+		}
+		if err != nil {
+			str := "%s: Tor control address '%s' is invalid: %v"
+			err := fmt.Errorf(str, funcName, cfg.TorControl, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return err
+		}
+	}
+
 	// Tor stream isolation requires either proxy or onion proxy to be set.
 	if cfg.TorIsolation && cfg.Proxy == "" && cfg.OnionProxy == "" {
 		str := "%s: Tor stream isolation requires either proxy or " +
@@ -715,8 +1046,18 @@ func DefaultConfig() *Config {
 		MaxUpPerPeer:    defaultMaxUpPerPeer,
 		MaxOutbound:     defaultMaxOutbound,
 		RequestExpire:   defaultRequestTimeout,
+		VerifyWorkers:   runtime.NumCPU(),
 		dnsSeeds:        defaultDNSSeeds,
-		CleanupInterval: defaultCleanupInterval,
+		BanDuration:     defaultBanDuration,
+		BanThreshold:    defaultBanThreshold,
+		MaxObjectSize:   defaultMaxObjectSize,
+		MaxObjectTTL:    defaultMaxObjectTTL,
+		OutboxConfirms:  defaultOutboxConfirms,
+		OutboxAnnounce:  defaultOutboxAnnounce,
+		RPCStreamBuffer: defaultStreamBuffer,
+		RPCLaggards:     defaultLaggards,
+		PowWorkers:      defaultPowWorkers,
+		PowQueue:        defaultPowQueue,
 	}
 }
 
@@ -9,8 +9,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/pow"
 )
 
 func setup(dataDir string, defaultConfigContents, configFileContents, configFilename *string) error {
@@ -126,3 +130,136 @@ func TestLoadConfig(t *testing.T) {
 	testConfig(t, 5, q, &q, &z, nil, nil)
 	testConfig(t, 6, q, &q, nil, &z, &file)
 }
+
+func TestValidateStreams(t *testing.T) {
+	tests := []struct {
+		streams  []uint32
+		expected []uint32
+		err      bool
+	}{
+		{nil, []uint32{1}, false},
+		{[]uint32{2}, []uint32{2}, false},
+		{[]uint32{1, 2}, []uint32{1, 2}, false},
+		{[]uint32{0}, nil, true},
+		{[]uint32{1, 2, 1}, nil, true},
+	}
+
+	for i, test := range tests {
+		Config := DefaultConfig()
+		defer resetCfg(Config)()
+
+		// resetCfg has already validated the config once, so we start
+		// over with the streams we want to test.
+		Config.Streams = test.streams
+		err := Config.Validate("test")
+		if test.err {
+			if err == nil {
+				t.Errorf("Error, test id %d: expected error for streams %v.", i, test.streams)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error, test id %d: unexpected error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(Config.Streams, test.expected) {
+			t.Errorf("Error, test id %d: expected streams %v got %v.", i, test.expected, Config.Streams)
+		}
+	}
+}
+
+func TestValidateExternalOnion(t *testing.T) {
+	tests := []struct {
+		onion     string
+		proxy     string
+		expected  string
+		listeners []string
+		err       bool
+	}{
+		{"abcdefghijklmnop.onion", "", "abcdefghijklmnop.onion:8443",
+			[]string{":8443"}, false},
+		{"abcdefghijklmnop.onion:8444", "", "abcdefghijklmnop.onion:8444",
+			[]string{":8443"}, false},
+		{"abcdefghijklmnop.onion", "127.0.0.1:9050", "abcdefghijklmnop.onion:8443",
+			[]string{"127.0.0.1:8443"}, false},
+		{"1.2.3.4", "", "", nil, true},
+		{"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", "", "", nil, true},
+	}
+
+	for i, test := range tests {
+		Config := DefaultConfig()
+		defer resetCfg(Config)()
+
+		Config.Listeners = nil
+		Config.ExternalOnion = test.onion
+		Config.Proxy = test.proxy
+		err := Config.Validate("test")
+		if test.err {
+			if err == nil {
+				t.Errorf("Error, test id %d: expected error for onion %s.", i, test.onion)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error, test id %d: unexpected error %v", i, err)
+			continue
+		}
+		if Config.ExternalOnion != test.expected {
+			t.Errorf("Error, test id %d: expected onion %s got %s.", i, test.expected, Config.ExternalOnion)
+		}
+		if Config.DisableListen || !reflect.DeepEqual(Config.Listeners, test.listeners) {
+			t.Errorf("Error, test id %d: expected listeners %v got %v.", i, test.listeners, Config.Listeners)
+		}
+	}
+}
+
+func TestValidateLocalPow(t *testing.T) {
+	trials, extra := pow.Default.NonceTrialsPerByte, pow.Default.ExtraBytes
+	tests := []struct {
+		trials uint64
+		extra  uint64
+		err    bool
+	}{
+		{0, 0, false},
+		{trials, extra, false},
+		{2 * trials, 0, false},
+		{0, 2 * extra, false},
+		{trials - 1, 0, true},
+		{0, extra - 1, true},
+	}
+
+	for i, test := range tests {
+		Config := DefaultConfig()
+		defer resetCfg(Config)()
+
+		Config.LocalTrials = test.trials
+		Config.LocalExtraBytes = test.extra
+		err := Config.Validate("test")
+		if test.err && err == nil {
+			t.Errorf("Error, test id %d: expected error for pow %d, %d.", i, test.trials, test.extra)
+		}
+		if !test.err && err != nil {
+			t.Errorf("Error, test id %d: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestDeprecatedCleanupInterval(t *testing.T) {
+	Config := DefaultConfig()
+	defer resetCfg(Config)()
+
+	// An old config file which still sets the option can be read.
+	contents := "cleanupinterval=1h"
+	err := setup(Config.DataDir, &contents, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadConfig("test", Config, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if Config.CleanupInterval != time.Hour {
+		t.Errorf("expected cleanupinterval 1h, got %v", Config.CleanupInterval)
+	}
+}
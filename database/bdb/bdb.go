@@ -7,7 +7,6 @@ package bdb
 
 import (
 	"bytes"
-	"container/heap"
 	"encoding/binary"
 	"errors"
 	prand "math/rand"
@@ -38,15 +37,17 @@ var (
 	// Inventory hash (32 bytes) -> Object data
 	objectsBucket = []byte("objectsByHashes")
 
-	// - Getpubkey/Pubkey/Msg/Broadcast/Unknown (bucket)
-	// -- Counter value (uint64) -> Inventory hash (32 bytes)
+	// - Stream number (uint32) (bucket)
+	// -- Getpubkey/Pubkey/Msg/Broadcast/Unknown (bucket)
+	// --- Counter value (uint64) -> Inventory hash (32 bytes)
 	countersBucket = []byte("objectsByCounters")
 
 	// Used to keep track of the last assigned counter value. Needed because
 	// expired objects may be removed and if the expired object was the most
 	// recently added object, counter values could mess up.
 	//
-	// Getpubkey/Pubkey/Msg/Broadcast/Unknown -> uint64
+	// - Stream number (uint32) (bucket)
+	// -- Getpubkey/Pubkey/Msg/Broadcast/Unknown -> uint64
 	counterPosBucket = []byte("counterPositions")
 
 	// Tag (32 bytes) -> Encrypted pubkey
@@ -75,72 +76,92 @@ var (
 
 type counter struct {
 	ObjectType wire.ObjectType
+	stream     uint32
 	counter    uint64
 }
 
+// streamKey returns the key of the buckets which hold the counters of the
+// given stream.
+func streamKey(stream uint32) []byte {
+	k := make([]byte, 4)
+	binary.BigEndian.PutUint32(k, stream)
+	return k
+}
+
+// counterBucket returns the bucket which maps the counter values of objects
+// of the given type in the given stream to their hashes, or nil if no such
+// object has ever been inserted.
+func counterBucket(tx *bolt.Tx, objType wire.ObjectType, stream uint32) *bolt.Bucket {
+	b := tx.Bucket(countersBucket).Bucket(streamKey(stream))
+	if b == nil {
+		return nil
+	}
+	return b.Bucket([]byte(objType.String()))
+}
+
+// expiration is the expiration time and stream of an object, which are kept
+// in memory so that the object need not be read to check them.
+type expiration struct {
+	exp    time.Time
+	stream uint64
+}
+
 // NewBoltDB creates aan implementation of database.Database interface
 // with boltDB as a backend store.
 func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error) {
-	q := expiredQueue(make([]*expiration, 0))
-	ex := &q
-	heap.Init(ex)
-
 	counters := make(map[hash.Sha]counter)
+	expirations := make(map[hash.Sha]expiration)
 
 	// Initialize database.
 	err := db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(objectsBucket)
+		// Upgrade existing databases before anything else is done so that
+		// the migrations see the database as it was left by the version of
+		// bmd which wrote it.
+		_, exists, err := dbVersion(tx)
 		if err != nil {
 			return err
 		}
+		if exists {
+			err = checkAndUpgrade(tx)
+			if err != nil {
+				return err
+			}
+		} else {
+			b, err := tx.CreateBucket(miscBucket)
+			if err != nil {
+				return err
+			}
 
-		b, err := tx.CreateBucket(countersBucket)
-		if err == nil { // Create all sub-buckets with object types.
-			for _, objType := range objTypes {
-				_, err = b.CreateBucket([]byte(objType.String()))
-				if err != nil {
-					return err
-				}
+			// Set misc parameters.
+			err = b.Put(versionKey, []byte{latestDbVersion})
+			if err != nil {
+				return err
 			}
-		} else if err != bolt.ErrBucketExists {
-			return err
 		}
 
-		b, err = tx.CreateBucket(counterPosBucket)
-		if err == nil { // Initialize all the counter values.
-			zero := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-			for _, objType := range objTypes {
-				err = b.Put([]byte(objType.String()), zero)
-				if err != nil {
-					return err
-				}
-			}
-		} else if err != bolt.ErrBucketExists {
+		_, err = tx.CreateBucketIfNotExists(objectsBucket)
+		if err != nil {
 			return err
 		}
 
-		_, err = tx.CreateBucketIfNotExists(encPubkeysBucket)
+		// The buckets for the counters of each stream are created when the
+		// first object in the stream is inserted.
+		_, err = tx.CreateBucketIfNotExists(countersBucket)
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.CreateBucketIfNotExists(pubIDBucket)
+		_, err = tx.CreateBucketIfNotExists(counterPosBucket)
 		if err != nil {
 			return err
 		}
 
-		b, err = tx.CreateBucket(miscBucket)
-		if err == nil {
-			// Set misc parameters.
-			err = b.Put(versionKey, []byte{latestDbVersion})
-			if err != nil {
-				return err
-			}
-		} else if err != bolt.ErrBucketExists {
+		_, err = tx.CreateBucketIfNotExists(encPubkeysBucket)
+		if err != nil {
 			return err
 		}
 
-		err = checkAndUpgrade(tx)
+		_, err = tx.CreateBucketIfNotExists(pubIDBucket)
 		if err != nil {
 			return err
 		}
@@ -152,7 +173,7 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 	}
 
 	err = db.View(func(tx *bolt.Tx) error {
-		// Recreate the expired queue.
+		// Make a map of hashes to expirations.
 		err := tx.Bucket(objectsBucket).ForEach(func(k, v []byte) error {
 			header, err := wire.DecodeObjectHeader(bytes.NewReader(v))
 			if err != nil {
@@ -160,12 +181,10 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 			}
 
 			hash, _ := hash.NewSha(k)
-
-			// push the object onto the expired queue.
-			heap.Push(ex, &expiration{
-				exp:  header.Expiration(),
-				hash: hash,
-			})
+			expirations[*hash] = expiration{
+				exp:    header.Expiration(),
+				stream: header.StreamNumber,
+			}
 
 			return nil
 		})
@@ -175,15 +194,28 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 
 		// make a map of hashes to counters.
 		countersBucket := tx.Bucket(countersBucket)
-		for _, objType := range objTypes {
-			countersBucket.Bucket([]byte(objType.String())).ForEach(func(k, v []byte) error {
-				count := binary.BigEndian.Uint64(k)
-				hash, _ := hash.NewSha(v)
-				counters[*hash] = counter{ObjectType: objType, counter: count}
+		return countersBucket.ForEach(func(sk, _ []byte) error {
+			streamBucket := countersBucket.Bucket(sk)
+			if streamBucket == nil {
 				return nil
-			})
-		}
-		return nil
+			}
+			stream := binary.BigEndian.Uint32(sk)
+
+			for _, objType := range objTypes {
+				b := streamBucket.Bucket([]byte(objType.String()))
+				if b == nil {
+					continue
+				}
+				b.ForEach(func(k, v []byte) error {
+					count := binary.BigEndian.Uint64(k)
+					hash, _ := hash.NewSha(v)
+					counters[*hash] = counter{ObjectType: objType,
+						stream: stream, counter: count}
+					return nil
+				})
+			}
+			return nil
+		})
 	})
 	if err != nil {
 		return nil, err
@@ -239,7 +271,10 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 				bCounter := make([]byte, 8)
 				binary.BigEndian.PutUint64(bCounter, count.counter)
 
-				bucket := tx.Bucket(countersBucket).Bucket([]byte(count.ObjectType.String()))
+				bucket := counterBucket(tx, count.ObjectType, count.stream)
+				if bucket == nil {
+					return database.ErrNonexistentObject
+				}
 				v := bucket.Get(bCounter)
 				if v == nil {
 					return database.ErrNonexistentObject
@@ -259,8 +294,9 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 					return err
 				}
 
-				// Remove object from index.
+				// Remove object from indices.
 				delete(counters, *hash)
+				delete(expirations, *hash)
 			}
 
 			return nil
@@ -353,7 +389,7 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 	// in the database, halting if an error is returned.
 	forAllObjects := func(f func(*hash.Sha, obj.Object) error) error {
 		err := db.View(func(tx *bolt.Tx) error {
-			tx.Bucket(objectsBucket).ForEach(func(h, o []byte) error {
+			return tx.Bucket(objectsBucket).ForEach(func(h, o []byte) error {
 				sh, err := hash.NewSha(h)
 				if err != nil {
 					return err
@@ -371,8 +407,6 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 
 				return nil
 			})
-
-			return nil
 		})
 		if err != nil {
 			return err
@@ -398,11 +432,11 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 		FetchObjectByHash: fetchObjectByHash,
 
 		// FetchObjectByCounter returns the corresponding object based on the
-		// counter. Note that each object type has a different counter, with unknown
-		// objects being consolidated into one counter. Counters are meant for use
-		// as a convenience method for fetching new data from database since last
-		// check.
-		FetchObjectByCounter: func(objType wire.ObjectType,
+		// counter. Note that each stream has a different counter for each object
+		// type, with unknown objects being consolidated into one counter.
+		// Counters are meant for use as a convenience method for fetching new
+		// data from database since last check.
+		FetchObjectByCounter: func(objType wire.ObjectType, stream uint32,
 			counter uint64) (obj.Object, error) {
 
 			bCounter := make([]byte, 8)
@@ -412,7 +446,11 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 			var err error
 
 			err = db.View(func(tx *bolt.Tx) error {
-				hash := tx.Bucket(countersBucket).Bucket([]byte(objType.String())).Get(bCounter)
+				bucket := counterBucket(tx, objType, stream)
+				if bucket == nil {
+					return database.ErrNonexistentObject
+				}
+				hash := bucket.Get(bCounter)
 				if hash == nil {
 					return database.ErrNonexistentObject
 				}
@@ -431,12 +469,12 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 			return o, nil
 		},
 
-		// FetchObjectsFromCounter returns a slice of `count' objects which have a
-		// counter position starting from `counter'. It also returns the counter
-		// value of the last object, which could be useful for more queries to the
-		// function.
-		FetchObjectsFromCounter: func(objType wire.ObjectType, counter uint64,
-			count uint64) ([]database.ObjectWithCounter, uint64, error) {
+		// FetchObjectsFromCounter returns a slice of `count' objects of the given
+		// type in the given stream which have a counter position starting from
+		// `counter'. It also returns the counter value of the last object, which
+		// could be useful for more queries to the function.
+		FetchObjectsFromCounter: func(objType wire.ObjectType, stream uint32,
+			counter uint64, count uint64) ([]database.ObjectWithCounter, uint64, error) {
 
 			bCounter := make([]byte, 8)
 			binary.BigEndian.PutUint64(bCounter, counter)
@@ -445,7 +483,11 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 			var lastCounter uint64
 
 			err := db.View(func(tx *bolt.Tx) error {
-				cursor := tx.Bucket(countersBucket).Bucket([]byte(objType.String())).Cursor()
+				bucket := counterBucket(tx, objType, stream)
+				if bucket == nil {
+					return nil
+				}
+				cursor := bucket.Cursor()
 
 				i := uint64(0)
 				k, v := cursor.Seek(bCounter)
@@ -637,12 +679,17 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 		},
 
 		// GetCounter returns the highest value of counter that exists for objects
-		// of the given type.
-		GetCounter: func(objType wire.ObjectType) (uint64, error) {
+		// of the given type in the given stream.
+		GetCounter: func(objType wire.ObjectType, stream uint32) (uint64, error) {
 			var counter uint64
 
 			err := db.View(func(tx *bolt.Tx) error {
-				k, _ := tx.Bucket(countersBucket).Bucket([]byte(objType.String())).Cursor().Last()
+				bucket := counterBucket(tx, objType, stream)
+				if bucket == nil {
+					return nil
+				}
+
+				k, _ := bucket.Cursor().Last()
 				if k == nil {
 					counter = 0
 				} else {
@@ -657,7 +704,7 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 		},
 
 		// InsertObject inserts the given object into the database and returns the
-		// counter position. If the object is a PubKey, it inserts it into a
+		// counter position within its stream. If the object is a PubKey, it inserts it into a
 		// separate place where it isn't touched by RemoveObject or
 		// RemoveExpiredObjects and has to be removed using RemovePubKey.
 		InsertObject: func(o obj.Object) (uint64, error) {
@@ -709,23 +756,40 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 					return err
 				}
 
+				// Get the buckets for the counters of the stream, creating them
+				// if this is the first object in it.
+				sk := streamKey(uint32(header.StreamNumber))
+				typeKey := []byte(header.ObjectType.String())
+				positions, err := tx.Bucket(counterPosBucket).CreateBucketIfNotExists(sk)
+				if err != nil {
+					return err
+				}
+				byCounter, err := tx.Bucket(countersBucket).CreateBucketIfNotExists(sk)
+				if err != nil {
+					return err
+				}
+				byCounter, err = byCounter.CreateBucketIfNotExists(typeKey)
+				if err != nil {
+					return err
+				}
+
 				// Get latest counter value.
-				v := tx.Bucket(counterPosBucket).Get([]byte(header.ObjectType.String()))
-				count = binary.BigEndian.Uint64(v) + 1
+				count = 1
+				if v := positions.Get(typeKey); v != nil {
+					count = binary.BigEndian.Uint64(v) + 1
+				}
 
 				bCounter := make([]byte, 8)
 				binary.BigEndian.PutUint64(bCounter, count)
 
 				// Store counter value along with hash.
-				err = tx.Bucket(countersBucket).Bucket([]byte(header.ObjectType.String())).
-					Put(bCounter, obj.InventoryHash(o)[:])
+				err = byCounter.Put(bCounter, obj.InventoryHash(o)[:])
 				if err != nil {
 					return err
 				}
 
 				// Store new counter value.
-				return tx.Bucket(counterPosBucket).Put([]byte(header.ObjectType.String()),
-					bCounter)
+				return positions.Put(typeKey, bCounter)
 			})
 			if err != nil {
 				return 0, err
@@ -735,9 +799,13 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 			if objectType > wire.HighestKnownObjectType {
 				objectType = objectTypeUnknown
 			}
-			counters[*hash] = counter{counter: count, ObjectType: objectType}
+			counters[*hash] = counter{counter: count, ObjectType: objectType,
+				stream: uint32(header.StreamNumber)}
 
-			heap.Push(ex, &expiration{exp: header.Expiration(), hash: hash})
+			expirations[*hash] = expiration{
+				exp:    header.Expiration(),
+				stream: header.StreamNumber,
+			}
 
 			return count, err
 		},
@@ -756,13 +824,15 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 			return remove([]counter{count})
 		},
 
-		// RemoveObjectByCounter removes the object with the specified counter value
-		// from the database.
-		RemoveObjectByCounter: func(objType wire.ObjectType, count uint64) error {
+		// RemoveObjectByCounter removes the object of the given type in the given
+		// stream with the specified counter value from the database.
+		RemoveObjectByCounter: func(objType wire.ObjectType, stream uint32,
+			count uint64) error {
 			mtx.Lock()
 			defer mtx.Unlock()
 
-			return remove([]counter{counter{ObjectType: objType, counter: count}})
+			return remove([]counter{counter{ObjectType: objType, stream: stream,
+				counter: count}})
 		},
 
 		// RemoveExpiredObjects prunes all objects in the main circulation store
@@ -777,22 +847,16 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 
 			r := make([]*hash.Sha, 0, expiredSliceSize)
 
-			for {
-				last := ex.Peek()
+			counts := make([]counter, 0, expiredSliceSize)
 
-				if last == nil || t.Before(last.exp) {
-					break
+			for h, e := range expirations {
+				if t.Before(e.exp) {
+					continue
 				}
 
-				r = append(r, last.hash)
-
-				heap.Pop(ex)
-			}
-
-			counts := make([]counter, 0, len(r))
-
-			for _, hash := range r {
-				counts = append(counts, counters[*hash])
+				hash := h
+				r = append(r, &hash)
+				counts = append(counts, counters[h])
 			}
 
 			return r, remove(counts)
@@ -826,26 +890,27 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 		},
 
 		// FetchRandomInvHashes returns the specified number of inventory hashes
-		// corresponding to random unexpired objects from the database. It does not
-		// guarantee that the number of returned inventory vectors would be `count'.
-		FetchRandomInvHashes: func(count uint64) ([]*wire.InvVect, error) {
+		// corresponding to random unexpired objects from the database in the
+		// given streams. It does not guarantee that the number of returned
+		// inventory vectors would be `count'.
+		FetchRandomInvHashes: func(count uint64, streams ...uint32) ([]*wire.InvVect, error) {
 			mtx.Lock()
 			defer mtx.Unlock()
 
 			hashes := make([]*wire.InvVect, 0, count)
 			now := now()
-			randomizer := make(map[*hash.Sha]struct{})
+			randomizer := make(map[hash.Sha]struct{})
 
-			for _, e := range *ex {
-				if now.Before(e.exp) {
-					randomizer[e.hash] = struct{}{}
+			for h, e := range expirations {
+				if now.Before(e.exp) && database.InStreams(e.stream, streams) {
+					randomizer[h] = struct{}{}
 				}
 			}
 
 			// go ensures that the iteration order is random.
 			for hash := range randomizer {
 				inv := &wire.InvVect{}
-				copy(inv[:], (*hash)[:])
+				copy(inv[:], hash[:])
 				hashes = append(hashes, inv)
 				if uint64(len(hashes)) == count {
 					break
@@ -855,6 +920,27 @@ func NewBoltDB(db *bolt.DB, stats database.Stats, now Now) (*database.Db, error)
 			return hashes, nil
 		},
 
+		// FetchInvHashesAfter returns at most the specified number of
+		// inventory hashes corresponding to unexpired objects in increasing
+		// order, starting after the given hash.
+		FetchInvHashesAfter: func(after *hash.Sha, count uint64, streams ...uint32) ([]*wire.InvVect, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			hashes := make([]*wire.InvVect, 0, len(expirations))
+			now := now()
+
+			for h, e := range expirations {
+				if now.Before(e.exp) && database.InStreams(e.stream, streams) {
+					inv := &wire.InvVect{}
+					copy(inv[:], h[:])
+					hashes = append(hashes, inv)
+				}
+			}
+
+			return database.InvHashesAfter(hashes, after, count), nil
+		},
+
 		// Get the addresses corresponding to all public identities in the database.
 		GetAllIdentities: func() ([]bmutil.Address, error) {
 			var addrs []bmutil.Address
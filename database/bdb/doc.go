@@ -9,11 +9,13 @@
 // -- Inventory hash (32 bytes) -> Object data
 //
 // - objectsByCounters (bucket)
-// -- Getpubkey/Pubkey/Msg/Broadcast/Unknown (bucket)
-// --- Counter value (uint64) -> Inventory hash (32 bytes)
+// -- Stream number (uint32) (bucket)
+// --- Getpubkey/Pubkey/Msg/Broadcast/Unknown (bucket)
+// ---- Counter value (uint64) -> Inventory hash (32 bytes)
 //
 // - counterPositions (bucket)
-// -- Getpubkey/Pubkey/Msg/Broadcast/Unknown -> uint64
+// -- Stream number (uint32) (bucket)
+// --- Getpubkey/Pubkey/Msg/Broadcast/Unknown -> uint64
 //
 // - encryptedPubkeysByTag (bucket)
 // -- Tag (32 bytes) -> Encrypted pubkey
@@ -28,4 +30,8 @@
 //
 // - misc
 // -- version -> uint8
+//
+// When the structure changes, the version is increased and a migration is
+// added which upgrades databases written by earlier versions of bmd. These
+// are run in order when the database is opened.
 package bdb
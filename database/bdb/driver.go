@@ -20,7 +20,7 @@ import (
 
 const (
 	// latestDbVersion is the most recent version of database.
-	latestDbVersion = 0x01
+	latestDbVersion = 0x02
 )
 
 var log = btclog.Disabled
@@ -107,17 +107,9 @@ func OpenDB(args ...interface{}) (*database.Db, error) {
 
 	bdb, err = NewBoltDB(db, z, now)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return bdb, nil
 }
-
-// checkAndUpgrade checks for and upgrades the database version.
-func checkAndUpgrade(tx *bolt.Tx) error {
-	v := tx.Bucket(miscBucket).Get(versionKey)
-	if v[0] != latestDbVersion {
-		return errors.New("Unrecognized database version.")
-	}
-	return nil
-}
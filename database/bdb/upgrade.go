@@ -0,0 +1,245 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/boltdb/bolt"
+)
+
+// migration is a step which upgrades the database from the previous version
+// to version.
+type migration struct {
+	version     byte
+	description string
+	upgrade     func(tx *bolt.Tx) error
+}
+
+// migrations is the list of upgrade steps, in order of increasing version.
+// Whenever the layout of the database changes, a migration must be appended
+// here and latestDbVersion must be set to its version.
+var migrations = []migration{
+	{
+		version:     2,
+		description: "key object counters by stream",
+		upgrade:     keyCountersByStream,
+	},
+}
+
+// errDryRun is used to roll back the transaction in which a dry run of the
+// database upgrade is done.
+var errDryRun = errors.New("dry run")
+
+// dbVersion returns the version of the database, or false if the database
+// has not been initialized yet.
+func dbVersion(tx *bolt.Tx) (byte, bool, error) {
+	b := tx.Bucket(miscBucket)
+	if b == nil {
+		return 0, false, nil
+	}
+	v := b.Get(versionKey)
+	if len(v) != 1 {
+		return 0, false, errors.New("Missing or malformed database version.")
+	}
+	return v[0], true, nil
+}
+
+// upgrade runs all migrations in the given list which are newer than the
+// version of the database, updating the version after each one, and returns
+// the migrations which were run. The database must not be newer than latest.
+// All migrations are run in the given transaction, so if one fails, the
+// database is left as it was when the transaction is rolled back.
+func upgrade(tx *bolt.Tx, migrations []migration, latest byte) ([]migration, error) {
+	version, ok, err := dbVersion(tx)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("Database not initialized.")
+	}
+
+	if version > latest {
+		return nil, fmt.Errorf("Database version %d is newer than version %d, "+
+			"which is the latest supported by this version of bmd.",
+			version, latest)
+	}
+
+	var applied []migration
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		log.Infof("Upgrading database from version %d to version %d: %s",
+			version, m.version, m.description)
+		if err = m.upgrade(tx); err != nil {
+			return nil, fmt.Errorf("Upgrade to database version %d failed: %v",
+				m.version, err)
+		}
+
+		version = m.version
+		err = tx.Bucket(miscBucket).Put(versionKey, []byte{version})
+		if err != nil {
+			return nil, err
+		}
+		applied = append(applied, m)
+	}
+
+	if version != latest {
+		return nil, fmt.Errorf("No upgrade path from database version %d "+
+			"to version %d.", version, latest)
+	}
+
+	return applied, nil
+}
+
+// checkAndUpgrade checks for and upgrades the database version.
+func checkAndUpgrade(tx *bolt.Tx) error {
+	_, err := upgrade(tx, migrations, latestDbVersion)
+	return err
+}
+
+// DryRunUpgrade reports which upgrade steps would be run to bring the
+// database at the given path up to the latest version. The steps are
+// actually run to check that they work, but the changes are rolled back.
+func DryRunUpgrade(dbpath string) ([]string, error) {
+	log = database.GetLog()
+
+	db, err := bolt.Open(dbpath, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var steps []string
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, ok, err := dbVersion(tx); err != nil {
+			return err
+		} else if !ok {
+			return errDryRun // A new database is created at the latest version.
+		}
+
+		applied, err := upgrade(tx, migrations, latestDbVersion)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			steps = append(steps, fmt.Sprintf("version %d: %s",
+				m.version, m.description))
+		}
+		return errDryRun
+	})
+	if err != errDryRun {
+		return nil, err
+	}
+
+	return steps, nil
+}
+
+// keyCountersByStream moves the counter of every object into the buckets of
+// its stream. Objects keep their counter values, and each stream with objects
+// of a type continues from the position which the type had before, so that no
+// counter value is given out twice.
+func keyCountersByStream(tx *bolt.Tx) error {
+	objects := tx.Bucket(objectsBucket)
+	oldCounters := tx.Bucket(countersBucket)
+	oldPositions := tx.Bucket(counterPosBucket)
+	if objects == nil || oldCounters == nil || oldPositions == nil {
+		return nil // Nothing has been stored yet.
+	}
+
+	// entry is the counter of an object in the old layout along with the
+	// stream of the object.
+	type entry struct {
+		typeKey, counter, hash, stream []byte
+	}
+
+	// Read everything before the buckets are replaced, copying the keys
+	// and values since they are not valid once their bucket is deleted.
+	var entries []entry
+	err := oldCounters.ForEach(func(typeKey, _ []byte) error {
+		b := oldCounters.Bucket(typeKey)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			data := objects.Get(v)
+			if data == nil {
+				return nil // The counter of an object which is gone.
+			}
+			header, err := wire.DecodeObjectHeader(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, entry{
+				typeKey: append([]byte(nil), typeKey...),
+				counter: append([]byte(nil), k...),
+				hash:    append([]byte(nil), v...),
+				stream:  streamKey(uint32(header.StreamNumber)),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	positions := make(map[string][]byte)
+	err = oldPositions.ForEach(func(k, v []byte) error {
+		positions[string(k)] = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.DeleteBucket(countersBucket); err != nil {
+		return err
+	}
+	if err = tx.DeleteBucket(counterPosBucket); err != nil {
+		return err
+	}
+	newCounters, err := tx.CreateBucket(countersBucket)
+	if err != nil {
+		return err
+	}
+	newPositions, err := tx.CreateBucket(counterPosBucket)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		b, err := newCounters.CreateBucketIfNotExists(e.stream)
+		if err != nil {
+			return err
+		}
+		b, err = b.CreateBucketIfNotExists(e.typeKey)
+		if err != nil {
+			return err
+		}
+		if err = b.Put(e.counter, e.hash); err != nil {
+			return err
+		}
+
+		b, err = newPositions.CreateBucketIfNotExists(e.stream)
+		if err != nil {
+			return err
+		}
+		if pos, ok := positions[string(e.typeKey)]; ok {
+			if err = b.Put(e.typeKey, pos); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+	"github.com/boltdb/bolt"
+)
+
+// TestMigrationsOrdered checks that the list of migrations is ordered and
+// ends at the latest version.
+func TestMigrationsOrdered(t *testing.T) {
+	version := byte(1)
+	for _, m := range migrations {
+		if m.version != version+1 {
+			t.Errorf("migration to version %d follows version %d", m.version, version)
+		}
+		version = m.version
+	}
+	if version != latestDbVersion {
+		t.Errorf("migrations end at version %d but the latest version is %d",
+			version, latestDbVersion)
+	}
+}
+
+// openTestBolt creates a new bolt database with only a version number.
+func openTestBolt(t *testing.T, version byte) (*bolt.DB, string, func()) {
+	dir, err := ioutil.TempDir("", "bdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.db")
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(miscBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(versionKey, []byte{version})
+	})
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return db, path, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	testBucket := []byte("test")
+	testMigrations := []migration{
+		{
+			version:     2,
+			description: "create test bucket",
+			upgrade: func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucket(testBucket)
+				return err
+			},
+		},
+		{
+			version:     3,
+			description: "fill test bucket",
+			upgrade: func(tx *bolt.Tx) error {
+				return tx.Bucket(testBucket).Put([]byte("key"), []byte("value"))
+			},
+		},
+	}
+
+	db, _, cleanup := openTestBolt(t, 1)
+	defer cleanup()
+
+	// Upgrade to a version for which there are no migrations.
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := upgrade(tx, testMigrations, 4)
+		return err
+	})
+	if err == nil {
+		t.Error("expected error upgrading without a path to the latest version")
+	}
+
+	// A failed migration leaves the database untouched.
+	failing := append(testMigrations[:1:1], migration{
+		version:     3,
+		description: "fail",
+		upgrade: func(tx *bolt.Tx) error {
+			return errors.New("failed")
+		},
+	})
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := upgrade(tx, failing, 3)
+		return err
+	})
+	if err == nil {
+		t.Error("expected error from failing migration")
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if version, _, _ := dbVersion(tx); version != 1 {
+			t.Errorf("expected version 1 after failed upgrade, got %d", version)
+		}
+		if tx.Bucket(testBucket) != nil {
+			t.Error("test bucket created by failed upgrade")
+		}
+		return nil
+	})
+
+	// Successful upgrade.
+	var applied []migration
+	err = db.Update(func(tx *bolt.Tx) error {
+		var err error
+		applied, err = upgrade(tx, testMigrations, 3)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("expected 2 migrations to be applied, got %d", len(applied))
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if version, _, _ := dbVersion(tx); version != 3 {
+			t.Errorf("expected version 3 after upgrade, got %d", version)
+		}
+		if b := tx.Bucket(testBucket); b == nil || string(b.Get([]byte("key"))) != "value" {
+			t.Error("migrations were not applied")
+		}
+		return nil
+	})
+
+	// Nothing more to do.
+	err = db.Update(func(tx *bolt.Tx) error {
+		var err error
+		applied, err = upgrade(tx, testMigrations, 3)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected no migrations to be applied, got %d", len(applied))
+	}
+
+	// The database is newer than this version of bmd.
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := upgrade(tx, testMigrations[:1], 2)
+		return err
+	})
+	if err == nil {
+		t.Error("expected error opening newer database")
+	}
+}
+
+func TestOpenNewerDatabase(t *testing.T) {
+	db, path, cleanup := openTestBolt(t, latestDbVersion+1)
+	defer cleanup()
+	db.Close()
+
+	if _, err := DryRunUpgrade(path); err == nil {
+		t.Error("expected error from dry run on newer database")
+	}
+
+	if _, err := database.OpenDB("boltdb", path); err == nil {
+		t.Error("expected error opening newer database")
+	}
+}
+
+func TestDryRunUpgrade(t *testing.T) {
+	db, path, cleanup := openTestBolt(t, latestDbVersion)
+	defer cleanup()
+	db.Close()
+
+	steps, err := DryRunUpgrade(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(steps) != 0 {
+		t.Errorf("expected no upgrade steps, got %v", steps)
+	}
+}
+
+// TestKeyCountersByStream checks that the counters of a version 1 database
+// are moved into the buckets of the streams of their objects.
+func TestKeyCountersByStream(t *testing.T) {
+	db, _, cleanup := openTestBolt(t, 1)
+	defer cleanup()
+
+	bCounter := func(count uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, count)
+		return b
+	}
+
+	expires := time.Now().Add(time.Hour)
+	objects := []obj.Object{
+		wire.NewMsgObject(wire.NewObjectHeader(1, expires, wire.ObjectTypeMsg, 1, 1),
+			[]byte{1, 2, 3}),
+		wire.NewMsgObject(wire.NewObjectHeader(2, expires, wire.ObjectTypeMsg, 1, 2),
+			[]byte{4, 5, 6}),
+	}
+	typeKey := []byte(wire.ObjectTypeMsg.String())
+
+	// Lay out the database as version 1 did. The msg with counter 3 has
+	// been removed.
+	err := db.Update(func(tx *bolt.Tx) error {
+		objs, err := tx.CreateBucket(objectsBucket)
+		if err != nil {
+			return err
+		}
+		counters, err := tx.CreateBucket(countersBucket)
+		if err != nil {
+			return err
+		}
+		msgs, err := counters.CreateBucket(typeKey)
+		if err != nil {
+			return err
+		}
+		for i, o := range objects {
+			h := obj.InventoryHash(o)[:]
+			if err = objs.Put(h, wire.Encode(o)); err != nil {
+				return err
+			}
+			if err = msgs.Put(bCounter(uint64(i+1)), h); err != nil {
+				return err
+			}
+		}
+
+		positions, err := tx.CreateBucket(counterPosBucket)
+		if err != nil {
+			return err
+		}
+		return positions.Put(typeKey, bCounter(3))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := upgrade(tx, migrations, latestDbVersion)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		for i, o := range objects {
+			stream := uint32(i + 1)
+			b := counterBucket(tx, wire.ObjectTypeMsg, stream)
+			if b == nil || !bytes.Equal(b.Get(bCounter(uint64(i+1))), obj.InventoryHash(o)[:]) {
+				t.Errorf("counter of msg #%d not moved to stream %d", i, stream)
+				continue
+			}
+			if b.Stats().KeyN != 1 {
+				t.Errorf("expected 1 counter in stream %d, got %d", stream, b.Stats().KeyN)
+			}
+
+			pos := tx.Bucket(counterPosBucket).Bucket(streamKey(stream)).Get(typeKey)
+			if !bytes.Equal(pos, bCounter(3)) {
+				t.Errorf("expected position 3 in stream %d, got %x", stream, pos)
+			}
+		}
+		return nil
+	})
+
+	// New objects get counters after the old position in their stream.
+	bdb, err := NewBoltDB(db, database.NewDisabledStatsRecorder(), time.Now)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if count, err := bdb.GetCounter(wire.ObjectTypeMsg, 2); err != nil || count != 2 {
+		t.Errorf("GetCounter: expected 2, got %d, error %v", count, err)
+	}
+	count, err := bdb.InsertObject(wire.NewMsgObject(
+		wire.NewObjectHeader(3, expires, wire.ObjectTypeMsg, 1, 2), []byte{7, 8, 9}))
+	if err != nil || count != 4 {
+		t.Errorf("InsertObject: expected counter 4, got %d, error %v", count, err)
+	}
+}
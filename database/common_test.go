@@ -17,6 +17,7 @@ import (
 	"github.com/DanielKrawisz/bmd/database"
 	"github.com/DanielKrawisz/bmd/database/bdb"
 	_ "github.com/DanielKrawisz/bmd/database/memdb"
+	"github.com/DanielKrawisz/bmd/database/sqlite"
 )
 
 // createDB creates a new db instance and returns a timepasses function and
@@ -49,10 +50,21 @@ func createDB(dbType string) (*database.Db, func(), func(), error) {
 	}
 
 	currentTime := time.Now().Add(-20 * time.Minute)
-	// Create a new database.
-	db, err := database.OpenDB(dbType, f.Name(), database.NewDisabledStatsRecorder(), bdb.Now(func() time.Time {
+	now := func() time.Time {
 		return currentTime
-	}))
+	}
+
+	// Each driver has its own type for the function which tells the time.
+	var nowArg interface{}
+	switch dbType {
+	case "sqlite":
+		nowArg = sqlite.Now(now)
+	default:
+		nowArg = bdb.Now(now)
+	}
+
+	// Create a new database.
+	db, err := database.OpenDB(dbType, f.Name(), database.NewDisabledStatsRecorder(), nowArg)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("error creating db: %v", err)
 	}
@@ -56,18 +56,19 @@ type Db struct {
 	FetchObjectByHash func(*hash.Sha) (obj.Object, error)
 
 	// FetchObjectByCounter returns the corresponding object based on the
-	// counter. Note that each object type has a different counter, with unknown
-	// objects being consolidated into one counter. Counters are meant for use
-	// as a convenience method for fetching new data from database since last
-	// check.
-	FetchObjectByCounter func(wire.ObjectType, uint64) (obj.Object, error)
-
-	// FetchObjectsFromCounter returns a slice of `count' objects which have a
-	// counter position starting from `counter'. It also returns the counter
-	// value of the last object, which could be useful for more queries to the
-	// function.
-	FetchObjectsFromCounter func(objType wire.ObjectType, counter uint64,
-		count uint64) ([]ObjectWithCounter, uint64, error)
+	// counter. Note that each stream has a different counter for each object
+	// type, with unknown objects being consolidated into one counter.
+	// Counters are meant for use as a convenience method for fetching new
+	// data from database since last check.
+	FetchObjectByCounter func(objType wire.ObjectType, stream uint32,
+		counter uint64) (obj.Object, error)
+
+	// FetchObjectsFromCounter returns a slice of `count' objects of the given
+	// type in the given stream which have a counter position starting from
+	// `counter'. It also returns the counter value of the last object, which
+	// could be useful for more queries to the function.
+	FetchObjectsFromCounter func(objType wire.ObjectType, stream uint32,
+		counter uint64, count uint64) ([]ObjectWithCounter, uint64, error)
 
 	// FetchIdentityByAddress returns identity.PublicID stored in the form
 	// of a PubKey message in the pubkey database.
@@ -75,27 +76,37 @@ type Db struct {
 
 	// FetchRandomInvHashes returns at most the specified number of
 	// inventory hashes corresponding to random unexpired objects from
-	// the database. It does not guarantee that the number of returned
+	// the database. If any streams are given, only objects in those streams
+	// are returned. It does not guarantee that the number of returned
 	// inventory vectors would be `count'.
-	FetchRandomInvHashes func(count uint64) ([]*wire.InvVect, error)
+	FetchRandomInvHashes func(count uint64, streams ...uint32) ([]*wire.InvVect, error)
+
+	// FetchInvHashesAfter returns at most the specified number of inventory
+	// hashes corresponding to unexpired objects in increasing order, starting
+	// after the given hash. If the given hash is nil, it starts from the
+	// lowest hash. If any streams are given, only objects in those streams
+	// are returned. Fewer than `count' hashes are returned only when there
+	// are no more.
+	FetchInvHashesAfter func(after *hash.Sha, count uint64, streams ...uint32) ([]*wire.InvVect, error)
 
 	// GetCounter returns the highest value of counter that exists for objects
-	// of the given type.
-	GetCounter func(wire.ObjectType) (uint64, error)
+	// of the given type in the given stream.
+	GetCounter func(objType wire.ObjectType, stream uint32) (uint64, error)
 
 	// InsertObject inserts the given object into the database and returns the
-	// counter position. If the object is a PubKey, it inserts it into a
-	// separate place where it isn't touched by RemoveObject or
-	// RemoveExpiredObjects and has to be removed using RemovePubKey.
+	// counter position within its stream. If the object is a PubKey, it
+	// inserts it into a separate place where it isn't touched by RemoveObject
+	// or RemoveExpiredObjects and has to be removed using RemovePubKey.
 	InsertObject func(obj.Object) (uint64, error)
 
 	// RemoveObject removes the object with the specified hash from the
 	// database. Does not remove PubKeys.
 	RemoveObject func(*hash.Sha) error
 
-	// RemoveObjectByCounter removes the object with the specified counter value
-	// from the database.
-	RemoveObjectByCounter func(wire.ObjectType, uint64) error
+	// RemoveObjectByCounter removes the object of the given type in the given
+	// stream with the specified counter value from the database.
+	RemoveObjectByCounter func(objType wire.ObjectType, stream uint32,
+		counter uint64) error
 
 	// RemoveExpiredObjects prunes all objects in the main circulation store
 	// whose expiry time has passed (along with a margin of 3 hours). This does
@@ -13,9 +13,9 @@ Basic Design
 
 The basic design of this package is to store objects to be propagated separate
 from the objects that need to persist (pubkeys). The objects to be propagated
-are periodically deleted as they expire. It uses counters instead of timestamps
-to keep track of the order that objects were received in. High level operations
-such as FetchIdentityByAddress are also provided for convenience.
+are deleted by an ExpiryScheduler as they expire. It uses counters instead of
+timestamps to keep track of the order that objects were received in. High level
+operations such as FetchIdentityByAddress are also provided for convenience.
 
 Usage
 
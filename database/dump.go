@@ -0,0 +1,237 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/pow"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+// A dump of the object database is a header followed by one record for every
+// object. The header is the magic bytes dumpMagic and the format version as a
+// uint32. Each record is the counter of the object in the database which was
+// dumped as a uint64, the length of the object as a uint32, and the object in
+// its wire encoding. All integers are big endian. Objects of known types are
+// written stream by stream in the order of their counters so that loading a
+// dump preserves the order in which they were received.
+
+const (
+	// dumpVersion is the version of the dump format.
+	dumpVersion = 1
+
+	// maxDumpObjectSize is the size of the largest object which may appear
+	// in a dump. Nothing larger could have been received from the network.
+	maxDumpObjectSize = 1 << 18
+
+	// dumpPageSize is how many objects are fetched from the database at a
+	// time while dumping.
+	dumpPageSize = 1000
+)
+
+// dumpMagic identifies a file as a dump of the object database.
+var dumpMagic = [8]byte{'b', 'm', 'd', 'o', 'b', 'j', 'd', 'b'}
+
+// ErrInvalidDump is returned when reading something which is not a dump of
+// the object database.
+var ErrInvalidDump = errors.New("not a valid object database dump")
+
+// dumpTypes are the object types which have their own counters.
+var dumpTypes = []wire.ObjectType{
+	wire.ObjectTypeGetPubKey,
+	wire.ObjectTypePubKey,
+	wire.ObjectTypeMsg,
+	wire.ObjectTypeBroadcast,
+}
+
+// writeDumpRecord writes a single object to a dump.
+func writeDumpRecord(w io.Writer, counter uint64, o obj.Object) error {
+	b := wire.Encode(o)
+
+	var header [12]byte
+	binary.BigEndian.PutUint64(header[:8], counter)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(b)))
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// DumpObjects writes every unexpired object in the database to w along with
+// its counter, and returns the number of objects written.
+func DumpObjects(db *Db, w io.Writer) (int, error) {
+	var header [12]byte
+	copy(header[:8], dumpMagic[:])
+	binary.BigEndian.PutUint32(header[8:], dumpVersion)
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	written := 0
+	dumped := make(map[hash.Sha]struct{})
+
+	// Find the streams of the objects, since each has its own counters.
+	inStream := make(map[uint32]struct{})
+	err := db.ForAllObjects(func(h *hash.Sha, o obj.Object) error {
+		inStream[uint32(o.Header().StreamNumber)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return written, err
+	}
+	streams := make([]int, 0, len(inStream))
+	for stream := range inStream {
+		streams = append(streams, int(stream))
+	}
+	sort.Ints(streams)
+
+	// First go through the objects with counters in order.
+	for _, stream := range streams {
+		for _, objType := range dumpTypes {
+			var counter uint64
+			for {
+				objects, last, err := db.FetchObjectsFromCounter(objType,
+					uint32(stream), counter, dumpPageSize)
+				if err != nil {
+					return written, err
+				}
+
+				for _, o := range objects {
+					dumped[*obj.InventoryHash(o.Object)] = struct{}{}
+					if now.After(o.Object.Header().Expiration()) {
+						continue
+					}
+
+					err = writeDumpRecord(w, o.Counter, o.Object)
+					if err != nil {
+						return written, err
+					}
+					written++
+				}
+
+				if len(objects) < dumpPageSize {
+					break
+				}
+				counter = last + 1
+			}
+		}
+	}
+
+	// Then whatever is left, which are objects of unknown types.
+	err = db.ForAllObjects(func(h *hash.Sha, o obj.Object) error {
+		if _, ok := dumped[*h]; ok {
+			return nil
+		}
+		if now.After(o.Header().Expiration()) {
+			return nil
+		}
+
+		if err := writeDumpRecord(w, 0, o); err != nil {
+			return err
+		}
+		written++
+		return nil
+	})
+
+	return written, err
+}
+
+// LoadStats describes the result of loading a dump into the database.
+type LoadStats struct {
+	// Inserted is the number of objects inserted into the database.
+	Inserted int
+
+	// Duplicate is the number of objects which were already in the database.
+	Duplicate int
+
+	// Expired is the number of objects which were skipped because they are
+	// expired.
+	Expired int
+
+	// InvalidPow is the number of objects which were skipped because their
+	// proof of work is insufficient.
+	InvalidPow int
+}
+
+// LoadObjects reads a dump written by DumpObjects and inserts the objects in
+// it into the database. Objects which have expired or which have insufficient
+// proof of work are skipped, as are objects which are already in the database.
+func LoadObjects(db *Db, r io.Reader) (*LoadStats, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidDump
+		}
+		return nil, err
+	}
+	if string(header[:8]) != string(dumpMagic[:]) {
+		return nil, ErrInvalidDump
+	}
+	if version := binary.BigEndian.Uint32(header[8:]); version != dumpVersion {
+		return nil, fmt.Errorf("unsupported object database dump version %d",
+			version)
+	}
+
+	stats := &LoadStats{}
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		size := binary.BigEndian.Uint32(header[8:])
+		if size > maxDumpObjectSize {
+			return stats, fmt.Errorf("object of size %d in dump exceeds maximum "+
+				"size %d", size, maxDumpObjectSize)
+		}
+
+		b := make([]byte, size)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return stats, err
+		}
+
+		o, err := obj.ReadObject(b)
+		if err != nil {
+			return stats, err
+		}
+
+		now := time.Now()
+		if now.After(o.Header().Expiration()) {
+			stats.Expired++
+			continue
+		}
+
+		if !o.CheckPow(pow.Default, now) {
+			stats.InvalidPow++
+			continue
+		}
+
+		_, err = db.InsertObject(o)
+		switch err {
+		case nil:
+			stats.Inserted++
+		case ErrDuplicateObject:
+			stats.Duplicate++
+		case ErrExpired:
+			stats.Expired++
+		default:
+			return stats, err
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package database_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/pow"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+// doPow returns a copy of the object with a valid nonce.
+func doPow(o obj.Object) obj.Object {
+	b := wire.Encode(o)
+	section := b[8:]
+	target := pow.CalculateTarget(uint64(len(section)),
+		uint64(o.Header().Expiration().Sub(time.Now()).Seconds()), pow.Default)
+	nonce := pow.DoSequential(target, hash.Sha512(section))
+	binary.BigEndian.PutUint64(b, uint64(nonce))
+	o, _ = obj.ReadObject(b)
+	return o
+}
+
+func testDumpAndLoad(t *testing.T, from, to string, withPow obj.Object) {
+	src, _, teardown, err := createDB(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	// Only the unexpired objects are dumped.
+	for _, objects := range testObj {
+		for _, o := range objects {
+			src.InsertObject(o)
+		}
+	}
+	if _, err = src.InsertObject(withPow); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := database.DumpObjects(src, &buf)
+	if err != nil {
+		t.Fatalf("DumpObjects (%s): unexpected error %v", from, err)
+	}
+	if n != len(testObj)+1 {
+		t.Errorf("DumpObjects (%s): expected %d objects, got %d", from,
+			len(testObj)+1, n)
+	}
+
+	dst, _, teardown, err := createDB(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	// The test objects do not have valid proof of work, so only one object
+	// is inserted.
+	dump := buf.Bytes()
+	stats, err := database.LoadObjects(dst, bytes.NewReader(dump))
+	if err != nil {
+		t.Fatalf("LoadObjects (%s to %s): unexpected error %v", from, to, err)
+	}
+	expected := database.LoadStats{Inserted: 1, InvalidPow: len(testObj)}
+	if *stats != expected {
+		t.Errorf("LoadObjects (%s to %s): expected %v, got %v", from, to,
+			expected, *stats)
+	}
+	if exists, _ := dst.ExistsObject(obj.InventoryHash(withPow)); !exists {
+		t.Errorf("LoadObjects (%s to %s): object not inserted", from, to)
+	}
+
+	// Loading again inserts nothing new.
+	stats, err = database.LoadObjects(dst, bytes.NewReader(dump))
+	if err != nil {
+		t.Fatalf("LoadObjects (%s to %s): unexpected error %v", from, to, err)
+	}
+	expected = database.LoadStats{Duplicate: 1, InvalidPow: len(testObj)}
+	if *stats != expected {
+		t.Errorf("LoadObjects (%s to %s): expected %v, got %v", from, to,
+			expected, *stats)
+	}
+
+	// A truncated dump is an error.
+	_, err = database.LoadObjects(dst, bytes.NewReader(dump[:len(dump)-1]))
+	if err == nil {
+		t.Errorf("LoadObjects (%s to %s): expected error loading truncated dump",
+			from, to)
+	}
+}
+
+func TestDumpAndLoad(t *testing.T) {
+	withPow := doPow(obj.NewMessage(0, time.Now().Add(10*time.Minute), 1,
+		[]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+
+	for _, from := range []string{"memdb", "boltdb"} {
+		for _, to := range []string{"memdb", "boltdb"} {
+			testDumpAndLoad(t, from, to, withPow)
+		}
+	}
+}
+
+func TestLoadInvalidDump(t *testing.T) {
+	db, _, teardown, err := createDB("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardown()
+
+	for _, dump := range [][]byte{
+		nil,
+		[]byte("bmdobj"),
+		[]byte("not a dump at all"),
+	} {
+		if _, err := database.LoadObjects(db, bytes.NewReader(dump)); err != database.ErrInvalidDump {
+			t.Errorf("expected ErrInvalidDump loading %q, got %v", dump, err)
+		}
+	}
+}
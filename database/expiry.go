@@ -0,0 +1,295 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+// ExpiredObject describes an object which has been removed from the database
+// by an ExpiryScheduler.
+type ExpiredObject struct {
+	Hash       *hash.Sha
+	ObjectType wire.ObjectType
+	Stream     uint64
+	Expiration time.Time
+}
+
+// ExpiryStats describes the backlog of an ExpiryScheduler.
+type ExpiryStats struct {
+	// Scheduled is the number of objects waiting to be removed.
+	Scheduled int
+
+	// Overdue is the number of scheduled objects whose time to be removed
+	// has already passed.
+	Overdue int
+
+	// Lag is how long ago the oldest overdue object should have been
+	// removed. It is zero if no objects are overdue.
+	Lag time.Duration
+
+	// Removed is the total number of objects which have been removed.
+	Removed uint64
+}
+
+// expiry is an object waiting to be removed.
+type expiry struct {
+	ExpiredObject
+	removeAt time.Time
+}
+
+// expiryQueue implements heap.Interface and holds expiries with the earliest
+// first.
+type expiryQueue []*expiry
+
+func (q expiryQueue) Len() int { return len(q) }
+
+func (q expiryQueue) Less(i, j int) bool {
+	return q[i].removeAt.Before(q[j].removeAt)
+}
+
+func (q expiryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *expiryQueue) Push(x interface{}) {
+	*q = append(*q, x.(*expiry))
+}
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[0 : n-1]
+	return item
+}
+
+// overdue returns the number of expiries in the heap below index i which are
+// to be removed before t.
+func (q expiryQueue) overdue(i int, t time.Time) int {
+	if i >= len(q) || q[i].removeAt.After(t) {
+		return 0
+	}
+	return 1 + q.overdue(2*i+1, t) + q.overdue(2*i+2, t)
+}
+
+// ExpiryScheduler removes each object from the database as soon as it has
+// been expired for ExpiredCacheTime, and notifies its subscribers of every
+// object that it removes. Objects are scheduled for removal as they are
+// inserted through the database's InsertObject.
+type ExpiryScheduler struct {
+	// remove removes an object from the database. It is the database's
+	// RemoveObject function.
+	remove func(*hash.Sha) error
+
+	mtx         sync.Mutex
+	queue       expiryQueue
+	removed     uint64
+	subscribers map[int]func(*ExpiredObject)
+	nextID      int
+
+	wake chan struct{}
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewExpiryScheduler creates an ExpiryScheduler for the database, scheduling
+// the removal of every object already in it. The database's InsertObject
+// function is replaced so that new objects are scheduled as well. Use Start
+// to begin removing objects.
+func NewExpiryScheduler(db *Db) (*ExpiryScheduler, error) {
+	s := &ExpiryScheduler{
+		remove:      db.RemoveObject,
+		subscribers: make(map[int]func(*ExpiredObject)),
+		wake:        make(chan struct{}, 1),
+		quit:        make(chan struct{}),
+	}
+
+	err := db.ForAllObjects(func(h *hash.Sha, o obj.Object) error {
+		s.queue = append(s.queue, newExpiry(h, o.Header()))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	heap.Init(&s.queue)
+
+	insert := db.InsertObject
+	db.InsertObject = func(o obj.Object) (uint64, error) {
+		count, err := insert(o)
+		if err != nil {
+			return count, err
+		}
+
+		s.schedule(newExpiry(obj.InventoryHash(o), o.Header()))
+		return count, nil
+	}
+
+	return s, nil
+}
+
+// newExpiry creates an expiry for the object with the given hash and header.
+func newExpiry(h *hash.Sha, header *wire.ObjectHeader) *expiry {
+	return &expiry{
+		ExpiredObject: ExpiredObject{
+			Hash:       h,
+			ObjectType: header.ObjectType,
+			Stream:     header.StreamNumber,
+			Expiration: header.Expiration(),
+		},
+		removeAt: header.Expiration().Add(-ExpiredCacheTime),
+	}
+}
+
+// schedule adds an expiry to the queue, waking up the scheduler if it is now
+// the first one to be removed.
+func (s *ExpiryScheduler) schedule(e *expiry) {
+	s.mtx.Lock()
+	heap.Push(&s.queue, e)
+	first := s.queue[0] == e
+	s.mtx.Unlock()
+
+	if first {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe adds a function which is called with every object that the
+// scheduler removes. It is called from the scheduler's goroutine, so it must
+// not block for long. The returned function cancels the subscription.
+func (s *ExpiryScheduler) Subscribe(f func(*ExpiredObject)) func() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.subscribers[id] = f
+
+	return func() {
+		s.mtx.Lock()
+		delete(s.subscribers, id)
+		s.mtx.Unlock()
+	}
+}
+
+// Stats returns the current backlog of the scheduler.
+func (s *ExpiryScheduler) Stats() *ExpiryStats {
+	now := time.Now()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	stats := &ExpiryStats{
+		Scheduled: len(s.queue),
+		Overdue:   s.queue.overdue(0, now),
+		Removed:   s.removed,
+	}
+	if stats.Overdue > 0 {
+		stats.Lag = now.Sub(s.queue[0].removeAt)
+	}
+	return stats
+}
+
+// next returns the first expiry in the queue, if any.
+func (s *ExpiryScheduler) next() *expiry {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if len(s.queue) == 0 {
+		return nil
+	}
+	return s.queue[0]
+}
+
+// removeDue removes every object whose time has come from the database and
+// notifies the subscribers.
+func (s *ExpiryScheduler) removeDue() {
+	now := time.Now()
+
+	for {
+		s.mtx.Lock()
+		if len(s.queue) == 0 || s.queue[0].removeAt.After(now) {
+			s.mtx.Unlock()
+			return
+		}
+		e := heap.Pop(&s.queue).(*expiry)
+		s.mtx.Unlock()
+
+		// The object may already have been removed some other way.
+		err := s.remove(e.Hash)
+		if err == ErrNonexistentObject {
+			continue
+		}
+		if err != nil {
+			log.Errorf("Failed to remove expired object %s: %v",
+				e.Hash.String()[:8], err)
+			continue
+		}
+
+		s.mtx.Lock()
+		s.removed++
+		subscribers := make([]func(*ExpiredObject), 0, len(s.subscribers))
+		for _, f := range s.subscribers {
+			subscribers = append(subscribers, f)
+		}
+		s.mtx.Unlock()
+
+		log.Tracef("Expired object %s removed.", e.Hash.String()[:8])
+		for _, f := range subscribers {
+			f(&e.ExpiredObject)
+		}
+	}
+}
+
+// expiryHandler removes objects as they expire. It must be run as a
+// goroutine.
+func (s *ExpiryScheduler) expiryHandler() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			s.removeDue()
+		case <-s.wake:
+		case <-s.quit:
+			s.wg.Done()
+			return
+		}
+
+		// Wait until the next object is to be removed. The timer is
+		// drained first so that it can be reset safely.
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if e := s.next(); e != nil {
+			timer.Reset(e.removeAt.Sub(time.Now()))
+		}
+	}
+}
+
+// Start begins removing objects as they expire.
+func (s *ExpiryScheduler) Start() {
+	s.wg.Add(1)
+	go s.expiryHandler()
+}
+
+// Stop stops removing objects and waits for the scheduler to finish.
+func (s *ExpiryScheduler) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
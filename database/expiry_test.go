@@ -0,0 +1,94 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+func TestExpiryScheduler(t *testing.T) {
+	db, err := database.OpenDB("memdb")
+	if err != nil {
+		t.Fatalf("Failed to open test database %v", err)
+	}
+	defer db.Close()
+
+	// newObject returns an object which is to be removed from the database
+	// after the given duration.
+	newObject := func(nonce uint64, d time.Duration) *wire.MsgObject {
+		exp := time.Now().Add(database.ExpiredCacheTime).Add(d)
+		return wire.NewMsgObject(wire.NewObjectHeader(nonce, exp,
+			wire.ObjectTypeMsg, 1, 1), []byte{1, 2, 3})
+	}
+
+	// An object which is already overdue before the scheduler is created.
+	overdue := newObject(1, -time.Minute)
+	soon := newObject(2, 100*time.Millisecond)
+	later := newObject(3, time.Hour)
+
+	if _, err = db.InsertObject(overdue); err != nil {
+		t.Fatalf("InsertObject: unexpected error %v", err)
+	}
+
+	s, err := database.NewExpiryScheduler(db)
+	if err != nil {
+		t.Fatalf("NewExpiryScheduler: unexpected error %v", err)
+	}
+
+	if _, err = db.InsertObject(later); err != nil {
+		t.Fatalf("InsertObject: unexpected error %v", err)
+	}
+	if _, err = db.InsertObject(soon); err != nil {
+		t.Fatalf("InsertObject: unexpected error %v", err)
+	}
+
+	stats := s.Stats()
+	if stats.Scheduled != 3 || stats.Overdue != 1 || stats.Lag < time.Minute {
+		t.Errorf("Stats: unexpected backlog %+v", stats)
+	}
+
+	removed := make(chan *database.ExpiredObject, 3)
+	cancel := s.Subscribe(func(e *database.ExpiredObject) {
+		removed <- e
+	})
+	defer cancel()
+
+	s.Start()
+	defer s.Stop()
+
+	for i, o := range []*wire.MsgObject{overdue, soon} {
+		select {
+		case e := <-removed:
+			if !e.Hash.IsEqual(obj.InventoryHash(o)) {
+				t.Errorf("Object %d: expected %s to be removed, got %s",
+					i, obj.InventoryHash(o), e.Hash)
+			}
+			if e.ObjectType != wire.ObjectTypeMsg || e.Stream != 1 {
+				t.Errorf("Object %d: unexpected type %s or stream %d",
+					i, e.ObjectType, e.Stream)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Object %d was not removed", i)
+		}
+
+		if exists, _ := db.ExistsObject(obj.InventoryHash(o)); exists {
+			t.Errorf("Object %d still exists after being removed", i)
+		}
+	}
+
+	if exists, _ := db.ExistsObject(obj.InventoryHash(later)); !exists {
+		t.Error("Object which has not expired was removed")
+	}
+
+	stats = s.Stats()
+	if stats.Scheduled != 1 || stats.Overdue != 0 || stats.Removed != 2 {
+		t.Errorf("Stats: unexpected backlog %+v", stats)
+	}
+}
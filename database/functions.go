@@ -4,6 +4,14 @@
 
 package database
 
+import (
+	"bytes"
+	"sort"
+
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
 // RemoveAllIdentities clears all public keys from the database.
 func (db *Db) RemoveAllIdentities() error {
 	a, err := db.GetAllIdentities()
@@ -17,3 +25,55 @@ func (db *Db) RemoveAllIdentities() error {
 
 	return nil
 }
+
+// InStreams returns whether the given stream number is in the list of streams.
+// An empty list is taken to include every stream. It is the one place where
+// membership of a stream is decided, for the database as well as for the
+// server and its peers.
+func InStreams(stream uint64, streams []uint32) bool {
+	if len(streams) == 0 {
+		return true
+	}
+
+	for _, s := range streams {
+		if uint64(s) == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// invVects sorts inventory vectors in increasing order.
+type invVects []*wire.InvVect
+
+func (v invVects) Len() int {
+	return len(v)
+}
+
+func (v invVects) Less(i, j int) bool {
+	return bytes.Compare(v[i][:], v[j][:]) < 0
+}
+
+func (v invVects) Swap(i, j int) {
+	v[i], v[j] = v[j], v[i]
+}
+
+// InvHashesAfter returns at most count of the given inventory hashes which
+// come after the given hash, in increasing order. A nil hash is before every
+// other. It is used by database drivers which do not store objects in order
+// to implement FetchInvHashesAfter.
+func InvHashesAfter(hashes []*wire.InvVect, after *hash.Sha, count uint64) []*wire.InvVect {
+	res := make([]*wire.InvVect, 0, len(hashes))
+	for _, h := range hashes {
+		if after == nil || bytes.Compare(h[:], after[:]) > 0 {
+			res = append(res, h)
+		}
+	}
+
+	sort.Sort(invVects(res))
+
+	if uint64(len(res)) > count {
+		res = res[:count]
+	}
+	return res
+}
@@ -222,9 +222,10 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 	defer tc.teardown()
 
 	objType := testObj.Header().ObjectType
+	stream := uint32(testObj.Header().StreamNumber)
 
 	// Test that the counter starts at zero.
-	count, err := tc.db.GetCounter(objType)
+	count, err := tc.db.GetCounter(objType, stream)
 	if err != nil {
 		tc.t.Errorf("GetCounter (%s): object type %s, got error %v.",
 			tc.dbType, objType, err)
@@ -234,14 +235,14 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 	}
 
 	// Try to grab an element that is not there.
-	_, err = tc.db.FetchObjectByCounter(objType, 1)
+	_, err = tc.db.FetchObjectByCounter(objType, stream, 1)
 	if err == nil {
 		tc.t.Errorf("FetchObjectByCounter (%s): fetching nonexistent"+
 			" object, expected error got none", tc.dbType)
 	}
 
 	// Try to remove an element that is not there.
-	err = tc.db.RemoveObjectByCounter(objType, 1)
+	err = tc.db.RemoveObjectByCounter(objType, stream, 1)
 	if err == nil {
 		tc.t.Errorf("RemoveObjectByCounter (%s): removing nonexistent"+
 			" object of type %s, expected error got none",
@@ -261,7 +262,7 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 	}
 
 	// Try to fetch an object that should be there now.
-	testMsg, err := tc.db.FetchObjectByCounter(objType, 1)
+	testMsg, err := tc.db.FetchObjectByCounter(objType, stream, 1)
 	obj.InventoryHash(testMsg) // to make sure it's equal
 
 	if err != nil {
@@ -284,7 +285,7 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 	}
 
 	// Try fetching the new object.
-	testMsg, err = tc.db.FetchObjectByCounter(objType, 2)
+	testMsg, err = tc.db.FetchObjectByCounter(objType, stream, 2)
 	if err != nil {
 		tc.t.Fatalf("Could not retrieve object: ", err)
 	}
@@ -299,7 +300,7 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 	}
 
 	// Test that the counter has incremented.
-	count, err = tc.db.GetCounter(objType)
+	count, err = tc.db.GetCounter(objType, stream)
 	if err != nil {
 		tc.t.Errorf("GetCounter (%s): object type %s, got error %v",
 			tc.dbType, objType, err)
@@ -310,7 +311,7 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 	}
 
 	// Test FetchObjectsFromCounter for various input values.
-	fetch, n, err := tc.db.FetchObjectsFromCounter(objType, 3, 2)
+	fetch, n, err := tc.db.FetchObjectsFromCounter(objType, stream, 3, 2)
 	if err != nil {
 		tc.t.Errorf("FetchObjectsFromCounter (%s): object type %s, "+
 			"expected empty slice got error %v", tc.dbType, objType, err)
@@ -326,7 +327,7 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 			objType, n)
 	}
 
-	fetch, n, err = tc.db.FetchObjectsFromCounter(objType, 1, 3)
+	fetch, n, err = tc.db.FetchObjectsFromCounter(objType, stream, 1, 3)
 	if err != nil {
 		tc.t.Errorf("FetchObjectsFromCounter (%s): object type %s,"+
 			" got error %v", tc.dbType, objType, err)
@@ -342,7 +343,7 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 			objType, n)
 	}
 
-	fetch, n, err = tc.db.FetchObjectsFromCounter(objType, 1, 1)
+	fetch, n, err = tc.db.FetchObjectsFromCounter(objType, stream, 1, 1)
 	if err != nil {
 		tc.t.Errorf("FetchObjectsFromCounter (%s): object type %s,"+
 			" got error %v", tc.dbType, objType, err)
@@ -358,7 +359,7 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 			objType, n)
 	}
 
-	fetch, n, err = tc.db.FetchObjectsFromCounter(objType, 2, 3)
+	fetch, n, err = tc.db.FetchObjectsFromCounter(objType, stream, 2, 3)
 	if err != nil {
 		tc.t.Errorf("FetchObjectsFromCounter (%s): object type %s,"+
 			" got error %v", tc.dbType, objType, err)
@@ -375,28 +376,28 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 	}
 
 	// Test that objects can be removed after being added.
-	err = tc.db.RemoveObjectByCounter(objType, 1)
+	err = tc.db.RemoveObjectByCounter(objType, stream, 1)
 	if err != nil {
 		tc.t.Errorf("RemoveObjectByCounter (%s): object type %s,"+
 			" got error %v", tc.dbType, objType, err)
 	}
 
 	// Removing an object that has already been removed.
-	err = tc.db.RemoveObjectByCounter(objType, 1)
+	err = tc.db.RemoveObjectByCounter(objType, stream, 1)
 	if err == nil {
 		tc.t.Errorf("RemoveObjectByCounter (%s): removing already removed"+
 			" object of type %s, got no error", tc.dbType, objType)
 	}
 
 	// Removing a nonexistent object
-	err = tc.db.RemoveObjectByCounter(objType, 3)
+	err = tc.db.RemoveObjectByCounter(objType, stream, 3)
 	if err == nil {
 		tc.t.Errorf("RemoveObjectByCounter (%s): removing nonexistent"+
 			" object of type %s, got no error", tc.dbType, objType)
 	}
 
 	// Test that objects cannot be fetched after being removed.
-	_, err = tc.db.FetchObjectByCounter(objType, 1)
+	_, err = tc.db.FetchObjectByCounter(objType, stream, 1)
 	if err == nil {
 		tc.t.Errorf("FetchObjectByCounter (%s): fetching nonexistent"+
 			" object of type %s, got no error", tc.dbType, objType)
@@ -404,7 +405,7 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 
 	// Test that the counter values returned by FetchObjectsFromCounter are
 	// correct after some objects have been removed.
-	fetch, n, err = tc.db.FetchObjectsFromCounter(objType, 1, 3)
+	fetch, n, err = tc.db.FetchObjectsFromCounter(objType, stream, 1, 3)
 	if err != nil {
 		tc.t.Errorf("FetchObjectByCounter (%s): object type %s,"+
 			" got error %v", tc.dbType, objType, err)
@@ -419,6 +420,51 @@ func testCounter(tc *testContext, testObj, expiredTestObj obj.Object) {
 			" incorrect counter value, expected 2 got %d", tc.dbType,
 			objType, n)
 	}
+
+	// Test that each stream has its own counters.
+	other := inStream(testObj, byte(stream+1))
+	count, err = tc.db.InsertObject(other)
+	if err != nil {
+		tc.t.Errorf("InsertObject (%s): object of type %s in stream %d,"+
+			" got error %v", tc.dbType, objType, stream+1, err)
+	}
+	if count != 1 {
+		tc.t.Errorf("InsertObject (%s): object of type %s in stream %d,"+
+			" expected counter 1, got %d", tc.dbType, objType, stream+1, count)
+	}
+
+	count, err = tc.db.GetCounter(objType, stream)
+	if err != nil {
+		tc.t.Errorf("GetCounter (%s): object type %s, got error %v",
+			tc.dbType, objType, err)
+	}
+	if count != 2 {
+		tc.t.Errorf("GetCounter (%s): object type %s, expected 2 after insert"+
+			" in stream %d, got %d", tc.dbType, objType, stream+1, count)
+	}
+
+	fetch, _, err = tc.db.FetchObjectsFromCounter(objType, stream+1, 1, 3)
+	if err != nil {
+		tc.t.Errorf("FetchObjectsFromCounter (%s): object type %s in stream %d,"+
+			" got error %v", tc.dbType, objType, stream+1, err)
+	}
+	if len(fetch) != 1 || !reflect.DeepEqual(wire.Encode(fetch[0].Object), wire.Encode(other)) {
+		tc.t.Errorf("FetchObjectsFromCounter (%s): object type %s in stream %d,"+
+			" expected only the object in the stream", tc.dbType, objType, stream+1)
+	}
+}
+
+// inStream returns a copy of the given object in another stream. The version
+// and stream of the object must take one byte each.
+func inStream(o obj.Object, stream byte) obj.Object {
+	b := wire.Encode(o)
+	b[21] = stream // After the nonce, expiration, object type and version.
+
+	o, err := obj.ReadObject(b)
+	if err != nil {
+		panic(err.Error())
+	}
+	return o
 }
 
 func makeIdentity(passphrase string, version uint64, expiration time.Duration) (Address, obj.Object) {
@@ -468,7 +514,7 @@ func testPubKey(tc *testContext) {
 		tc.t.Errorf("InsertObject (%s): inserting invalid pubkey, got error %v",
 			tc.dbType, err)
 	}
-	count, _ := tc.db.GetCounter(wire.ObjectTypePubKey)
+	count, _ := tc.db.GetCounter(wire.ObjectTypePubKey, 1)
 	if count != 1 {
 		tc.t.Errorf("GetCounter (%s): got %d expected %d", tc.dbType, count, 1)
 	}
@@ -644,7 +690,7 @@ func testPubKey(tc *testContext) {
 	}
 }
 
-// tests FetchRandomInvHashes and FilterObjects
+// tests FetchRandomInvHashes, FetchInvHashesAfter and FilterObjects
 func testFilters(tc *testContext) {
 	defer tc.teardown()
 
@@ -656,16 +702,20 @@ func testFilters(tc *testContext) {
 
 	type randomInvHashesTest struct {
 		count         uint64
+		streams       []uint32
 		expectedCount int
 	}
 
 	randomInvHashesTests := []randomInvHashesTest{
-		{2, 2},
-		{15, 12},
+		{2, nil, 2},
+		{15, nil, 12},
+		{15, []uint32{1}, 12},
+		{15, []uint32{1, 2}, 12},
+		{15, []uint32{2}, 0},
 	}
 
 	for i, tst := range randomInvHashesTests {
-		hashes, err := tc.db.FetchRandomInvHashes(tst.count)
+		hashes, err := tc.db.FetchRandomInvHashes(tst.count, tst.streams...)
 		if err != nil {
 			tc.t.Fatalf("FetchRandomInvHashes (%s): test #%d, got error %v",
 				tc.dbType, i, err)
@@ -675,6 +725,58 @@ func testFilters(tc *testContext) {
 				" expected %d", tc.dbType, i, len(hashes), tst.expectedCount)
 		}
 	}
+
+	all, err := tc.db.FetchRandomInvHashes(100)
+	if err != nil {
+		tc.t.Fatalf("FetchRandomInvHashes (%s): got error %v", tc.dbType, err)
+	}
+
+	// Page through the inventory a few hashes at a time.
+	var after *hash.Sha
+	seen := make(map[wire.InvVect]struct{})
+	for {
+		hashes, err := tc.db.FetchInvHashesAfter(after, 5)
+		if err != nil {
+			tc.t.Fatalf("FetchInvHashesAfter (%s): got error %v", tc.dbType, err)
+		}
+		if len(hashes) > 5 {
+			tc.t.Fatalf("FetchInvHashesAfter (%s): got length %d, expected at"+
+				" most 5", tc.dbType, len(hashes))
+		}
+
+		for _, h := range hashes {
+			if after != nil && bytes.Compare(h[:], after[:]) <= 0 {
+				tc.t.Errorf("FetchInvHashesAfter (%s): hash %x returned after %x",
+					tc.dbType, h[:], after[:])
+			}
+			seen[*h] = struct{}{}
+			after = (*hash.Sha)(h)
+		}
+
+		if len(hashes) < 5 {
+			break
+		}
+	}
+
+	if len(seen) != len(all) {
+		tc.t.Errorf("FetchInvHashesAfter (%s): got %d hashes, expected %d",
+			tc.dbType, len(seen), len(all))
+	}
+	for _, h := range all {
+		if _, ok := seen[*h]; !ok {
+			tc.t.Errorf("FetchInvHashesAfter (%s): hash %x not returned",
+				tc.dbType, h[:])
+		}
+	}
+
+	hashes, err := tc.db.FetchInvHashesAfter(nil, 15, 2)
+	if err != nil {
+		tc.t.Fatalf("FetchInvHashesAfter (%s): got error %v", tc.dbType, err)
+	}
+	if len(hashes) != 0 {
+		tc.t.Errorf("FetchInvHashesAfter (%s): got %d hashes in stream 2",
+			tc.dbType, len(hashes))
+	}
 }
 
 func testRemoveExpiredObjects(tc *testContext) {
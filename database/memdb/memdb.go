@@ -22,9 +22,15 @@ import (
 	"github.com/DanielKrawisz/bmutil/wire/obj"
 )
 
-// expiredSliceSize is the initial capacity of the slice that holds hashes of
-// expired objects returned by RemoveExpiredObjects.
-const expiredSliceSize = 50
+const (
+	// expiredSliceSize is the initial capacity of the slice that holds hashes
+	// of expired objects returned by RemoveExpiredObjects.
+	expiredSliceSize = 50
+
+	// objectTypeUnknown is the counter type shared by all objects of
+	// unknown types.
+	objectTypeUnknown wire.ObjectType = wire.ObjectType(999)
+)
 
 // counters type serves to enable sorting of uint64 slices using sort.Sort
 // function. Implements sort.Interface.
@@ -51,11 +57,32 @@ type counter struct {
 	CounterPos uint64
 }
 
+// newCounter returns a counter with no elements.
+func newCounter() *counter {
+	return &counter{make(map[uint64]*hash.Sha), 0}
+}
+
 func (cmap *counter) Insert(hash *hash.Sha) {
 	cmap.CounterPos++                      // increment, new item.
 	cmap.ByCounter[cmap.CounterPos] = hash // insert to counter map
 }
 
+// counterKey identifies a counter. Each stream has a counter for every known
+// object type and one which all unknown object types share.
+type counterKey struct {
+	objType wire.ObjectType
+	stream  uint32
+}
+
+// newCounterKey returns the key of the counter for objects of the given type
+// in the given stream.
+func newCounterKey(objType wire.ObjectType, stream uint32) counterKey {
+	if objType > wire.HighestKnownObjectType {
+		objType = objectTypeUnknown
+	}
+	return counterKey{objType: objType, stream: stream}
+}
+
 // newMemDb returns a new memory-only database ready for object insertion.
 // It is a concrete implementation of the database.Db which
 // provides a memory-only database. Since it is memory-only, it is obviously not
@@ -72,27 +99,13 @@ func newMemDb() *database.Db {
 	objectsByHash := make(map[hash.Sha]obj.Object)
 	encryptedPubKeyByTag := make(map[hash.Sha]obj.Object)
 	pubIDByAddress := make(map[string]identity.Public)
-	msgCounter := &counter{make(map[uint64]*hash.Sha), 0}
-	broadcastCounter := &counter{make(map[uint64]*hash.Sha), 0}
-	pubKeyCounter := &counter{make(map[uint64]*hash.Sha), 0}
-	getPubKeyCounter := &counter{make(map[uint64]*hash.Sha), 0}
-	unknownObjCounter := &counter{make(map[uint64]*hash.Sha), 0}
-
-	// getCounterMap is a helper function used to get the map which maps counter to
-	// object hash based on `objType'.
-	getCounter := func(objType wire.ObjectType) *counter {
-		switch objType {
-		case wire.ObjectTypeBroadcast:
-			return broadcastCounter
-		case wire.ObjectTypeMsg:
-			return msgCounter
-		case wire.ObjectTypePubKey:
-			return pubKeyCounter
-		case wire.ObjectTypeGetPubKey:
-			return getPubKeyCounter
-		default:
-			return unknownObjCounter
-		}
+	counterMaps := make(map[counterKey]*counter)
+
+	// getCounter is a helper function used to get the map which maps counter to
+	// object hash based on `objType' and `stream'. It returns nil if no object
+	// of the type has ever been inserted in the stream.
+	getCounter := func(objType wire.ObjectType, stream uint32) *counter {
+		return counterMaps[newCounterKey(objType, stream)]
 	}
 
 	// No locks here, meant to be used inside public facing functions.
@@ -154,11 +167,7 @@ func newMemDb() *database.Db {
 			objectsByHash = nil
 			encryptedPubKeyByTag = nil
 			pubIDByAddress = nil
-			msgCounter = nil
-			broadcastCounter = nil
-			pubKeyCounter = nil
-			getPubKeyCounter = nil
-			unknownObjCounter = nil
+			counterMaps = nil
 			closed = true
 			return nil
 		},
@@ -193,18 +202,22 @@ func newMemDb() *database.Db {
 		},
 
 		// FetchObjectByCounter returns the corresponding object based on the
-		// counter. Note that each object type has a different counter, with unknown
-		// objects being consolidated into one counter. Counters are meant for use
-		// as a convenience method for fetching new data from database since last
-		// check.
-		FetchObjectByCounter: func(objType wire.ObjectType, counter uint64) (obj.Object, error) {
+		// counter. Note that each stream has a different counter for each object
+		// type, with unknown objects being consolidated into one counter.
+		// Counters are meant for use as a convenience method for fetching new
+		// data from database since last check.
+		FetchObjectByCounter: func(objType wire.ObjectType, stream uint32,
+			counter uint64) (obj.Object, error) {
 			mtx.RLock()
 			defer mtx.RUnlock()
 			if closed {
 				return nil, database.ErrDbClosed
 			}
 
-			counterMap := getCounter(objType)
+			counterMap := getCounter(objType, stream)
+			if counterMap == nil {
+				return nil, database.ErrNonexistentObject
+			}
 			hash, ok := counterMap.ByCounter[counter]
 			if !ok {
 				return nil, database.ErrNonexistentObject
@@ -213,19 +226,22 @@ func newMemDb() *database.Db {
 			return obj, nil
 		},
 
-		// FetchObjectsFromCounter returns a slice of `count' objects which have a
-		// counter position starting from `counter'. It also returns the counter
-		// value of the last object, which could be useful for more queries to the
-		// function.
-		FetchObjectsFromCounter: func(objType wire.ObjectType, counter uint64,
-			count uint64) ([]database.ObjectWithCounter, uint64, error) {
+		// FetchObjectsFromCounter returns a slice of `count' objects of the given
+		// type in the given stream which have a counter position starting from
+		// `counter'. It also returns the counter value of the last object, which
+		// could be useful for more queries to the function.
+		FetchObjectsFromCounter: func(objType wire.ObjectType, stream uint32,
+			counter uint64, count uint64) ([]database.ObjectWithCounter, uint64, error) {
 			mtx.RLock()
 			defer mtx.RUnlock()
 			if closed {
 				return nil, 0, database.ErrDbClosed
 			}
 
-			counterMap := getCounter(objType)
+			counterMap := getCounter(objType, stream)
+			if counterMap == nil {
+				return []database.ObjectWithCounter{}, 0, nil
+			}
 
 			var c uint64 // count
 
@@ -320,9 +336,9 @@ func newMemDb() *database.Db {
 
 		// FetchRandomInvHashes returns at most the specified number of
 		// inventory hashes corresponding to random unexpired objects from
-		// the database. It does not guarantee that the number of returned
+		// the database in the given streams. It does not guarantee that the number of returned
 		// inventory vectors would be `count'.
-		FetchRandomInvHashes: func(count uint64) ([]*wire.InvVect, error) {
+		FetchRandomInvHashes: func(count uint64, streams ...uint32) ([]*wire.InvVect, error) {
 			mtx.RLock()
 			defer mtx.RUnlock()
 			if closed {
@@ -348,7 +364,7 @@ func newMemDb() *database.Db {
 					delete(objectsByHash, hash)
 				}
 
-				if t.Before(expiration) {
+				if t.Before(expiration) && database.InStreams(o.Header().StreamNumber, streams) {
 					res = append(res, (*wire.InvVect)(&hash))
 					counter++
 				}
@@ -357,21 +373,47 @@ func newMemDb() *database.Db {
 			return res, nil
 		},
 
+		// FetchInvHashesAfter returns at most the specified number of
+		// inventory hashes corresponding to unexpired objects in increasing
+		// order, starting after the given hash.
+		FetchInvHashesAfter: func(after *hash.Sha, count uint64, streams ...uint32) ([]*wire.InvVect, error) {
+			mtx.RLock()
+			defer mtx.RUnlock()
+			if closed {
+				return nil, database.ErrDbClosed
+			}
+
+			t := time.Now()
+			hashes := make([]*wire.InvVect, 0, len(objectsByHash))
+			for h, o := range objectsByHash {
+				header := o.Header()
+				if t.Before(header.Expiration()) && database.InStreams(header.StreamNumber, streams) {
+					inv := wire.InvVect(h)
+					hashes = append(hashes, &inv)
+				}
+			}
+
+			return database.InvHashesAfter(hashes, after, count), nil
+		},
+
 		// GetCounter returns the highest value of counter that exists for objects
-		// of the given type.
-		GetCounter: func(objType wire.ObjectType) (uint64, error) {
+		// of the given type in the given stream.
+		GetCounter: func(objType wire.ObjectType, stream uint32) (uint64, error) {
 			mtx.RLock()
 			defer mtx.RUnlock()
 			if closed {
 				return 0, database.ErrDbClosed
 			}
 
-			c := getCounter(objType)
+			c := getCounter(objType, stream)
+			if c == nil {
+				return 0, nil
+			}
 			return c.CounterPos, nil
 		},
 
 		// InsertObject inserts the given object into the database and returns the
-		// counter position. If the object is a PubKey, it inserts it into a
+		// counter position within its stream. If the object is a PubKey, it inserts it into a
 		// separate place where it isn't touched by RemoveObject or
 		// RemoveExpiredObjects and has to be removed using RemovePubKey.
 		InsertObject: func(o obj.Object) (uint64, error) {
@@ -393,7 +435,13 @@ func newMemDb() *database.Db {
 			objectsByHash[*hash] = object
 
 			// increment counter
-			counterMap := getCounter(o.Header().ObjectType)
+			header := o.Header()
+			key := newCounterKey(header.ObjectType, uint32(header.StreamNumber))
+			counterMap, ok := counterMaps[key]
+			if !ok {
+				counterMap = newCounter()
+				counterMaps[key] = counterMap
+			}
 			counterMap.Insert(hash)
 			pos := counterMap.CounterPos
 
@@ -420,7 +468,8 @@ func newMemDb() *database.Db {
 			}
 
 			// check and remove object from counter maps
-			counterMap := getCounter(obj.Header().ObjectType)
+			header := obj.Header()
+			counterMap := getCounter(header.ObjectType, uint32(header.StreamNumber))
 
 			for k, v := range counterMap.ByCounter { // go through each element
 				if v.IsEqual(hash) { // we got a match, so delete
@@ -435,16 +484,20 @@ func newMemDb() *database.Db {
 			return nil
 		},
 
-		// RemoveObjectByCounter removes the object with the specified counter value
-		// from the database.
-		RemoveObjectByCounter: func(objType wire.ObjectType, counter uint64) error {
+		// RemoveObjectByCounter removes the object of the given type in the given
+		// stream with the specified counter value from the database.
+		RemoveObjectByCounter: func(objType wire.ObjectType, stream uint32,
+			counter uint64) error {
 			mtx.Lock()
 			defer mtx.Unlock()
 			if closed {
 				return database.ErrDbClosed
 			}
 
-			counterMap := getCounter(objType)
+			counterMap := getCounter(objType, stream)
+			if counterMap == nil {
+				return database.ErrNonexistentObject
+			}
 			hash, ok := counterMap.ByCounter[counter]
 			if !ok {
 				return database.ErrNonexistentObject
@@ -474,7 +527,8 @@ func newMemDb() *database.Db {
 				header := obj.Header()
 				if t.After(header.Expiration()) { // expired
 					// remove from counter map
-					counterMap := getCounter(header.ObjectType)
+					counterMap := getCounter(header.ObjectType,
+						uint32(header.StreamNumber))
 
 					for k, v := range counterMap.ByCounter { // go through each element
 						if v.IsEqual(&hash) { // we got a match, so delete
@@ -562,7 +616,7 @@ func newMemDb() *database.Db {
 			for h, o := range objectsByHash {
 				err := f(&h, o)
 				if err != nil {
-					return nil
+					return err
 				}
 			}
 
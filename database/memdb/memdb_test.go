@@ -50,21 +50,21 @@ func TestClosed(t *testing.T) {
 		t.Errorf("RemoveExpiredObjects: unexpected error %v", err)
 	}
 
-	_, err = db.FetchObjectByCounter(wire.ObjectType(4), 1)
+	_, err = db.FetchObjectByCounter(wire.ObjectType(4), 1, 1)
 	if err != database.ErrDbClosed {
 		t.Errorf("FetchObjectByCounter: unexpected error %v", err)
 	}
 
-	_, _, err = db.FetchObjectsFromCounter(wire.ObjectType(4), 1, 10)
+	_, _, err = db.FetchObjectsFromCounter(wire.ObjectType(4), 1, 1, 10)
 	if err != database.ErrDbClosed {
 		t.Errorf("FetchObjectsFromCounter: unexpected error %v", err)
 	}
 
-	if _, err := db.GetCounter(wire.ObjectType(4)); err != database.ErrDbClosed {
+	if _, err := db.GetCounter(wire.ObjectType(4), 1); err != database.ErrDbClosed {
 		t.Errorf("GetCounter: unexpected error %v", err)
 	}
 
-	if err := db.RemoveObjectByCounter(wire.ObjectType(4), 3); err !=
+	if err := db.RemoveObjectByCounter(wire.ObjectType(4), 1, 3); err !=
 		database.ErrDbClosed {
 		t.Errorf("RemoveObjectByCounter: unexpected error %v", err)
 	}
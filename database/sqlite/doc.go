@@ -0,0 +1,44 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package sqlite implements an instance of the database package backed by
+SQLite, using a pure Go SQLite implementation so that no cgo is required.
+
+Because the database is an ordinary SQLite file, it can be inspected and backed
+up with standard SQL tools while bmd is not writing to it. The schema is:
+
+	objects
+	  hash        BLOB     inventory hash (32 bytes), primary key
+	  type        INTEGER  counter type: the object type, or 999 for all
+	                       unknown object types
+	  counter     INTEGER  counter value within the type and stream
+	  expiration  INTEGER  expiration time as a unix timestamp
+	  stream      INTEGER  stream number
+	  data        BLOB     object in its wire encoding
+	  (indexed on type, stream and counter, and on expiration)
+
+	counters
+	  type        INTEGER  counter type
+	  stream      INTEGER  stream number
+	  position    INTEGER  last assigned counter value
+	  (primary key is type and stream)
+
+	encrypted_pubkeys
+	  tag         BLOB     tag (32 bytes), primary key
+	  data        BLOB     encrypted pubkey in its wire encoding
+
+	identities
+	  address         TEXT     address starting with BM-, primary key
+	  nonce_trials    INTEGER
+	  extra_bytes     INTEGER
+	  behavior        INTEGER
+	  signing_key     BLOB     compressed public key (33 bytes)
+	  encryption_key  BLOB     compressed public key (33 bytes)
+
+	misc
+	  key    TEXT  primary key
+	  value  BLOB  ("version" -> uint8)
+*/
+package sqlite
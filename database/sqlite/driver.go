@@ -0,0 +1,109 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/database"
+	"github.com/btcsuite/btclog"
+	_ "modernc.org/sqlite" // Registers the sqlite driver for database/sql.
+)
+
+const (
+	// latestDbVersion is the most recent version of database.
+	latestDbVersion = 0x02
+)
+
+var log = btclog.Disabled
+
+// Now is a function which returns the current time. It may be given to
+// OpenDB in order to control time in tests.
+type Now func() time.Time
+
+func init() {
+	driver := database.DriverDB{DbType: "sqlite", OpenDB: OpenDB}
+	database.AddDBDriver(driver)
+}
+
+// parseString parses the arguments from the database package Open/Create methods.
+func parseString(funcName string, arg interface{}) (string, error) {
+	dbPath, ok := arg.(string)
+	if !ok {
+		return "", fmt.Errorf("First argument to sqlite.%s is invalid -- "+
+			"expected string", funcName)
+	}
+	return dbPath, nil
+}
+
+// parseStats parses the arguments from the database package Open/Create methods.
+func parseStats(funcName string, arg interface{}) (database.Stats, error) {
+	z, ok := arg.(database.Stats)
+	if !ok {
+		return database.Stats{}, fmt.Errorf("Second argument to sqlite.%s is "+
+			"invalid -- expected database.Stats", funcName)
+	}
+	return z, nil
+}
+
+// parseNow parses a function that is used to tell the current time.
+func parseNow(argNumber int, funcName string, arg interface{}) (Now, error) {
+	z, ok := arg.(Now)
+	if !ok {
+		return nil, fmt.Errorf("argument %d of to sqlite.%s is invalid -- "+
+			"expected Now", argNumber, funcName)
+	}
+	return z, nil
+}
+
+// OpenDB opens a database, initializing it if necessary.
+func OpenDB(args ...interface{}) (*database.Db, error) {
+	if len(args) == 0 {
+		return nil, errors.New("Path to database required.")
+	}
+
+	if len(args) > 3 {
+		return nil, errors.New("Too many arguments for OpenDB.")
+	}
+
+	dbpath, err := parseString("OpenDB", args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	log = database.GetLog()
+	now := time.Now
+	z := database.NewDisabledStatsRecorder()
+
+	if len(args) >= 2 {
+		z, err = parseStats("OpenDB", args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) >= 3 {
+		now, err = parseNow(3, "OpenDB", args[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open("sqlite", dbpath)
+	if err != nil {
+		return nil, err
+	}
+
+	sdb, err := NewSqliteDB(db, z, now)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return sdb, nil
+}
@@ -0,0 +1,850 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmutil"
+	"github.com/DanielKrawisz/bmutil/cipher"
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/identity"
+	"github.com/DanielKrawisz/bmutil/pow"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+const (
+	// expiredSliceSize is the initial capacity of the slice that holds hashes
+	// of expired objects returned by RemoveExpiredObjects.
+	expiredSliceSize = 300
+
+	// forAllObjectsBatchSize is the number of objects read at a time by
+	// ForAllObjects.
+	forAllObjectsBatchSize = 100
+
+	// objectTypeUnknown is the counter type shared by all objects of
+	// unknown types.
+	objectTypeUnknown wire.ObjectType = wire.ObjectType(999)
+)
+
+// schema creates the tables and indexes of the database.
+const schema = `
+CREATE TABLE IF NOT EXISTS objects (
+	hash       BLOB    PRIMARY KEY,
+	type       INTEGER NOT NULL,
+	counter    INTEGER NOT NULL,
+	expiration INTEGER NOT NULL,
+	stream     INTEGER NOT NULL,
+	data       BLOB    NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS objects_by_counter ON objects (type, stream, counter);
+CREATE INDEX IF NOT EXISTS objects_by_expiration ON objects (expiration);
+
+CREATE TABLE IF NOT EXISTS counters (
+	type     INTEGER NOT NULL,
+	stream   INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	PRIMARY KEY (type, stream)
+);
+
+CREATE TABLE IF NOT EXISTS encrypted_pubkeys (
+	tag  BLOB PRIMARY KEY,
+	data BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS identities (
+	address        TEXT    PRIMARY KEY,
+	nonce_trials   INTEGER NOT NULL,
+	extra_bytes    INTEGER NOT NULL,
+	behavior       INTEGER NOT NULL,
+	signing_key    BLOB    NOT NULL,
+	encryption_key BLOB    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS misc (
+	key   TEXT PRIMARY KEY,
+	value BLOB NOT NULL
+);
+`
+
+// upgradeCounters upgrades a version 1 database, in which there was a counter
+// for each object type, to have a counter for each type in each stream.
+// Objects keep their counter values, and each stream with objects of a type
+// continues from the position which the type had before, so that no counter
+// value is given out twice.
+const upgradeCounters = `
+DROP INDEX objects_by_counter;
+CREATE UNIQUE INDEX objects_by_counter ON objects (type, stream, counter);
+
+ALTER TABLE counters RENAME TO counters_by_type;
+CREATE TABLE counters (
+	type     INTEGER NOT NULL,
+	stream   INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	PRIMARY KEY (type, stream)
+);
+INSERT INTO counters (type, stream, position)
+	SELECT DISTINCT c.type, o.stream, c.position
+	FROM counters_by_type c JOIN objects o ON o.type = c.type;
+DROP TABLE counters_by_type;
+`
+
+// versionKey is the key in the misc table under which the database version
+// is stored.
+const versionKey = "version"
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// counterType returns the type under which objects of the given type are
+// counted. Objects of all unknown types share a single counter.
+func counterType(objType wire.ObjectType) wire.ObjectType {
+	if objType > wire.HighestKnownObjectType {
+		return objectTypeUnknown
+	}
+	return objType
+}
+
+// decodeObject decodes an object as it is stored in the database.
+func decodeObject(b []byte) (obj.Object, error) {
+	o, err := obj.DecodeObject(bytes.NewReader(b))
+	if err != nil {
+		log.Criticalf("Decoding object failed: %v", err)
+		return nil, err
+	}
+	return o, nil
+}
+
+// affected returns database.ErrNonexistentObject if a statement did not
+// change any rows.
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return database.ErrNonexistentObject
+	}
+	return nil
+}
+
+// putIdentity stores a public identity.
+func putIdentity(q querier, address string, powData *pow.Data, behavior uint32,
+	signingKey, encryptionKey []byte) error {
+	_, err := q.Exec(`INSERT OR REPLACE INTO identities (address, nonce_trials,
+		extra_bytes, behavior, signing_key, encryption_key) VALUES (?, ?, ?, ?, ?, ?)`,
+		address, int64(powData.NonceTrialsPerByte), int64(powData.ExtraBytes),
+		int64(behavior), signingKey, encryptionKey)
+	return err
+}
+
+// getIdentity returns the public identity with the given address if it has
+// been stored.
+func getIdentity(q querier, addr bmutil.Address) (identity.Public, error) {
+	address := addr.String()
+
+	var nonceTrials, extraBytes, behavior int64
+	var sig, enc []byte
+	err := q.QueryRow(`SELECT nonce_trials, extra_bytes, behavior, signing_key,
+		encryption_key FROM identities WHERE address = ?`, address).Scan(
+		&nonceTrials, &extraBytes, &behavior, &sig, &enc)
+	if err == sql.ErrNoRows {
+		return nil, database.ErrNonexistentObject
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sigKey, err := wire.NewPubKey(sig)
+	if err != nil {
+		log.Criticalf("Failed to parse public signing key for %s: %v",
+			address, err)
+		return nil, err
+	}
+
+	signKey, err := identity.NewPubKey(sigKey)
+	if err != nil {
+		log.Criticalf("Failed to parse public signing key for %s: %v",
+			address, err)
+		return nil, err
+	}
+
+	encKeyData, err := wire.NewPubKey(enc)
+	if err != nil {
+		log.Criticalf("Failed to parse public encryption key for %s: %v",
+			address, err)
+		return nil, err
+	}
+
+	encKey, err := identity.NewPubKey(encKeyData)
+	if err != nil {
+		log.Criticalf("Failed to parse public encryption key for %s: %v",
+			address, err)
+		return nil, err
+	}
+
+	return identity.NewPublic(
+		&identity.PublicKey{
+			Verification: signKey,
+			Encryption:   encKey,
+		},
+		addr.Version(), addr.Stream(),
+		uint32(behavior),
+		&pow.Data{
+			NonceTrialsPerByte: uint64(nonceTrials),
+			ExtraBytes:         uint64(extraBytes),
+		})
+}
+
+// insertPubkey inserts a pubkey into the identities or encrypted pubkeys
+// table. It's a helper function called from within InsertObject.
+func insertPubkey(q querier, o obj.Object) error {
+	switch pubkeyMsg := o.(type) {
+	case *obj.SimplePubKey:
+		id, err := cipher.ToIdentity(pubkeyMsg)
+		if err != nil {
+			return err
+		}
+
+		data := pubkeyMsg.Data()
+		return putIdentity(q, id.Address().String(), &pow.Default,
+			data.Behavior, data.Verification.Bytes(), data.Encryption.Bytes())
+
+	case *obj.ExtendedPubKey:
+		id, err := cipher.ToIdentity(pubkeyMsg)
+		if err != nil {
+			return err
+		}
+
+		var b bytes.Buffer
+		pubkeyMsg.Encode(&b)
+
+		_, err = q.Exec(`INSERT OR REPLACE INTO encrypted_pubkeys (tag, data)
+			VALUES (?, ?)`, bmutil.Tag(id.Address())[:], b.Bytes())
+		return err
+
+	case *obj.EncryptedPubKey:
+		var b bytes.Buffer
+		pubkeyMsg.Encode(&b)
+
+		_, err := q.Exec(`INSERT OR REPLACE INTO encrypted_pubkeys (tag, data)
+			VALUES (?, ?)`, pubkeyMsg.Tag[:], b.Bytes())
+		return err
+	}
+
+	return nil
+}
+
+// initialize creates the tables of the database if necessary and checks its
+// version.
+func initialize(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(schema); err != nil {
+		return err
+	}
+
+	var version []byte
+	err = tx.QueryRow(`SELECT value FROM misc WHERE key = ?`,
+		versionKey).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(`INSERT INTO misc (key, value) VALUES (?, ?)`,
+			versionKey, []byte{latestDbVersion})
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case len(version) != 1:
+		return errors.New("Missing or malformed database version.")
+	case version[0] > latestDbVersion:
+		return fmt.Errorf("Database version %d is newer than version %d, "+
+			"which is the latest supported by this version of bmd.",
+			version[0], latestDbVersion)
+	case version[0] == 1:
+		log.Infof("Upgrading database from version 1 to version %d: "+
+			"key object counters by stream", latestDbVersion)
+		if _, err = tx.Exec(upgradeCounters); err != nil {
+			return fmt.Errorf("Upgrade to database version %d failed: %v",
+				latestDbVersion, err)
+		}
+		_, err = tx.Exec(`UPDATE misc SET value = ? WHERE key = ?`,
+			[]byte{latestDbVersion}, versionKey)
+		if err != nil {
+			return err
+		}
+	case version[0] != latestDbVersion:
+		return errors.New("Unrecognized database version.")
+	}
+
+	return tx.Commit()
+}
+
+// NewSqliteDB creates an implementation of the database.Db interface with
+// SQLite as a backend store.
+func NewSqliteDB(db *sql.DB, stats database.Stats, now Now) (*database.Db, error) {
+	// SQLite only allows one writer at a time, so everything goes through
+	// a single connection. This also makes transactions serializable.
+	db.SetMaxOpenConns(1)
+
+	if err := initialize(db); err != nil {
+		return nil, err
+	}
+
+	// existsObject returns whether or not an object with the given inventory
+	// hash exists in the database.
+	existsObject := func(q querier, hash *hash.Sha) (bool, error) {
+		var one int
+		err := q.QueryRow(`SELECT 1 FROM objects WHERE hash = ?`,
+			hash[:]).Scan(&one)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	return &database.Db{
+		// Close cleanly shuts down the database and syncs all data.
+		Close: db.Close,
+
+		// ExistsObject returns whether or not an object with the given inventory
+		// hash exists in the database.
+		ExistsObject: func(hash *hash.Sha) (bool, error) {
+			return existsObject(db, hash)
+		},
+
+		// FetchObjectByHash returns an object from the database as a wire.MsgObject.
+		FetchObjectByHash: func(hash *hash.Sha) (obj.Object, error) {
+			var b []byte
+			err := db.QueryRow(`SELECT data FROM objects WHERE hash = ?`,
+				hash[:]).Scan(&b)
+			if err == sql.ErrNoRows {
+				return nil, database.ErrNonexistentObject
+			}
+			if err != nil {
+				return nil, err
+			}
+			return decodeObject(b)
+		},
+
+		// FetchObjectByCounter returns the corresponding object based on the
+		// counter. Note that each stream has a different counter for each object
+		// type, with unknown objects being consolidated into one counter.
+		// Counters are meant for use as a convenience method for fetching new
+		// data from database since last check.
+		FetchObjectByCounter: func(objType wire.ObjectType, stream uint32,
+			counter uint64) (obj.Object, error) {
+			var b []byte
+			err := db.QueryRow(`SELECT data FROM objects WHERE type = ? AND
+				stream = ? AND counter = ?`, int64(counterType(objType)),
+				int64(stream), int64(counter)).Scan(&b)
+			if err == sql.ErrNoRows {
+				return nil, database.ErrNonexistentObject
+			}
+			if err != nil {
+				return nil, err
+			}
+			return decodeObject(b)
+		},
+
+		// FetchObjectsFromCounter returns a slice of `count' objects of the given
+		// type in the given stream which have a counter position starting from
+		// `counter'. It also returns the counter value of the last object, which
+		// could be useful for more queries to the function.
+		FetchObjectsFromCounter: func(objType wire.ObjectType, stream uint32,
+			counter uint64, count uint64) ([]database.ObjectWithCounter, uint64, error) {
+
+			rows, err := db.Query(`SELECT counter, data FROM objects WHERE
+				type = ? AND stream = ? AND counter >= ? ORDER BY counter LIMIT ?`,
+				int64(counterType(objType)), int64(stream), int64(counter),
+				int64(count))
+			if err != nil {
+				return nil, 0, err
+			}
+			defer rows.Close()
+
+			objects := make([]database.ObjectWithCounter, 0, count)
+			var lastCounter uint64
+			for rows.Next() {
+				var c int64
+				var b []byte
+				if err = rows.Scan(&c, &b); err != nil {
+					return nil, 0, err
+				}
+
+				o, err := decodeObject(b)
+				if err != nil {
+					return nil, 0, err
+				}
+
+				objects = append(objects, database.ObjectWithCounter{
+					Counter: uint64(c),
+					Object:  o,
+				})
+				lastCounter = uint64(c)
+			}
+			if err = rows.Err(); err != nil {
+				return nil, 0, err
+			}
+
+			return objects, lastCounter, nil
+		},
+
+		// FetchIdentityByAddress returns identity.Public stored in the form
+		// of a PubKey message in the pubkey database.
+		FetchIdentityByAddress: func(addr bmutil.Address) (identity.Public, error) {
+			// Check if we already have the public keys.
+			id, err := getIdentity(db, addr)
+			if err == nil {
+				return id, nil
+			}
+
+			// Possible that encrypted pubkeys not yet decrypted and stored here.
+			if err != database.ErrNonexistentObject {
+				return nil, err
+			}
+
+			if addr.Version() == obj.SimplePubKeyVersion {
+				// There's no way that we can have these unencrypted keys since they are
+				// always added to the identities table.
+				return nil, database.ErrNonexistentObject
+			}
+
+			// We don't support any other version.
+			if addr.Version() != obj.EncryptedPubKeyVersion && addr.Version() != obj.ExtendedPubKeyVersion {
+				return nil, database.ErrNotImplemented
+			}
+
+			// Try finding the public key with the required tag and then decrypting it.
+			addrTag := bmutil.Tag(addr)[:]
+
+			tx, err := db.Begin()
+			if err != nil {
+				return nil, err
+			}
+			defer tx.Rollback()
+
+			var v []byte
+			err = tx.QueryRow(`SELECT data FROM encrypted_pubkeys WHERE tag = ?`,
+				addrTag).Scan(&v)
+			if err == sql.ErrNoRows {
+				return nil, database.ErrNonexistentObject
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			msg, err := obj.DecodePubKey(bytes.NewReader(v))
+			if err != nil {
+				log.Criticalf("Failed to decode pubkey with tag %x: %v", addrTag, err)
+				return nil, err
+			}
+
+			// Decrypt the pubkey.
+			pubkey, err := cipher.TryDecryptAndVerifyPubKey(msg, addr)
+			if err != nil {
+				// It's an invalid pubkey so remove it.
+				_, derr := tx.Exec(`DELETE FROM encrypted_pubkeys WHERE tag = ?`, addrTag)
+				if derr == nil {
+					tx.Commit()
+				}
+				return nil, err
+			}
+
+			// Already verified them in TryDecryptAndVerifyPubKey.
+			data := pubkey.Data()
+			signKey, _ := data.Verification.ToBtcec()
+			encKey, _ := data.Encryption.ToBtcec()
+
+			// And we have the identity.
+			id, err = identity.NewPublic(
+				&identity.PublicKey{
+					Verification: (*identity.PubKey)(signKey),
+					Encryption:   (*identity.PubKey)(encKey),
+				},
+				msg.Header().Version, msg.Header().StreamNumber,
+				pubkey.Behavior(), pubkey.Pow())
+			if err != nil {
+				return nil, err
+			}
+
+			// Add public key to database and delete it from encrypted pubkeys.
+			err = putIdentity(tx, addr.String(), data.Pow, data.Behavior,
+				data.Verification.Bytes(), data.Encryption.Bytes())
+			if err != nil {
+				return nil, err
+			}
+
+			_, err = tx.Exec(`DELETE FROM encrypted_pubkeys WHERE tag = ?`, addrTag)
+			if err != nil {
+				return nil, err
+			}
+
+			if err = tx.Commit(); err != nil {
+				return nil, err
+			}
+
+			return id, nil
+		},
+
+		// FetchRandomInvHashes returns at most the specified number of
+		// inventory hashes corresponding to random unexpired objects from
+		// the database in the given streams. It does not guarantee that the
+		// number of returned inventory vectors would be `count'.
+		FetchRandomInvHashes: func(count uint64, streams ...uint32) ([]*wire.InvVect, error) {
+			query := `SELECT hash FROM objects WHERE expiration > ?`
+			args := []interface{}{now().Unix()}
+			if len(streams) != 0 {
+				query += ` AND stream IN (?` + strings.Repeat(`, ?`, len(streams)-1) + `)`
+				for _, s := range streams {
+					args = append(args, int64(s))
+				}
+			}
+			query += ` ORDER BY RANDOM() LIMIT ?`
+			args = append(args, int64(count))
+
+			rows, err := db.Query(query, args...)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			hashes := make([]*wire.InvVect, 0, count)
+			for rows.Next() {
+				var h []byte
+				if err = rows.Scan(&h); err != nil {
+					return nil, err
+				}
+
+				inv := &wire.InvVect{}
+				copy(inv[:], h)
+				hashes = append(hashes, inv)
+			}
+			if err = rows.Err(); err != nil {
+				return nil, err
+			}
+
+			return hashes, nil
+		},
+
+		// FetchInvHashesAfter returns at most the specified number of
+		// inventory hashes corresponding to unexpired objects in increasing
+		// order, starting after the given hash.
+		FetchInvHashesAfter: func(after *hash.Sha, count uint64, streams ...uint32) ([]*wire.InvVect, error) {
+			query := `SELECT hash FROM objects WHERE expiration > ?`
+			args := []interface{}{now().Unix()}
+			if after != nil {
+				query += ` AND hash > ?`
+				args = append(args, after[:])
+			}
+			if len(streams) != 0 {
+				query += ` AND stream IN (?` + strings.Repeat(`, ?`, len(streams)-1) + `)`
+				for _, s := range streams {
+					args = append(args, int64(s))
+				}
+			}
+			query += ` ORDER BY hash LIMIT ?`
+			args = append(args, int64(count))
+
+			rows, err := db.Query(query, args...)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			hashes := make([]*wire.InvVect, 0, count)
+			for rows.Next() {
+				var h []byte
+				if err = rows.Scan(&h); err != nil {
+					return nil, err
+				}
+
+				inv := &wire.InvVect{}
+				copy(inv[:], h)
+				hashes = append(hashes, inv)
+			}
+			if err = rows.Err(); err != nil {
+				return nil, err
+			}
+
+			return hashes, nil
+		},
+
+		// GetCounter returns the highest value of counter that exists for objects
+		// of the given type in the given stream.
+		GetCounter: func(objType wire.ObjectType, stream uint32) (uint64, error) {
+			var position int64
+			err := db.QueryRow(`SELECT position FROM counters WHERE type = ? AND
+				stream = ?`, int64(counterType(objType)), int64(stream)).Scan(&position)
+			if err == sql.ErrNoRows {
+				return 0, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			return uint64(position), nil
+		},
+
+		// InsertObject inserts the given object into the database and returns the
+		// counter position within its stream. If the object is a PubKey, it inserts it into a
+		// separate place where it isn't touched by RemoveObject or
+		// RemoveExpiredObjects and has to be removed using RemovePubKey.
+		InsertObject: func(o obj.Object) (uint64, error) {
+			hash := obj.InventoryHash(o)
+			header := o.Header()
+
+			// Don't insert an object if it is already expired.
+			now := now()
+			if now.Add(database.ExpiredCacheTime).After(header.Expiration()) {
+				return 0, database.ErrExpired
+			}
+
+			b := wire.Encode(o)
+
+			tx, err := db.Begin()
+			if err != nil {
+				return 0, err
+			}
+			defer tx.Rollback()
+
+			// Check if we already have the object.
+			exists, err := existsObject(tx, hash)
+			if err != nil {
+				return 0, err
+			}
+			if exists {
+				return 0, database.ErrDuplicateObject
+			}
+
+			// Insert into pubkey table if it is a pubkey.
+			if header.ObjectType == wire.ObjectTypePubKey {
+				object, _ := obj.ReadObject(b)
+				if err := insertPubkey(tx, object); err != nil {
+					log.Infof("Failed to insert pubkey: %v", err)
+				}
+				// We don't care much about error. Ignore it.
+			}
+
+			// Get latest counter value.
+			objType := int64(counterType(header.ObjectType))
+			stream := int64(header.StreamNumber)
+			var position int64
+			err = tx.QueryRow(`SELECT position FROM counters WHERE type = ? AND
+				stream = ?`, objType, stream).Scan(&position)
+			if err != nil && err != sql.ErrNoRows {
+				return 0, err
+			}
+			count := position + 1
+
+			_, err = tx.Exec(`INSERT INTO objects (hash, type, counter,
+				expiration, stream, data) VALUES (?, ?, ?, ?, ?, ?)`,
+				hash[:], objType, count, header.Expiration().Unix(), stream, b)
+			if err != nil {
+				return 0, err
+			}
+
+			// Store new counter value.
+			_, err = tx.Exec(`INSERT OR REPLACE INTO counters (type, stream,
+				position) VALUES (?, ?, ?)`, objType, stream, count)
+			if err != nil {
+				return 0, err
+			}
+
+			if err = tx.Commit(); err != nil {
+				return 0, err
+			}
+
+			stats.RecordObject(hash, uint64(len(b)), now)
+
+			return uint64(count), nil
+		},
+
+		// RemoveObject removes the object with the specified hash from the
+		// database. Does not remove PubKeys.
+		RemoveObject: func(hash *hash.Sha) error {
+			return affected(db.Exec(`DELETE FROM objects WHERE hash = ?`, hash[:]))
+		},
+
+		// RemoveObjectByCounter removes the object of the given type in the given
+		// stream with the specified counter value from the database.
+		RemoveObjectByCounter: func(objType wire.ObjectType, stream uint32,
+			counter uint64) error {
+			return affected(db.Exec(`DELETE FROM objects WHERE type = ? AND
+				stream = ? AND counter = ?`, int64(counterType(objType)),
+				int64(stream), int64(counter)))
+		},
+
+		// RemoveExpiredObjects prunes all objects in the main circulation store
+		// whose expiry time has passed (along with a margin of 3 hours). This does
+		// not touch the pubkeys stored in the public key collection.
+		RemoveExpiredObjects: func() ([]*hash.Sha, error) {
+			// Current time - 3 hours
+			t := now().Add(database.ExpiredCacheTime).Unix()
+
+			tx, err := db.Begin()
+			if err != nil {
+				return nil, err
+			}
+			defer tx.Rollback()
+
+			rows, err := tx.Query(`SELECT hash FROM objects WHERE expiration < ?`, t)
+			if err != nil {
+				return nil, err
+			}
+
+			r := make([]*hash.Sha, 0, expiredSliceSize)
+			for rows.Next() {
+				var b []byte
+				if err = rows.Scan(&b); err != nil {
+					rows.Close()
+					return nil, err
+				}
+
+				h, err := hash.NewSha(b)
+				if err != nil {
+					rows.Close()
+					return nil, err
+				}
+				r = append(r, h)
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return nil, err
+			}
+
+			_, err = tx.Exec(`DELETE FROM objects WHERE expiration < ?`, t)
+			if err != nil {
+				return nil, err
+			}
+
+			if err = tx.Commit(); err != nil {
+				return nil, err
+			}
+
+			return r, nil
+		},
+
+		// RemoveEncryptedPubKey removes a v4 PubKey with the specified tag from the
+		// encrypted PubKey store. Note that it doesn't touch the general object
+		// store and won't remove the public key from there.
+		RemoveEncryptedPubKey: func(tag *hash.Sha) error {
+			return affected(db.Exec(`DELETE FROM encrypted_pubkeys WHERE tag = ?`,
+				tag[:]))
+		},
+
+		// RemoveIdentity removes the public identity corresponding the given
+		// address from the database. This includes any v2/v3/previously used v4
+		// identities. Note that it doesn't touch the general object store and won't
+		// remove the public key object from there.
+		RemoveIdentity: func(addr bmutil.Address) error {
+			return affected(db.Exec(`DELETE FROM identities WHERE address = ?`,
+				addr.String()))
+		},
+
+		// Get the addresses corresponding to all public identities in the database.
+		GetAllIdentities: func() ([]bmutil.Address, error) {
+			rows, err := db.Query(`SELECT address FROM identities`)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			var addrs []bmutil.Address
+			for rows.Next() {
+				var address string
+				if err = rows.Scan(&address); err != nil {
+					return nil, err
+				}
+
+				addr, err := bmutil.DecodeAddress(address)
+				if err != nil {
+					continue
+				}
+				addrs = append(addrs, addr)
+			}
+			if err = rows.Err(); err != nil {
+				return nil, err
+			}
+
+			return addrs, nil
+		},
+
+		// ForAllObjects applies a function to every object in the database,
+		// halting if an error is returned. Objects are read in batches so
+		// that the function may use the database.
+		ForAllObjects: func(f func(*hash.Sha, obj.Object) error) error {
+			type row struct {
+				hash *hash.Sha
+				data []byte
+			}
+
+			last := []byte{}
+			for {
+				rows, err := db.Query(`SELECT hash, data FROM objects WHERE
+					hash > ? ORDER BY hash LIMIT ?`, last, forAllObjectsBatchSize)
+				if err != nil {
+					return err
+				}
+
+				batch := make([]row, 0, forAllObjectsBatchSize)
+				for rows.Next() {
+					var h, b []byte
+					if err = rows.Scan(&h, &b); err != nil {
+						rows.Close()
+						return err
+					}
+
+					sh, err := hash.NewSha(h)
+					if err != nil {
+						rows.Close()
+						return err
+					}
+					batch = append(batch, row{sh, b})
+				}
+				rows.Close()
+				if err = rows.Err(); err != nil {
+					return err
+				}
+
+				for _, r := range batch {
+					o, err := decodeObject(r.data)
+					if err != nil {
+						return err
+					}
+
+					if err = f(r.hash, o); err != nil {
+						return err
+					}
+				}
+
+				if len(batch) < forAllObjectsBatchSize {
+					return nil
+				}
+				last = batch[len(batch)-1].hash[:]
+			}
+		},
+	}, nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+// metricsObjectTypes are the object types for which object counters are
+// reported.
+var metricsObjectTypes = []wire.ObjectType{
+	wire.ObjectTypeGetPubKey,
+	wire.ObjectTypePubKey,
+	wire.ObjectTypeMsg,
+	wire.ObjectTypeBroadcast,
+}
+
+// metricSample is a single value of a metric along with its labels, which
+// must already be formatted as in the Prometheus text format.
+type metricSample struct {
+	labels string
+	value  float64
+}
+
+// writeMetric writes a metric and its samples in the Prometheus text format.
+func writeMetric(w io.Writer, name, typ, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	for _, sample := range samples {
+		value := strconv.FormatFloat(sample.value, 'g', -1, 64)
+		if sample.labels == "" {
+			fmt.Fprintf(w, "%s %s\n", name, value)
+		} else {
+			fmt.Fprintf(w, "%s{%s} %s\n", name, sample.labels, value)
+		}
+	}
+}
+
+// metricsServer serves metrics about bmd over HTTP in the Prometheus text
+// format.
+type metricsServer struct {
+	server   *server
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// ServeHTTP writes the current metrics in response to any request.
+func (m *metricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	m.writeMetrics(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// writeMetrics collects the metrics and writes them to w.
+func (m *metricsServer) writeMetrics(w io.Writer) {
+	s := m.server
+
+	// Peers and the bytes exchanged with them.
+	traffic := s.traffic()
+	writeMetric(w, "bmd_peers", "gauge", "Number of connected peers.",
+		metricSample{`direction="inbound"`, float64(traffic.inbound)},
+		metricSample{`direction="outbound"`, float64(traffic.outbound)})
+	writeMetric(w, "bmd_peer_received_bytes_total", "counter",
+		"Total number of bytes received from peers.",
+		metricSample{"", float64(traffic.bytesReceived)})
+	writeMetric(w, "bmd_peer_sent_bytes_total", "counter",
+		"Total number of bytes sent to peers.",
+		metricSample{"", float64(traffic.bytesSent)})
+
+	// Upload quota.
+	quota := s.bandwidth.Quota.Usage(time.Now())
+	writeMetric(w, "bmd_upload_quota_bytes", "gauge",
+		"Number of bytes which may be uploaded to peers this month, or 0 if there is no limit.",
+		metricSample{"", float64(quota.Limit)})
+	writeMetric(w, "bmd_upload_quota_used_bytes", "gauge",
+		"Number of bytes uploaded to peers this month.",
+		metricSample{"", float64(quota.Used)})
+	writeMetric(w, "bmd_upload_quota_refused_total", "counter",
+		"Total number of getdata requests refused in whole or in part because of the upload quota.",
+		metricSample{"", float64(quota.Refused)})
+
+	// Object manager.
+	if status := s.objectManager.Status(); status != nil {
+		writeMetric(w, "bmd_objects_requested", "gauge",
+			"Number of objects requested from peers but not yet received.",
+			metricSample{"", float64(status.Requested)})
+		writeMetric(w, "bmd_objects_unknown", "gauge",
+			"Number of objects advertised by peers but not yet requested.",
+			metricSample{"", float64(status.Unknown)})
+		writeMetric(w, "bmd_expired_objects_removed_total", "counter",
+			"Total number of expired objects removed from the database.",
+			metricSample{"", float64(status.Expired)})
+		writeMetric(w, "bmd_object_requests_moved_total", "counter",
+			"Total number of object requests moved from slow peers to faster ones.",
+			metricSample{"", float64(status.Moved)})
+		writeMetric(w, "bmd_verify_queued_objects", "gauge",
+			"Number of objects received from peers waiting to be checked.",
+			metricSample{"", float64(status.VerifyQueued)})
+		writeMetric(w, "bmd_verifying_objects", "gauge",
+			"Number of objects received from peers being checked or waiting for objects received before them.",
+			metricSample{"", float64(status.Verifying)})
+		writeMetric(w, "bmd_verified_objects_total", "counter",
+			"Total number of objects received from peers which have been checked.",
+			metricSample{"", float64(status.Verified)})
+		writeMetric(w, "bmd_verify_seconds_total", "counter",
+			"Total time from the receipt of objects from peers until they were checked.",
+			metricSample{"", status.VerifyTime.Seconds()})
+		writeMetric(w, "bmd_rejected_objects", "gauge",
+			"Number of recently rejected objects which are not requested again.",
+			metricSample{"", float64(status.Rejected)})
+		writeMetric(w, "bmd_rejected_objects_advertised_total", "counter",
+			"Total number of times peers have advertised recently rejected objects.",
+			metricSample{"", float64(status.RejectedAdvertised)})
+		writeMetric(w, "bmd_outbox_scheduled_objects", "gauge",
+			"Number of objects in the outbox waiting to be sent.",
+			metricSample{"", float64(status.Scheduled)})
+		writeMetric(w, "bmd_outbox_unconfirmed_objects", "gauge",
+			"Number of objects sent from the outbox but not yet requested or advertised by enough peers.",
+			metricSample{"", float64(status.Unconfirmed)})
+	}
+
+	// Database.
+	streams := s.Streams()
+	samples := make([]metricSample, 0, len(streams)*len(metricsObjectTypes))
+	for _, stream := range streams {
+		for _, objType := range metricsObjectTypes {
+			counter, err := s.db.GetCounter(objType, stream)
+			if err != nil {
+				serverLog.Errorf("GetCounter, database error: %v", err)
+				continue
+			}
+			samples = append(samples, metricSample{
+				fmt.Sprintf(`stream="%d",type="%s"`, stream, objType),
+				float64(counter)})
+		}
+	}
+	writeMetric(w, "bmd_object_counter", "gauge",
+		"Highest counter value of objects in the database by stream and type.",
+		samples...)
+
+	// Backlog of the expiry scheduler.
+	expiry := s.expiry.Stats()
+	writeMetric(w, "bmd_expiry_scheduled_objects", "gauge",
+		"Number of objects scheduled to be removed from the database when they expire.",
+		metricSample{"", float64(expiry.Scheduled)})
+	writeMetric(w, "bmd_expiry_overdue_objects", "gauge",
+		"Number of expired objects whose removal from the database is overdue.",
+		metricSample{"", float64(expiry.Overdue)})
+	writeMetric(w, "bmd_expiry_lag_seconds", "gauge",
+		"How long ago the oldest overdue object should have been removed.",
+		metricSample{"", expiry.Lag.Seconds()})
+
+	if cfg.DbType != "memdb" {
+		if fi, err := os.Stat(cfg.objectDbPath()); err == nil {
+			writeMetric(w, "bmd_database_size_bytes", "gauge",
+				"Size of the object database.",
+				metricSample{"", float64(fi.Size())})
+		}
+	}
+
+	// RPC server.
+	if cfg.EnableRPC {
+		writeMetric(w, "bmd_rpc_clients", "gauge",
+			"Number of connected RPC clients.",
+			metricSample{"", float64(s.rpcServer.Clients())})
+
+		// Streams come and go, so they are only reported together.
+		streams := s.rpcServer.StreamStats()
+		var buffered, lagging int
+		for _, st := range streams {
+			buffered += st.Buffered
+			if st.Lagging {
+				lagging++
+			}
+		}
+		sent, dropped := s.rpcServer.StreamTotals()
+		writeMetric(w, "bmd_rpc_streams", "gauge",
+			"Number of open streams of objects to RPC clients.",
+			metricSample{"", float64(len(streams))})
+		writeMetric(w, "bmd_rpc_stream_sent_objects_total", "counter",
+			"Objects sent over streams to RPC clients.",
+			metricSample{"", float64(sent)})
+		writeMetric(w, "bmd_rpc_stream_dropped_objects_total", "counter",
+			"Objects dropped from the buffers of streams while their clients were behind.",
+			metricSample{"", float64(dropped)})
+		writeMetric(w, "bmd_rpc_stream_buffered_objects", "gauge",
+			"Objects waiting to be sent over open streams.",
+			metricSample{"", float64(buffered)})
+		writeMetric(w, "bmd_rpc_streams_lagging", "gauge",
+			"Number of open streams whose clients have fallen behind.",
+			metricSample{"", float64(lagging)})
+
+		if s.rpcServer.powQueue != nil {
+			queued, running, solved := s.rpcServer.powQueue.Stats()
+			writeMetric(w, "bmd_pow_queued_objects", "gauge",
+				"Objects waiting for their proof of work to be done for RPC clients.",
+				metricSample{"", float64(queued)})
+			writeMetric(w, "bmd_pow_running_objects", "gauge",
+				"Objects whose proof of work is being done for RPC clients.",
+				metricSample{"", float64(running)})
+			writeMetric(w, "bmd_pow_solved_objects_total", "counter",
+				"Objects whose proof of work has been done for RPC clients.",
+				metricSample{"", float64(solved)})
+		}
+	}
+}
+
+// Start begins serving metrics.
+func (m *metricsServer) Start() {
+	m.wg.Add(1)
+	go func() {
+		serverLog.Infof("Metrics server listening on %s", m.listener.Addr())
+		http.Serve(m.listener, m)
+		serverLog.Tracef("Metrics listener done for %s", m.listener.Addr())
+		m.wg.Done()
+	}()
+}
+
+// Stop stops serving metrics.
+func (m *metricsServer) Stop() error {
+	err := m.listener.Close()
+	m.wg.Wait()
+	return err
+}
+
+// newMetricsServer returns a new metricsServer listening on the given
+// address.
+func newMetricsServer(s *server, addr string) (*metricsServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricsServer{
+		server:   s,
+		listener: listener,
+	}, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DanielKrawisz/bmd/objmgr/stats"
+	"github.com/DanielKrawisz/bmd/peer"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+func TestWriteMetric(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetric(&buf, "bmd_test", "gauge", "A test metric.",
+		metricSample{`direction="inbound"`, 3},
+		metricSample{`direction="outbound"`, 1.5})
+	writeMetric(&buf, "bmd_test_total", "counter", "Another test metric.",
+		metricSample{"", 1234567})
+
+	expected := `# HELP bmd_test A test metric.
+# TYPE bmd_test gauge
+bmd_test{direction="inbound"} 3
+bmd_test{direction="outbound"} 1.5
+# HELP bmd_test_total Another test metric.
+# TYPE bmd_test_total counter
+bmd_test_total 1.234567e+06
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	remoteAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8445}
+
+	cfg.MaxPeers = 0
+	defer resetCfg(cfg)()
+	cfg.MetricsListen = "127.0.0.1:0"
+	defer func() {
+		cfg.MetricsListen = ""
+	}()
+
+	listeners := []string{net.JoinHostPort("", "8445")}
+	serv, err := newServer(listeners, getMemDb([]obj.Object{testObj[0], testObj[2]}),
+		MockListen([]*MockListener{
+			NewMockListener(remoteAddr, make(chan peer.Connection), make(chan struct{}, 1))}), nil, stats.Stats{})
+	if err != nil {
+		t.Fatalf("Server creation failed: %s", err)
+	}
+	serv.Start()
+
+	// Fetch the metrics from the port the listener was given.
+	resp, err := http.Get("http://" + serv.metrics.listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("Metrics request failed: %s", err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Reading metrics failed: %s", err)
+	}
+	body := string(b)
+
+	for _, line := range []string{
+		`bmd_peers{direction="inbound"} 0`,
+		`bmd_peers{direction="outbound"} 0`,
+		`bmd_upload_quota_bytes 0`,
+		`bmd_upload_quota_refused_total 0`,
+		`bmd_objects_requested 0`,
+		`bmd_objects_unknown 0`,
+		`bmd_expired_objects_removed_total 0`,
+		`bmd_object_requests_moved_total 0`,
+		`bmd_verify_queued_objects 0`,
+		`bmd_verified_objects_total 0`,
+		`bmd_rejected_objects 0`,
+		`bmd_object_counter{stream="1",type="` + wire.ObjectTypeGetPubKey.String() + `"} 1`,
+		`bmd_object_counter{stream="1",type="` + wire.ObjectTypePubKey.String() + `"} 1`,
+		`bmd_object_counter{stream="1",type="` + wire.ObjectTypeMsg.String() + `"} 0`,
+		`bmd_expiry_scheduled_objects 2`,
+		`bmd_expiry_overdue_objects 0`,
+		`bmd_expiry_lag_seconds 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metric %s not found in\n%s", line, body)
+		}
+	}
+
+	serv.Stop()
+	serv.WaitForShutdown()
+}
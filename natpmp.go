@@ -0,0 +1,491 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+// NAT-PMP (RFC 6886) and PCP (RFC 6887) implementations of the NAT interface.
+// Both protocols are spoken over UDP to the default gateway. PCP is the
+// successor of NAT-PMP and most gateways which speak PCP also speak NAT-PMP,
+// so PCP is tried first.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// natpmpPort is the port on the gateway which NAT-PMP and PCP use.
+	natpmpPort = 5351
+
+	// natpmpTries is the number of times a request is sent before giving
+	// up. The time waited doubles after each try.
+	natpmpTries = 4
+
+	// natpmpInitialTimeout is how long to wait for the first reply.
+	natpmpInitialTimeout = 250 * time.Millisecond
+
+	natpmpVersion = 0
+	pcpVersion    = 2
+
+	// Opcodes. Replies have the high bit set.
+	natpmpOpExternalAddress = 0
+	natpmpOpMapUDP          = 1
+	natpmpOpMapTCP          = 2
+	pcpOpAnnounce           = 0
+	pcpOpMap                = 1
+	natpmpOpReply           = 128
+
+	// Sizes of the messages.
+	natpmpExternalAddressSize = 12
+	natpmpMapSize             = 16
+	pcpHeaderSize             = 24
+	pcpMapSize                = pcpHeaderSize + 36
+
+	// IANA protocol numbers used by PCP.
+	pcpProtocolTCP = 6
+	pcpProtocolUDP = 17
+)
+
+var (
+	// natpmpResultErrors are the errors corresponding to NAT-PMP result
+	// codes.
+	natpmpResultErrors = map[uint16]error{
+		1: errors.New("NAT-PMP unsupported version"),
+		2: errors.New("NAT-PMP not authorized"),
+		3: errors.New("NAT-PMP network failure"),
+		4: errors.New("NAT-PMP out of resources"),
+		5: errors.New("NAT-PMP unsupported opcode"),
+	}
+
+	// pcpResultErrors are the errors corresponding to PCP result codes.
+	pcpResultErrors = map[byte]error{
+		1:  errors.New("PCP unsupported version"),
+		2:  errors.New("PCP not authorized"),
+		3:  errors.New("PCP malformed request"),
+		4:  errors.New("PCP unsupported opcode"),
+		5:  errors.New("PCP unsupported option"),
+		6:  errors.New("PCP malformed option"),
+		7:  errors.New("PCP network failure"),
+		8:  errors.New("PCP no resources"),
+		9:  errors.New("PCP unsupported protocol"),
+		10: errors.New("PCP user exceeded quota"),
+		11: errors.New("PCP cannot provide external address"),
+		12: errors.New("PCP address mismatch"),
+		13: errors.New("PCP excessive remote peers"),
+	}
+
+	// errNoGatewayResponse is returned when the gateway does not answer.
+	errNoGatewayResponse = errors.New("no response from gateway")
+)
+
+// leasedNAT is implemented by NATs which may grant a port mapping for less
+// time than was asked for, so that the mapping can be renewed in time.
+type leasedNAT interface {
+	// Lifetime returns how long the most recent port mapping lasts.
+	Lifetime() time.Duration
+}
+
+// natpmpRequest sends a request to the gateway and returns the first reply
+// for which valid returns true. The request is sent again with a doubling
+// timeout if there is no reply.
+func natpmpRequest(gateway *net.UDPAddr, msg []byte, valid func([]byte) bool) ([]byte, error) {
+	conn, err := net.DialUDP("udp", nil, gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1100)
+	timeout := natpmpInitialTimeout
+	for i := 0; i < natpmpTries; i++ {
+		_, err = conn.Write(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		err = conn.SetReadDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+
+			if valid(buf[:n]) {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+
+		timeout *= 2
+	}
+
+	return nil, errNoGatewayResponse
+}
+
+// natpmpNAT implements the NAT interface with NAT-PMP.
+type natpmpNAT struct {
+	gateway  *net.UDPAddr
+	lifetime time.Duration
+}
+
+// request sends a NAT-PMP request and returns the reply, which is checked to
+// be at least size bytes long and to have a successful result code.
+func (n *natpmpNAT) request(msg []byte, size int) ([]byte, error) {
+	reply, err := natpmpRequest(n.gateway, msg, func(b []byte) bool {
+		// Replies to unsupported versions may be shorter than usual,
+		// so only the header is checked here.
+		return len(b) >= 4 && b[0] == natpmpVersion &&
+			b[1] == msg[1]|natpmpOpReply
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := binary.BigEndian.Uint16(reply[2:4])
+	if result != 0 {
+		if err, ok := natpmpResultErrors[result]; ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("NAT-PMP error %d", result)
+	}
+	if len(reply) < size {
+		return nil, errors.New("NAT-PMP reply too short")
+	}
+
+	return reply, nil
+}
+
+// GetExternalAddress implements the NAT interface by asking the gateway for
+// its external address.
+func (n *natpmpNAT) GetExternalAddress() (net.IP, error) {
+	reply, err := n.request([]byte{natpmpVersion, natpmpOpExternalAddress},
+		natpmpExternalAddressSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.IPv4(reply[8], reply[9], reply[10], reply[11]), nil
+}
+
+// mapPort sends a mapping request and returns the mapped external port and
+// the lifetime of the mapping in seconds.
+func (n *natpmpNAT) mapPort(protocol string, externalPort, internalPort, timeout int) (int, int, error) {
+	var op byte
+	switch strings.ToLower(protocol) {
+	case "tcp":
+		op = natpmpOpMapTCP
+	case "udp":
+		op = natpmpOpMapUDP
+	default:
+		return 0, 0, fmt.Errorf("unsupported protocol %s", protocol)
+	}
+
+	msg := make([]byte, 12)
+	msg[0] = natpmpVersion
+	msg[1] = op
+	binary.BigEndian.PutUint16(msg[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(msg[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(msg[8:12], uint32(timeout))
+
+	reply, err := n.request(msg, natpmpMapSize)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(binary.BigEndian.Uint16(reply[10:12])),
+		int(binary.BigEndian.Uint32(reply[12:16])), nil
+}
+
+// AddPortMapping implements the NAT interface by asking the gateway to
+// forward the external port to the internal port for timeout seconds. The
+// gateway may choose a different external port, which is returned.
+func (n *natpmpNAT) AddPortMapping(protocol string, externalPort, internalPort int,
+	description string, timeout int) (int, error) {
+	mapped, lifetime, err := n.mapPort(protocol, externalPort, internalPort, timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	n.lifetime = time.Duration(lifetime) * time.Second
+	return mapped, nil
+}
+
+// DeletePortMapping implements the NAT interface by asking the gateway to
+// remove the mapping for the internal port.
+func (n *natpmpNAT) DeletePortMapping(protocol string, externalPort, internalPort int) error {
+	_, _, err := n.mapPort(protocol, 0, internalPort, 0)
+	return err
+}
+
+// Lifetime returns how long the most recent port mapping lasts.
+func (n *natpmpNAT) Lifetime() time.Duration {
+	return n.lifetime
+}
+
+// pcpNAT implements the NAT interface with PCP.
+type pcpNAT struct {
+	gateway  *net.UDPAddr
+	clientIP net.IP
+	nonce    [12]byte
+	external net.IP
+	lifetime time.Duration
+}
+
+// request sends a PCP request and returns the reply, which is checked to be
+// at least size bytes long and to have a successful result code.
+func (p *pcpNAT) request(msg []byte, size int) ([]byte, error) {
+	reply, err := natpmpRequest(p.gateway, msg, func(b []byte) bool {
+		// A gateway which only speaks NAT-PMP answers with a NAT-PMP
+		// header saying that the version is unsupported.
+		if len(b) >= 4 && b[0] == natpmpVersion {
+			return true
+		}
+		return len(b) >= pcpHeaderSize && b[0] == pcpVersion &&
+			b[1] == msg[1]|natpmpOpReply
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reply[0] != pcpVersion {
+		return nil, pcpResultErrors[1]
+	}
+	if result := reply[3]; result != 0 {
+		if err, ok := pcpResultErrors[result]; ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("PCP error %d", result)
+	}
+	if len(reply) < size {
+		return nil, errors.New("PCP reply too short")
+	}
+
+	return reply, nil
+}
+
+// header returns a new PCP request with the common header filled in.
+func (p *pcpNAT) header(op byte, size int, lifetime int) []byte {
+	msg := make([]byte, size)
+	msg[0] = pcpVersion
+	msg[1] = op
+	binary.BigEndian.PutUint32(msg[4:8], uint32(lifetime))
+	copy(msg[8:24], p.clientIP.To16())
+	return msg
+}
+
+// announce checks whether the gateway speaks PCP.
+func (p *pcpNAT) announce() error {
+	_, err := p.request(p.header(pcpOpAnnounce, pcpHeaderSize, 0), pcpHeaderSize)
+	return err
+}
+
+// mapPort sends a MAP request and returns the reply.
+func (p *pcpNAT) mapPort(protocol string, externalPort, internalPort, timeout int) ([]byte, error) {
+	var proto byte
+	switch strings.ToLower(protocol) {
+	case "tcp":
+		proto = pcpProtocolTCP
+	case "udp":
+		proto = pcpProtocolUDP
+	default:
+		return nil, fmt.Errorf("unsupported protocol %s", protocol)
+	}
+
+	msg := p.header(pcpOpMap, pcpMapSize, timeout)
+	copy(msg[24:36], p.nonce[:])
+	msg[36] = proto
+	binary.BigEndian.PutUint16(msg[40:42], uint16(internalPort))
+	binary.BigEndian.PutUint16(msg[42:44], uint16(externalPort))
+	// Any IPv4 external address.
+	copy(msg[44:60], net.IPv4zero.To16())
+
+	reply, err := p.request(msg, pcpMapSize)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(reply[24:36], p.nonce[:]) {
+		return nil, errors.New("PCP reply has wrong nonce")
+	}
+
+	return reply, nil
+}
+
+// GetExternalAddress implements the NAT interface. PCP has no request for the
+// external address, which is instead given in the reply to a port mapping, so
+// a port mapping must be added first.
+func (p *pcpNAT) GetExternalAddress() (net.IP, error) {
+	if p.external == nil {
+		return nil, errors.New("PCP external address is unknown until a port is mapped")
+	}
+	return p.external, nil
+}
+
+// AddPortMapping implements the NAT interface by asking the gateway to
+// forward the external port to the internal port for timeout seconds. The
+// gateway may choose a different external port, which is returned.
+func (p *pcpNAT) AddPortMapping(protocol string, externalPort, internalPort int,
+	description string, timeout int) (int, error) {
+	reply, err := p.mapPort(protocol, externalPort, internalPort, timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	p.lifetime = time.Duration(binary.BigEndian.Uint32(reply[4:8])) * time.Second
+	p.external = net.IP(append([]byte(nil), reply[44:60]...))
+	return int(binary.BigEndian.Uint16(reply[42:44])), nil
+}
+
+// DeletePortMapping implements the NAT interface by asking the gateway to
+// remove the mapping for the internal port.
+func (p *pcpNAT) DeletePortMapping(protocol string, externalPort, internalPort int) error {
+	_, err := p.mapPort(protocol, externalPort, internalPort, 0)
+	return err
+}
+
+// Lifetime returns how long the most recent port mapping lasts.
+func (p *pcpNAT) Lifetime() time.Duration {
+	return p.lifetime
+}
+
+// probeNATPMP returns a NAT for the gateway using PCP if the gateway speaks
+// it, and NAT-PMP otherwise.
+func probeNATPMP(gateway *net.UDPAddr) (NAT, error) {
+	// Find out which of our addresses the gateway sees.
+	conn, err := net.DialUDP("udp", nil, gateway)
+	if err != nil {
+		return nil, err
+	}
+	clientIP := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	p := &pcpNAT{
+		gateway:  gateway,
+		clientIP: clientIP,
+	}
+	_, err = rand.Read(p.nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	err = p.announce()
+	if err == nil {
+		return p, nil
+	}
+	if err == errNoGatewayResponse {
+		return nil, err
+	}
+
+	n := &natpmpNAT{gateway: gateway}
+	_, err = n.GetExternalAddress()
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// defaultGateway returns the ip address of the default IPv4 gateway. It is
+// read from the routing table where that is possible, and otherwise guessed
+// to be the first address on the network of a private interface address.
+func defaultGateway() (net.IP, error) {
+	if f, err := os.Open("/proc/net/route"); err == nil {
+		defer f.Close()
+		if gw := parseRouteTable(f); gw != nil {
+			return gw, nil
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP.To4()
+		if ip == nil || ip.IsLoopback() || !isPrivateIPv4(ip) {
+			continue
+		}
+
+		gw := ip.Mask(ipnet.Mask)
+		gw[3]++
+		return gw, nil
+	}
+
+	return nil, errors.New("unable to find default gateway")
+}
+
+// parseRouteTable returns the gateway of the default route from a routing
+// table in the format of /proc/net/route, or nil if there is none.
+func parseRouteTable(r io.Reader) net.IP {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+
+		// The address is in host byte order, which is little endian on
+		// every platform that Linux runs bmd on.
+		return net.IPv4(b[3], b[2], b[1], b[0])
+	}
+	return nil
+}
+
+// isPrivateIPv4 returns whether the IPv4 address is in one of the private
+// address ranges of RFC 1918.
+func isPrivateIPv4(ip net.IP) bool {
+	return ip[0] == 10 ||
+		(ip[0] == 172 && ip[1]&0xf0 == 16) ||
+		(ip[0] == 192 && ip[1] == 168)
+}
+
+// discoverNATPMP looks for a gateway which speaks PCP or NAT-PMP, returning a
+// NAT for it if so.
+func discoverNATPMP() (NAT, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+
+	return probeNATPMP(&net.UDPAddr{IP: gw, Port: natpmpPort})
+}
+
+// discoverNAT looks for a way to map our listening port through the gateway,
+// trying UPnP first and then PCP and NAT-PMP.
+func discoverNAT() (NAT, error) {
+	nat, err := Discover()
+	if err == nil {
+		return nat, nil
+	}
+	upnpErr := err
+
+	nat, err = discoverNATPMP()
+	if err == nil {
+		return nat, nil
+	}
+
+	return nil, fmt.Errorf("%v; PCP and NAT-PMP: %v", upnpErr, err)
+}
@@ -0,0 +1,209 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGateway runs a fake gateway on a local UDP port which answers each
+// request with the reply returned by answer, if any. It is stopped by closing
+// the returned connection.
+func fakeGateway(t *testing.T, answer func(req []byte) []byte) (*net.UDPConn, *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if reply := answer(buf[:n]); reply != nil {
+				conn.WriteToUDP(reply, addr)
+			}
+		}
+	}()
+
+	return conn, conn.LocalAddr().(*net.UDPAddr)
+}
+
+// natpmpAnswer answers requests like a NAT-PMP gateway with the external
+// address 1.2.3.4 which maps every port to the external port plus one. The
+// lifetimes of the mappings requested are sent over the channel.
+func natpmpAnswer(lifetimes chan uint32) func([]byte) []byte {
+	return func(req []byte) []byte {
+		if req[0] != natpmpVersion {
+			// Unsupported version.
+			return []byte{natpmpVersion, req[1] | natpmpOpReply, 0, 1}
+		}
+
+		switch req[1] {
+		case natpmpOpExternalAddress:
+			reply := make([]byte, natpmpExternalAddressSize)
+			reply[1] = natpmpOpExternalAddress | natpmpOpReply
+			copy(reply[8:], []byte{1, 2, 3, 4})
+			return reply
+		case natpmpOpMapTCP:
+			lifetime := binary.BigEndian.Uint32(req[8:12])
+			lifetimes <- lifetime
+			reply := make([]byte, natpmpMapSize)
+			reply[1] = natpmpOpMapTCP | natpmpOpReply
+			copy(reply[8:10], req[4:6])
+			external := binary.BigEndian.Uint16(req[6:8])
+			if lifetime != 0 {
+				external++
+			}
+			binary.BigEndian.PutUint16(reply[10:12], external)
+			binary.BigEndian.PutUint32(reply[12:16], lifetime/2)
+			return reply
+		default:
+			return []byte{natpmpVersion, req[1] | natpmpOpReply, 0, 5}
+		}
+	}
+}
+
+// pcpAnswer answers requests like a PCP gateway with the external address
+// 1.2.3.4 which maps every port to the suggested external port plus one.
+func pcpAnswer(lifetimes chan uint32) func([]byte) []byte {
+	return func(req []byte) []byte {
+		if req[0] != pcpVersion || len(req) < pcpHeaderSize {
+			return nil
+		}
+
+		switch req[1] {
+		case pcpOpAnnounce:
+			reply := make([]byte, pcpHeaderSize)
+			reply[0] = pcpVersion
+			reply[1] = pcpOpAnnounce | natpmpOpReply
+			return reply
+		case pcpOpMap:
+			lifetime := binary.BigEndian.Uint32(req[4:8])
+			lifetimes <- lifetime
+			reply := make([]byte, pcpMapSize)
+			copy(reply, req)
+			reply[1] = pcpOpMap | natpmpOpReply
+			binary.BigEndian.PutUint32(reply[4:8], lifetime/2)
+			external := binary.BigEndian.Uint16(req[42:44])
+			if lifetime != 0 {
+				external++
+			}
+			binary.BigEndian.PutUint16(reply[42:44], external)
+			copy(reply[44:60], net.IPv4(1, 2, 3, 4).To16())
+			return reply
+		default:
+			reply := make([]byte, pcpHeaderSize)
+			reply[0] = pcpVersion
+			reply[1] = req[1] | natpmpOpReply
+			reply[3] = 4
+			return reply
+		}
+	}
+}
+
+// testPortMapping maps a port with the NAT and checks the results against
+// what the fake gateways return.
+func testPortMapping(t *testing.T, nat NAT, lifetimes chan uint32) {
+	port, err := nat.AddPortMapping("tcp", 8444, 8444, "bmd listen port", 1200)
+	if err != nil {
+		t.Fatalf("AddPortMapping: unexpected error %v", err)
+	}
+	if port != 8445 {
+		t.Errorf("AddPortMapping: expected port 8445, got %d", port)
+	}
+	if l := <-lifetimes; l != 1200 {
+		t.Errorf("AddPortMapping: requested lifetime %d", l)
+	}
+	if l := nat.(leasedNAT).Lifetime(); l != 10*time.Minute {
+		t.Errorf("Lifetime: expected 10 minutes, got %v", l)
+	}
+
+	ip, err := nat.GetExternalAddress()
+	if err != nil {
+		t.Fatalf("GetExternalAddress: unexpected error %v", err)
+	}
+	if !ip.Equal(net.IPv4(1, 2, 3, 4)) {
+		t.Errorf("GetExternalAddress: expected 1.2.3.4, got %v", ip)
+	}
+
+	if _, err = nat.AddPortMapping("sctp", 8444, 8444, "", 1200); err == nil {
+		t.Error("AddPortMapping: expected error for unsupported protocol")
+	}
+
+	if err = nat.DeletePortMapping("tcp", 8444, 8444); err != nil {
+		t.Errorf("DeletePortMapping: unexpected error %v", err)
+	}
+	if l := <-lifetimes; l != 0 {
+		t.Errorf("DeletePortMapping: requested lifetime %d", l)
+	}
+}
+
+func TestNATPMP(t *testing.T) {
+	lifetimes := make(chan uint32, 2)
+	conn, gateway := fakeGateway(t, natpmpAnswer(lifetimes))
+	defer conn.Close()
+
+	nat, err := probeNATPMP(gateway)
+	if err != nil {
+		t.Fatalf("probeNATPMP: unexpected error %v", err)
+	}
+	if _, ok := nat.(*natpmpNAT); !ok {
+		t.Fatalf("probeNATPMP: expected NAT-PMP, got %T", nat)
+	}
+
+	testPortMapping(t, nat, lifetimes)
+}
+
+func TestPCP(t *testing.T) {
+	lifetimes := make(chan uint32, 2)
+	conn, gateway := fakeGateway(t, pcpAnswer(lifetimes))
+	defer conn.Close()
+
+	nat, err := probeNATPMP(gateway)
+	if err != nil {
+		t.Fatalf("probeNATPMP: unexpected error %v", err)
+	}
+	if _, ok := nat.(*pcpNAT); !ok {
+		t.Fatalf("probeNATPMP: expected PCP, got %T", nat)
+	}
+
+	// The external address is not known until a port is mapped.
+	if _, err = nat.GetExternalAddress(); err == nil {
+		t.Error("GetExternalAddress: expected error before mapping a port")
+	}
+
+	testPortMapping(t, nat, lifetimes)
+}
+
+func TestNATPMPNoGateway(t *testing.T) {
+	conn, gateway := fakeGateway(t, func([]byte) []byte { return nil })
+	defer conn.Close()
+
+	if _, err := probeNATPMP(gateway); err != errNoGatewayResponse {
+		t.Errorf("probeNATPMP: expected %v, got %v", errNoGatewayResponse, err)
+	}
+}
+
+func TestParseRouteTable(t *testing.T) {
+	table := `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	0000A8C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth0	00000000	0100A8C0	0003	0	0	0	00000000	0	0	0
+`
+	gw := parseRouteTable(strings.NewReader(table))
+	if !gw.Equal(net.IPv4(192, 168, 0, 1)) {
+		t.Errorf("expected gateway 192.168.0.1, got %v", gw)
+	}
+
+	if gw = parseRouteTable(strings.NewReader("")); gw != nil {
+		t.Errorf("expected no gateway, got %v", gw)
+	}
+}
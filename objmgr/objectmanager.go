@@ -10,7 +10,10 @@ package objmgr
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
+	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,7 +22,6 @@ import (
 	"github.com/DanielKrawisz/bmd/peer"
 	"github.com/DanielKrawisz/bmd/objmgr/stats"
 	"github.com/DanielKrawisz/bmutil/hash"
-	"github.com/DanielKrawisz/bmutil/pow"
 	"github.com/DanielKrawisz/bmutil/wire"
 	"github.com/DanielKrawisz/bmutil/wire/obj"
 )
@@ -62,11 +64,24 @@ type invMsg struct {
 	peer *peer.Peer
 }
 
+// getDataMsg packages a bitmessage getdata message and the peer it came from
+// together so the object manager has access to that information.
+type getDataMsg struct {
+	getData *wire.MsgGetData
+	peer    *peer.Peer
+}
+
 // donePeerMsg signifies a newly disconnected peer to the object manager.
 type donePeerMsg struct {
 	peer *peer.Peer
 }
 
+// expiredMsg signifies that an object has expired and has been removed from
+// the database.
+type expiredMsg struct {
+	object *database.ExpiredObject
+}
+
 // peerRequest represents the peer from which an object was requested along with
 // the timestamp.
 type peerRequest struct {
@@ -75,12 +90,101 @@ type peerRequest struct {
 	timestamp time.Time
 	// The time that the inv was first heard about.
 	knownSince time.Time
+	// The peers which the object was requested from before, which were too
+	// slow to deliver it. They may still do so.
+	moved []*peer.Peer
+}
+
+// from returns whether the object was requested from the given peer.
+func (r *peerRequest) from(p *peer.Peer) bool {
+	if r.peer == p {
+		return true
+	}
+	for _, mp := range r.moved {
+		if mp == p {
+			return true
+		}
+	}
+	return false
+}
+
+// sendMsg submits an object to the outbox.
+type sendMsg struct {
+	object   *wire.MsgObject
+	sendAt   time.Time
+	minPeers int
+	reply    chan sendReply
+}
+
+// sendReply is the result of a sendMsg.
+type sendReply struct {
+	counter uint64
+	err     error
+}
+
+// statusMsg requests the current status of the object manager.
+type statusMsg struct {
+	reply chan *Status
+}
+
+// Status describes the current state of the object manager.
+type Status struct {
+	// Peers is the number of peers known to the object manager.
+	Peers int
+
+	// Requested is the number of objects which have been requested from
+	// peers but not yet received.
+	Requested int
+
+	// Unknown is the number of objects which we have heard about but have
+	// not yet requested.
+	Unknown int
+
+	// Expired is the total number of expired objects which have been
+	// removed from the database.
+	Expired uint64
+
+	// Scheduled is the number of objects in the outbox which are waiting
+	// to be sent.
+	Scheduled int
+
+	// Unconfirmed is the number of objects in the outbox which have been
+	// sent but not yet requested from us or advertised to us by enough
+	// peers.
+	Unconfirmed int
+
+	// Moved is the total number of requests which have been moved from
+	// slow peers to faster ones.
+	Moved uint64
+
+	// VerifyQueued is the number of objects received from peers which are
+	// waiting to be checked, and Verifying is the number which are being
+	// checked or waiting for objects received before them.
+	VerifyQueued int
+	Verifying    int
+
+	// Verified is the total number of objects received from peers which
+	// have been checked, and VerifyTime is the total time from their
+	// receipt until they were checked.
+	Verified   uint64
+	VerifyTime time.Duration
+
+	// Rejected is the number of recently rejected objects which are not
+	// requested again, and RejectedAdvertised is the total number of times
+	// peers have advertised them.
+	Rejected           int
+	RejectedAdvertised uint64
 }
 
-// readyPeerMsg signals that a peer is ready to download more objects.
-type readyPeerMsg peer.Peer
+// relayInv is an inventory vector waiting to be relayed along with the stream
+// of the object it refers to.
+type relayInv struct {
+	inv    *wire.InvVect
+	stream uint32
+}
 
 type server interface {
+	Streams() []uint32
 	DisconnectPeer(*peer.Peer)
 	NotifyObject(wire.ObjectType)
 }
@@ -88,11 +192,11 @@ type server interface {
 // ObjectManager provides a concurrency safe object manager for handling all
 // incoming and outgoing.
 type ObjectManager struct {
-	requestExpire   time.Duration
-	cleanupInterval time.Duration
+	requestExpire time.Duration
 
 	server   server
 	db       *database.Db
+	policy   Policy
 	started  int32
 	shutdown int32
 
@@ -103,19 +207,33 @@ type ObjectManager struct {
 	// The set of peers that are working on downloading messages.
 	working map[*peer.Peer]struct{}
 
+	// The download windows of the peers, which limit how many objects are
+	// requested from each of them at once.
+	windows map[*peer.Peer]*downloadWindow
+
 	// The set of objects which we do not have and which have not been
 	// assigned to peers for download.
 	unknown struct {
 		put  func(wire.InvVect, time.Time)
 		get  func(wire.InvVect) (time.Time, bool)
+		del  func(wire.InvVect)
 		size func() uint32
 	}
 
 	// The set of objects which have been assigned to peers for download.
 	requested map[wire.InvVect]*peerRequest
 
+	// The objects which may still be delivered by peers they are no longer
+	// requested from.
+	superseded supersededRequests
+	moved      uint64
+
 	relayInvList *list.List
+	outbox       *Outbox
+	verifier     *verifier
+	rejects      *rejectCache
 	msgChan      chan interface{}
+	expired      uint64
 	wg           sync.WaitGroup
 	quit         chan struct{}
 
@@ -133,6 +251,7 @@ func (om *ObjectManager) handleNewPeer(p *peer.Peer) {
 
 	// Add the peer
 	om.peers[p] = time.Time{}
+	om.windows[p] = newDownloadWindow(p.Inventory)
 	log.Debug("Peer ", p.Addr().String(), " added to object manager.")
 }
 
@@ -146,17 +265,23 @@ func (om *ObjectManager) handleDonePeer(p *peer.Peer) {
 	// Remove the peer from the list of candidate peers.
 	delete(om.peers, p)
 	delete(om.working, p)
+	delete(om.windows, p)
+	om.rejects.forget(p)
 
 	reassignInvs := 0
+	now := time.Now()
 	// Remove requested objects from the global map so that they will be fetched
 	// from elsewhere next time we get an inv.
 	for invHash, rqst := range om.requested {
 		if rqst.peer == p { // peer matches
 			delete(om.requested, invHash)
+			om.superseded.add(invHash, now, rqst.moved...)
 
 			om.unknown.put(invHash, rqst.knownSince)
 
 			reassignInvs++
+		} else if rqst.from(p) {
+			rqst.moved = removePeer(rqst.moved, p)
 		}
 	}
 
@@ -171,8 +296,20 @@ func (om *ObjectManager) handleObjectMsg(omsg *objectMsg) {
 	hash := obj.InventoryHash(omsg.object)
 	invVect := (*wire.InvVect)(hash)
 
-	// Unrequested data means disconnect.
-	if rqst, exists := om.requested[*invVect]; !exists || rqst.peer != omsg.peer {
+	// Objects which were requested from the peer before they were moved to
+	// another peer or received from one are ignored.
+	rqst, exists := om.requested[*invVect]
+	if (!exists || !rqst.from(omsg.peer)) && om.superseded.remove(*invVect, omsg.peer) {
+		if w, ok := om.windows[omsg.peer]; ok {
+			w.deliver(false, time.Now())
+		}
+		log.Trace(omsg.peer.PrependAddr(fmt.Sprint("Object ", hash.String()[:8],
+			" received after it was no longer expected.")))
+		return
+	}
+
+	// Unrequested data is ignored and counts against the peer.
+	if !exists || !rqst.from(omsg.peer) {
 		// An attacker could guess which objects are being requested from peers
 		// and send them before the actual peer the object was requested from,
 		// thus disconnecting legitimate peers. We want to prevent against such
@@ -180,28 +317,81 @@ func (om *ObjectManager) handleObjectMsg(omsg *objectMsg) {
 		// from.
 		// NOTE: PyBitmessage does not do this, so for now if this actually
 		// happens it should be considered more likely to be an indication of a
-		// bug in bmd itself rather than a malicious peer.
+		// bug in bmd itself rather than a malicious peer. The penalty is
+		// therefore transient, so that only peers which do this repeatedly
+		// are banned.
 		log.Error(omsg.peer.Addr().String(),
-			" Disconnecting because of unrequested object ", hash.String()[:8], " received.")
-		om.server.DisconnectPeer(omsg.peer)
+			" Unrequested object ", hash.String()[:8], " received.")
+		omsg.peer.AddBanScore(0, peer.BanScoreUnrequestedObject,
+			"unrequested object")
 		return
 	}
 
 	now := time.Now()
 	om.peers[omsg.peer] = time.Now()
 
+	// The other peers the object was requested from are no longer expected
+	// to deliver it.
 	delete(om.requested, *invVect)
+	if w, ok := om.windows[omsg.peer]; ok {
+		w.deliver(omsg.peer == rqst.peer, now)
+		w.verify()
+	}
+	if omsg.peer != rqst.peer {
+		if w, ok := om.windows[rqst.peer]; ok {
+			w.drop(1, now)
+		}
+		om.superseded.add(*invVect, now, rqst.peer)
+	}
+	om.superseded.add(*invVect, now, removePeer(rqst.moved, omsg.peer)...)
+
+	// The object is checked by the verifier, which hands it back to
+	// handleVerified.
+	om.verifier.submit(omsg.object, omsg.peer, now)
+}
 
-	// Check PoW.
-	if !omsg.object.CheckPow(pow.Default, time.Now()) {
-		return // invalid PoW
+// handleVerified handles an object received from a peer once it has been
+// checked against the policy by the verifier.
+func (om *ObjectManager) handleVerified(j *verifyJob) {
+	hash := (*hash.Sha)(&j.inv)
+
+	// Give the peer more to download once half of its window, or all of
+	// it, is free.
+	if w, ok := om.windows[j.peer]; ok {
+		w.verified()
+		if busy := w.busy(); busy == 0 || busy == w.size/2 {
+			om.handleReadyPeer(j.peer)
+		}
 	}
 
-	log.Debugf(omsg.peer.PrependAddr(fmt.Sprint("Object ", hash.String()[:8],
-		" received; ", omsg.peer.Inventory.NumRequests(), " still assigned; ", len(om.requested), " still queued; ",
-		om.unknown.size(), " unqueued; last receipt = ", now)))
+	// Only an object with insufficient proof of work is the peer's fault.
+	// Others may be expired by the time they arrive or otherwise just not
+	// be wanted by us. Either way, the object is not requested again for a
+	// while.
+	if j.err != nil {
+		om.rejects.add(j.inv, j.err, time.Now())
+
+		if rerr, ok := j.err.(*RejectError); ok && rerr.Code == RejectPow {
+			j.peer.AddBanScore(peer.BanScoreInvalidPow, 0, fmt.Sprint(
+				"invalid proof of work on object ", hash.String()[:8]))
+			return
+		}
+
+		log.Debug(j.peer.PrependAddr(fmt.Sprint("Object ", hash.String()[:8],
+			" rejected: ", j.err)))
+		return
+	}
 
-	om.HandleInsert(omsg.object)
+	log.Debugf(j.peer.PrependAddr(fmt.Sprint("Object ", hash.String()[:8],
+		" received; ", j.peer.Inventory.NumRequests(), " still assigned; ", len(om.requested), " still queued; ",
+		om.unknown.size(), " unqueued; last receipt = ", j.received)))
+
+	// The object is inserted here rather than by the verifier's workers,
+	// so that objects are inserted in the order they arrived, and so that
+	// an object is always either in the verifier or in the database when
+	// handleInvMsg checks whether to request it. Inserting an object takes
+	// little time next to checking its proof of work.
+	om.HandleInsert(j.object)
 }
 
 // HandleInsert inserts a new object into the database and relays it to the peers.
@@ -218,11 +408,21 @@ func (om *ObjectManager) HandleInsert(object *wire.MsgObject) uint64 {
 	om.server.NotifyObject(object.Header().ObjectType)
 
 	// Advertise objects to other peers.
-	om.relayInvList.PushBack(invVect)
+	om.relayInvList.PushBack(&relayInv{
+		inv:    invVect,
+		stream: uint32(object.Header().StreamNumber),
+	})
 
 	return counter
 }
 
+// Accept checks whether an object is accepted by the object manager's policy.
+// Objects submitted locally must be checked with Accept before they are
+// passed to HandleInsert.
+func (om *ObjectManager) Accept(object *wire.MsgObject, source Source) error {
+	return om.policy.Accept(object, source, time.Now())
+}
+
 // HaveInventory returns whether or not the inventory represented by the passed
 // inventory vector is known. This includes checking all of the various places
 // inventory can be.
@@ -234,15 +434,46 @@ func (om *ObjectManager) HaveInventory(invVect *wire.InvVect) (bool, error) {
 // We examine the inventory advertised by the remote peer and act accordingly.
 func (om *ObjectManager) handleInvMsg(imsg *invMsg) {
 	requestList := make([]*wire.InvVect, len(imsg.inv.InvList))
+	host := peerHost(imsg.peer)
+	now := time.Now()
 
 	// Request the advertised inventory if we don't already have it.
 	numInvs := uint32(0)
+	var penalty, rejected uint32
 	for _, iv := range imsg.inv.InvList {
+		// Note the peers which advertise objects from the outbox. Peers
+		// never advertise an object to the peer they got it from, so these
+		// got it elsewhere.
+		if _, confirmed := om.outbox.relayed(iv, host); confirmed {
+			log.Debug("Object ", (*hash.Sha)(iv).String()[:8],
+				" relayed by enough peers; removed from outbox.")
+		}
+
+		// Objects which were rejected recently are not requested again.
+		// Peers which advertise an object with insufficient proof of work
+		// over and over are penalized. Other objects may have been
+		// rejected for reasons which are no fault of the peer.
+		if r, repeat := om.rejects.advertise(*iv, imsg.peer, now); r != nil {
+			if code, ok := r.code(); repeat && ok && code == RejectPow {
+				penalty += peer.BanScoreRejectedInv
+				rejected++
+			}
+			log.Trace(imsg.peer.PrependAddr(fmt.Sprint("Object ",
+				(*hash.Sha)(iv).String()[:8], " advertised after it was rejected: ",
+				r.err)))
+			continue
+		}
+
 		haveInv, err := om.HaveInventory(iv)
 		if err != nil || haveInv {
 			continue
 		}
 
+		// If the object has been received and is being checked, ignore it.
+		if om.verifier.has(iv) {
+			continue
+		}
+
 		// If the object is already known about, ignore it.
 		if _, ok := om.unknown.get(*iv); ok {
 			continue
@@ -258,18 +489,22 @@ func (om *ObjectManager) handleInvMsg(imsg *invMsg) {
 		numInvs++
 	}
 
+	if penalty > 0 {
+		imsg.peer.AddBanScore(0, penalty, fmt.Sprint("advertised ", rejected,
+			" rejected objects"))
+	}
+
 	// Nothing to request, so we are done.
 	if numInvs == 0 {
 		return
 	}
 	log.Debug("Inv received with ", len(requestList), " unknown objects.")
 
-	// If the request can fit, we just request it from the peer that told us
-	// about it in the first place.
-	if numInvs <= peer.MaxPeerRequests-imsg.peer.Inventory.NumRequests() {
-
+	// If the request can fit in the window of the peer that told us about it
+	// in the first place, we just request it from that peer.
+	w, ok := om.windows[imsg.peer]
+	if ok && int(numInvs) <= w.room() && !om.verifier.full() {
 		for _, iv := range requestList[:numInvs] {
-			now := time.Now()
 			om.requested[*iv] = &peerRequest{
 				peer:       imsg.peer,
 				timestamp:  now,
@@ -280,6 +515,7 @@ func (om *ObjectManager) handleInvMsg(imsg *invMsg) {
 			"; total assigned is ", len(om.requested))
 
 		imsg.peer.PushGetDataMsg(requestList[:numInvs])
+		w.request(int(numInvs), now)
 		om.working[imsg.peer] = struct{}{}
 
 		// Send the peer more to download if there is more that it could be doing.
@@ -292,7 +528,6 @@ func (om *ObjectManager) handleInvMsg(imsg *invMsg) {
 		return
 	}
 
-	now := time.Now()
 	// Add the new invs to the list of unknown invs.
 	for _, iv := range requestList[:numInvs] {
 		om.unknown.put(*iv, now)
@@ -305,15 +540,50 @@ func (om *ObjectManager) handleInvMsg(imsg *invMsg) {
 	}
 }
 
+// peerLatency is a peer with its average round trip time.
+type peerLatency struct {
+	peer    *peer.Peer
+	latency time.Duration
+}
+
+// byLatency implements sort.Interface to sort peers by latency, putting those
+// whose latency has not been measured last.
+type byLatency []peerLatency
+
+func (s byLatency) Len() int      { return len(s) }
+func (s byLatency) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byLatency) Less(i, j int) bool {
+	if s[i].latency == 0 || s[j].latency == 0 {
+		return s[j].latency == 0 && s[i].latency != 0
+	}
+	return s[i].latency < s[j].latency
+}
+
+// peersByLatency returns the peers ordered by their average round trip time,
+// so that objects are requested from the fastest peers first.
+func peersByLatency(peers map[*peer.Peer]time.Time) []*peer.Peer {
+	latencies := make(byLatency, 0, len(peers))
+	for p := range peers {
+		latencies = append(latencies, peerLatency{p, p.Latency().Average})
+	}
+	sort.Sort(latencies)
+
+	sorted := make([]*peer.Peer, len(latencies))
+	for i, pl := range latencies {
+		sorted[i] = pl.peer
+	}
+	return sorted
+}
+
 // assignRequests takes the list of unknown objects and tries to assign some
-// to each peer, up to the maximum allowed.
+// to each peer, up to the maximum allowed, starting with the fastest peers.
 func (om *ObjectManager) assignRequests() {
 	if len(om.peers) == 0 {
 		return
 	}
 	log.Trace("Assigning objects to peers for download; number of requested objects: ", len(om.requested))
 
-	for peer := range om.peers {
+	for _, peer := range peersByLatency(om.peers) {
 		if om.unknown.size() == 0 {
 			return
 		}
@@ -337,7 +607,10 @@ func (om *ObjectManager) clearRequests(d time.Duration) {
 	for h, rqst := range om.requested {
 		// if request has expired
 		if rqst.timestamp.Add(d).Before(now) {
-			peerQueueSize := rqst.peer.Inventory.NumRequests()
+			var peerQueueSize int
+			if w, ok := om.windows[rqst.peer]; ok {
+				peerQueueSize = w.outstanding
+			}
 			lastReceipt := om.peers[rqst.peer]
 			// If the peer has a long queue of requested objects, then don't
 			// disconnect it unless it has not received SOME object recently enough,
@@ -351,7 +624,7 @@ func (om *ObjectManager) clearRequests(d time.Duration) {
 				// may have just expired. Therefore, drop the request without
 				// penalizing the peer.
 				if rqst.knownSince.Add(unsentObjectPenaltyTimeout).Before(rqst.timestamp) {
-					delete(om.requested, h)
+					om.dropRequest(h, now)
 					continue
 				}
 
@@ -370,13 +643,273 @@ func (om *ObjectManager) clearRequests(d time.Duration) {
 			}
 		}
 	}
+
+	om.superseded.expire(d, now)
+	om.rejects.expire(now)
+}
+
+// removePeer returns the peers other than p.
+func removePeer(peers []*peer.Peer, p *peer.Peer) []*peer.Peer {
+	var others []*peer.Peer
+	for _, op := range peers {
+		if op != p {
+			others = append(others, op)
+		}
+	}
+	return others
+}
+
+// dropRequest forgets the request for an object, so that the peers it was
+// requested from are no longer expected to deliver it.
+func (om *ObjectManager) dropRequest(inv wire.InvVect, now time.Time) {
+	rqst, ok := om.requested[inv]
+	if !ok {
+		return
+	}
+
+	delete(om.requested, inv)
+	if w, ok := om.windows[rqst.peer]; ok {
+		w.drop(1, now)
+	}
+	om.superseded.add(inv, now, rqst.peer)
+	om.superseded.add(inv, now, rqst.moved...)
+}
+
+// peerRate is a peer with its delivery rate.
+type peerRate struct {
+	peer *peer.Peer
+	rate float64
+}
+
+// byRate implements sort.Interface to sort peers by delivery rate, fastest
+// first.
+type byRate []peerRate
+
+func (s byRate) Len() int           { return len(s) }
+func (s byRate) Less(i, j int) bool { return s[i].rate > s[j].rate }
+func (s byRate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// rebalanceRequests moves the requests which have waited too long for a slow
+// peer to faster peers which know the objects and have room for them. The
+// windows of the slow peers are shrunk.
+func (om *ObjectManager) rebalanceRequests() {
+	rates := make(byRate, 0, len(om.windows))
+	for p, w := range om.windows {
+		if w.rate > 0 {
+			rates = append(rates, peerRate{p, w.rate})
+		}
+	}
+	if len(rates) == 0 {
+		return
+	}
+	sort.Sort(rates)
+
+	now := time.Now()
+	moves := make(map[*peer.Peer][]*wire.InvVect)
+	stalled := make(map[*peer.Peer]struct{})
+	for inv, rqst := range om.requested {
+		w, ok := om.windows[rqst.peer]
+		if !ok || now.Sub(rqst.timestamp) < w.stallTimeout() {
+			continue
+		}
+
+		// Find the fastest peer which is faster than this one.
+		var to *peer.Peer
+		for _, pr := range rates {
+			if pr.rate <= w.rate {
+				break
+			}
+			if rqst.from(pr.peer) ||
+				om.windows[pr.peer].room() <= len(moves[pr.peer]) ||
+				!pr.peer.Inventory.IsKnown(&inv) {
+				continue
+			}
+			to = pr.peer
+			break
+		}
+		if to == nil {
+			continue
+		}
+
+		w.drop(1, now)
+		stalled[rqst.peer] = struct{}{}
+
+		rqst.moved = append(rqst.moved, rqst.peer)
+		rqst.peer = to
+		rqst.timestamp = now
+
+		newiv := inv
+		moves[to] = append(moves[to], &newiv)
+	}
+
+	for p := range stalled {
+		om.windows[p].stall()
+	}
+	for p, ivl := range moves {
+		log.Debug(p.PrependAddr(fmt.Sprint(len(ivl),
+			" stalled requests moved from slower peers.")))
+		om.moved += uint64(len(ivl))
+		p.PushGetDataMsg(ivl)
+		om.windows[p].request(len(ivl), now)
+	}
+}
+
+// handleExpiredMsg forgets about an object which has expired and has been
+// removed from the database, so that it is no longer requested from peers or
+// assumed to be known by them.
+func (om *ObjectManager) handleExpiredMsg(ex *database.ExpiredObject) {
+	inv := (*wire.InvVect)(ex.Hash)
+
+	om.expired++
+	om.unknown.del(*inv)
+	om.dropRequest(*inv, time.Now())
+	delete(om.superseded, *inv)
+	for peer := range om.peers {
+		peer.Inventory.RemoveKnown(inv)
+	}
+
+	om.outbox.remove(inv)
+}
+
+// handleGetDataMsg notes the peers which request objects from the outbox,
+// which shows that they are being passed on.
+func (om *ObjectManager) handleGetDataMsg(gmsg *getDataMsg) {
+	host := peerHost(gmsg.peer)
+	for _, iv := range gmsg.getData.InvList {
+		if _, confirmed := om.outbox.relayed(iv, host); confirmed {
+			log.Debug("Object ", (*hash.Sha)(iv).String()[:8],
+				" requested by enough peers; removed from outbox.")
+		}
+	}
+}
+
+// peerHost returns the host of a peer, so that a peer which reconnects from
+// another port is recognized.
+func peerHost(p *peer.Peer) string {
+	host, _, err := net.SplitHostPort(p.Addr().String())
+	if err != nil {
+		return p.Addr().String()
+	}
+	return host
+}
+
+// saveOutbox saves the outbox so that it survives a restart. It is called
+// periodically and on shutdown rather than on every change, since changes
+// may come with every inv message and the outbox is saved as a whole.
+func (om *ObjectManager) saveOutbox() {
+	if err := om.outbox.save(); err != nil {
+		log.Errorf("Failed to save outbox: %v", err)
+	}
+}
+
+// release inserts an object from the outbox into the database and relays it
+// to the peers. It returns the counter value of the inserted object.
+func (om *ObjectManager) release(e *outboxEntry, now time.Time) (uint64, error) {
+	e.released = true
+	om.outbox.dirty = true
+	if len(om.peers) > 0 {
+		e.announced = now
+	}
+
+	// The object may have been received from a peer in the meantime.
+	if have, err := om.HaveInventory(&e.inv); err == nil && have {
+		om.relayInvList.PushBack(&relayInv{
+			inv:    &e.inv,
+			stream: e.stream(),
+		})
+		return 0, nil
+	}
+
+	counter := om.HandleInsert(e.object)
+	if counter == 0 {
+		om.outbox.remove(&e.inv)
+		return 0, fmt.Errorf("failed to insert object %s",
+			(*hash.Sha)(&e.inv).String()[:8])
+	}
+
+	log.Debug("Object ", (*hash.Sha)(&e.inv).String()[:8],
+		" released from outbox.")
+	return counter, nil
+}
+
+// announce announces an object from the outbox again to the peers in its
+// stream which have neither requested it from us nor advertised it to us,
+// including those which are already known to have it.
+func (om *ObjectManager) announce(e *outboxEntry, now time.Time) {
+	for peer := range om.peers {
+		if !peer.InStream(e.stream()) {
+			continue
+		}
+		if _, ok := e.relayedBy[peerHost(peer)]; ok {
+			continue
+		}
+
+		peer.Inventory.RemoveKnown(&e.inv)
+		peer.HandleRelayInvMsg([]*wire.InvVect{&e.inv})
+		e.announced = now
+	}
+}
+
+// handleSendMsg adds an object to the outbox, releasing it at once if it is
+// due. It replies with the counter value of the object if it was inserted
+// into the database, or 0 if it is waiting.
+func (om *ObjectManager) handleSendMsg(msg *sendMsg) {
+	e, err := om.outbox.add(msg.object, msg.sendAt, msg.minPeers)
+	if err != nil {
+		msg.reply <- sendReply{err: err}
+		return
+	}
+
+	var counter uint64
+	now := time.Now()
+	if !now.Before(e.sendAt) && len(om.peers) >= e.minPeers {
+		counter, err = om.release(e, now)
+	} else {
+		log.Debug("Object ", (*hash.Sha)(&e.inv).String()[:8],
+			" added to outbox to be sent at ", e.sendAt, " with ",
+			e.minPeers, " peers connected.")
+	}
+
+	// Objects submitted locally are saved at once, so that none is lost
+	// once the sender has been told it is in the outbox.
+	om.saveOutbox()
+	msg.reply <- sendReply{counter: counter, err: err}
+}
+
+// handleOutbox releases the objects in the outbox which are due, announces
+// again those which have not been relayed by enough peers, and saves the
+// outbox if it has changed.
+func (om *ObjectManager) handleOutbox() {
+	now := time.Now()
+	om.outbox.removeExpired(now)
+
+	for _, e := range om.outbox.due(now, len(om.peers)) {
+		if _, err := om.release(e, now); err != nil {
+			log.Error(err)
+		}
+	}
+
+	if len(om.peers) > 0 {
+		for _, e := range om.outbox.unconfirmed(now) {
+			log.Trace("Announcing object ", (*hash.Sha)(&e.inv).String()[:8],
+				" from outbox again.")
+			om.announce(e, now)
+		}
+	}
+
+	om.saveOutbox()
 }
 
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
-// known to have it. It is invoked from the peerHandler goroutine.
-func (om *ObjectManager) handleRelayInvMsg(inv []*wire.InvVect) {
+// known to have it. Inventory is only relayed to peers in the same stream as
+// the object. It is invoked from the peerHandler goroutine.
+func (om *ObjectManager) handleRelayInvMsg(inv map[uint32][]*wire.InvVect) {
 	for peer := range om.peers {
-		peer.HandleRelayInvMsg(inv)
+		for stream, ivl := range inv {
+			if peer.InStream(stream) {
+				peer.HandleRelayInvMsg(ivl)
+			}
+		}
 	}
 }
 
@@ -385,17 +918,33 @@ func (om *ObjectManager) handleRelayInvMsg(inv []*wire.InvVect) {
 // handlers.
 func (om *ObjectManager) objectHandler() {
 	clearTick := time.NewTicker(om.requestExpire / 2)
+	rebalanceTick := time.NewTicker(rebalanceInterval)
 	relayInvTick := time.NewTicker(10 * time.Second)
-	cleanupTick := time.NewTicker(om.cleanupInterval)
 
 	for {
 		select {
 		case <-om.quit:
+			om.saveOutbox()
 			relayInvTick.Stop()
+			rebalanceTick.Stop()
 			clearTick.Stop()
 			om.wg.Done()
 			return
 
+		case <-rebalanceTick.C:
+			om.rebalanceRequests()
+
+		case j := <-om.verifier.results:
+			full := om.verifier.full()
+			for _, verified := range om.verifier.complete(j, time.Now()) {
+				om.handleVerified(verified)
+			}
+
+			// Objects are requested again once the verifier has room.
+			if full && !om.verifier.full() {
+				om.assignRequests()
+			}
+
 		case <-clearTick.C:
 			// Under normal operation, we expire requests much more quickly
 			// than during the initial download.
@@ -404,41 +953,22 @@ func (om *ObjectManager) objectHandler() {
 		// Relay to the other peers all the new invs collected
 		// during the past ten seconds.
 		case <-relayInvTick.C:
+			om.handleOutbox()
 			if om.relayInvList.Len() == 0 {
 				continue
 			}
-			invs := make([]*wire.InvVect, om.relayInvList.Len())
-			i := 0
+			invs := make(map[uint32][]*wire.InvVect)
 			for e := om.relayInvList.Front(); e != nil; e = e.Next() {
-				invs[i] = e.Value.(*wire.InvVect)
-				i++
+				ri := e.Value.(*relayInv)
+				invs[ri.stream] = append(invs[ri.stream], ri.inv)
 			}
+			log.Trace("Relaying list of invs of size ", om.relayInvList.Len())
 			om.relayInvList = list.New()
-			log.Trace("Relaying list of invs of size ", len(invs))
 			om.handleRelayInvMsg(invs)
 
-		// Clean all expired inventory from all peers.
-		case <-cleanupTick.C:
-			expired, err := om.db.RemoveExpiredObjects()
-			log.Trace("Cleanup time: ", len(expired), " objects removed.")
-			if len(expired) == 0 || err != nil {
-				continue
-			}
-			for _, ex := range expired {
-
-				inv := (*wire.InvVect)(ex)
-
-				for peer := range om.peers {
-					peer.Inventory.RemoveKnown(inv)
-				}
-			}
-
 		case m := <-om.msgChan:
 			switch msg := m.(type) {
 
-			case *readyPeerMsg:
-				om.handleReadyPeer((*peer.Peer)(msg))
-
 			case *newPeerMsg:
 				om.handleNewPeer(msg.peer)
 
@@ -448,8 +978,37 @@ func (om *ObjectManager) objectHandler() {
 			case *invMsg:
 				om.handleInvMsg(msg)
 
+			case *getDataMsg:
+				om.handleGetDataMsg(msg)
+
 			case *donePeerMsg:
 				om.handleDonePeer(msg.peer)
+
+			case *expiredMsg:
+				om.handleExpiredMsg(msg.object)
+
+			case *sendMsg:
+				om.handleSendMsg(msg)
+
+			case *statusMsg:
+				scheduled, unconfirmed := om.outbox.counts()
+				queued, verifying := om.verifier.queued()
+				msg.reply <- &Status{
+					Peers:        len(om.peers),
+					Requested:    len(om.requested),
+					Unknown:      int(om.unknown.size()),
+					Expired:      om.expired,
+					Scheduled:    scheduled,
+					Unconfirmed:  unconfirmed,
+					Moved:        om.moved,
+					VerifyQueued: queued,
+					Verifying:    verifying,
+					Verified:     om.verifier.verified,
+					VerifyTime:   om.verifier.latency,
+
+					Rejected:           om.rejects.len(),
+					RejectedAdvertised: om.rejects.advertised,
+				}
 			}
 		}
 	}
@@ -465,16 +1024,6 @@ func (om *ObjectManager) NewPeer(p *peer.Peer) {
 	om.msgChan <- &newPeerMsg{peer: p}
 }
 
-// ReadyPeer signals that a peer is ready to download more objects.
-func (om *ObjectManager) ReadyPeer(p *peer.Peer) {
-	// Ignore if we are shutting down.
-	if atomic.LoadInt32(&om.shutdown) != 0 {
-		return
-	}
-
-	om.msgChan <- (*readyPeerMsg)(p)
-}
-
 // QueueObject adds the passed object message and peer to the object handling
 // queue.
 func (om *ObjectManager) QueueObject(object *wire.MsgObject, p *peer.Peer) {
@@ -496,6 +1045,17 @@ func (om *ObjectManager) QueueInv(inv *wire.MsgInv, p *peer.Peer) {
 	om.msgChan <- &invMsg{inv: inv, peer: p}
 }
 
+// QueueGetData adds the passed getdata message and peer to the object handling
+// queue.
+func (om *ObjectManager) QueueGetData(getData *wire.MsgGetData, p *peer.Peer) {
+	// Ignore if we are shutting down.
+	if atomic.LoadInt32(&om.shutdown) != 0 {
+		return
+	}
+
+	om.msgChan <- &getDataMsg{getData: getData, peer: p}
+}
+
 // DonePeer informs the object manager that a peer has disconnected.
 func (om *ObjectManager) DonePeer(p *peer.Peer) {
 	// Ignore if we are shutting down.
@@ -506,6 +1066,64 @@ func (om *ObjectManager) DonePeer(p *peer.Peer) {
 	om.msgChan <- &donePeerMsg{peer: p}
 }
 
+// ExpireObject informs the object manager that an object has expired and has
+// been removed from the database.
+func (om *ObjectManager) ExpireObject(ex *database.ExpiredObject) {
+	// Ignore if we are shutting down.
+	if atomic.LoadInt32(&om.shutdown) != 0 {
+		return
+	}
+
+	select {
+	case om.msgChan <- &expiredMsg{object: ex}:
+	case <-om.quit:
+	}
+}
+
+// Send submits an object to be sent out to the network once the time is
+// sendAt and at least minPeers peers are connected. The object waits in the
+// outbox until then and is announced again periodically until enough peers
+// have relayed it back to us. It returns the counter value of the object if it
+// was inserted into the database at once, or 0 if it is waiting. Objects
+// submitted locally must be checked with Accept before they are passed to
+// Send.
+func (om *ObjectManager) Send(object *wire.MsgObject, sendAt time.Time, minPeers int) (uint64, error) {
+	if atomic.LoadInt32(&om.shutdown) != 0 {
+		return 0, errors.New("object manager is shutting down")
+	}
+
+	reply := make(chan sendReply, 1)
+	select {
+	case om.msgChan <- &sendMsg{
+		object:   object,
+		sendAt:   sendAt,
+		minPeers: minPeers,
+		reply:    reply,
+	}:
+	case <-om.quit:
+		return 0, errors.New("object manager is shutting down")
+	}
+
+	r := <-reply
+	return r.counter, r.err
+}
+
+// Status returns the current status of the object manager. It returns nil if
+// the object manager is not running.
+func (om *ObjectManager) Status() *Status {
+	if atomic.LoadInt32(&om.started) == 0 || atomic.LoadInt32(&om.shutdown) != 0 {
+		return nil
+	}
+
+	reply := make(chan *Status, 1)
+	select {
+	case om.msgChan <- &statusMsg{reply: reply}:
+		return <-reply
+	case <-om.quit:
+		return nil
+	}
+}
+
 // Start begins the core object handler which processes object messages.
 func (om *ObjectManager) Start() {
 	// Already started?
@@ -515,6 +1133,7 @@ func (om *ObjectManager) Start() {
 
 	log.Info("Object manager started.")
 
+	om.verifier.start()
 	om.wg.Add(1)
 	go om.objectHandler()
 }
@@ -530,21 +1149,35 @@ func (om *ObjectManager) Stop() error {
 
 	close(om.quit)
 	om.wg.Wait()
+	om.verifier.stop()
 	return nil
 }
 
-// NewObjectManager returns a new bitmessage object manager. Use Start to begin
-// processing objects and inv messages asynchronously.
-func NewObjectManager(s server, db *database.Db, requestExpire,
-	cleanupInterval time.Duration, z stats.Stats) *ObjectManager {
+// NewObjectManager returns a new bitmessage object manager. Objects received
+// from peers are checked against the policy on verifyWorkers goroutines. Use
+// Start to begin processing objects and inv messages asynchronously.
+func NewObjectManager(s server, db *database.Db, policy Policy, outbox *Outbox,
+	requestExpire time.Duration, verifyWorkers int, z stats.Stats) *ObjectManager {
 	unk := make(map[wire.InvVect]time.Time)
 
 	// A timer that tests when the object manager is up-to-date with the network.
 	var upToDateTimer stats.UpToDateTimer
 
+	del := func(w wire.InvVect) {
+		if _, ok := unk[w]; !ok {
+			return
+		}
+		delete(unk, w)
+
+		if len(unk) == 0 {
+			upToDateTimer.Finish()
+		}
+	}
+
 	unknown := struct {
 		put  func(wire.InvVect, time.Time)
 		get  func(wire.InvVect) (time.Time, bool)
+		del  func(wire.InvVect)
 		size func() uint32
 	}{
 		put: func(w wire.InvVect, t time.Time) {
@@ -558,33 +1191,29 @@ func NewObjectManager(s server, db *database.Db, requestExpire,
 			t, ok := unk[w]
 			return t, ok
 		},
+		del: del,
 		size: func() uint32 {
 			return uint32(len(unk))
 		},
 	}
 
-	del := func(w wire.InvVect) {
-		delete(unk, w)
-
-		if len(unk) == 0 {
-			upToDateTimer.Finish()
-		}
-	}
-
 	peers := make(map[*peer.Peer]time.Time)
 	working := make(map[*peer.Peer]struct{})
+	windows := make(map[*peer.Peer]*downloadWindow)
 	requested := make(map[wire.InvVect]*peerRequest)
 
 	// cleanUnknown is called after all the downloading work is done and it clears
-	// out any remaining invs that are not known by any peers.
+	// out any remaining invs that are not known by any peers. The others are
+	// requested from the fastest peer which knows them.
 	cleanUnknown := func() {
 		if unknown.size() == 0 {
 			return
 		}
 
+		sorted := peersByLatency(peers)
 	loop:
 		for iv := range unk {
-			for peer := range peers {
+			for _, peer := range sorted {
 				if peer.Inventory.IsKnown(&iv) {
 					peer.PushGetDataMsg([]*wire.InvVect{&iv})
 					continue loop
@@ -595,7 +1224,15 @@ func NewObjectManager(s server, db *database.Db, requestExpire,
 		}
 	}
 
+	verifier := newVerifier(policy, verifyWorkers)
+
 	handleReadyPeer := func(p *peer.Peer) {
+		// Nothing more is requested while the verifier is full. The peer
+		// is still working, since it has objects in the verifier.
+		if verifier.full() {
+			return
+		}
+
 		assigned := uint32(0)
 
 		// Detect if no peers are working by the end of this function and call
@@ -617,11 +1254,13 @@ func NewObjectManager(s server, db *database.Db, requestExpire,
 		}
 		log.Trace("Assigning objects to peer ", p.Addr().String())
 
-		// If the object is already downloading too much, return.
-		max := peer.MaxPeerRequests - p.Inventory.NumRequests()
-		if max <= 0 {
+		// If the peer already has as many requests as its window allows,
+		// return.
+		w, ok := windows[p]
+		if !ok || w.room() == 0 {
 			return
 		}
+		max := uint32(w.room())
 
 		requestList := make([]*wire.InvVect, max)
 
@@ -668,20 +1307,26 @@ func NewObjectManager(s server, db *database.Db, requestExpire,
 		log.Trace(assigned, " objects assigned to peer ", p.Addr().String(), ". ", unknown.size(),
 			" unassigned objects remaining and ", len(requested), " total assigned.")
 		p.PushGetDataMsg(requestList[:assigned])
+		w.request(int(assigned), time.Now())
 	}
 
 	return &ObjectManager{
 		requestExpire:   requestExpire,
-		cleanupInterval: cleanupInterval,
 		server:          s,
 		db:              db,
+		policy:          policy,
 		requested:       requested,
 		unknown:         unknown,
 		msgChan:         make(chan interface{}),
 		quit:            make(chan struct{}),
 		peers:           peers,
 		working:         working,
+		windows:         windows,
+		superseded:      make(supersededRequests),
 		relayInvList:    list.New(),
+		outbox:          outbox,
+		verifier:        verifier,
+		rejects:         newRejectCache(maxRejects, rejectLifetime),
 		handleReadyPeer: handleReadyPeer,
 	}
 }
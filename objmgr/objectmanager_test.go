@@ -0,0 +1,217 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/addrmgr"
+	"github.com/DanielKrawisz/bmd/database"
+	_ "github.com/DanielKrawisz/bmd/database/memdb"
+	"github.com/DanielKrawisz/bmd/objmgr/stats"
+	"github.com/DanielKrawisz/bmd/peer"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+// testServer serves both as the server of the object manager and as that of
+// the peers in the tests.
+type testServer struct {
+	om     *ObjectManager
+	db     *database.Db
+	banned map[*peer.Peer]struct{}
+}
+
+func (s *testServer) Nonce() uint64                     { return 1 }
+func (s *testServer) Streams() []uint32                 { return []uint32{1} }
+func (s *testServer) AddrManager() *addrmgr.AddrManager { return nil }
+func (s *testServer) ObjectManager() peer.ObjectManager { return s.om }
+func (s *testServer) Db() *database.Db                  { return s.db }
+func (s *testServer) SyncMemory() *peer.SyncMemory      { return nil }
+func (s *testServer) DonePeer(*peer.Peer)               {}
+func (s *testServer) BanPeer(p *peer.Peer)              { s.banned[p] = struct{}{} }
+func (s *testServer) BanThreshold() uint32              { return 100 }
+func (s *testServer) DisconnectPeer(*peer.Peer)         {}
+func (s *testServer) NotifyObject(wire.ObjectType)      {}
+
+// testConn is a connection to a peer which sends and receives nothing.
+type testConn struct {
+	addr net.Addr
+}
+
+func (c *testConn) WriteMessage(wire.Message) error    { return nil }
+func (c *testConn) ReadMessage() (wire.Message, error) { return nil, nil }
+func (c *testConn) BytesWritten() uint64               { return 0 }
+func (c *testConn) BytesRead() uint64                  { return 0 }
+func (c *testConn) LastWrite() time.Time               { return time.Time{} }
+func (c *testConn) LastRead() time.Time                { return time.Time{} }
+func (c *testConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *testConn) Connected() bool                    { return true }
+func (c *testConn) Connect() error                     { return nil }
+func (c *testConn) Close()                             {}
+
+// testSend records the inventory queued for a peer.
+type testSend struct {
+	inventory []*wire.InvVect
+}
+
+func (s *testSend) QueueMessage(wire.Message) error        { return nil }
+func (s *testSend) QueueDataRequest([]*wire.InvVect) error { return nil }
+func (s *testSend) QueueInventory(inv []*wire.InvVect) error {
+	s.inventory = append(s.inventory, inv...)
+	return nil
+}
+func (s *testSend) Start(conn peer.Connection) {}
+func (s *testSend) Running() bool              { return true }
+func (s *testSend) Stop()                      {}
+
+// newTestObjectManager returns an object manager which is not running, so
+// that the tests can call its handlers directly.
+func newTestObjectManager(t *testing.T, cfg *OutboxConfig) (*ObjectManager, *testServer) {
+	db, err := database.OpenDB("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := NewOutbox(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{
+		db:     db,
+		banned: make(map[*peer.Peer]struct{}),
+	}
+	s.om = NewObjectManager(s, db, &testPolicy{}, outbox, time.Minute, 1,
+		stats.Stats{})
+	return s.om, s
+}
+
+// newTestPeer adds a peer at the given address to the object manager.
+func newTestPeer(om *ObjectManager, s *testServer, ip string, port int) (*peer.Peer, *testSend) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+	na, _ := wire.NewNetAddress(addr, 1, 0)
+	send := &testSend{}
+	p := peer.NewPeerHandshakeComplete(s, &testConn{addr: addr},
+		peer.NewInventory(), send, na)
+	om.handleNewPeer(p)
+	return p, send
+}
+
+func TestOutboxConfirmation(t *testing.T) {
+	om, s := newTestObjectManager(t, &OutboxConfig{
+		Confirmations: 2,
+		Reannounce:    time.Minute,
+	})
+	a, aSend := newTestPeer(om, s, "10.0.0.1", 8444)
+	b, _ := newTestPeer(om, s, "10.0.0.2", 8444)
+
+	object := testOutboxObject(time.Now().Add(time.Hour), 1)
+	reply := make(chan sendReply, 1)
+	om.handleSendMsg(&sendMsg{object: object, reply: reply})
+	if r := <-reply; r.err != nil || r.counter == 0 {
+		t.Fatalf("object not released: %v", r.err)
+	}
+	e := om.outbox.entries[*obj.InventoryHash(object)]
+	ivl := []*wire.InvVect{&e.inv}
+
+	// The object is announced to peer a, which takes note that we have it.
+	// When peer a goes on to announce the object, the send filter for its
+	// connection to us keeps it from being announced back.
+	a.HandleRelayInvMsg(ivl)
+	if sent := aSend.inventory; len(sent) != 1 {
+		t.Fatalf("expected 1 inv announced to peer a, got %d", len(sent))
+	}
+	remote := peer.NewInventory()
+	remote.AddKnown(&e.inv)
+	if echo := remote.FilterKnown(ivl); len(echo) != 0 {
+		t.Fatal("object announced back to the peer it came from")
+	}
+
+	// Peer a requests the object, which counts once however often it does.
+	om.handleGetDataMsg(&getDataMsg{getData: &wire.MsgGetData{InvList: ivl}, peer: a})
+	om.handleGetDataMsg(&getDataMsg{getData: &wire.MsgGetData{InvList: ivl}, peer: a})
+	if _, ok := om.outbox.entries[e.inv]; !ok {
+		t.Fatal("object confirmed by a single peer")
+	}
+
+	// Peer b got the object elsewhere before we announced it, and
+	// advertises it to us.
+	om.handleInvMsg(&invMsg{inv: &wire.MsgInv{InvList: ivl}, peer: b})
+	if _, ok := om.outbox.entries[e.inv]; ok {
+		t.Error("object not confirmed by two peers")
+	}
+}
+
+func TestRejectedInvReconnect(t *testing.T) {
+	om, s := newTestObjectManager(t, &OutboxConfig{
+		Confirmations: 1,
+		Reannounce:    time.Minute,
+	})
+	now := time.Now()
+	badPow, expired := wire.InvVect{1}, wire.InvVect{2}
+	om.rejects.add(badPow, reject(RejectPow, "insufficient pow"), now)
+	om.rejects.add(expired, reject(RejectExpired, "expired"), now)
+	advertise := func(p *peer.Peer, inv wire.InvVect) {
+		om.handleInvMsg(&invMsg{
+			inv:  &wire.MsgInv{InvList: []*wire.InvVect{&inv}},
+			peer: p,
+		})
+	}
+
+	// A peer which advertises an object with insufficient proof of work
+	// again is penalized.
+	p, _ := newTestPeer(om, s, "10.0.0.1", 8444)
+	advertise(p, badPow)
+	if score := p.BanScore(); score != 0 {
+		t.Errorf("peer penalized for advertising the object once: %d", score)
+	}
+	advertise(p, badPow)
+	if p.BanScore() == 0 {
+		t.Error("peer not penalized for advertising the object again")
+	}
+
+	// Once it reconnects, even from the same host, it starts over.
+	om.handleDonePeer(p)
+	p, _ = newTestPeer(om, s, "10.0.0.1", 8445)
+	advertise(p, badPow)
+	if score := p.BanScore(); score != 0 {
+		t.Errorf("peer penalized after reconnecting: %d", score)
+	}
+
+	// Objects which were rejected through no fault of the peer may be
+	// advertised any number of times.
+	for i := 0; i < 3; i++ {
+		advertise(p, expired)
+	}
+	if score := p.BanScore(); score != 0 {
+		t.Errorf("peer penalized for advertising an expired object: %d", score)
+	}
+}
+
+func TestMalformedInv(t *testing.T) {
+	om, s := newTestObjectManager(t, &OutboxConfig{
+		Confirmations: 1,
+		Reannounce:    time.Minute,
+	})
+	p, _ := newTestPeer(om, s, "10.0.0.1", 8444)
+
+	// Empty invs and invs which are too big are penalized.
+	if err := p.HandleInvMsg(&wire.MsgInv{}); err == nil {
+		t.Error("empty inv accepted")
+	}
+	if score := p.BanScore(); score != peer.BanScoreMalformedInv {
+		t.Errorf("expected ban score %d, got %d", peer.BanScoreMalformedInv, score)
+	}
+
+	big := &wire.MsgInv{InvList: make([]*wire.InvVect, wire.MaxInvPerMsg+1)}
+	if err := p.HandleInvMsg(big); err == nil {
+		t.Error("inv which is too big accepted")
+	}
+	if score := p.BanScore(); score != 2*peer.BanScoreMalformedInv {
+		t.Errorf("expected ban score %d, got %d", 2*peer.BanScoreMalformedInv, score)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+// outboxVersion is the version of the format of the outbox file.
+const outboxVersion = 1
+
+// ErrInOutbox is returned when an object is submitted which is already waiting
+// in the outbox.
+var ErrInOutbox = errors.New("object already in outbox")
+
+// OutboxConfig configures the outbox of objects submitted locally.
+type OutboxConfig struct {
+	// File is the file in which the outbox is saved so that it survives a
+	// restart. The outbox is not saved if it is empty.
+	File string
+
+	// Confirmations is the number of peers at distinct hosts which must
+	// request an object from us, or advertise it to us having got it
+	// elsewhere, before it is taken to have been relayed and is removed
+	// from the outbox.
+	Confirmations int
+
+	// Reannounce is how often an object which has not been taken to have
+	// been relayed is announced again to the peers which have neither
+	// requested nor advertised it.
+	Reannounce time.Duration
+}
+
+// outboxEntry is an object waiting in the outbox.
+type outboxEntry struct {
+	object *wire.MsgObject
+	inv    wire.InvVect
+
+	// The object is released once the time is sendAt and at least minPeers
+	// peers are connected. It is then inserted into the database and
+	// announced to the peers.
+	sendAt   time.Time
+	minPeers int
+	released bool
+
+	// announced is when the object was last announced, and relayedBy are
+	// the hosts of the peers which have requested it from us or advertised
+	// it to us since.
+	announced time.Time
+	relayedBy map[string]struct{}
+}
+
+// Outbox holds the objects submitted locally until they are sent out to the
+// network and relayed by enough peers. It is not safe for concurrent access;
+// it is used only from the object manager's goroutine.
+type Outbox struct {
+	cfg     OutboxConfig
+	entries map[wire.InvVect]*outboxEntry
+
+	// dirty is whether the outbox has changed since it was last saved.
+	dirty bool
+}
+
+type serializedOutboxEntry struct {
+	Object    []byte
+	SendAt    int64
+	MinPeers  int
+	Released  bool
+	RelayedBy []string
+}
+
+type serializedOutbox struct {
+	Version int
+	Entries []serializedOutboxEntry
+}
+
+// NewOutbox returns an Outbox with the objects saved in the configured file,
+// if any. Objects which have expired are dropped.
+func NewOutbox(cfg *OutboxConfig) (*Outbox, error) {
+	o := &Outbox{
+		cfg:     *cfg,
+		entries: make(map[wire.InvVect]*outboxEntry),
+	}
+	if cfg.File == "" {
+		return o, nil
+	}
+
+	r, err := os.Open(cfg.File)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s error opening file: %v", cfg.File, err)
+	}
+	defer r.Close()
+
+	var so serializedOutbox
+	err = json.NewDecoder(r).Decode(&so)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", cfg.File, err)
+	}
+
+	if so.Version != outboxVersion {
+		return nil, fmt.Errorf("unknown version %v in serialized outbox",
+			so.Version)
+	}
+
+	now := time.Now()
+	for _, se := range so.Entries {
+		object, err := wire.DecodeMsgObject(se.Object)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding object in %s: %v",
+				cfg.File, err)
+		}
+		if object.Header().Expiration().Before(now) {
+			continue
+		}
+
+		e := newOutboxEntry(object, time.Unix(se.SendAt, 0), se.MinPeers)
+		e.released = se.Released
+		for _, host := range se.RelayedBy {
+			e.relayedBy[host] = struct{}{}
+		}
+		o.entries[e.inv] = e
+	}
+
+	return o, nil
+}
+
+// newOutboxEntry creates an entry for an object to be sent at the given time
+// once the given number of peers are connected.
+func newOutboxEntry(object *wire.MsgObject, sendAt time.Time, minPeers int) *outboxEntry {
+	return &outboxEntry{
+		object:    object,
+		inv:       wire.InvVect(*obj.InventoryHash(object)),
+		sendAt:    sendAt,
+		minPeers:  minPeers,
+		relayedBy: make(map[string]struct{}),
+	}
+}
+
+// stream returns the stream of the object.
+func (e *outboxEntry) stream() uint32 {
+	return uint32(e.object.Header().StreamNumber)
+}
+
+// save writes the outbox to its file if it has changed since it was last
+// saved. The file is removed if the outbox is empty. Otherwise, it is written
+// under another name first and then renamed, so that it is never left half
+// written.
+func (o *Outbox) save() error {
+	if o.cfg.File == "" || !o.dirty {
+		return nil
+	}
+
+	if len(o.entries) == 0 {
+		err := os.Remove(o.cfg.File)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing file %s: %v", o.cfg.File, err)
+		}
+		o.dirty = false
+		return nil
+	}
+
+	so := serializedOutbox{
+		Version: outboxVersion,
+		Entries: make([]serializedOutboxEntry, 0, len(o.entries)),
+	}
+	for _, e := range o.entries {
+		se := serializedOutboxEntry{
+			Object:    wire.Encode(e.object),
+			SendAt:    e.sendAt.Unix(),
+			MinPeers:  e.minPeers,
+			Released:  e.released,
+			RelayedBy: make([]string, 0, len(e.relayedBy)),
+		}
+		for host := range e.relayedBy {
+			se.RelayedBy = append(se.RelayedBy, host)
+		}
+		so.Entries = append(so.Entries, se)
+	}
+
+	w, err := ioutil.TempFile(filepath.Dir(o.cfg.File), filepath.Base(o.cfg.File))
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %v",
+			o.cfg.File, err)
+	}
+
+	err = json.NewEncoder(w).Encode(&so)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(w.Name(), o.cfg.File)
+	}
+	if err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("failed to write file %s: %v", o.cfg.File, err)
+	}
+
+	o.dirty = false
+	return nil
+}
+
+// add adds an object to the outbox.
+func (o *Outbox) add(object *wire.MsgObject, sendAt time.Time, minPeers int) (*outboxEntry, error) {
+	e := newOutboxEntry(object, sendAt, minPeers)
+	if _, ok := o.entries[e.inv]; ok {
+		return nil, ErrInOutbox
+	}
+
+	o.entries[e.inv] = e
+	o.dirty = true
+	return e, nil
+}
+
+// remove removes an object from the outbox and returns whether it was in it.
+func (o *Outbox) remove(inv *wire.InvVect) bool {
+	if _, ok := o.entries[*inv]; !ok {
+		return false
+	}
+	delete(o.entries, *inv)
+	o.dirty = true
+	return true
+}
+
+// due returns the objects which are waiting to be released and may be
+// released at the given time with the given number of peers connected.
+func (o *Outbox) due(now time.Time, peers int) []*outboxEntry {
+	var due []*outboxEntry
+	for _, e := range o.entries {
+		if !e.released && !now.Before(e.sendAt) && peers >= e.minPeers {
+			due = append(due, e)
+		}
+	}
+	return due
+}
+
+// unconfirmed returns the released objects which have not been announced
+// since the reannounce interval before the given time.
+func (o *Outbox) unconfirmed(now time.Time) []*outboxEntry {
+	var unconfirmed []*outboxEntry
+	for _, e := range o.entries {
+		if e.released && now.Sub(e.announced) >= o.cfg.Reannounce {
+			unconfirmed = append(unconfirmed, e)
+		}
+	}
+	return unconfirmed
+}
+
+// relayed records that a peer at the given host requested the object from us
+// or advertised it to us. It returns whether this was news, and whether the object has
+// now been advertised by enough peers, in which case it is removed.
+func (o *Outbox) relayed(inv *wire.InvVect, host string) (news, confirmed bool) {
+	e, ok := o.entries[*inv]
+	if !ok || !e.released {
+		return false, false
+	}
+	if _, ok := e.relayedBy[host]; ok {
+		return false, false
+	}
+
+	e.relayedBy[host] = struct{}{}
+	o.dirty = true
+	if len(e.relayedBy) < o.cfg.Confirmations {
+		return true, false
+	}
+	delete(o.entries, *inv)
+	return true, true
+}
+
+// removeExpired removes the objects which have expired and returns how many
+// there were.
+func (o *Outbox) removeExpired(now time.Time) int {
+	removed := 0
+	for inv, e := range o.entries {
+		if e.object.Header().Expiration().Before(now) {
+			delete(o.entries, inv)
+			o.dirty = true
+			removed++
+		}
+	}
+	return removed
+}
+
+// counts returns the number of objects waiting to be released and the number
+// which have been released but not yet relayed by enough peers.
+func (o *Outbox) counts() (scheduled, unconfirmed int) {
+	for _, e := range o.entries {
+		if e.released {
+			unconfirmed++
+		} else {
+			scheduled++
+		}
+	}
+	return scheduled, unconfirmed
+}
@@ -0,0 +1,209 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+// testOutboxObject returns an object in stream 1 with the given expiration
+// and payload.
+func testOutboxObject(expires time.Time, payload byte) *wire.MsgObject {
+	return wire.NewMsgObject(wire.NewObjectHeader(0, expires,
+		wire.ObjectTypeMsg, 1, 1), []byte{payload})
+}
+
+func TestOutbox(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	o, err := NewOutbox(&OutboxConfig{
+		Confirmations: 2,
+		Reannounce:    time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewOutbox: unexpected error %v", err)
+	}
+
+	expires := now.Add(time.Hour)
+	immediate, err := o.add(testOutboxObject(expires, 1), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("add: unexpected error %v", err)
+	}
+	later, err := o.add(testOutboxObject(expires, 2), now.Add(10*time.Minute), 0)
+	if err != nil {
+		t.Fatalf("add: unexpected error %v", err)
+	}
+	peers, err := o.add(testOutboxObject(expires, 3), time.Time{}, 3)
+	if err != nil {
+		t.Fatalf("add: unexpected error %v", err)
+	}
+	if _, err := o.add(testOutboxObject(expires, 1), time.Time{}, 0); err != ErrInOutbox {
+		t.Errorf("expected error %v, got %v", ErrInOutbox, err)
+	}
+
+	// Only the objects whose time has come and which have enough peers are
+	// due.
+	tests := []struct {
+		now   time.Time
+		peers int
+		due   []*outboxEntry
+	}{
+		{now, 0, []*outboxEntry{immediate}},
+		{now, 3, []*outboxEntry{immediate, peers}},
+		{now.Add(10 * time.Minute), 1, []*outboxEntry{immediate, later}},
+	}
+	for i, test := range tests {
+		due := o.due(test.now, test.peers)
+		if len(due) != len(test.due) {
+			t.Errorf("case %d: expected %d objects due, got %d", i,
+				len(test.due), len(due))
+			continue
+		}
+		for _, e := range test.due {
+			found := false
+			for _, d := range due {
+				found = found || d == e
+			}
+			if !found {
+				t.Errorf("case %d: object %v not due", i, e.inv)
+			}
+		}
+	}
+
+	// Objects which have not been released are not confirmed.
+	if news, _ := o.relayed(&immediate.inv, "10.0.0.1"); news {
+		t.Error("object relayed before it was released")
+	}
+
+	immediate.released = true
+	immediate.announced = now
+	if scheduled, unconfirmed := o.counts(); scheduled != 2 || unconfirmed != 1 {
+		t.Errorf("expected 2 scheduled and 1 unconfirmed, got %d and %d",
+			scheduled, unconfirmed)
+	}
+
+	// Released objects are announced again once the reannounce interval
+	// has passed.
+	if n := len(o.unconfirmed(now.Add(30 * time.Second))); n != 0 {
+		t.Errorf("expected no objects to announce, got %d", n)
+	}
+	if n := len(o.unconfirmed(now.Add(time.Minute))); n != 1 {
+		t.Errorf("expected 1 object to announce, got %d", n)
+	}
+
+	// The object is removed once peers at enough distinct hosts have
+	// requested or advertised it.
+	if news, confirmed := o.relayed(&immediate.inv, "10.0.0.1"); !news || confirmed {
+		t.Errorf("first relay: expected news and not confirmed, got %v and %v",
+			news, confirmed)
+	}
+	if news, _ := o.relayed(&immediate.inv, "10.0.0.1"); news {
+		t.Error("second relay from the same host was news")
+	}
+	if news, confirmed := o.relayed(&immediate.inv, "10.0.0.2"); !news || !confirmed {
+		t.Errorf("relay from second host: expected confirmed, got %v and %v",
+			news, confirmed)
+	}
+	if _, ok := o.entries[immediate.inv]; ok {
+		t.Error("confirmed object still in outbox")
+	}
+
+	// Expired objects are removed.
+	if n := o.removeExpired(expires.Add(time.Second)); n != 2 {
+		t.Errorf("expected 2 expired objects, got %d", n)
+	}
+	if len(o.entries) != 0 {
+		t.Errorf("expected empty outbox, got %d objects", len(o.entries))
+	}
+}
+
+func TestOutboxSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &OutboxConfig{
+		File:          filepath.Join(dir, "outbox.json"),
+		Confirmations: 2,
+		Reannounce:    time.Minute,
+	}
+	o, err := NewOutbox(cfg)
+	if err != nil {
+		t.Fatalf("NewOutbox: unexpected error %v", err)
+	}
+
+	now := time.Unix(time.Now().Unix(), 0)
+	scheduled, err := o.add(testOutboxObject(now.Add(time.Hour), 1),
+		now.Add(10*time.Minute), 2)
+	if err != nil {
+		t.Fatalf("add: unexpected error %v", err)
+	}
+	released, err := o.add(testOutboxObject(now.Add(time.Hour), 2), time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("add: unexpected error %v", err)
+	}
+	released.released = true
+	o.relayed(&released.inv, "10.0.0.1")
+
+	// An object which expires in between is dropped when the outbox is
+	// loaded.
+	if _, err = o.add(testOutboxObject(now.Add(time.Second), 3), time.Time{}, 0); err != nil {
+		t.Fatalf("add: unexpected error %v", err)
+	}
+
+	if err = o.save(); err != nil {
+		t.Fatalf("save: unexpected error %v", err)
+	}
+	if o.dirty {
+		t.Error("outbox still marked as changed after it was saved")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only the outbox file, got %d files", len(files))
+	}
+	time.Sleep(2 * time.Second)
+
+	loaded, err := NewOutbox(cfg)
+	if err != nil {
+		t.Fatalf("NewOutbox: unexpected error %v", err)
+	}
+	if len(loaded.entries) != 2 {
+		t.Fatalf("expected 2 objects, got %d", len(loaded.entries))
+	}
+
+	e := loaded.entries[scheduled.inv]
+	if e == nil {
+		t.Fatal("scheduled object not loaded")
+	}
+	if !e.sendAt.Equal(scheduled.sendAt) || e.minPeers != 2 || e.released {
+		t.Errorf("scheduled object loaded as sent at %v with %d peers, released %v",
+			e.sendAt, e.minPeers, e.released)
+	}
+
+	e = loaded.entries[released.inv]
+	if e == nil {
+		t.Fatal("released object not loaded")
+	}
+	if _, ok := e.relayedBy["10.0.0.1"]; !e.released || !ok {
+		t.Errorf("released object loaded as released %v, relayed by %v",
+			e.released, e.relayedBy)
+	}
+
+	// The file is removed once the outbox is empty.
+	loaded.remove(&scheduled.inv)
+	loaded.remove(&released.inv)
+	if err = loaded.save(); err != nil {
+		t.Fatalf("save: unexpected error %v", err)
+	}
+	if _, err = os.Stat(cfg.File); !os.IsNotExist(err) {
+		t.Errorf("expected file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/pow"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+// Source is where an object came from.
+type Source int
+
+const (
+	// SourcePeer is an object received from a peer.
+	SourcePeer Source = iota
+
+	// SourceLocal is an object submitted by a local client over RPC.
+	SourceLocal
+)
+
+// String returns the source in human-readable form.
+func (s Source) String() string {
+	switch s {
+	case SourcePeer:
+		return "peer"
+	case SourceLocal:
+		return "local"
+	default:
+		return fmt.Sprintf("Source(%d)", int(s))
+	}
+}
+
+// RejectCode identifies the reason that an object was rejected.
+type RejectCode int
+
+const (
+	// RejectExpired means that the object has already expired.
+	RejectExpired RejectCode = iota
+
+	// RejectStream means that the object is in a stream which we do not
+	// participate in.
+	RejectStream
+
+	// RejectSize means that the object is too large.
+	RejectSize
+
+	// RejectTTL means that the object expires too far in the future.
+	RejectTTL
+
+	// RejectType means that objects of the object's type are not accepted.
+	RejectType
+
+	// RejectPow means that the object's proof of work is insufficient.
+	RejectPow
+
+	// RejectRule means that the object was rejected by an operator rule.
+	RejectRule
+)
+
+// RejectError is returned by a Policy when an object is rejected.
+type RejectError struct {
+	Code   RejectCode
+	Reason string
+}
+
+// Error returns the reason that the object was rejected.
+func (e *RejectError) Error() string {
+	return e.Reason
+}
+
+// reject returns a new RejectError.
+func reject(code RejectCode, format string, a ...interface{}) *RejectError {
+	return &RejectError{
+		Code:   code,
+		Reason: fmt.Sprintf(format, a...),
+	}
+}
+
+// Policy decides which objects are accepted into the database. It must be
+// safe for concurrent use.
+type Policy interface {
+	// Accept returns nil if the object should be accepted, and otherwise a
+	// *RejectError describing why it is not.
+	Accept(object *wire.MsgObject, source Source, now time.Time) error
+}
+
+// Rule is an operator-defined check which is run on every object which
+// passes the other checks of a policy. It returns nil to accept the object.
+// If a Rule returns an error which is not a *RejectError, it is turned into
+// one with the code RejectRule.
+type Rule func(object *wire.MsgObject, source Source) error
+
+// PolicyConfig is the configuration of the policy returned by NewPolicy.
+type PolicyConfig struct {
+	// Streams are the streams in which objects are accepted.
+	Streams []uint32
+
+	// MaxSize is the largest encoded object size that is accepted. Zero
+	// means no limit.
+	MaxSize int
+
+	// MaxTTL is how far in the future an object may expire. Zero means no
+	// limit.
+	MaxTTL time.Duration
+
+	// AllowTypes, if not empty, are the only object types which are
+	// accepted.
+	AllowTypes []wire.ObjectType
+
+	// DenyTypes are object types which are not accepted.
+	DenyTypes []wire.ObjectType
+
+	// LocalPow is the proof of work required of objects submitted locally.
+	// If nil, the network default is used. Objects from peers always
+	// require the network default.
+	LocalPow *pow.Data
+
+	// Rules are additional checks run on objects in order.
+	Rules []Rule
+}
+
+// policy is the Policy returned by NewPolicy.
+type policy struct {
+	streams  map[uint64]struct{}
+	maxSize  int
+	maxTTL   time.Duration
+	allow    map[wire.ObjectType]struct{}
+	deny     map[wire.ObjectType]struct{}
+	localPow pow.Data
+	rules    []Rule
+}
+
+// typeSet turns a list of object types into a set.
+func typeSet(types []wire.ObjectType) map[wire.ObjectType]struct{} {
+	set := make(map[wire.ObjectType]struct{}, len(types))
+	for _, t := range types {
+		set[t] = struct{}{}
+	}
+	return set
+}
+
+// Accept checks the object against the policy.
+func (p *policy) Accept(object *wire.MsgObject, source Source, now time.Time) error {
+	header := object.Header()
+
+	if now.After(header.Expiration()) {
+		return reject(RejectExpired, "object expired at %s", header.Expiration())
+	}
+
+	if p.maxTTL != 0 && header.Expiration().Sub(now) > p.maxTTL {
+		return reject(RejectTTL, "object expires at %s, more than %s in the future",
+			header.Expiration(), p.maxTTL)
+	}
+
+	if _, ok := p.streams[header.StreamNumber]; !ok {
+		return reject(RejectStream, "invalid stream %d", header.StreamNumber)
+	}
+
+	if p.maxSize != 0 {
+		if size := len(wire.Encode(object)); size > p.maxSize {
+			return reject(RejectSize, "object size %d exceeds maximum %d",
+				size, p.maxSize)
+		}
+	}
+
+	if len(p.allow) != 0 {
+		if _, ok := p.allow[header.ObjectType]; !ok {
+			return reject(RejectType, "objects of type %s not accepted",
+				header.ObjectType)
+		}
+	}
+	if _, ok := p.deny[header.ObjectType]; ok {
+		return reject(RejectType, "objects of type %s not accepted",
+			header.ObjectType)
+	}
+
+	powData := pow.Default
+	if source == SourceLocal {
+		powData = p.localPow
+	}
+	if !object.CheckPow(powData, now) {
+		return reject(RejectPow, "invalid proof of work")
+	}
+
+	for _, rule := range p.rules {
+		if err := rule(object, source); err != nil {
+			if rerr, ok := err.(*RejectError); ok {
+				return rerr
+			}
+			return reject(RejectRule, "%v", err)
+		}
+	}
+
+	return nil
+}
+
+// NewPolicy returns a Policy which implements the given configuration.
+func NewPolicy(cfg *PolicyConfig) Policy {
+	p := &policy{
+		streams:  make(map[uint64]struct{}, len(cfg.Streams)),
+		maxSize:  cfg.MaxSize,
+		maxTTL:   cfg.MaxTTL,
+		allow:    typeSet(cfg.AllowTypes),
+		deny:     typeSet(cfg.DenyTypes),
+		localPow: pow.Default,
+		rules:    cfg.Rules,
+	}
+
+	for _, s := range cfg.Streams {
+		p.streams[uint64(s)] = struct{}{}
+	}
+
+	if cfg.LocalPow != nil {
+		p.localPow = *cfg.LocalPow
+	}
+
+	return p
+}
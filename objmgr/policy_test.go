@@ -0,0 +1,125 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/pow"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+// testObject returns an object with valid proof of work at the given time for
+// the given pow parameters.
+func testObject(now time.Time, objType wire.ObjectType, expires time.Time,
+	stream uint64, payload []byte, data pow.Data) *wire.MsgObject {
+	o := wire.NewMsgObject(wire.NewObjectHeader(0, expires, objType, 1, stream),
+		payload)
+
+	b := wire.Encode(o)
+	section := b[8:]
+	target := pow.CalculateTarget(uint64(len(section)),
+		uint64(expires.Sub(now).Seconds()), data)
+	nonce := pow.DoSequential(target, hash.Sha512(section))
+	binary.BigEndian.PutUint64(b, uint64(nonce))
+
+	o, _ = wire.DecodeMsgObject(b)
+	return o
+}
+
+func TestPolicy(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(10 * time.Minute)
+	payload := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// Proof of work which is much easier than the network default.
+	easyPow := pow.Data{
+		NonceTrialsPerByte: 1,
+		ExtraBytes:         1,
+	}
+
+	msg := testObject(now, wire.ObjectTypeMsg, expires, 1, payload, pow.Default)
+	broadcast := testObject(now, wire.ObjectTypeBroadcast, expires, 1, payload, pow.Default)
+	easy := testObject(now, wire.ObjectTypeMsg, expires, 1, payload, easyPow)
+	stream2 := testObject(now, wire.ObjectTypeMsg, expires, 2, payload, pow.Default)
+	longTTL := testObject(now, wire.ObjectTypeMsg, now.Add(2*time.Hour), 1, payload, pow.Default)
+	large := testObject(now, wire.ObjectTypeMsg, expires, 1, make([]byte, 200), pow.Default)
+	expired := wire.NewMsgObject(wire.NewObjectHeader(0, now.Add(-time.Minute),
+		wire.ObjectTypeMsg, 1, 1), payload)
+	badPow := wire.NewMsgObject(wire.NewObjectHeader(0, expires,
+		wire.ObjectTypeMsg, 1, 1), payload)
+
+	errRule := errors.New("rule")
+	rule := func(object *wire.MsgObject, source Source) error {
+		if object == broadcast && source == SourcePeer {
+			return errRule
+		}
+		return nil
+	}
+
+	tests := []struct {
+		cfg    PolicyConfig
+		object *wire.MsgObject
+		source Source
+		reject bool
+		code   RejectCode
+	}{
+		{PolicyConfig{Streams: []uint32{1}}, msg, SourcePeer, false, 0},
+		{PolicyConfig{Streams: []uint32{1}}, msg, SourceLocal, false, 0},
+		{PolicyConfig{Streams: []uint32{1}}, expired, SourcePeer, true, RejectExpired},
+		{PolicyConfig{Streams: []uint32{1}}, badPow, SourcePeer, true, RejectPow},
+		{PolicyConfig{Streams: []uint32{1}}, badPow, SourceLocal, true, RejectPow},
+		{PolicyConfig{Streams: []uint32{1}}, stream2, SourcePeer, true, RejectStream},
+		{PolicyConfig{Streams: []uint32{1, 2}}, stream2, SourcePeer, false, 0},
+		{PolicyConfig{Streams: []uint32{1}}, longTTL, SourcePeer, false, 0},
+		{PolicyConfig{Streams: []uint32{1}, MaxTTL: time.Hour}, longTTL, SourcePeer, true, RejectTTL},
+		{PolicyConfig{Streams: []uint32{1}, MaxTTL: time.Hour}, msg, SourcePeer, false, 0},
+		{PolicyConfig{Streams: []uint32{1}, MaxSize: 100}, large, SourcePeer, true, RejectSize},
+		{PolicyConfig{Streams: []uint32{1}, MaxSize: 100}, msg, SourcePeer, false, 0},
+		{PolicyConfig{Streams: []uint32{1},
+			AllowTypes: []wire.ObjectType{wire.ObjectTypeBroadcast}}, msg, SourcePeer, true, RejectType},
+		{PolicyConfig{Streams: []uint32{1},
+			AllowTypes: []wire.ObjectType{wire.ObjectTypeBroadcast}}, broadcast, SourcePeer, false, 0},
+		{PolicyConfig{Streams: []uint32{1},
+			DenyTypes: []wire.ObjectType{wire.ObjectTypeMsg}}, msg, SourcePeer, true, RejectType},
+		{PolicyConfig{Streams: []uint32{1},
+			DenyTypes: []wire.ObjectType{wire.ObjectTypeMsg}}, broadcast, SourcePeer, false, 0},
+
+		// Custom proof of work only applies to local objects.
+		{PolicyConfig{Streams: []uint32{1}}, easy, SourceLocal, true, RejectPow},
+		{PolicyConfig{Streams: []uint32{1}, LocalPow: &easyPow}, easy, SourceLocal, false, 0},
+		{PolicyConfig{Streams: []uint32{1}, LocalPow: &easyPow}, easy, SourcePeer, true, RejectPow},
+		{PolicyConfig{Streams: []uint32{1}, LocalPow: &easyPow}, msg, SourceLocal, false, 0},
+
+		// Operator rules.
+		{PolicyConfig{Streams: []uint32{1}, Rules: []Rule{rule}}, broadcast, SourcePeer, true, RejectRule},
+		{PolicyConfig{Streams: []uint32{1}, Rules: []Rule{rule}}, broadcast, SourceLocal, false, 0},
+		{PolicyConfig{Streams: []uint32{1}, Rules: []Rule{rule}}, msg, SourcePeer, false, 0},
+	}
+
+	for i, test := range tests {
+		err := NewPolicy(&test.cfg).Accept(test.object, test.source, now)
+		if !test.reject {
+			if err != nil {
+				t.Errorf("case %d: unexpected error %v", i, err)
+			}
+			continue
+		}
+
+		rerr, ok := err.(*RejectError)
+		if !ok {
+			t.Errorf("case %d: expected *RejectError, got %v", i, err)
+			continue
+		}
+		if rerr.Code != test.code {
+			t.Errorf("case %d: expected code %d, got %d (%v)", i, test.code,
+				rerr.Code, rerr)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/peer"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+const (
+	// maxRejects is the number of rejected objects which are remembered.
+	maxRejects = 50000
+
+	// rejectLifetime is how long a rejected object is remembered.
+	rejectLifetime = time.Hour
+)
+
+// rejectedObject is an object received from a peer which was rejected.
+type rejectedObject struct {
+	inv   wire.InvVect
+	err   error
+	since time.Time
+
+	// advertised is the number of times each connected peer has advertised
+	// the object since it was rejected.
+	advertised map[*peer.Peer]int
+}
+
+// code returns the reason the object was rejected, and whether it is known.
+func (r *rejectedObject) code() (RejectCode, bool) {
+	rerr, ok := r.err.(*RejectError)
+	if !ok {
+		return 0, false
+	}
+	return rerr.Code, true
+}
+
+// rejectCache remembers the objects which were rejected recently so that they
+// are not requested again from every peer which advertises them. It holds at
+// most limit objects, dropping the oldest when it is full. It is used only
+// from the object manager's goroutine.
+type rejectCache struct {
+	limit    int
+	lifetime time.Duration
+
+	// objects holds the elements of order, which lists the rejected
+	// objects from the oldest rejection to the newest.
+	objects map[wire.InvVect]*list.Element
+	order   *list.List
+
+	// peers holds the rejected objects in the cache which each peer has
+	// advertised, so that they can be forgotten when it disconnects.
+	peers map[*peer.Peer]map[wire.InvVect]struct{}
+
+	// advertised is the total number of times rejected objects have been
+	// advertised by peers.
+	advertised uint64
+}
+
+// newRejectCache returns an empty reject cache.
+func newRejectCache(limit int, lifetime time.Duration) *rejectCache {
+	return &rejectCache{
+		limit:    limit,
+		lifetime: lifetime,
+		objects:  make(map[wire.InvVect]*list.Element),
+		order:    list.New(),
+		peers:    make(map[*peer.Peer]map[wire.InvVect]struct{}),
+	}
+}
+
+// add records that an object was rejected and why.
+func (c *rejectCache) add(inv wire.InvVect, err error, now time.Time) {
+	if c.limit <= 0 {
+		return
+	}
+
+	if e, ok := c.objects[inv]; ok {
+		r := e.Value.(*rejectedObject)
+		r.err = err
+		r.since = now
+		c.order.MoveToBack(e)
+		return
+	}
+
+	for c.order.Len() >= c.limit {
+		c.remove(c.order.Front())
+	}
+
+	c.objects[inv] = c.order.PushBack(&rejectedObject{
+		inv:        inv,
+		err:        err,
+		since:      now,
+		advertised: make(map[*peer.Peer]int),
+	})
+}
+
+// remove removes a rejected object along with the peers' advertisements of it.
+func (c *rejectCache) remove(e *list.Element) {
+	r := e.Value.(*rejectedObject)
+	for p := range r.advertised {
+		invs := c.peers[p]
+		delete(invs, r.inv)
+		if len(invs) == 0 {
+			delete(c.peers, p)
+		}
+	}
+	delete(c.objects, r.inv)
+	c.order.Remove(e)
+}
+
+// get returns the rejected object for the inventory vector, or nil if it has
+// not been rejected within the lifetime of the cache.
+func (c *rejectCache) get(inv wire.InvVect, now time.Time) *rejectedObject {
+	e, ok := c.objects[inv]
+	if !ok {
+		return nil
+	}
+
+	r := e.Value.(*rejectedObject)
+	if now.Sub(r.since) >= c.lifetime {
+		c.remove(e)
+		return nil
+	}
+	return r
+}
+
+// advertise records that a peer advertised an object. If the object was
+// rejected, it is returned along with whether the peer has advertised it
+// before since then. A peer which reconnects starts over.
+func (c *rejectCache) advertise(inv wire.InvVect, p *peer.Peer, now time.Time) (*rejectedObject, bool) {
+	r := c.get(inv, now)
+	if r == nil {
+		return nil, false
+	}
+
+	c.advertised++
+	r.advertised[p]++
+	if r.advertised[p] == 1 {
+		invs, ok := c.peers[p]
+		if !ok {
+			invs = make(map[wire.InvVect]struct{})
+			c.peers[p] = invs
+		}
+		invs[inv] = struct{}{}
+	}
+	return r, r.advertised[p] > 1
+}
+
+// forget forgets the advertisements of a peer which has disconnected.
+func (c *rejectCache) forget(p *peer.Peer) {
+	for inv := range c.peers[p] {
+		if e, ok := c.objects[inv]; ok {
+			delete(e.Value.(*rejectedObject).advertised, p)
+		}
+	}
+	delete(c.peers, p)
+}
+
+// expire removes the objects which were rejected longer ago than the lifetime
+// of the cache.
+func (c *rejectCache) expire(now time.Time) {
+	for c.order.Len() > 0 {
+		e := c.order.Front()
+		if now.Sub(e.Value.(*rejectedObject).since) < c.lifetime {
+			return
+		}
+		c.remove(e)
+	}
+}
+
+// len returns the number of rejected objects in the cache.
+func (c *rejectCache) len() int {
+	return c.order.Len()
+}
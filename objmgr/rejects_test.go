@@ -0,0 +1,93 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/peer"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+func TestRejectCache(t *testing.T) {
+	c := newRejectCache(2, time.Hour)
+	now := time.Now()
+	inv1, inv2, inv3 := wire.InvVect{1}, wire.InvVect{2}, wire.InvVect{3}
+	a, b := &peer.Peer{}, &peer.Peer{}
+
+	if r, _ := c.advertise(inv1, a, now); r != nil {
+		t.Fatal("object advertised before it was rejected")
+	}
+	if c.advertised != 0 {
+		t.Errorf("expected no advertisements, got %d", c.advertised)
+	}
+
+	c.add(inv1, reject(RejectPow, "insufficient pow"), now)
+	r, repeat := c.advertise(inv1, a, now)
+	if r == nil || repeat {
+		t.Fatalf("unexpected result of first advertisement %v, %v", r, repeat)
+	}
+	if code, ok := r.code(); !ok || code != RejectPow {
+		t.Errorf("expected code %v, got %v", RejectPow, code)
+	}
+	if _, repeat := c.advertise(inv1, b, now); repeat {
+		t.Error("advertisement from another peer counted as a repeat")
+	}
+	if _, repeat := c.advertise(inv1, a, now); !repeat {
+		t.Error("second advertisement from peer not counted as a repeat")
+	}
+	if c.advertised != 3 {
+		t.Errorf("expected 3 advertisements, got %d", c.advertised)
+	}
+
+	// The advertisements of a peer are forgotten when it disconnects.
+	c.forget(a)
+	if _, repeat := c.advertise(inv1, a, now); repeat {
+		t.Error("advertisement after the peer was forgotten counted as a repeat")
+	}
+	if _, repeat := c.advertise(inv1, b, now); !repeat {
+		t.Error("advertisements of another peer forgotten")
+	}
+
+	// Errors which are not a RejectError have no code.
+	c.add(inv2, errors.New("error"), now.Add(time.Minute))
+	if _, ok := c.get(inv2, now).code(); ok {
+		t.Error("code returned for unknown error")
+	}
+
+	// The oldest rejection is dropped when the cache is full.
+	c.add(inv3, reject(RejectExpired, "expired"), now.Add(2*time.Minute))
+	if c.len() != 2 {
+		t.Errorf("expected 2 rejected objects, got %d", c.len())
+	}
+	if c.get(inv1, now) != nil {
+		t.Error("oldest rejected object not dropped")
+	}
+	if len(c.peers) != 0 {
+		t.Errorf("advertisements of dropped object still remembered: %v", c.peers)
+	}
+
+	// Rejections expire after the lifetime of the cache.
+	if c.get(inv2, now.Add(time.Hour+time.Minute)) != nil {
+		t.Error("expired rejection returned")
+	}
+	if c.len() != 1 {
+		t.Errorf("expected 1 rejected object, got %d", c.len())
+	}
+	c.expire(now.Add(time.Hour))
+	if c.len() != 1 {
+		t.Errorf("expected 1 rejected object, got %d", c.len())
+	}
+	c.advertise(inv3, a, now.Add(time.Hour))
+	c.expire(now.Add(2 * time.Hour))
+	if c.len() != 0 {
+		t.Errorf("expected no rejected objects, got %d", c.len())
+	}
+	if len(c.peers) != 0 {
+		t.Errorf("advertisements of expired object still remembered: %v", c.peers)
+	}
+}
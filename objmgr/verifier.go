@@ -0,0 +1,200 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/peer"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+const (
+	// maxVerifyQueue is the number of objects which may wait for a worker
+	// of the verifier. Further objects wait in the verifier's backlog.
+	maxVerifyQueue = 1000
+
+	// maxVerifyBacklog is the number of objects in the verifier at which
+	// no more objects are requested from peers until some are handed back.
+	maxVerifyBacklog = 5 * maxVerifyQueue
+)
+
+// verifyJob is an object received from a peer which is to be checked against
+// the policy.
+type verifyJob struct {
+	object   *wire.MsgObject
+	inv      wire.InvVect
+	peer     *peer.Peer
+	received time.Time
+
+	// err is the result of the check. It is set by a worker and may only
+	// be read once the job has been received from the results channel,
+	// which is recorded in done.
+	err  error
+	done bool
+}
+
+// verifier checks objects received from peers against the policy, which
+// includes their proof of work, on a fixed number of workers so that the object
+// manager's goroutine is not held up by large objects. The checked objects are
+// handed back to the object manager in the order they were submitted, so that
+// they are inserted into the database in the order they arrived.
+//
+// Objects are only submitted once they have been requested from a peer, so the
+// verifier is kept from growing without bound by requesting no more objects
+// while it is full. Each object also takes up room in the download window of
+// the peer it came from until it is handed back, so that a peer which sends
+// objects faster than they can be checked is given less to download.
+//
+// Only the workers and the channels are used concurrently. Everything else is
+// used only from the object manager's goroutine.
+type verifier struct {
+	policy  Policy
+	workers int
+
+	jobs    chan *verifyJob
+	results chan *verifyJob
+	quit    chan struct{}
+	wg      sync.WaitGroup
+
+	// pending are the jobs which have not been handed back, in the order
+	// they were submitted, and backlog are those which are waiting for room
+	// in the jobs channel.
+	pending *list.List
+	backlog *list.List
+	objects map[wire.InvVect]struct{}
+
+	// verified is the total number of objects checked and latency is the
+	// total time from their receipt until they were handed back.
+	verified uint64
+	latency  time.Duration
+}
+
+// newVerifier creates a verifier with the given number of workers. Use start
+// to begin checking objects.
+func newVerifier(policy Policy, workers int) *verifier {
+	return &verifier{
+		policy:  policy,
+		workers: workers,
+		jobs:    make(chan *verifyJob, maxVerifyQueue),
+		results: make(chan *verifyJob),
+		quit:    make(chan struct{}),
+		pending: list.New(),
+		backlog: list.New(),
+		objects: make(map[wire.InvVect]struct{}),
+	}
+}
+
+// worker checks objects until the verifier is stopped. It must be run as a
+// goroutine.
+func (v *verifier) worker() {
+	defer v.wg.Done()
+
+	for {
+		select {
+		case j := <-v.jobs:
+			j.err = v.policy.Accept(j.object, SourcePeer, j.received)
+
+			select {
+			case v.results <- j:
+			case <-v.quit:
+				return
+			}
+
+		case <-v.quit:
+			return
+		}
+	}
+}
+
+// start starts the workers.
+func (v *verifier) start() {
+	for i := 0; i < v.workers; i++ {
+		v.wg.Add(1)
+		go v.worker()
+	}
+}
+
+// stop stops the workers and waits for them to finish. The objects which have
+// not been handed back are dropped.
+func (v *verifier) stop() {
+	close(v.quit)
+	v.wg.Wait()
+}
+
+// submit queues an object received from a peer to be checked.
+func (v *verifier) submit(object *wire.MsgObject, p *peer.Peer, received time.Time) {
+	j := &verifyJob{
+		object:   object,
+		inv:      wire.InvVect(*obj.InventoryHash(object)),
+		peer:     p,
+		received: received,
+	}
+
+	v.objects[j.inv] = struct{}{}
+	v.pending.PushBack(j)
+	v.backlog.PushBack(j)
+	v.fill()
+}
+
+// fill moves jobs from the backlog to the workers while there is room.
+func (v *verifier) fill() {
+	for v.backlog.Len() > 0 {
+		e := v.backlog.Front()
+		select {
+		case v.jobs <- e.Value.(*verifyJob):
+			v.backlog.Remove(e)
+		default:
+			return
+		}
+	}
+}
+
+// complete takes a job which has been received from the results channel and
+// returns the jobs which may now be handed back, in the order they were
+// submitted.
+func (v *verifier) complete(done *verifyJob, now time.Time) []*verifyJob {
+	done.done = true
+
+	var ready []*verifyJob
+	for v.pending.Len() > 0 {
+		e := v.pending.Front()
+		j := e.Value.(*verifyJob)
+		if !j.done {
+			break
+		}
+
+		v.pending.Remove(e)
+		delete(v.objects, j.inv)
+		v.verified++
+		v.latency += now.Sub(j.received)
+		ready = append(ready, j)
+	}
+
+	v.fill()
+	return ready
+}
+
+// full returns whether the verifier holds so many objects that no more should
+// be requested.
+func (v *verifier) full() bool {
+	return v.pending.Len() >= maxVerifyBacklog
+}
+
+// has returns whether the object is waiting to be checked or handed back.
+func (v *verifier) has(inv *wire.InvVect) bool {
+	_, ok := v.objects[*inv]
+	return ok
+}
+
+// queued returns the number of objects waiting for a worker and the number
+// which are being checked or waiting to be handed back.
+func (v *verifier) queued() (queued, verifying int) {
+	queued = v.backlog.Len() + len(v.jobs)
+	return queued, v.pending.Len() - queued
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+// testPolicy is a Policy which rejects the objects in reject and which
+// waits for release before checking the objects in hold.
+type testPolicy struct {
+	reject  map[*wire.MsgObject]struct{}
+	hold    map[*wire.MsgObject]struct{}
+	release chan struct{}
+}
+
+func (p *testPolicy) Accept(object *wire.MsgObject, source Source, now time.Time) error {
+	if _, ok := p.hold[object]; ok {
+		<-p.release
+	}
+	if _, ok := p.reject[object]; ok {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+// waitVerified collects the jobs handed back by the verifier until there are
+// n of them.
+func waitVerified(t *testing.T, v *verifier, n int) []*verifyJob {
+	var verified []*verifyJob
+	for len(verified) < n {
+		select {
+		case j := <-v.results:
+			verified = append(verified, v.complete(j, time.Now())...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d objects verified", len(verified), n)
+		}
+	}
+	return verified
+}
+
+func TestVerifier(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	objects := make([]*wire.MsgObject, 4)
+	for i := range objects {
+		objects[i] = testOutboxObject(expires, byte(i))
+	}
+
+	policy := &testPolicy{
+		reject:  map[*wire.MsgObject]struct{}{objects[2]: {}},
+		hold:    map[*wire.MsgObject]struct{}{objects[0]: {}},
+		release: make(chan struct{}),
+	}
+	v := newVerifier(policy, 2)
+	v.start()
+	defer v.stop()
+
+	now := time.Now()
+	for _, object := range objects {
+		v.submit(object, nil, now)
+	}
+
+	inv := wire.InvVect{}
+	if v.has(&inv) {
+		t.Error("verifier has object which was not submitted")
+	}
+	for _, j := range objectsJobs(v) {
+		if !v.has(&j.inv) {
+			t.Errorf("verifier does not have object %v", j.inv)
+		}
+	}
+
+	// Nothing is handed back while the first object is being checked.
+	select {
+	case j := <-v.results:
+		if ready := v.complete(j, time.Now()); len(ready) != 0 {
+			t.Fatalf("%d objects handed back before the first", len(ready))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no object verified")
+	}
+	if queued, verifying := v.queued(); queued+verifying != 4 {
+		t.Errorf("expected 4 objects in the verifier, got %d",
+			queued+verifying)
+	}
+
+	// Once it has been checked, every object is handed back in order.
+	close(policy.release)
+	verified := waitVerified(t, v, len(objects))
+	for i, j := range verified {
+		if j.object != objects[i] {
+			t.Errorf("object %d not handed back in order", i)
+		}
+		if (i == 2) != (j.err != nil) {
+			t.Errorf("object %d: unexpected result %v", i, j.err)
+		}
+		if v.has(&j.inv) {
+			t.Errorf("verifier still has object %d", i)
+		}
+	}
+
+	if queued, verifying := v.queued(); queued != 0 || verifying != 0 {
+		t.Errorf("expected empty verifier, got %d queued and %d verifying",
+			queued, verifying)
+	}
+	if v.verified != uint64(len(objects)) {
+		t.Errorf("expected %d objects verified, got %d", len(objects), v.verified)
+	}
+}
+
+func TestVerifierBacklog(t *testing.T) {
+	policy := &testPolicy{}
+	v := newVerifier(policy, 1)
+	v.start()
+	defer v.stop()
+
+	// More objects than fit in the queue wait in the backlog, until the
+	// verifier is full.
+	expires := time.Now().Add(time.Hour)
+	n := maxVerifyBacklog
+	for i := 0; i < n; i++ {
+		object := wire.NewMsgObject(wire.NewObjectHeader(uint64(i), expires,
+			wire.ObjectTypeMsg, 1, 1), nil)
+		v.submit(object, nil, time.Now())
+	}
+	if v.backlog.Len() == 0 {
+		t.Error("expected objects in the backlog")
+	}
+	if !v.full() {
+		t.Error("verifier not full")
+	}
+
+	if verified := waitVerified(t, v, n); len(verified) != n {
+		t.Errorf("expected %d objects verified, got %d", n, len(verified))
+	}
+	if v.full() {
+		t.Error("verifier still full once every object was handed back")
+	}
+}
+
+// objectsJobs returns the jobs pending in the verifier.
+func objectsJobs(v *verifier) []*verifyJob {
+	var jobs []*verifyJob
+	for e := v.pending.Front(); e != nil; e = e.Next() {
+		jobs = append(jobs, e.Value.(*verifyJob))
+	}
+	return jobs
+}
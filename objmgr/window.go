@@ -0,0 +1,272 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"time"
+
+	"github.com/DanielKrawisz/bmd/peer"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+const (
+	// initialWindow is the number of objects which may be requested from a
+	// peer before its delivery rate is known.
+	initialWindow = 32
+
+	// minWindow and maxWindow bound the number of objects which may be
+	// requested from a peer at once.
+	minWindow = 8
+	maxWindow = 2000
+
+	// windowDuration is how long a peer should take to deliver a full
+	// window at its measured rate.
+	windowDuration = 10 * time.Second
+
+	// rateInterval is the shortest interval over which the delivery rate of
+	// a peer is measured.
+	rateInterval = 2 * time.Second
+
+	// rateWeight is the weight given to each new measurement in the rolling
+	// delivery rate of a peer.
+	rateWeight = 0.3
+
+	// stallFactor is how many times longer than expected from its delivery
+	// rate a request may wait before it is moved to a faster peer.
+	stallFactor = 3
+
+	// minStallTimeout is the shortest time a request waits before it may be
+	// moved to a faster peer.
+	minStallTimeout = 10 * time.Second
+
+	// unknownStallTimeout is how long a request waits before it may be moved
+	// to a faster peer if the peer it was sent to has not delivered anything.
+	unknownStallTimeout = 30 * time.Second
+
+	// rebalanceInterval is how often requests are checked for being stuck
+	// on slow peers.
+	rebalanceInterval = 5 * time.Second
+)
+
+// downloadWindow limits the objects requested from a peer at once to about as
+// many as it delivers within windowDuration. It is used only from the object
+// manager's goroutine.
+type downloadWindow struct {
+	// size is the number of objects which may be requested from the peer
+	// at once and outstanding is the number which are. verifying is the
+	// number which the peer has delivered but which are still in the
+	// verifier, and which take up room in the window as well.
+	size        int
+	outstanding int
+	verifying   int
+
+	// inventory is the inventory of the peer, if any, whose count of
+	// requests is kept equal to outstanding so that it can be read from
+	// other goroutines.
+	inventory *peer.Inventory
+
+	// rate is the rolling delivery rate of the peer in objects per second,
+	// or 0 if it is not known yet.
+	rate float64
+
+	// delivered is the number of objects delivered since the start of the
+	// current measurement, which is zero while nothing is requested.
+	delivered int
+	since     time.Time
+}
+
+// newDownloadWindow returns the window of a peer with the given inventory
+// which has just connected.
+func newDownloadWindow(inventory *peer.Inventory) *downloadWindow {
+	return &downloadWindow{size: initialWindow, inventory: inventory}
+}
+
+// add changes the number of outstanding requests by n, but not below zero.
+func (w *downloadWindow) add(n int) {
+	if w.outstanding+n < 0 {
+		n = -w.outstanding
+	}
+	w.outstanding += n
+
+	if w.inventory == nil {
+		return
+	}
+	if n > 0 {
+		w.inventory.AddRequest(uint32(n))
+	} else if n < 0 {
+		w.inventory.DropRequest(uint32(-n))
+	}
+}
+
+// busy returns the number of objects which take up room in the window.
+func (w *downloadWindow) busy() int {
+	return w.outstanding + w.verifying
+}
+
+// room returns how many more objects may be requested from the peer.
+func (w *downloadWindow) room() int {
+	if w.busy() >= w.size {
+		return 0
+	}
+	return w.size - w.busy()
+}
+
+// request records that objects were requested from the peer. It is called
+// once the getdata message has been pushed, so that the peer can tell
+// whether other requests were outstanding when it was sent.
+func (w *downloadWindow) request(n int, now time.Time) {
+	if w.since.IsZero() {
+		w.since = now
+		w.delivered = 0
+	}
+	w.add(n)
+}
+
+// drop records that objects requested from the peer are no longer expected
+// from it.
+func (w *downloadWindow) drop(n int, now time.Time) {
+	w.add(-n)
+	if w.outstanding == 0 {
+		w.measure(now)
+		w.since = time.Time{}
+	}
+}
+
+// deliver records that the peer delivered an object. If it was one which is
+// still expected from it, it frees up room in the window.
+func (w *downloadWindow) deliver(expected bool, now time.Time) {
+	w.delivered++
+	if expected {
+		w.add(-1)
+	}
+
+	if w.outstanding == 0 {
+		w.measure(now)
+		w.since = time.Time{}
+	} else if now.Sub(w.since) >= rateInterval {
+		w.measure(now)
+		w.since = now
+		w.delivered = 0
+	}
+}
+
+// verify records that an object delivered by the peer was submitted to the
+// verifier.
+func (w *downloadWindow) verify() {
+	w.verifying++
+}
+
+// verified records that an object delivered by the peer was handed back by
+// the verifier, which frees up room in the window.
+func (w *downloadWindow) verified() {
+	if w.verifying > 0 {
+		w.verifying--
+	}
+}
+
+// measure updates the delivery rate with the objects delivered since the
+// start of the current measurement and resizes the window.
+func (w *downloadWindow) measure(now time.Time) {
+	elapsed := now.Sub(w.since)
+	if w.since.IsZero() || elapsed <= 0 || w.delivered == 0 {
+		return
+	}
+
+	rate := float64(w.delivered) / elapsed.Seconds()
+	if w.rate == 0 {
+		w.rate = rate
+	} else {
+		w.rate += rateWeight * (rate - w.rate)
+	}
+	w.resize(int(w.rate * windowDuration.Seconds()))
+}
+
+// stall records that requests had to be moved from the peer because it was
+// too slow, and halves its window.
+func (w *downloadWindow) stall() {
+	w.rate /= 2
+	w.resize(w.size / 2)
+}
+
+// resize sets the size of the window within its bounds.
+func (w *downloadWindow) resize(size int) {
+	if size < minWindow {
+		size = minWindow
+	} else if size > maxWindow {
+		size = maxWindow
+	}
+	w.size = size
+}
+
+// stallTimeout returns how long a request may wait for the peer before it is
+// moved to a faster peer.
+func (w *downloadWindow) stallTimeout() time.Duration {
+	if w.rate == 0 {
+		return unknownStallTimeout
+	}
+
+	timeout := time.Duration(stallFactor * float64(w.size) / w.rate *
+		float64(time.Second))
+	if timeout < minStallTimeout {
+		return minStallTimeout
+	}
+	return timeout
+}
+
+// supersededRequest lists the peers which an object was requested from but
+// which are no longer expected to deliver it, because it was moved to another
+// peer or received from one. They may still deliver it for a while.
+type supersededRequest struct {
+	peers []*peer.Peer
+	since time.Time
+}
+
+// supersededRequests holds the superseded requests of the object manager. It
+// is used only from the object manager's goroutine.
+type supersededRequests map[wire.InvVect]*supersededRequest
+
+// add records that the object is no longer expected from the given peers.
+func (s supersededRequests) add(inv wire.InvVect, now time.Time, peers ...*peer.Peer) {
+	if len(peers) == 0 {
+		return
+	}
+
+	sr, ok := s[inv]
+	if !ok {
+		sr = &supersededRequest{}
+		s[inv] = sr
+	}
+	sr.peers = append(sr.peers, peers...)
+	sr.since = now
+}
+
+// remove removes the peer from the superseded request for the object and
+// returns whether it was in it.
+func (s supersededRequests) remove(inv wire.InvVect, p *peer.Peer) bool {
+	sr, ok := s[inv]
+	if !ok {
+		return false
+	}
+
+	for i, sp := range sr.peers {
+		if sp == p {
+			sr.peers = append(sr.peers[:i], sr.peers[i+1:]...)
+			if len(sr.peers) == 0 {
+				delete(s, inv)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes the superseded requests which are older than d.
+func (s supersededRequests) expire(d time.Duration, now time.Time) {
+	for inv, sr := range s {
+		if sr.since.Add(d).Before(now) {
+			delete(s, inv)
+		}
+	}
+}
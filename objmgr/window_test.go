@@ -0,0 +1,155 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package objmgr
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/peer"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+func TestDownloadWindow(t *testing.T) {
+	inventory := peer.NewInventory()
+	w := newDownloadWindow(inventory)
+	now := time.Now()
+
+	if w.room() != initialWindow {
+		t.Fatalf("expected room %d, got %d", initialWindow, w.room())
+	}
+	if w.stallTimeout() != unknownStallTimeout {
+		t.Errorf("expected stall timeout %s, got %s", unknownStallTimeout,
+			w.stallTimeout())
+	}
+
+	// A peer which delivers 100 objects per second gets a window of
+	// windowDuration at that rate.
+	w.request(initialWindow, now)
+	if w.room() != 0 {
+		t.Errorf("expected no room, got %d", w.room())
+	}
+	for i := 1; i <= 20; i++ {
+		w.deliver(true, now.Add(time.Duration(i)*10*time.Millisecond))
+	}
+	if w.outstanding != initialWindow-20 {
+		t.Errorf("expected %d outstanding, got %d", initialWindow-20, w.outstanding)
+	}
+	if n := inventory.NumRequests(); n != initialWindow-20 {
+		t.Errorf("expected %d requests in the inventory, got %d",
+			initialWindow-20, n)
+	}
+	for i := 21; i <= initialWindow; i++ {
+		w.deliver(true, now.Add(time.Duration(i)*10*time.Millisecond))
+	}
+	if math.Abs(w.rate-100) > 1e-9 {
+		t.Errorf("expected rate 100, got %f", w.rate)
+	}
+	if w.size < 999 || w.size > 1000 {
+		t.Errorf("expected size 1000, got %d", w.size)
+	}
+	if d := w.stallTimeout() - 30*time.Second; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("expected stall timeout 30s, got %s", w.stallTimeout())
+	}
+
+	// The rate is measured while the peer is busy.
+	now = now.Add(time.Minute)
+	w.request(w.size, now)
+	for i := 1; i <= 100; i++ {
+		w.deliver(true, now.Add(time.Duration(i)*rateInterval/100))
+	}
+	if math.Abs(w.rate-85) > 1e-9 {
+		t.Errorf("expected rate 85, got %f", w.rate)
+	}
+	if w.size < 849 || w.size > 850 {
+		t.Errorf("expected size 850, got %d", w.size)
+	}
+
+	// Objects which are no longer expected from the peer free up room.
+	w.drop(w.outstanding-10, now)
+	if w.room() != w.size-10 {
+		t.Errorf("expected room %d, got %d", w.size-10, w.room())
+	}
+	if n := inventory.NumRequests(); n != 10 {
+		t.Errorf("expected 10 requests in the inventory, got %d", n)
+	}
+
+	// Objects delivered after they were dropped are not counted twice.
+	w.drop(20, now)
+	w.deliver(false, now)
+	if w.outstanding != 0 || inventory.NumRequests() != 0 {
+		t.Errorf("expected no outstanding requests, got %d and %d",
+			w.outstanding, inventory.NumRequests())
+	}
+
+	// Objects take up room until they are handed back by the verifier.
+	w.verify()
+	w.verify()
+	if w.room() != w.size-2 {
+		t.Errorf("expected room %d, got %d", w.size-2, w.room())
+	}
+	w.verified()
+	w.verified()
+	w.verified()
+	if w.verifying != 0 || w.room() != w.size {
+		t.Errorf("expected room %d, got %d", w.size, w.room())
+	}
+
+	// A peer which is too slow has its window halved, down to minWindow.
+	size := w.size
+	w.stall()
+	if w.size != size/2 {
+		t.Errorf("expected size %d, got %d", size/2, w.size)
+	}
+	for i := 0; i < 10; i++ {
+		w.stall()
+	}
+	if w.size != minWindow {
+		t.Errorf("expected size %d, got %d", minWindow, w.size)
+	}
+	if w.stallTimeout() < minStallTimeout {
+		t.Errorf("stall timeout %s below minimum", w.stallTimeout())
+	}
+}
+
+func TestSupersededRequests(t *testing.T) {
+	s := make(supersededRequests)
+	p1, p2, p3 := &peer.Peer{}, &peer.Peer{}, &peer.Peer{}
+	inv := wire.InvVect{1}
+	now := time.Now()
+
+	s.add(inv, now)
+	if len(s) != 0 {
+		t.Errorf("expected no superseded requests, got %d", len(s))
+	}
+
+	s.add(inv, now, p1, p2)
+	if s.remove(inv, p3) {
+		t.Error("removed peer which was not superseded")
+	}
+	if !s.remove(inv, p1) {
+		t.Error("superseded peer not removed")
+	}
+	if s.remove(inv, p1) {
+		t.Error("superseded peer removed twice")
+	}
+	if !s.remove(inv, p2) {
+		t.Error("superseded peer not removed")
+	}
+	if len(s) != 0 {
+		t.Errorf("expected no superseded requests, got %d", len(s))
+	}
+
+	s.add(inv, now, p3)
+	s.expire(time.Minute, now.Add(30*time.Second))
+	if len(s) != 1 {
+		t.Errorf("expected 1 superseded request, got %d", len(s))
+	}
+	s.expire(time.Minute, now.Add(2*time.Minute))
+	if len(s) != 0 {
+		t.Errorf("expected no superseded requests, got %d", len(s))
+	}
+}
@@ -24,7 +24,7 @@ var NewConn = peer.NewConnection
 // connection. Use Start to begin processing incoming and outgoing messages.
 func NewInboundPeer(s *server, conn peer.Connection) *peer.Peer {
 	inventory := peer.NewInventory()
-	sq := peer.NewSend(inventory, s.Db())
+	sq := peer.NewSend(inventory, s.Db(), s.bandwidth.Quota, true)
 	return peer.NewPeer(s, conn, inventory, sq, nil, true, false)
 }
 
@@ -55,9 +55,10 @@ func NewOutboundPeer(addr string, s *server, stream uint32, persistent bool) *pe
 		return nil
 	}
 
-	conn := NewConn((*peer.Addr)(na), int64(cfg.MaxDownPerPeer), int64(cfg.MaxUpPerPeer))
+	conn := NewConn((*peer.Addr)(na), int64(cfg.MaxDownPerPeer),
+		int64(cfg.MaxUpPerPeer), s.bandwidth)
 	inventory := peer.NewInventory()
-	sq := peer.NewSend(inventory, s.db)
+	sq := peer.NewSend(inventory, s.db, s.bandwidth.Quota, false)
 	p := peer.NewPeer(s, conn, inventory, sq, na, false, persistent)
 
 	peerLog.Debug("NewOutboundPeer ", addr, " created.")
@@ -0,0 +1,260 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// rateChunk is the largest number of bytes which a connection transfers
+	// at once under a shared rate limit, so that connections take turns in
+	// small pieces rather than whole messages.
+	rateChunk = 4096
+
+	// rateBurst is how long a shared rate limit may go unused and still be
+	// made up for by a burst of traffic.
+	rateBurst = time.Second
+
+	// quotaReserve is the part of the upload quota which is kept for our own
+	// use. Once no more than this is left, objects are no longer sent to
+	// inbound peers.
+	quotaReserve = 0.1
+)
+
+// RateLimit is a limit on the number of bytes per second transferred by all
+// the connections which share it. Connections wait their turn in the order in
+// which they ask for it, so that each gets a fair share.
+type RateLimit struct {
+	rate float64
+
+	mtx sync.Mutex
+	// next is the time at which the bytes reserved so far will have been
+	// transferred at the limiting rate.
+	next time.Time
+}
+
+// NewRateLimit returns a RateLimit of the given number of bytes per second.
+// A rate of zero means no limit.
+func NewRateLimit(rate int64) *RateLimit {
+	return &RateLimit{rate: float64(rate)}
+}
+
+// reserve reserves n bytes and returns how long to wait before transferring
+// them.
+func (l *RateLimit) reserve(n int, now time.Time) time.Duration {
+	if l == nil || l.rate <= 0 || n <= 0 {
+		return 0
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if earliest := now.Add(-rateBurst); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+
+	if wait := l.next.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// wait blocks until n bytes may be transferred.
+func (l *RateLimit) wait(n int) {
+	if d := l.reserve(n, time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// QuotaUsage describes how much of an upload quota has been used.
+type QuotaUsage struct {
+	// Month is the start of the calendar month in UTC which the quota is
+	// counted over.
+	Month time.Time
+
+	// Limit is the number of bytes which may be uploaded in the month, or
+	// zero if there is no limit, and Used is the number which have been.
+	Limit uint64
+	Used  uint64
+
+	// Refused is the number of getdata requests which have been refused,
+	// in whole or in part, because of the quota since bmd was started.
+	Refused uint64
+}
+
+// UploadQuota counts the bytes uploaded to all peers during each calendar
+// month against a limit. Once the limit is nearly reached, objects are only
+// sent to the peers we connected to ourselves, and once it is reached, to
+// none at all. It is safe for concurrent access.
+type UploadQuota struct {
+	limit uint64
+
+	mtx     sync.Mutex
+	month   time.Time
+	used    uint64
+	refused uint64
+}
+
+// monthStart returns the start of the calendar month of t in UTC.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// NewUploadQuota returns an UploadQuota of limit bytes per month, of which
+// used bytes have already been uploaded in the month of the given time. A
+// limit of zero means that uploads are only counted.
+func NewUploadQuota(limit uint64, month time.Time, used uint64) *UploadQuota {
+	return &UploadQuota{
+		limit: limit,
+		month: monthStart(month),
+		used:  used,
+	}
+}
+
+// roll starts counting a new month if now is past the current one. The
+// mutex must be held.
+func (q *UploadQuota) roll(now time.Time) {
+	if month := monthStart(now); !month.Equal(q.month) {
+		q.month = month
+		q.used = 0
+	}
+}
+
+// add records that n bytes were uploaded.
+func (q *UploadQuota) add(n uint64, now time.Time) {
+	if q == nil || n == 0 {
+		return
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.roll(now)
+	before := q.used
+	q.used += n
+
+	if q.limit == 0 {
+		return
+	}
+	reserve := uint64(quotaReserve * float64(q.limit))
+	if before < q.limit && q.used >= q.limit {
+		log.Warnf("Upload quota of %d bytes used up; no more objects will "+
+			"be sent to peers this month.", q.limit)
+	} else if before < q.limit-reserve && q.used >= q.limit-reserve {
+		log.Warnf("Upload quota of %d bytes nearly used up; no more objects "+
+			"will be sent to inbound peers this month.", q.limit)
+	}
+}
+
+// allow returns whether objects may be sent to a peer, and counts the
+// request as refused if not.
+func (q *UploadQuota) allow(inbound bool, now time.Time) bool {
+	if q == nil || q.limit == 0 {
+		return true
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.roll(now)
+	limit := q.limit
+	if inbound {
+		limit -= uint64(quotaReserve * float64(q.limit))
+	}
+	if q.used < limit {
+		return true
+	}
+
+	q.refused++
+	return false
+}
+
+// Usage returns how much of the quota has been used.
+func (q *UploadQuota) Usage(now time.Time) QuotaUsage {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.roll(now)
+	return QuotaUsage{
+		Month:   q.month,
+		Limit:   q.limit,
+		Used:    q.used,
+		Refused: q.refused,
+	}
+}
+
+// Bandwidth holds the limits on the traffic of all connections together.
+// Any of them may be nil.
+type Bandwidth struct {
+	Up    *RateLimit
+	Down  *RateLimit
+	Quota *UploadQuota
+}
+
+// writer returns w limited by the upload rate and counted against the quota.
+func (bw *Bandwidth) writer(w io.Writer) io.Writer {
+	if bw == nil {
+		return w
+	}
+	return &limitedWriter{w: w, bw: bw}
+}
+
+// reader returns r limited by the download rate.
+func (bw *Bandwidth) reader(r io.Reader) io.Reader {
+	if bw == nil || bw.Down == nil {
+		return r
+	}
+	return &limitedReader{r: r, limit: bw.Down}
+}
+
+// limitedWriter writes in chunks, waiting for its turn under the shared
+// upload rate before each.
+type limitedWriter struct {
+	w  io.Writer
+	bw *Bandwidth
+}
+
+// Write writes b to the underlying writer.
+func (lw *limitedWriter) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > rateChunk {
+			chunk = chunk[:rateChunk]
+		}
+
+		lw.bw.Up.wait(len(chunk))
+		n, err := lw.w.Write(chunk)
+		written += n
+		lw.bw.Quota.add(uint64(n), time.Now())
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// limitedReader reads in chunks, and waits for its turn under the shared
+// download rate after each, which holds up the next read.
+type limitedReader struct {
+	r     io.Reader
+	limit *RateLimit
+}
+
+// Read reads from the underlying reader into b.
+func (lr *limitedReader) Read(b []byte) (int, error) {
+	if len(b) > rateChunk {
+		b = b[:rateChunk]
+	}
+	n, err := lr.r.Read(b)
+	lr.limit.wait(n)
+	return n, err
+}
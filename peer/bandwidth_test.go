@@ -0,0 +1,126 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	now := time.Now()
+
+	// No limit.
+	var l *RateLimit
+	if d := l.reserve(1000, now); d != 0 {
+		t.Errorf("expected no wait without a limit, got %s", d)
+	}
+	if d := NewRateLimit(0).reserve(1000, now); d != 0 {
+		t.Errorf("expected no wait with a zero limit, got %s", d)
+	}
+
+	// A second's worth of traffic may go through at once after the limit
+	// has gone unused.
+	l = NewRateLimit(1000)
+	if d := l.reserve(1000, now); d != 0 {
+		t.Errorf("expected no wait, got %s", d)
+	}
+
+	// After that, connections take turns at the limiting rate.
+	if d := l.reserve(500, now); d != 500*time.Millisecond {
+		t.Errorf("expected wait 500ms, got %s", d)
+	}
+	if d := l.reserve(500, now); d != time.Second {
+		t.Errorf("expected wait 1s, got %s", d)
+	}
+	if d := l.reserve(100, now.Add(time.Second)); d != 100*time.Millisecond {
+		t.Errorf("expected wait 100ms, got %s", d)
+	}
+
+	// Unused time is only made up for up to rateBurst.
+	if d := l.reserve(1500, now.Add(time.Hour)); d != 500*time.Millisecond {
+		t.Errorf("expected wait 500ms, got %s", d)
+	}
+}
+
+func TestUploadQuota(t *testing.T) {
+	now := time.Date(2016, time.March, 31, 23, 0, 0, 0, time.UTC)
+	q := NewUploadQuota(1000, now, 0)
+
+	// Everyone is served until the reserve is reached.
+	q.add(899, now)
+	if !q.allow(true, now) || !q.allow(false, now) {
+		t.Error("request refused before the quota is nearly used up")
+	}
+
+	// Then only outbound peers are served.
+	q.add(1, now)
+	if q.allow(true, now) {
+		t.Error("inbound peer served after the quota is nearly used up")
+	}
+	if !q.allow(false, now) {
+		t.Error("outbound peer refused before the quota is used up")
+	}
+
+	// Then no one is.
+	q.add(100, now)
+	if q.allow(true, now) || q.allow(false, now) {
+		t.Error("request served after the quota is used up")
+	}
+	usage := q.Usage(now)
+	if usage.Used != 1000 || usage.Refused != 3 ||
+		!usage.Month.Equal(time.Date(2016, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	// The quota starts over each month.
+	next := now.Add(2 * time.Hour)
+	if !q.allow(true, next) {
+		t.Error("request refused in a new month")
+	}
+	if usage := q.Usage(next); usage.Used != 0 {
+		t.Errorf("expected unused quota, got %+v", usage)
+	}
+
+	// Without a limit, uploads are only counted.
+	q = NewUploadQuota(0, now, 0)
+	q.add(1<<40, now)
+	if !q.allow(true, now) {
+		t.Error("request refused without a limit")
+	}
+}
+
+func TestBandwidthWriter(t *testing.T) {
+	var bw *Bandwidth
+	var buf bytes.Buffer
+	if bw.writer(&buf) != &buf || bw.reader(&buf) != &buf {
+		t.Error("no bandwidth limits should not wrap connections")
+	}
+
+	now := time.Now()
+	bw = &Bandwidth{
+		Up:    NewRateLimit(0),
+		Quota: NewUploadQuota(0, now, 0),
+	}
+	data := bytes.Repeat([]byte{1}, 3*rateChunk+1)
+	n, err := bw.writer(&buf).Write(data)
+	if err != nil || n != len(data) {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("wrong data written")
+	}
+	if usage := bw.Quota.Usage(now); usage.Used != uint64(len(data)) {
+		t.Errorf("expected %d bytes used, got %d", len(data), usage.Used)
+	}
+
+	// Reads are in chunks of at most rateChunk.
+	bw.Down = NewRateLimit(0)
+	b := make([]byte, len(data))
+	if n, err := bw.reader(&buf).Read(b); err != nil || n != rateChunk {
+		t.Errorf("Read returned %d, %v", n, err)
+	}
+}
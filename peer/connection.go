@@ -52,6 +52,7 @@ type connection struct {
 	idleTimer     *time.Timer
 	maxUp         *maxrate.MaxRate
 	maxDown       *maxrate.MaxRate
+	bw            *Bandwidth
 }
 
 // WriteMessage sends a bitmessage p2p message along the tcp connection.
@@ -66,7 +67,7 @@ func (pc *connection) WriteMessage(msg wire.Message) error {
 	pc.connMtx.RUnlock()
 
 	// Write message to peer.
-	n, err := wire.WriteMessageN(conn, msg, wire.MainNet)
+	n, err := wire.WriteMessageN(pc.bw.writer(conn), msg, wire.MainNet)
 
 	pc.sentMtx.Lock()
 	pc.bytesSent += uint64(n)
@@ -98,7 +99,7 @@ func (pc *connection) ReadMessage() (wire.Message, error) {
 	pc.connMtx.RUnlock()
 
 	// Read message from peer.
-	n, msg, _, err := wire.ReadMessageN(conn, wire.MainNet)
+	n, msg, err := readMessage(pc.bw.reader(conn))
 
 	pc.receivedMtx.Lock()
 	pc.bytesReceived += uint64(n)
@@ -195,8 +196,10 @@ func SetDialer(dialer func(string, string) (net.Conn, error)) {
 	dial = dialer
 }
 
-// NewConnection creates a new *connection.
-func NewConnection(addr net.Addr, maxDown, maxUp int64) Connection {
+// NewConnection creates a new *connection. Its traffic counts against the
+// limits in bw, which may be nil, along with that of the other connections
+// which share them.
+func NewConnection(addr net.Addr, maxDown, maxUp int64, bw *Bandwidth) Connection {
 	idleTimeout := time.Minute * pingTimeoutMinutes
 
 	pc := &connection{
@@ -204,6 +207,7 @@ func NewConnection(addr net.Addr, maxDown, maxUp int64) Connection {
 		idleTimeout: idleTimeout,
 		maxDown:     maxrate.New(float64(maxDown), 1),
 		maxUp:       maxrate.New(float64(maxUp), 1),
+		bw:          bw,
 	}
 
 	pc.idleTimer = time.AfterFunc(pc.idleTimeout, func() {
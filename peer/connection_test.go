@@ -64,7 +64,7 @@ func TestDial(t *testing.T) {
 	d := peer.TstSwapDial(mocknet.Dialer(localAddr, false, false))
 	defer peer.TstSwapDial(d)
 
-	conn := peer.NewConnection(remoteAddr, maxUpload, maxDownload)
+	conn := peer.NewConnection(remoteAddr, maxUpload, maxDownload, nil)
 	if conn == nil {
 		t.Errorf("No connection returned.")
 	}
@@ -78,7 +78,7 @@ func TestDial(t *testing.T) {
 	}
 
 	peer.TstSwapDial(mocknet.Dialer(localAddr, true, false))
-	conn = peer.NewConnection(remoteAddr, maxUpload, maxDownload)
+	conn = peer.NewConnection(remoteAddr, maxUpload, maxDownload, nil)
 	err = conn.Connect()
 	if err == nil {
 		t.Errorf("Error expected dialing failed connection.")
@@ -94,7 +94,7 @@ func TestUnconnectedConnection(t *testing.T) {
 	d := peer.TstSwapDial(mocknet.Dialer(localAddr, false, false))
 	defer peer.TstSwapDial(d)
 
-	conn := peer.NewConnection(remoteAddr, maxUpload, maxDownload)
+	conn := peer.NewConnection(remoteAddr, maxUpload, maxDownload, nil)
 	if conn.RemoteAddr() == nil {
 		t.Error("The remote adder should not be nil.")
 	}
@@ -117,14 +117,14 @@ func TestInterruptedConnection(t *testing.T) {
 	d := peer.TstSwapDial(mocknet.Dialer(localAddr, false, true))
 	defer peer.TstSwapDial(d)
 
-	conn := peer.NewConnection(remoteAddr, maxUpload, maxDownload)
+	conn := peer.NewConnection(remoteAddr, maxUpload, maxDownload, nil)
 	conn.Connect()
 	msg, err := conn.ReadMessage()
 	if err == nil || msg != nil {
 		t.Error("Connection should be closed.")
 	}
 
-	conn = peer.NewConnection(remoteAddr, maxUpload, maxDownload)
+	conn = peer.NewConnection(remoteAddr, maxUpload, maxDownload, nil)
 	conn.Connect()
 	err = conn.WriteMessage(&wire.MsgVerAck{})
 	if err == nil {
@@ -17,7 +17,9 @@ for them when a connection is opened.
 Send manages everything that is to be sent to the remote peer eventually. Data
 requests, inv trickles, and other messages.
 
-Peer manages the peer object overall and routes incoming messages.
+Peer manages the peer object overall and routes incoming messages. It pings the
+remote peer when nothing has been received from it for a while and keeps
+rolling statistics of the round trip times to it.
 
 Logic is an interface that has functions such as HandleAddrMsg that handles the
 different kinds of messages in the bitmessage protocol.
@@ -25,5 +27,9 @@ different kinds of messages in the bitmessage protocol.
 Inventory can be used to store the known inventory and the requested inventory
 for a peer.
 
+Bandwidth holds the limits on the traffic of all connections together, which
+they share fairly, and a monthly upload quota. Once the quota is nearly used
+up, objects are only sent to the peers we connected to ourselves.
+
 */
 package peer
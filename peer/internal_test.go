@@ -20,11 +20,16 @@ import (
 // TstNewConnection is used to create a new connection with a mock conn instead
 // of a real one for testing purposes.
 func TstNewConnection(conn net.Conn) Connection {
-	return &connection{
-		conn:    conn,
-		maxDown: maxrate.New(100000000, 20),
-		maxUp:   maxrate.New(100000000, 20),
+	pc := &connection{
+		conn:        conn,
+		addr:        conn.RemoteAddr(),
+		idleTimeout: time.Hour,
+		maxDown:     maxrate.New(100000000, 20),
+		maxUp:       maxrate.New(100000000, 20),
 	}
+	pc.idleTimer = time.AfterFunc(pc.idleTimeout, func() {})
+	pc.idleTimer.Stop()
+	return pc
 }
 
 // TstNewListneter returns a new listener with a user defined net.Listener, which
@@ -51,17 +51,28 @@ func (I *Inventory) RemoveKnown(invVect *wire.InvVect) {
 	I.known.Delete(invVect)
 }
 
+// NumKnown returns the number of inventory hashes that the peer is known to
+// have.
+func (I *Inventory) NumKnown() int {
+	I.mutex.RLock()
+	defer I.mutex.RUnlock()
+
+	return I.known.Len()
+}
+
 // AddRequest marks that a certain number of objects have been requested.
 func (I *Inventory) AddRequest(i uint32) {
 	atomic.AddInt32(&I.requested, int32(i))
 }
 
-// DropRequest marks that a certain number of objects have been requested.
-func (I *Inventory) DropRequest() {
-	atomic.AddInt32(&I.requested, -1)
+// DropRequest marks that a certain number of requested objects are no longer
+// expected from the peer.
+func (I *Inventory) DropRequest(i uint32) {
+	atomic.AddInt32(&I.requested, -int32(i))
 }
 
-// NumRequests is the number of object download requests that have been made.
+// NumRequests is the number of object download requests that are outstanding.
+// It is kept by the object manager.
 func (I *Inventory) NumRequests() uint32 {
 	return uint32(atomic.LoadInt32(&I.requested))
 }
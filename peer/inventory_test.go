@@ -33,6 +33,31 @@ func TestIsAddKnown(t *testing.T) {
 	}
 }
 
+func TestNumKnown(t *testing.T) {
+	inventory := peer.NewInventory()
+
+	if inventory.NumKnown() != 0 {
+		t.Error("Number of known inventory should be zero.")
+	}
+
+	a := (*wire.InvVect)(randomShaHash())
+	b := (*wire.InvVect)(randomShaHash())
+	inventory.AddKnown(a)
+	inventory.AddKnown(b)
+	inventory.AddKnown(a)
+
+	if inventory.NumKnown() != 2 {
+		t.Errorf("Number of known inventory should be 2, got %d.",
+			inventory.NumKnown())
+	}
+
+	inventory.RemoveKnown(b)
+	if inventory.NumKnown() != 1 {
+		t.Errorf("Number of known inventory should be 1, got %d.",
+			inventory.NumKnown())
+	}
+}
+
 func TestRequest(t *testing.T) {
 	inventory := peer.NewInventory()
 
@@ -45,6 +70,12 @@ func TestRequest(t *testing.T) {
 	if inventory.NumRequests() != 3 {
 		t.Error("Number of requests should be three.")
 	}
+
+	inventory.DropRequest(2)
+
+	if inventory.NumRequests() != 1 {
+		t.Error("Number of requests should be one.")
+	}
 }
 
 func TestFilterKnown(t *testing.T) {
@@ -0,0 +1,320 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+const (
+	// syncMemoryMinutes is the number of minutes after a peer disconnects
+	// that we remember how much of our inventory was advertised to it.
+	syncMemoryMinutes = 30
+
+	// syncCounterPageSize is the number of objects to retrieve at a time
+	// when looking for objects which are new since a previous sync.
+	syncCounterPageSize = 1000
+)
+
+// syncTypes are the object types whose counters are used to find the objects
+// that are new since a peer was last synced. Objects of unknown type are only
+// found by paging through the whole inventory.
+var syncTypes = []wire.ObjectType{
+	wire.ObjectTypeGetPubKey,
+	wire.ObjectTypePubKey,
+	wire.ObjectTypeMsg,
+	wire.ObjectTypeBroadcast,
+}
+
+// syncCounter identifies the counter of an object type in a stream.
+type syncCounter struct {
+	objType wire.ObjectType
+	stream  uint32
+}
+
+// syncState records how far inventory sync with a remote address has gotten.
+type syncState struct {
+	// cursor is the last hash advertised while paging through the inventory,
+	// or nil if paging has not begun.
+	cursor *hash.Sha
+
+	// done is whether paging reached the end of the inventory.
+	done bool
+
+	// counters are the counter positions of the database in the streams of
+	// the peer when the last sync began, or nil if there has not been one.
+	counters map[syncCounter]uint64
+
+	// active is whether a peer at the address is currently connected.
+	active bool
+
+	// lastSeen is when the state was last updated.
+	lastSeen time.Time
+}
+
+// SyncMemory remembers how much of our inventory was advertised to recently
+// connected outbound peers, by the address we connected to, so that a peer
+// which we reconnect to shortly after disconnecting is not sent everything
+// again. Inbound peers are not remembered, since different nodes may connect
+// from the same address, such as those behind one NAT or all those which come
+// in through tor. It is safe for concurrent access.
+type SyncMemory struct {
+	mtx   sync.Mutex
+	addrs map[string]syncState
+}
+
+// acquire returns the remembered state for the given address and marks it as
+// active. If there is already an active peer at the address, false is returned
+// and the state must not be saved.
+func (m *SyncMemory) acquire(addr string, now time.Time) (syncState, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	// Forget addresses which have been gone for too long.
+	for h, s := range m.addrs {
+		if !s.active && now.Sub(s.lastSeen) > syncMemoryMinutes*time.Minute {
+			delete(m.addrs, h)
+		}
+	}
+
+	state := m.addrs[addr]
+	if state.active {
+		return syncState{}, false
+	}
+
+	state.active = true
+	state.lastSeen = now
+	m.addrs[addr] = state
+	return state, true
+}
+
+// save updates the remembered state for the given address. It does not change
+// whether the address is active.
+func (m *SyncMemory) save(addr string, state syncState, now time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	state.active = m.addrs[addr].active
+	state.lastSeen = now
+	m.addrs[addr] = state
+}
+
+// release marks the given address as no longer active.
+func (m *SyncMemory) release(addr string, now time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	state, ok := m.addrs[addr]
+	if !ok {
+		return
+	}
+	state.active = false
+	state.lastSeen = now
+	m.addrs[addr] = state
+}
+
+// NewSyncMemory returns a new SyncMemory.
+func NewSyncMemory() *SyncMemory {
+	return &SyncMemory{
+		addrs: make(map[string]syncState),
+	}
+}
+
+// invSync advertises our inventory to a remote peer once the handshake is
+// complete. It pages through the unexpired inventory in order of hash, one
+// inv message at a time, so that the whole inventory is advertised no matter
+// how big it is. Since QueueMessage blocks when the send queue is full, pages
+// are only retrieved as fast as the peer can receive them.
+type invSync struct {
+	peer     *Peer
+	memory   *SyncMemory
+	addr     string
+	remember bool
+	state    syncState
+
+	// newer is inventory which was added since the peer was last synced,
+	// and which is sent before the rest.
+	newer []*wire.InvVect
+
+	quitOnce sync.Once
+	quit     chan struct{}
+}
+
+// start begins sync. The first inv message is sent before start returns and
+// the rest are sent from a separate go routine.
+func (s *invSync) start() {
+	if s.memory != nil && !s.peer.Inbound {
+		s.addr = s.peer.Addr().String()
+		s.state, s.remember = s.memory.acquire(s.addr, time.Now())
+	}
+
+	// The peer may have disconnected before the sync was registered with it.
+	if !s.peer.Connected() {
+		s.stop()
+		return
+	}
+
+	db := s.peer.server.Db()
+	streams := s.peer.Streams()
+	counters := make(map[syncCounter]uint64, len(syncTypes)*len(streams))
+	for _, stream := range streams {
+		for _, objType := range syncTypes {
+			counter, err := db.GetCounter(objType, stream)
+			if err != nil {
+				log.Errorf("GetCounter failed: %v", err)
+				return
+			}
+			counters[syncCounter{objType, stream}] = counter
+		}
+	}
+
+	if s.state.counters != nil {
+		log.Debug(s.peer.PrependAddr("Resuming inventory sync."))
+		s.newer = s.newInventory(counters)
+	}
+	s.state.counters = counters
+
+	if s.next() {
+		go s.run()
+	}
+}
+
+// run sends the remaining inventory. It must be run as a go routine.
+func (s *invSync) run() {
+	for s.next() {
+	}
+}
+
+// stop stops sync and tells the sync memory that the peer is gone.
+func (s *invSync) stop() {
+	s.quitOnce.Do(func() {
+		close(s.quit)
+		if s.remember {
+			s.memory.release(s.addr, time.Now())
+		}
+	})
+}
+
+// newInventory returns the inventory added to the database since the
+// remembered counter positions, up to the given ones. Streams which were not
+// synced before have no remembered positions, and their inventory is left to
+// be found by paging through the whole inventory.
+func (s *invSync) newInventory(counters map[syncCounter]uint64) []*wire.InvVect {
+	db := s.peer.server.Db()
+	now := time.Now()
+
+	var newer []*wire.InvVect
+	for _, stream := range s.peer.Streams() {
+		for _, objType := range syncTypes {
+			key := syncCounter{objType, stream}
+			previous, ok := s.state.counters[key]
+			if !ok {
+				continue
+			}
+
+			counter := previous + 1
+			for counter <= counters[key] {
+				objects, last, err := db.FetchObjectsFromCounter(objType,
+					stream, counter, syncCounterPageSize)
+				if err != nil {
+					log.Errorf("FetchObjectsFromCounter failed: %v", err)
+					break
+				}
+
+				for _, o := range objects {
+					if now.After(o.Object.Header().Expiration()) {
+						continue
+					}
+					newer = append(newer, (*wire.InvVect)(obj.InventoryHash(o.Object)))
+				}
+
+				if len(objects) < syncCounterPageSize {
+					break
+				}
+				counter = last + 1
+			}
+		}
+	}
+
+	return newer
+}
+
+// next sends the next inv message. It returns false once there is nothing
+// more to send or if the peer has disconnected.
+func (s *invSync) next() bool {
+	select {
+	case <-s.quit:
+		return false
+	default:
+	}
+
+	if len(s.newer) > 0 {
+		n := len(s.newer)
+		if n > wire.MaxInvPerMsg {
+			n = wire.MaxInvPerMsg
+		}
+		s.push(s.newer[:n])
+		s.newer = s.newer[n:]
+		return true
+	}
+
+	if s.state.done {
+		s.save()
+		return false
+	}
+
+	hashes, err := s.peer.server.Db().FetchInvHashesAfter(s.state.cursor,
+		wire.MaxInvPerMsg, s.peer.Streams()...)
+	if err != nil {
+		log.Errorf("FetchInvHashesAfter failed: %v", err)
+		return false
+	}
+
+	if len(hashes) > 0 {
+		s.push(hashes)
+		s.state.cursor = (*hash.Sha)(hashes[len(hashes)-1])
+	}
+	if len(hashes) < wire.MaxInvPerMsg {
+		s.state.done = true
+		log.Debug(s.peer.PrependAddr("Inventory sync complete."))
+	}
+
+	s.save()
+	return !s.state.done
+}
+
+// push sends an inv message with the hashes that the peer is not already
+// known to have.
+func (s *invSync) push(hashes []*wire.InvVect) {
+	ivl := s.peer.Inventory.FilterKnown(hashes)
+	if len(ivl) == 0 {
+		return
+	}
+
+	s.peer.QueueMessage(&wire.MsgInv{InvList: ivl})
+	log.Debug(s.peer.PrependAddr(fmt.Sprint("Inv message sent with ", len(ivl), " hashes.")))
+}
+
+// save updates the sync memory with the current state.
+func (s *invSync) save() {
+	if s.remember {
+		s.memory.save(s.addr, s.state, time.Now())
+	}
+}
+
+// newInvSync returns a new invSync for the given peer.
+func newInvSync(p *Peer, memory *SyncMemory) *invSync {
+	return &invSync{
+		peer:   p,
+		memory: memory,
+		quit:   make(chan struct{}),
+	}
+}
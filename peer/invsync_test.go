@@ -0,0 +1,95 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+// TestInvSyncMemory tests that sync is only remembered for outbound peers,
+// since different nodes may connect to us from the same address.
+func TestInvSyncMemory(t *testing.T) {
+	s := &pingServer{}
+	m := NewSyncMemory()
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8444}
+	na, _ := wire.NewNetAddress(addr, 1, 0)
+
+	for _, inbound := range []bool{true, false} {
+		p := NewPeer(s, NewConnection(addr, 0, 0, nil), NewInventory(), nil,
+			na, inbound, false)
+		newInvSync(p, m).start()
+
+		_, remembered := m.addrs[addr.String()]
+		if remembered == inbound {
+			t.Errorf("inbound %v: expected remembered %v", inbound, !inbound)
+		}
+	}
+}
+
+// TestSyncMemory tests that sync state is remembered across connections to
+// the same address until it expires.
+func TestSyncMemory(t *testing.T) {
+	m := NewSyncMemory()
+	now := time.Now()
+
+	state, ok := m.acquire("1.2.3.4:8444", now)
+	if !ok {
+		t.Fatal("Could not acquire new address.")
+	}
+	if state.counters != nil || state.cursor != nil || state.done {
+		t.Errorf("New address has state %v", state)
+	}
+
+	// A second connection from the same address does not use the memory.
+	if _, ok = m.acquire("1.2.3.4:8444", now); ok {
+		t.Error("Acquired address which is already active.")
+	}
+
+	cursor := &hash.Sha{1, 2, 3}
+	state.cursor = cursor
+	state.done = true
+	msgs := syncCounter{wire.ObjectTypeMsg, 1}
+	state.counters = map[syncCounter]uint64{msgs: 5}
+	m.save("1.2.3.4:8444", state, now)
+
+	// Saving does not release the address.
+	if _, ok = m.acquire("1.2.3.4:8444", now); ok {
+		t.Error("Acquired address which is still active after save.")
+	}
+
+	m.release("1.2.3.4:8444", now)
+
+	state, ok = m.acquire("1.2.3.4:8444", now.Add(time.Minute))
+	if !ok {
+		t.Fatal("Could not acquire released address.")
+	}
+	if state.cursor != cursor || !state.done ||
+		state.counters[msgs] != 5 {
+		t.Errorf("State not remembered: %v", state)
+	}
+	m.release("1.2.3.4:8444", now.Add(time.Minute))
+
+	// Other addresses are unaffected.
+	state, ok = m.acquire("5.6.7.8:8444", now)
+	if !ok || state.counters != nil {
+		t.Errorf("Unexpected state for other address: %v", state)
+	}
+	m.release("5.6.7.8:8444", now)
+
+	// The state is forgotten after a while.
+	state, ok = m.acquire("1.2.3.4:8444",
+		now.Add(time.Minute*(syncMemoryMinutes+2)))
+	if !ok {
+		t.Fatal("Could not acquire expired address.")
+	}
+	if state.counters != nil || state.cursor != nil || state.done {
+		t.Errorf("State not forgotten: %v", state)
+	}
+}
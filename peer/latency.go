@@ -0,0 +1,210 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+const (
+	// pingInterval is how long a connection may be idle before we ping the
+	// remote peer. It must be well below the idle timeout so that the
+	// reply arrives before the peer is timed out.
+	pingInterval = 2 * time.Minute
+
+	// latencyWeight is the weight given to each new round trip time in the
+	// rolling average latency of a peer.
+	latencyWeight = 0.2
+
+	// cmdPing is the command of a ping message. The remote peer answers it
+	// with a pong.
+	cmdPing = "ping"
+
+	// messageHeaderSize is the size of the header of a Bitmessage message:
+	// the magic bytes, the command, the payload length and the checksum.
+	messageHeaderSize = 24
+
+	// maxPingPayload is the largest payload of a ping message which is
+	// accepted. The payload is ignored.
+	maxPingPayload = 1024
+)
+
+// msgPing is a Bitmessage ping message, which has no payload. It is not part
+// of the wire package, so it is decoded by readMessage.
+type msgPing struct{}
+
+// Decode decodes r into the receiver.
+func (msg *msgPing) Decode(r io.Reader) error {
+	return nil
+}
+
+// Encode encodes the receiver to w.
+func (msg *msgPing) Encode(w io.Writer) error {
+	return nil
+}
+
+// Command returns the protocol command string for the message.
+func (msg *msgPing) Command() string {
+	return cmdPing
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.
+func (msg *msgPing) MaxPayloadLength() int {
+	return 0
+}
+
+var _ wire.Message = (*msgPing)(nil)
+
+// readMessage reads a message from r and returns the number of bytes read.
+// Ping messages are decoded here and everything else by the wire package.
+func readMessage(r io.Reader) (int, wire.Message, error) {
+	var hdr [messageHeaderSize]byte
+	n, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return n, nil, err
+	}
+
+	command := string(bytes.TrimRight(hdr[4:16], "\x00"))
+	if command != cmdPing || binary.BigEndian.Uint32(hdr[:4]) != uint32(wire.MainNet) {
+		n, msg, _, err := wire.ReadMessageN(io.MultiReader(
+			bytes.NewReader(hdr[:]), r), wire.MainNet)
+		return n, msg, err
+	}
+
+	length := binary.BigEndian.Uint32(hdr[16:20])
+	if length > maxPingPayload {
+		return n, nil, fmt.Errorf("ping payload of %d bytes exceeds maximum of %d",
+			length, maxPingPayload)
+	}
+	discarded, err := io.CopyN(ioutil.Discard, r, int64(length))
+	return n + int(discarded), &msgPing{}, err
+}
+
+// Latency describes the round trip times measured to a remote peer.
+type Latency struct {
+	// Average is a rolling average of the round trip times, weighted
+	// towards the most recent ones.
+	Average time.Duration
+
+	// Last and Min are the most recent and the lowest round trip times.
+	Last time.Duration
+	Min  time.Duration
+
+	// Samples is the number of round trips measured.
+	Samples uint64
+}
+
+// latencyStats measures the round trips to a remote peer. A round trip is
+// timed from a message which the peer must answer to the first answer. These
+// are a ping and its pong, a version message and its ver ack, and a getdata
+// message sent when there are no other requests outstanding and the first
+// object received after it. It is safe for concurrent access.
+type latencyStats struct {
+	mtx     sync.Mutex
+	latency Latency
+
+	// pingSent and requestSent are when the ping or the getdata message
+	// which is waiting to be answered was sent, or zero if there is none.
+	pingSent    time.Time
+	requestSent time.Time
+}
+
+// add adds a round trip time to the stats.
+func (l *latencyStats) add(rtt time.Duration) {
+	if rtt < 0 {
+		return
+	}
+
+	if l.latency.Samples == 0 {
+		l.latency.Average = rtt
+		l.latency.Min = rtt
+	} else {
+		l.latency.Average += time.Duration(latencyWeight *
+			float64(rtt-l.latency.Average))
+		if rtt < l.latency.Min {
+			l.latency.Min = rtt
+		}
+	}
+	l.latency.Last = rtt
+	l.latency.Samples++
+}
+
+// sample adds the time since start as a round trip time and returns true, or
+// returns false if start is zero.
+func (l *latencyStats) sample(start, now time.Time) bool {
+	if start.IsZero() {
+		return false
+	}
+	l.add(now.Sub(start))
+	return true
+}
+
+// pinged records that a ping was sent. A ping which has not been answered by
+// then is given up on, since not every peer answers pings.
+func (l *latencyStats) pinged(now time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.pingSent = now
+}
+
+// ponged records that a pong was received and measures the round trip if a
+// ping was waiting for it.
+func (l *latencyStats) ponged(now time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.sample(l.pingSent, now) {
+		l.pingSent = time.Time{}
+	}
+}
+
+// requested records that a getdata message was sent. The round trip is only
+// timed if there were no requests outstanding, so that the reply does not
+// wait behind objects requested earlier.
+func (l *latencyStats) requested(now time.Time, outstanding uint32) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if outstanding == 0 && l.requestSent.IsZero() {
+		l.requestSent = now
+	}
+}
+
+// received records that an object was received and measures the round trip
+// if a getdata message was waiting for it.
+func (l *latencyStats) received(now time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.sample(l.requestSent, now) {
+		l.requestSent = time.Time{}
+	}
+}
+
+// handshake measures the round trip from a version message to its ver ack.
+func (l *latencyStats) handshake(versionSent, now time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.sample(versionSent, now)
+}
+
+// stats returns the round trip times measured so far.
+func (l *latencyStats) stats() Latency {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.latency
+}
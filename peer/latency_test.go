@@ -0,0 +1,129 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/addrmgr"
+	"github.com/DanielKrawisz/bmd/database"
+	_ "github.com/DanielKrawisz/bmd/database/memdb"
+	"github.com/DanielKrawisz/bmutil/wire"
+)
+
+// pingServer is the server of the peers which ping each other.
+type pingServer struct {
+	db *database.Db
+}
+
+func (s *pingServer) Nonce() uint64                     { return 1 }
+func (s *pingServer) Streams() []uint32                 { return []uint32{1} }
+func (s *pingServer) AddrManager() *addrmgr.AddrManager { return nil }
+func (s *pingServer) ObjectManager() ObjectManager      { return nil }
+func (s *pingServer) Db() *database.Db                  { return s.db }
+func (s *pingServer) SyncMemory() *SyncMemory           { return nil }
+func (s *pingServer) DonePeer(*Peer)                    {}
+func (s *pingServer) BanPeer(*Peer)                     {}
+func (s *pingServer) BanThreshold() uint32              { return 0 }
+
+func TestLatencyStats(t *testing.T) {
+	var l latencyStats
+	now := time.Now()
+
+	// A pong which was not asked for is not timed.
+	l.ponged(now)
+	if s := l.stats(); s.Samples != 0 {
+		t.Fatalf("expected no samples, got %d", s.Samples)
+	}
+
+	l.pinged(now)
+	l.ponged(now.Add(100 * time.Millisecond))
+	s := l.stats()
+	if s.Samples != 1 || s.Average != 100*time.Millisecond ||
+		s.Last != 100*time.Millisecond || s.Min != 100*time.Millisecond {
+		t.Errorf("unexpected stats after first ping %+v", s)
+	}
+
+	// A second pong to the same ping is not timed.
+	l.ponged(now.Add(time.Second))
+	if s := l.stats(); s.Samples != 1 {
+		t.Errorf("expected 1 sample, got %d", s.Samples)
+	}
+
+	// The average moves towards new round trip times.
+	l.pinged(now)
+	l.ponged(now.Add(600 * time.Millisecond))
+	s = l.stats()
+	if s.Samples != 2 || s.Average != 200*time.Millisecond ||
+		s.Last != 600*time.Millisecond || s.Min != 100*time.Millisecond {
+		t.Errorf("unexpected stats after second ping %+v", s)
+	}
+
+	// Only getdata messages sent when nothing else is outstanding are
+	// timed, up to the first object received.
+	l.requested(now, 3)
+	l.received(now.Add(time.Second))
+	if s := l.stats(); s.Samples != 2 {
+		t.Errorf("expected 2 samples, got %d", s.Samples)
+	}
+	l.requested(now, 0)
+	l.requested(now.Add(10*time.Millisecond), 5)
+	l.received(now.Add(50 * time.Millisecond))
+	l.received(now.Add(time.Second))
+	s = l.stats()
+	if s.Samples != 3 || s.Last != 50*time.Millisecond || s.Min != 50*time.Millisecond {
+		t.Errorf("unexpected stats after getdata %+v", s)
+	}
+
+	// The handshake is timed from the version message.
+	l.handshake(now, now.Add(70*time.Millisecond))
+	l.handshake(time.Time{}, now)
+	if s := l.stats(); s.Samples != 4 || s.Last != 70*time.Millisecond {
+		t.Errorf("unexpected stats after handshake %+v", s)
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	db, err := database.OpenDB("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &pingServer{db: db}
+
+	// Two peers are connected to each other.
+	newPeer := func(conn net.Conn) *Peer {
+		addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8444}
+		na, _ := wire.NewNetAddress(addr, 1, 0)
+		inventory := NewInventory()
+		p := NewPeerHandshakeComplete(s, TstNewConnection(conn), inventory,
+			NewSend(inventory, db, nil, true), na)
+		if err := p.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a, b := net.Pipe()
+	pa, pb := newPeer(a), newPeer(b)
+	defer pa.Disconnect()
+	defer pb.Disconnect()
+
+	// The ping of one is answered by the other, which times the round trip.
+	pa.PushPingMsg()
+	deadline := time.Now().Add(5 * time.Second)
+	for pa.Latency().Samples == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("ping not answered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !pb.Connected() {
+		t.Error("peer disconnected after it was pinged")
+	}
+	if samples := pb.Latency().Samples; samples != 0 {
+		t.Errorf("expected no round trips measured by the pinged peer, got %d", samples)
+	}
+}
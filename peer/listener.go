@@ -28,6 +28,7 @@ type listener struct {
 	netListener net.Listener
 	maxDown     int64
 	maxUp       int64
+	bw          *Bandwidth
 }
 
 // Accept blocks until a new connection dials in. It returns a Connection object,
@@ -44,6 +45,7 @@ func (pl *listener) Accept() (Connection, error) {
 		idleTimeout: time.Minute * pingTimeoutMinutes,
 		maxDown:     maxrate.New(float64(pl.maxDown), 1),
 		maxUp:       maxrate.New(float64(pl.maxUp), 1),
+		bw:          pl.bw,
 	}
 
 	connection.idleTimer = time.AfterFunc(connection.idleTimeout, func() {
@@ -66,14 +68,19 @@ func (pl *listener) Addr() net.Addr {
 // A value that can be swapped out to create mock listeners.
 var listen = net.Listen
 
-// Listen creates a listener object. The value of listen can
-// be swapped out with a mock connection dialer for testing purposes.
-func Listen(service, addr string) (Listener, error) {
+// Listen creates a listener object. The connections it accepts are limited
+// to maxDown and maxUp bytes per second each and share the limits in bw,
+// which may be nil. The value of listen can be swapped out with a mock
+// connection dialer for testing purposes.
+func Listen(service, addr string, maxDown, maxUp int64, bw *Bandwidth) (Listener, error) {
 	netListener, err := listen(service, addr)
 	if err != nil {
 		return nil, err
 	}
 	return &listener{
 		netListener: netListener,
+		maxDown:     maxDown,
+		maxUp:       maxUp,
+		bw:          bw,
 	}, nil
 }
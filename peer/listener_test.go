@@ -131,7 +131,7 @@ func TestListen(t *testing.T) {
 	l := peer.TstSwapListen(builder.Listen)
 	defer peer.TstSwapListen(l)
 
-	listen, err := peer.Listen("tcp4", "8445")
+	listen, err := peer.Listen("tcp4", "8445", 0, 0, nil)
 	if listen == nil {
 		t.Errorf("No connection returned.")
 	}
@@ -139,7 +139,7 @@ func TestListen(t *testing.T) {
 		t.Errorf("Error %s returned.", err)
 	}
 
-	listen, err = peer.Listen("tcp4", "8445")
+	listen, err = peer.Listen("tcp4", "8445", 0, 0, nil)
 	if listen != nil {
 		t.Errorf("Connection returned when it should have failed.")
 	}
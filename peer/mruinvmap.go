@@ -36,6 +36,11 @@ func (m *MruInventoryMap) Exists(iv *wire.InvVect) bool {
 	return false
 }
 
+// Len returns the number of items in the map.
+func (m *MruInventoryMap) Len() int {
+	return len(m.invMap)
+}
+
 // Add adds the passed inventory to the map and handles eviction of the oldest
 // item if adding the new item would exceed the max limit.
 func (m *MruInventoryMap) Add(iv *wire.InvVect) {
@@ -52,14 +52,25 @@ const (
 	// message requiring a reply before we will ping a host.
 	pingTimeoutMinutes = 5
 
-	// MaxPeerRequests is the maximum number of outstanding object requests a
-	// may have at any given time.
-	MaxPeerRequests = 120
+	// BanScoreUnrequestedObject is the transient ban score added when a peer
+	// sends an object which was not requested from it.
+	BanScoreUnrequestedObject = 20
+
+	// BanScoreInvalidPow is the persistent ban score added when a peer sends
+	// an object with insufficient proof-of-work.
+	BanScoreInvalidPow = 25
+
+	// BanScoreRejectedInv is the transient ban score added when a peer
+	// advertises an object which we rejected and which it has advertised
+	// before, or which had insufficient proof-of-work.
+	BanScoreRejectedInv = 5
+
+	// BanScoreMalformedInv is the persistent ban score added when a peer
+	// sends an inv message which is empty or has too many entries.
+	BanScoreMalformedInv = 20
 )
 
 var (
-	defaultStreamList = []uint32{1}
-
 	// userAgentName is the user agent name and is used to help identify
 	// ourselves to other bitmessage peers.
 	userAgentName = "bmd"
@@ -71,10 +82,14 @@ var (
 
 type server interface {
 	Nonce() uint64
+	Streams() []uint32
 	AddrManager() *addrmgr.AddrManager
 	ObjectManager() ObjectManager
 	Db() *database.Db
+	SyncMemory() *SyncMemory
 	DonePeer(*Peer)
+	BanPeer(*Peer)
+	BanThreshold() uint32
 }
 
 // ObjectManager represents the object manager. It is returned by the server
@@ -82,8 +97,8 @@ type server interface {
 type ObjectManager interface {
 	NewPeer(*Peer)
 	DonePeer(*Peer)
-	ReadyPeer(*Peer)
 	QueueInv(inv *wire.MsgInv, p *Peer)
+	QueueGetData(getData *wire.MsgGetData, p *Peer)
 	QueueObject(inv *wire.MsgObject, p *Peer)
 }
 
@@ -111,23 +126,29 @@ type Peer struct {
 	// to the object manager that it is ready to start downloading.
 	invReceived bool
 
-	// signalReady determines when the peer tells the object manager that it
-	// is ready to request more objects from the remote peer. It does this
-	// when the number of requested objects falls to this amount.
-	signalReady uint32
-
 	// The set of addresses known to this peer.
 	knownAddresses map[string]struct{}
 
+	// invSync advertises our inventory to the peer after the handshake.
+	invSync *invSync
+
+	// banScore keeps track of how badly the peer has behaved.
+	banScore DynamicBanScore
+
+	// latency measures the round trips to the remote peer.
+	latency latencyStats
+
 	StatsMtx          sync.RWMutex // protects all statistics below here.
 	na                *wire.NetAddress
 	versionKnown      bool
 	versionSent       bool
+	versionSentAt     time.Time
 	verAckReceived    bool
 	handshakeComplete bool
 	protocolVersion   uint32
 	services          wire.ServiceFlag
 	userAgent         string
+	streams           []uint32
 }
 
 // VersionKnown returns the whether or not the version of a peer is known locally.
@@ -148,6 +169,96 @@ func (p *Peer) HandshakeComplete() bool {
 	return p.handshakeComplete
 }
 
+// Streams returns the streams which both we and the remote peer participate
+// in. It is empty until the version message of the remote peer is known. It is
+// safe for concurrent access.
+func (p *Peer) Streams() []uint32 {
+	p.StatsMtx.RLock()
+	defer p.StatsMtx.RUnlock()
+
+	return p.streams
+}
+
+// InStream returns whether the remote peer participates in the given stream
+// along with us. It is safe for concurrent access.
+func (p *Peer) InStream(stream uint32) bool {
+	p.StatsMtx.RLock()
+	defer p.StatsMtx.RUnlock()
+
+	// The streams of the peer are not known before its version message,
+	// and an empty list would be taken to include every stream.
+	return p.versionKnown && database.InStreams(uint64(stream), p.streams)
+}
+
+// UserAgent returns the user agent of the remote peer. It is empty until the
+// version message of the remote peer is known. It is safe for concurrent
+// access.
+func (p *Peer) UserAgent() string {
+	p.StatsMtx.RLock()
+	defer p.StatsMtx.RUnlock()
+
+	return p.userAgent
+}
+
+// BytesSent returns the total number of bytes sent to the remote peer.
+func (p *Peer) BytesSent() uint64 {
+	return p.conn.BytesWritten()
+}
+
+// BytesReceived returns the total number of bytes received from the remote
+// peer.
+func (p *Peer) BytesReceived() uint64 {
+	return p.conn.BytesRead()
+}
+
+// LastSend returns the time that a message was last sent to the remote peer.
+func (p *Peer) LastSend() time.Time {
+	return p.conn.LastWrite()
+}
+
+// LastReceive returns the time that a message was last received from the
+// remote peer.
+func (p *Peer) LastReceive() time.Time {
+	return p.conn.LastRead()
+}
+
+// Latency returns the round trip times measured to the remote peer. It is
+// safe for concurrent access.
+func (p *Peer) Latency() Latency {
+	return p.latency.stats()
+}
+
+// BanScore returns the current ban score of the peer.
+func (p *Peer) BanScore() uint32 {
+	return p.banScore.Int()
+}
+
+// AddBanScore increases the persistent and transient ban scores of the peer by
+// the given amounts. If the resulting score exceeds the ban threshold of the
+// server, the server is told to ban the peer and true is returned. Nothing
+// happens if the ban threshold is zero, which means that banning is disabled.
+func (p *Peer) AddBanScore(persistent, transient uint32, reason string) bool {
+	threshold := p.server.BanThreshold()
+	if threshold == 0 {
+		return false
+	}
+
+	score := p.banScore.Increase(persistent, transient)
+	if score > threshold/2 {
+		log.Warn(p.PrependAddr(fmt.Sprint("Misbehaving peer: ", reason,
+			" -- ban score increased to ", score, ".")))
+		if score > threshold {
+			log.Warn(p.PrependAddr("Misbehaving peer -- banning and disconnecting."))
+			p.server.BanPeer(p)
+			return true
+		}
+	} else {
+		log.Debug(p.PrependAddr(fmt.Sprint("Misbehaving peer: ", reason,
+			" -- ban score increased to ", score, ".")))
+	}
+	return false
+}
+
 // PrependAddr is a helper function for logging that adds the ip address to
 // the start of the string to be logged.
 func (p *Peer) PrependAddr(str string) string {
@@ -201,6 +312,13 @@ func (p *Peer) Disconnect() {
 		p.server.ObjectManager().DonePeer(p)
 	}
 
+	p.StatsMtx.RLock()
+	invSync := p.invSync
+	p.StatsMtx.RUnlock()
+	if invSync != nil {
+		invSync.stop()
+	}
+
 	p.server.DonePeer(p)
 	log.Info(p.PrependAddr("Disconnected."))
 	atomic.StoreInt32(&p.disconnect, 0)
@@ -279,7 +397,7 @@ func (p *Peer) PushVersionMsg() {
 	// Version message.
 	msg := wire.NewMsgVersion(
 		p.server.AddrManager().GetBestLocalAddress(theirNa), theirNa,
-		p.server.Nonce(), defaultStreamList)
+		p.server.Nonce(), p.server.Streams())
 	msg.AddUserAgent(userAgentName, userAgentVersion)
 
 	msg.AddrYou.Services = wire.SFNodeNetwork
@@ -292,6 +410,7 @@ func (p *Peer) PushVersionMsg() {
 
 	p.StatsMtx.Lock()
 	p.versionSent = true
+	p.versionSentAt = time.Now()
 	p.StatsMtx.Unlock()
 
 	log.Debug(p.PrependAddr("Version message sent."))
@@ -303,7 +422,17 @@ func (p *Peer) PushVerAckMsg() {
 	log.Debug(p.PrependAddr("Ver ack message sent."))
 }
 
+// PushPingMsg sends a ping to the remote peer. The round trip is timed if the
+// peer answers with a pong.
+func (p *Peer) PushPingMsg() {
+	p.latency.pinged(time.Now())
+	p.QueueMessage(&msgPing{})
+	log.Trace(p.PrependAddr("Ping sent."))
+}
+
 // PushGetDataMsg creates a GetData message and sends it to the remote peer.
+// The object manager counts the requests in the peer's inventory after
+// pushing them.
 func (p *Peer) PushGetDataMsg(ivl []*wire.InvVect) {
 	l := uint32(len(ivl))
 
@@ -311,15 +440,9 @@ func (p *Peer) PushGetDataMsg(ivl []*wire.InvVect) {
 		return
 	}
 
-	p.Inventory.AddRequest(l)
-	if p.Inventory.NumRequests() > MaxPeerRequests/2 {
-		p.signalReady = p.Inventory.NumRequests() / 2
-	} else {
-		p.signalReady = 0
-	}
+	p.latency.requested(time.Now(), p.Inventory.NumRequests())
 	log.Debug(p.PrependAddr(fmt.Sprint(l,
-		" requests assigned for a total of ", p.Inventory.NumRequests(),
-		"; signal ready at ", p.signalReady)))
+		" requests assigned in addition to ", p.Inventory.NumRequests())))
 
 	x := uint32(0)
 	for l-x > wire.MaxInvPerMsg {
@@ -363,13 +486,15 @@ func (p *Peer) PushObjectMsg(sha *hash.Sha) {
 }
 
 // PushAddrMsg sends one, or more, addr message(s) to the connected peer using
-// the provided addresses.
+// the provided addresses. Only the addresses in the streams which we share
+// with the peer are sent.
 func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) error {
 	// Nothing to send.
 	if len(addresses) == 0 {
 		return errors.New("Address list is empty.")
 	}
 
+	streams := p.Streams()
 	r := prand.New(prand.NewSource(time.Now().UnixNano()))
 	numAdded := 0
 	msg := wire.NewMsgAddr()
@@ -378,6 +503,12 @@ func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) error {
 			continue
 		}
 
+		// Filter addresses in streams the peer does not share with us. The
+		// streams are not known before the version message of the peer.
+		if len(streams) == 0 || !database.InStreams(uint64(Na.Stream), streams) {
+			continue
+		}
+
 		// Filter addresses the peer already knows about.
 		if _, exists := p.knownAddresses[addrmgr.NetAddressKey(Na)]; exists {
 			continue
@@ -446,7 +577,17 @@ func (p *Peer) HandleVersionMsg(msg *wire.MsgVersion) error {
 	}
 
 	log.Debug(p.PrependAddr("Version msg received."))
+
+	// Disconnect if we have no streams in common with the remote peer.
+	streams := commonStreams(p.server.Streams(), msg.StreamNumbers)
+	if len(streams) == 0 {
+		p.StatsMtx.Unlock()
+
+		return fmt.Errorf("No streams in common. Peer advertised %v.", msg.StreamNumbers)
+	}
+
 	p.versionKnown = true
+	p.streams = streams
 
 	// Set the supported services for the peer to what the remote peer
 	// advertised.
@@ -462,8 +603,8 @@ func (p *Peer) HandleVersionMsg(msg *wire.MsgVersion) error {
 		// Set up a NetAddress for the peer to be used with addrManager.
 		// We only do this inbound because outbound set this up
 		// at connection time and no point recomputing.
-		// We only use the first stream number for now because bitmessage has
-		// only one stream.
+		// The address is given the first stream which we have in common
+		// with the remote peer.
 
 		addr := p.Addr().String()
 		host, portStr, err := net.SplitHostPort(addr)
@@ -476,7 +617,7 @@ func (p *Peer) HandleVersionMsg(msg *wire.MsgVersion) error {
 			return err
 		}
 
-		na, err := p.server.AddrManager().HostToNetAddress(host, uint16(port), msg.StreamNumbers[0], p.services)
+		na, err := p.server.AddrManager().HostToNetAddress(host, uint16(port), streams[0], p.services)
 		if err != nil {
 			return fmt.Errorf("Can't send version message: %s", err)
 		}
@@ -505,6 +646,7 @@ func (p *Peer) HandleVersionMsg(msg *wire.MsgVersion) error {
 func (p *Peer) HandleVerAckMsg() error {
 	p.StatsMtx.RLock()
 	versionSent := p.versionSent
+	versionSentAt := p.versionSentAt
 	p.StatsMtx.RUnlock()
 	// If no version message has been sent disconnect.
 	if !versionSent {
@@ -513,6 +655,8 @@ func (p *Peer) HandleVerAckMsg() error {
 	}
 	log.Debug(p.PrependAddr("Ver ack msg received."))
 
+	p.latency.handshake(versionSentAt, time.Now())
+
 	p.verAckReceived = true
 	p.server.AddrManager().Connected(p.NetAddress())
 	p.handleInitialConnection()
@@ -528,13 +672,15 @@ func (p *Peer) HandleInvMsg(msg *wire.MsgInv) error {
 		return errors.New("Handshake not complete.")
 	}
 
-	// Disconnect if the message is too big.
+	// Penalize and disconnect if the message is too big.
 	if len(msg.InvList) > wire.MaxInvPerMsg {
+		p.AddBanScore(BanScoreMalformedInv, 0, "inv too big")
 		return errors.New("Inv too big.")
 	}
 
-	// Disconnect if the message is too big.
+	// Penalize and disconnect if the message is empty.
 	if len(msg.InvList) == 0 {
+		p.AddBanScore(BanScoreMalformedInv, 0, "empty inv")
 		return errors.New("Empty inv received.")
 	}
 
@@ -571,25 +717,21 @@ func (p *Peer) HandleGetDataMsg(msg *wire.MsgGetData) error {
 	if err != nil {
 		return err
 	}
+	p.server.ObjectManager().QueueGetData(msg, p)
 	p.server.AddrManager().Connected(p.NetAddress())
 	return nil
 }
 
-// HandleObjectMsg updates the peer's request list and sends the object to
-// the object manager.
+// HandleObjectMsg sends the object to the object manager, which keeps track
+// of the objects requested from the peer and requests more when it is ready.
 func (p *Peer) HandleObjectMsg(msg *wire.MsgObject) error {
 	if !p.HandshakeComplete() {
 		return errors.New("Handshake not complete.")
 	}
 
-	p.Inventory.DropRequest()
+	p.latency.received(time.Now())
 
 	p.server.ObjectManager().QueueObject(msg, p)
-
-	// signal object manager that we are ready to download more.
-	if p.signalReady == p.Inventory.NumRequests() || p.Inventory.NumRequests() == 0 {
-		p.server.ObjectManager().ReadyPeer(p)
-	}
 	p.server.AddrManager().Connected(p.NetAddress())
 
 	return nil
@@ -656,20 +798,19 @@ func (p *Peer) handleInitialConnection() {
 	}
 	// The initial handshake is complete.
 
+	invSync := newInvSync(p, p.server.SyncMemory())
+
 	p.StatsMtx.Lock()
 	p.handshakeComplete = true
+	p.invSync = invSync
 	p.StatsMtx.Unlock()
 
 	// Send a big addr message.
 	p.PushAddrMsg(p.server.AddrManager().AddressCache())
 
-	// Send a big inv message.
-	hashes, err := p.server.Db().FetchRandomInvHashes(wire.MaxInvPerMsg)
-	if err != nil {
-		log.Errorf("FetchRandomInvHashes failed: %v", err)
-		return
-	}
-	p.PushInvMsg(hashes)
+	// Advertise our inventory. This continues in the background if it does
+	// not fit in one inv message.
+	invSync.start()
 	log.Debug(p.PrependAddr("Handshake complete."))
 }
 
@@ -684,14 +825,31 @@ func (p *Peer) inHandler(handshakeTimeoutSeconds, idleTimeoutMinutes uint) {
 		p.Disconnect()
 	})
 
+	// Ping the remote peer whenever nothing has been received from it for
+	// a while, so that the connection is kept alive and the round trip is
+	// measured.
+	var pingTimer *time.Timer
+	pingTimer = time.AfterFunc(pingInterval, func() {
+		if !p.Connected() {
+			return
+		}
+		if p.HandshakeComplete() {
+			p.PushPingMsg()
+		}
+		pingTimer.Reset(pingInterval)
+	})
+
 out:
 	for atomic.LoadInt32(&p.disconnect) == 0 {
 		rmsg, err := p.conn.ReadMessage()
-		// Stop the timer now, if we go around again we will reset it.
+		// Stop the timers now, if we go around again we will reset them.
 		idleTimer.Stop()
+		pingTimer.Stop()
 		if err != nil && err != errNoConnection {
 			log.Debugf(p.PrependAddr("Invalid message received: "), err)
 			// Ignore messages we don't understand.
+			idleTimer.Reset(time.Duration(idleTimeoutMinutes) * time.Minute)
+			pingTimer.Reset(pingInterval)
 			continue
 		}
 		if rmsg == nil {
@@ -719,7 +877,11 @@ out:
 		case *wire.MsgObject:
 			err = p.HandleObjectMsg(msg)
 
+		case *msgPing:
+			p.QueueMessage(&wire.MsgPong{})
+
 		case *wire.MsgPong:
+			p.latency.ponged(time.Now())
 
 		default:
 			log.Warn(p.PrependAddr("Invalid message processed. This should not happen."))
@@ -732,9 +894,11 @@ out:
 		}
 
 		idleTimer.Reset(time.Duration(idleTimeoutMinutes) * time.Minute)
+		pingTimer.Reset(pingInterval)
 	}
 
 	idleTimer.Stop()
+	pingTimer.Stop()
 
 	// Ensure connection is closed and notify the server that the peer is
 	// done.
@@ -783,3 +947,18 @@ func NewPeerHandshakeComplete(s server, conn Connection, inventory *Inventory, s
 
 	return p
 }
+
+// commonStreams returns the streams in ours which also appear in theirs,
+// preserving the order of ours.
+func commonStreams(ours, theirs []uint32) []uint32 {
+	var common []uint32
+	for _, s := range ours {
+		for _, t := range theirs {
+			if s == t {
+				common = append(common, s)
+				break
+			}
+		}
+	}
+	return common
+}
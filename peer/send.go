@@ -78,6 +78,12 @@ type send struct {
 	// The database of objects.
 	db *database.Db
 
+	// The upload quota, which is checked before each object is sent, and
+	// whether the peer connected to us, in which case it is refused objects
+	// before the quota is entirely used up.
+	quota   *UploadQuota
+	inbound bool
+
 	// The state of the send queue.
 	started int32
 	stopped int32
@@ -193,7 +199,16 @@ out:
 		case <-send.quit:
 			break out
 		case invList := <-send.requestQueue:
-			for _, inv := range invList {
+			for i, inv := range invList {
+				// The quota is checked for every object because a single
+				// request may be for many of them. Once it is used up,
+				// the rest of the request is dropped.
+				if !send.quota.allow(send.inbound, time.Now()) {
+					log.Debug(send.PrependAddr(fmt.Sprint("Upload quota used up; ",
+						len(invList)-i, " requested objects not sent.")))
+					break
+				}
+
 				msg := retrieveObject(send.db, inv)
 				if msg != nil {
 					send.dataQueue <- msg
@@ -307,8 +322,10 @@ func (send *send) PrependAddr(str string) string {
 	return fmt.Sprintf("%s : %s", send.conn.RemoteAddr().String(), str)
 }
 
-// NewSend returns a new sendQueue object.
-func NewSend(inventory *Inventory, db *database.Db) Send {
+// NewSend returns a new sendQueue object. Objects are only sent while the
+// upload quota allows it, which may be nil; inbound is whether the peer
+// connected to us.
+func NewSend(inventory *Inventory, db *database.Db, quota *UploadQuota, inbound bool) Send {
 	return &send{
 		trickleTime:   time.Second * 10,
 		msgQueue:      make(chan wire.Message, sendQueueSize),
@@ -318,6 +335,8 @@ func NewSend(inventory *Inventory, db *database.Db) Send {
 		quit:          make(chan struct{}),
 		inventory:     inventory,
 		db:            db,
+		quota:         quota,
+		inbound:       inbound,
 		stopped:       1,
 	}
 }
@@ -25,7 +25,7 @@ func TestSendStartStop(t *testing.T) {
 	conn := NewMockConnection(mockAddr, true, false)
 	db, _ := database.OpenDB("memdb")
 
-	queue := peer.NewSend(peer.NewInventory(), db)
+	queue := peer.NewSend(peer.NewInventory(), db, nil, false)
 
 	if queue.Running() {
 		t.Errorf("queue should not be running yet. ")
@@ -81,7 +81,7 @@ func TestSendMessage(t *testing.T) {
 	db, _ := database.OpenDB("memdb")
 	var err error
 
-	queue := peer.NewSend(peer.NewInventory(), db)
+	queue := peer.NewSend(peer.NewInventory(), db, nil, false)
 
 	message := &wire.MsgVerAck{}
 
@@ -131,7 +131,7 @@ func TestRequestData(t *testing.T) {
 
 	var err error
 
-	queue := peer.NewSend(peer.NewInventory(), db)
+	queue := peer.NewSend(peer.NewInventory(), db, nil, false)
 	message := wire.NewMsgObject(wire.NewObjectHeader(345, time.Now(), wire.ObjectType(4), 1, 1), []byte{77, 82, 53, 48, 96, 1})
 
 	db.InsertObject(message)
@@ -188,6 +188,42 @@ func TestRequestData(t *testing.T) {
 	queue.Stop()
 }
 
+func TestRequestDataQuota(t *testing.T) {
+	db, _ := database.OpenDB("memdb")
+	message := wire.NewMsgObject(wire.NewObjectHeader(345, time.Now(), wire.ObjectType(4), 1, 1), []byte{77, 82, 53, 48, 96, 1})
+	db.InsertObject(message)
+	hashes := []*wire.InvVect{(*wire.InvVect)(obj.InventoryHash(message))}
+
+	// The quota is nearly used up, so objects are still sent to outbound
+	// peers but no longer to inbound ones.
+	quota := peer.NewUploadQuota(1000, time.Now(), 950)
+
+	conn := NewMockConnection(mockAddr, true, false)
+	queue := peer.NewSend(peer.NewInventory(), db, quota, false)
+	queue.Start(conn)
+	queue.QueueDataRequest(hashes)
+	if sentData := conn.MockRead(nil); sentData == nil ||
+		!bytes.Equal(wire.Encode(message), wire.Encode(sentData)) {
+		t.Errorf("Object not sent to outbound peer.")
+	}
+	queue.Stop()
+
+	conn = NewMockConnection(mockAddr, true, false)
+	queue = peer.NewSend(peer.NewInventory(), db, quota, true)
+	queue.Start(conn)
+	queue.QueueDataRequest(append(hashes, hashes...))
+
+	reset := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(reset) })
+	if sentData := conn.MockRead(reset); sentData != nil {
+		t.Errorf("Object sent to inbound peer after the quota was nearly used up.")
+	}
+	if refused := quota.Usage(time.Now()).Refused; refused != 1 {
+		t.Errorf("Expected 1 refused request, got %d", refused)
+	}
+	queue.Stop()
+}
+
 func TestQueueInv(t *testing.T) {
 	timerChan := make(chan time.Time)
 
@@ -198,7 +234,7 @@ func TestQueueInv(t *testing.T) {
 	db, _ := database.OpenDB("memdb")
 
 	var err error
-	queue := peer.NewSend(peer.NewInventory(), db)
+	queue := peer.NewSend(peer.NewInventory(), db, nil, false)
 
 	// The queue isn't running yet, so this should return an error.
 	err = queue.QueueInventory([]*wire.InvVect{(*wire.InvVect)(randomShaHash())})
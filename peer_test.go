@@ -87,8 +87,8 @@ func TestOutboundPeerHandshake(t *testing.T) {
 	remoteAddr := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 8333}
 
 	// A peer that establishes a handshake for outgoing peers.
-	handshakePeerBuilder := func(action *PeerAction) func(net.Addr, int64, int64) peer.Connection {
-		return func(addr net.Addr, maxDown, maxUp int64) peer.Connection {
+	handshakePeerBuilder := func(action *PeerAction) func(net.Addr, int64, int64, *peer.Bandwidth) peer.Connection {
+		return func(addr net.Addr, maxDown, maxUp int64, bw *peer.Bandwidth) peer.Connection {
 			return NewMockPeer(localAddr, remoteAddr, report,
 				NewOutboundHandshakePeerTester(action, msgAddr))
 		}
@@ -160,7 +160,9 @@ func TestInboundPeerHandshake(t *testing.T) {
 	futureVersion := wire.NewMsgVersion(addrin, addrout, nonce, streams)
 	futureVersion.ProtocolVersion = int32(4)
 
-	// The four test cases are all in this list.
+	otherStreamVersion := wire.NewMsgVersion(addrin, addrout, nonce, []uint32{2})
+
+	// The test cases are all in this list.
 	openingMsg := []*PeerAction{
 		&PeerAction{
 			Messages: []wire.Message{wire.NewMsgVersion(addrin, addrout, nonce, streams)},
@@ -190,6 +192,11 @@ func TestInboundPeerHandshake(t *testing.T) {
 			InteractionComplete: true,
 			DisconnectExpected:  false,
 		},
+		&PeerAction{
+			Messages:            []wire.Message{otherStreamVersion},
+			InteractionComplete: true,
+			DisconnectExpected:  true,
+		},
 	}
 
 	for testCase, open := range openingMsg {
@@ -0,0 +1,280 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DanielKrawisz/bmutil/pow"
+)
+
+// powCheckInterval is the number of nonces a worker tries between checks of
+// whether its job has been cancelled.
+const powCheckInterval = 1 << 14
+
+var (
+	// errPowQueueFull is returned when a job is submitted to a queue which
+	// already holds as many jobs as it may.
+	errPowQueueFull = errors.New("proof of work queue is full")
+
+	// errPowCancelled is the result of a job which was cancelled.
+	errPowCancelled = errors.New("proof of work cancelled")
+
+	// errPowStopped is the result of a job which was not done when its queue
+	// was stopped.
+	errPowStopped = errors.New("proof of work service stopped")
+)
+
+// powJob is the proof of work for an object, to be done by a powQueue.
+type powJob struct {
+	queue       *powQueue
+	target      uint64
+	initialHash []byte
+
+	trials uint64 // atomic
+
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	done       chan struct{}
+
+	// nonce and err are the result of the job. They may only be read once
+	// done is closed.
+	nonce uint64
+	err   error
+}
+
+// Cancel cancels the job, removing it from the queue if it has not started.
+// It does nothing if the job is done.
+func (j *powJob) Cancel() {
+	j.cancelOnce.Do(func() {
+		close(j.cancel)
+	})
+
+	if j.queue.remove(j) {
+		j.finish(0, errPowCancelled)
+	}
+}
+
+// Done returns a channel which is closed once the job is done.
+func (j *powJob) Done() <-chan struct{} {
+	return j.done
+}
+
+// Result returns the nonce found by the job, or the reason it was not found.
+// It may only be called once the job is done.
+func (j *powJob) Result() (uint64, error) {
+	return j.nonce, j.err
+}
+
+// Status returns the position of the job in the queue, starting from 1, and
+// the number of nonces tried so far. The position is 0 once the job has
+// started.
+func (j *powJob) Status() (position int, trials uint64) {
+	return j.queue.position(j), atomic.LoadUint64(&j.trials)
+}
+
+// finish records the result of the job.
+func (j *powJob) finish(nonce uint64, err error) {
+	j.nonce = nonce
+	j.err = err
+	close(j.done)
+}
+
+// solve tries one nonce after another until one reaches the target or the
+// job is cancelled.
+func (j *powJob) solve(quit <-chan struct{}) (uint64, error) {
+	for nonce := uint64(0); ; nonce++ {
+		if nonce%powCheckInterval == 0 {
+			atomic.StoreUint64(&j.trials, nonce)
+			select {
+			case <-j.cancel:
+				return 0, errPowCancelled
+			case <-quit:
+				return 0, errPowStopped
+			default:
+			}
+		}
+
+		if pow.Check(j.target, nonce, j.initialHash) {
+			atomic.StoreUint64(&j.trials, nonce+1)
+			return nonce, nil
+		}
+	}
+}
+
+// powQueue does the proof of work for objects on behalf of RPC clients. Jobs
+// wait in a queue of limited size and are taken in order by a fixed number of
+// workers, each of which does one job at a time.
+type powQueue struct {
+	workers   int
+	maxQueued int
+
+	mtx     sync.Mutex
+	queue   []*powJob
+	running int
+	solved  uint64
+
+	wake chan struct{}
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newPowQueue creates a powQueue with the given number of workers, which
+// holds up to maxQueued jobs waiting for a worker. Use Start to begin doing
+// jobs.
+func newPowQueue(workers, maxQueued int) *powQueue {
+	return &powQueue{
+		workers:   workers,
+		maxQueued: maxQueued,
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+	}
+}
+
+// Submit adds a job to find a nonce which reaches the target for the object
+// with the given initial hash. It returns errPowQueueFull if there is no room
+// for it.
+func (q *powQueue) Submit(target uint64, initialHash []byte) (*powJob, error) {
+	j := &powJob{
+		queue:       q,
+		target:      target,
+		initialHash: initialHash,
+		cancel:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	q.mtx.Lock()
+	select {
+	case <-q.quit:
+		q.mtx.Unlock()
+		return nil, errPowStopped
+	default:
+	}
+	if len(q.queue) >= q.maxQueued {
+		q.mtx.Unlock()
+		return nil, errPowQueueFull
+	}
+	q.queue = append(q.queue, j)
+	q.mtx.Unlock()
+
+	q.signal()
+	return j, nil
+}
+
+// Stats returns the number of jobs waiting in the queue, the number of jobs
+// being done and the total number of jobs which have been done.
+func (q *powQueue) Stats() (queued, running int, solved uint64) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return len(q.queue), q.running, q.solved
+}
+
+// signal wakes up a worker which is waiting for a job.
+func (q *powQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// position returns the position of the job in the queue, starting from 1, or
+// 0 if it is not in it.
+func (q *powQueue) position(j *powJob) int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for i, queued := range q.queue {
+		if queued == j {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// remove removes the job from the queue and returns whether it was in it.
+func (q *powQueue) remove(j *powJob) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for i, queued := range q.queue {
+		if queued == j {
+			q.queue = append(q.queue[:i], q.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// next takes the first job from the queue, if any.
+func (q *powQueue) next() *powJob {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.queue) == 0 {
+		return nil
+	}
+	j := q.queue[0]
+	q.queue = q.queue[1:]
+	q.running++
+
+	// Let another worker take the next job.
+	if len(q.queue) > 0 {
+		q.signal()
+	}
+	return j
+}
+
+// worker does jobs from the queue until the queue is stopped. It must be run
+// as a goroutine.
+func (q *powQueue) worker() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.wake:
+		case <-q.quit:
+			return
+		}
+
+		for j := q.next(); j != nil; j = q.next() {
+			nonce, err := j.solve(q.quit)
+
+			q.mtx.Lock()
+			q.running--
+			if err == nil {
+				q.solved++
+			}
+			q.mtx.Unlock()
+
+			j.finish(nonce, err)
+		}
+	}
+}
+
+// Start starts the workers.
+func (q *powQueue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Stop stops the workers and waits for them to finish. The jobs which are
+// not done end with errPowStopped.
+func (q *powQueue) Stop() {
+	q.mtx.Lock()
+	close(q.quit)
+	queued := q.queue
+	q.queue = nil
+	q.mtx.Unlock()
+
+	for _, j := range queued {
+		j.finish(0, errPowStopped)
+	}
+	q.wg.Wait()
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/pow"
+)
+
+// waitPowJob waits for a job to be done and returns its result.
+func waitPowJob(t *testing.T, j *powJob) (uint64, error) {
+	select {
+	case <-j.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("proof of work not done")
+	}
+	return j.Result()
+}
+
+func TestPowQueue(t *testing.T) {
+	q := newPowQueue(1, 1)
+	q.Start()
+
+	// A target which is reached by a few nonces on average.
+	target := ^uint64(0) / 4
+	initialHash := hash.Sha512([]byte("object"))
+	j, err := q.Submit(target, initialHash)
+	if err != nil {
+		t.Fatalf("Submit: unexpected error %v", err)
+	}
+	nonce, err := waitPowJob(t, j)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !pow.Check(target, nonce, initialHash) {
+		t.Errorf("nonce %d does not reach the target", nonce)
+	}
+	if _, trials := j.Status(); trials != nonce+1 {
+		t.Errorf("expected %d trials, got %d", nonce+1, trials)
+	}
+
+	// A target which can't be reached keeps the only worker busy, so the
+	// next job waits in the queue, after which it is full.
+	running, err := q.Submit(1, initialHash)
+	if err != nil {
+		t.Fatalf("Submit: unexpected error %v", err)
+	}
+	for i := 0; ; i++ {
+		if _, r, _ := q.Stats(); r == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("job was not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	queued, err := q.Submit(1, initialHash)
+	if err != nil {
+		t.Fatalf("Submit: unexpected error %v", err)
+	}
+	if position, _ := queued.Status(); position != 1 {
+		t.Errorf("expected queue position 1, got %d", position)
+	}
+	if _, err := q.Submit(1, initialHash); err != errPowQueueFull {
+		t.Errorf("expected error %v, got %v", errPowQueueFull, err)
+	}
+
+	// A job which is cancelled while queued is removed from the queue.
+	queued.Cancel()
+	if _, err := waitPowJob(t, queued); err != errPowCancelled {
+		t.Errorf("expected error %v, got %v", errPowCancelled, err)
+	}
+	if n, _, _ := q.Stats(); n != 0 {
+		t.Errorf("expected empty queue, got %d jobs", n)
+	}
+
+	// A running job stops when the queue is stopped.
+	q.Stop()
+	if _, err := waitPowJob(t, running); err != errPowStopped {
+		t.Errorf("expected error %v, got %v", errPowStopped, err)
+	}
+	if _, _, solved := q.Stats(); solved != 1 {
+		t.Errorf("expected 1 job done, got %d", solved)
+	}
+	if _, err := q.Submit(target, initialHash); err != errPowStopped {
+		t.Errorf("expected error %v, got %v", errPowStopped, err)
+	}
+}
+
+func TestPowQueueCancel(t *testing.T) {
+	q := newPowQueue(1, 1)
+	q.Start()
+	defer q.Stop()
+
+	j, err := q.Submit(1, hash.Sha512([]byte("object")))
+	if err != nil {
+		t.Fatalf("Submit: unexpected error %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	j.Cancel()
+	if _, err := waitPowJob(t, j); err != errPowCancelled {
+		t.Errorf("expected error %v, got %v", errPowCancelled, err)
+	}
+
+	// Cancelling a job which is done does nothing.
+	j.Cancel()
+}
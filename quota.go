@@ -0,0 +1,97 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/peer"
+)
+
+const (
+	// quotaFilename is the name of the file in the data directory in which
+	// the use of the upload quota is saved.
+	quotaFilename = "quota.json"
+
+	// quotaVersion is the version of the format of the quota file.
+	quotaVersion = 1
+
+	// quotaSaveInterval is how often the use of the upload quota is saved.
+	quotaSaveInterval = 10 * time.Minute
+)
+
+type serializedQuota struct {
+	Version int
+	Month   int64
+	Used    uint64
+}
+
+// loadUploadQuota returns an upload quota of limit bytes per month, which has
+// been used as much as recorded in the given file. A missing file results in
+// an unused quota. So does a file which cannot be read, in which case the
+// error is returned as well.
+func loadUploadQuota(filePath string, limit uint64) (*peer.UploadQuota, error) {
+	unused := peer.NewUploadQuota(limit, time.Now(), 0)
+
+	r, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return unused, nil
+	}
+	if err != nil {
+		return unused, fmt.Errorf("%s error opening file: %v", filePath, err)
+	}
+	defer r.Close()
+
+	var sq serializedQuota
+	err = json.NewDecoder(r).Decode(&sq)
+	if err != nil {
+		return unused, fmt.Errorf("error reading %s: %v", filePath, err)
+	}
+
+	if sq.Version != quotaVersion {
+		return unused, fmt.Errorf("unknown version %v in serialized quota",
+			sq.Version)
+	}
+
+	return peer.NewUploadQuota(limit, time.Unix(sq.Month, 0), sq.Used), nil
+}
+
+// saveUploadQuota writes the use of the upload quota to the given file. The
+// file is written under another name first and then renamed, so that it is
+// never left half written.
+func saveUploadQuota(filePath string, quota *peer.UploadQuota) error {
+	usage := quota.Usage(time.Now())
+	sq := serializedQuota{
+		Version: quotaVersion,
+		Month:   usage.Month.Unix(),
+		Used:    usage.Used,
+	}
+
+	w, err := ioutil.TempFile(filepath.Dir(filePath), quotaFilename)
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %v", filePath, err)
+	}
+
+	err = json.NewEncoder(w).Encode(&sq)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(w.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(w.Name())
+		return fmt.Errorf("failed to write file %s: %v", filePath, err)
+	}
+	return nil
+}
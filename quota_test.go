@@ -0,0 +1,81 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/peer"
+)
+
+func TestUploadQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, quotaFilename)
+
+	// A missing file is an unused quota.
+	quota, err := loadUploadQuota(file, 1000)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if usage := quota.Usage(time.Now()); usage.Used != 0 || usage.Limit != 1000 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	// The use of the quota survives a restart, even with a new limit.
+	now := time.Now()
+	if err = saveUploadQuota(file, peer.NewUploadQuota(1000, now, 600)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	quota, err = loadUploadQuota(file, 2000)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if usage := quota.Usage(now); usage.Used != 600 || usage.Limit != 2000 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	// The use of the quota in a previous month is forgotten.
+	lastMonth := now.UTC().AddDate(0, 0, -now.UTC().Day())
+	if err = saveUploadQuota(file, peer.NewUploadQuota(1000, lastMonth, 600)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	quota, err = loadUploadQuota(file, 1000)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if usage := quota.Usage(now); usage.Used != 0 {
+		t.Errorf("expected unused quota, got %+v", usage)
+	}
+
+	// A corrupt file is an error.
+	if err = ioutil.WriteFile(file, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	quota, err = loadUploadQuota(file, 1000)
+	if err == nil {
+		t.Error("expected error loading corrupt file")
+	}
+	if quota == nil || quota.Usage(now).Used != 0 {
+		t.Error("expected unused quota after loading corrupt file")
+	}
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected 1 file in the data directory, got %d", len(files))
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// newCert creates a certificate from the template, signed by the parent
+// certificate and key, or self-signed if parent is nil. The certificate and a
+// new key are returned PEM encoded.
+func newCert(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template.SerialNumber, err = rand.Int(rand.Reader,
+		new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent,
+		&key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+// NewCA creates a certificate authority which can issue client certificates
+// with NewClientCert. The certificate and key are returned PEM encoded.
+func NewCA(org string, validUntil time.Time) ([]byte, []byte, error) {
+	return newCert(&x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{org},
+			CommonName:   org,
+		},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              validUntil,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+}
+
+// NewClientCert issues a certificate for an RPC client, signed by the given
+// PEM encoded certificate authority. The name is the common name of the
+// certificate's subject, which the server takes as the name of the user. The
+// certificate and key are returned PEM encoded.
+func NewClientCert(caCert, caKey []byte, name string, validUntil time.Time) ([]byte, []byte, error) {
+	ca, err := tls.X509KeyPair(caCert, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	parent, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	parentKey, ok := ca.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("certificate authority key is not an ECDSA key")
+	}
+
+	return newCert(&x509.Certificate{
+		Subject: pkix.Name{
+			Organization: parent.Subject.Organization,
+			CommonName:   name,
+		},
+		NotBefore:   time.Now().Add(-24 * time.Hour),
+		NotAfter:    validUntil,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, parent, parentKey)
+}
+
+// clientCAPool reads the PEM encoded certificate authorities in the file.
+func clientCAPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no certificates found in " + path)
+	}
+	return pool, nil
+}
+
+// clientName returns the common name of the subject of the client's
+// certificate, if the client has given one which has been verified.
+func clientName(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 ||
+		len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName, true
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestNewClientCert(t *testing.T) {
+	validUntil := time.Now().Add(time.Hour)
+	caCert, caKey, err := NewCA("bmd test CA", validUntil)
+	if err != nil {
+		t.Fatalf("NewCA: unexpected error %v", err)
+	}
+
+	cert, key, err := NewClientCert(caCert, caKey, "agent", validUntil)
+	if err != nil {
+		t.Fatalf("NewClientCert: unexpected error %v", err)
+	}
+	if _, err = tls.X509KeyPair(cert, key); err != nil {
+		t.Fatalf("client certificate does not match its key: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCert) {
+		t.Fatal("failed to add CA certificate to pool")
+	}
+
+	block, _ := pem.Decode(cert)
+	if block == nil {
+		t.Fatal("client certificate is not PEM encoded")
+	}
+	clientCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chains, err := clientCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatalf("client certificate not verified: %v", err)
+	}
+	if name := chains[0][0].Subject.CommonName; name != "agent" {
+		t.Errorf("expected common name agent, got %s", name)
+	}
+
+	// A certificate from another CA is not accepted.
+	otherCert, otherKey, err := NewCA("other CA", validUntil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err = NewClientCert(otherCert, otherKey, "agent", validUntil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ = pem.Decode(cert)
+	clientCert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = clientCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err == nil {
+		t.Error("certificate from another CA was verified")
+	}
+}
@@ -12,10 +12,12 @@ type Config struct {
 	DisableTLS bool
 	Key        string
 	Cert       string
+	ClientCA   string
 	User       string
 	Pass       string
 	LimitUser  string
 	LimitPass  string
+	Users      []*User
 	MaxClients uint32
 	Listeners  []string
 }
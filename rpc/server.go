@@ -9,8 +9,7 @@
 package rpc
 
 import (
-	"crypto/sha256"
-	"crypto/subtle"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -18,7 +17,9 @@ import (
 	prand "math/rand"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcutil"
@@ -60,10 +61,53 @@ type Server struct {
 	on bool
 	wg sync.WaitGroup
 
-	rpcSrv       *grpc.Server
-	listeners    []net.Listener
-	limitauthsha [sha256.Size]byte
-	authsha      [sha256.Size]byte
+	rpcSrv    *grpc.Server
+	listeners []net.Listener
+
+	// users are the users who may call methods of the server, by name.
+	users map[string]*User
+
+	// clients is the number of connected clients. It is a pointer because
+	// Server is copied by value by its users.
+	clients *int32
+}
+
+// clientListener wraps a net.Listener to keep track of the number of
+// connections which are open.
+type clientListener struct {
+	net.Listener
+	clients *int32
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *clientListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt32(l.clients, 1)
+	return &clientConn{Conn: conn, clients: l.clients}, nil
+}
+
+// clientConn is a connection accepted by a clientListener.
+type clientConn struct {
+	net.Conn
+	clients *int32
+	closed  int32
+}
+
+// Close closes the connection.
+func (c *clientConn) Close() error {
+	if atomic.AddInt32(&c.closed, 1) == 1 {
+		atomic.AddInt32(c.clients, -1)
+	}
+	return c.Conn.Close()
+}
+
+// Clients returns the number of clients which are currently connected.
+func (s *Server) Clients() int {
+	return int(atomic.LoadInt32(s.clients))
 }
 
 // GRPC returns the grpc server.
@@ -71,56 +115,66 @@ func (s *Server) GRPC() *grpc.Server {
 	return s.rpcSrv
 }
 
-// RestrictAuth restricts access of the client, returning an error if the client
-// is not already authenticated.
-func (s *Server) RestrictAuth(ctx context.Context) codes.Code {
-	md, ok := metadata.FromContext(ctx)
-	if !ok {
-		return codes.Unauthenticated
-	}
-	login, ok := md["authorization"]
-	if !ok {
-		return codes.Unauthenticated
+// authenticate returns the user the client is authenticated as, either by
+// the authorization header or, if there is none, by its client certificate.
+func (s *Server) authenticate(ctx context.Context) (*User, codes.Code) {
+	var login []string
+	if md, ok := metadata.FromContext(ctx); ok {
+		login = md["authorization"]
 	}
 
-	authsha := sha256.Sum256([]byte(login[0]))
+	if len(login) == 0 {
+		name, ok := clientName(ctx)
+		if !ok {
+			return nil, codes.Unauthenticated
+		}
 
-	// Check for limited auth first as in environments with limited users, those
-	// are probably expected to have a higher volume of calls
-	limitcmp := subtle.ConstantTimeCompare(authsha[:], s.limitauthsha[:])
-	if limitcmp == 1 {
-		return codes.OK
+		user, ok := s.users[name]
+		if !ok {
+			return nil, codes.PermissionDenied
+		}
+		return user, codes.OK
 	}
 
-	// Check for admin-level auth
-	cmp := subtle.ConstantTimeCompare(authsha[:], s.authsha[:])
-	if cmp == 1 {
-		return codes.OK
+	if !strings.HasPrefix(login[0], "Basic ") {
+		return nil, codes.Unauthenticated
+	}
+	decoded, err := base64.StdEncoding.DecodeString(login[0][len("Basic "):])
+	if err != nil {
+		return nil, codes.Unauthenticated
 	}
+	i := strings.IndexByte(string(decoded), ':')
+	if i < 0 {
+		return nil, codes.Unauthenticated
+	}
+	name, pass := string(decoded[:i]), string(decoded[i+1:])
 
-	return codes.PermissionDenied
+	// The password is checked even if there is no such user.
+	user := s.users[name]
+	if !user.checkPassword(pass) {
+		return nil, codes.PermissionDenied
+	}
+	return user, codes.OK
 }
 
-// RestrictAdmin restricts access of the client, returning an error if the
-// client is not already authenticated as an admin.
-func (s *Server) RestrictAdmin(ctx context.Context) codes.Code {
-	md, ok := metadata.FromContext(ctx)
-	if !ok {
-		return codes.Unauthenticated
+// Restrict restricts access of the client to the method, returning an error
+// code if the client is not authenticated as a user which may call it, or if
+// the user has been sending objects too quickly.
+func (s *Server) Restrict(ctx context.Context, method string) codes.Code {
+	user, code := s.authenticate(ctx)
+	if code != codes.OK {
+		return code
 	}
-	login, ok := md["authorization"]
-	if !ok {
-		return codes.Unauthenticated
+	if !user.Allowed(method) {
+		return codes.PermissionDenied
 	}
 
-	authsha := sha256.Sum256([]byte(login[0]))
-	// Check for admin-level auth
-	cmp := subtle.ConstantTimeCompare(authsha[:], s.authsha[:])
-	if cmp == 1 {
-		return codes.OK
+	if _, ok := sendMethods[method]; ok && user.limit != nil &&
+		!user.limit.allow(time.Now()) {
+		return codes.ResourceExhausted
 	}
 
-	return codes.PermissionDenied
+	return codes.OK
 }
 
 // NewRPCServer returns a new instance of the Server struct.
@@ -137,27 +191,57 @@ func NewRPCServer(cfg *Config) (*Server, error) {
 			}
 		}
 
-		creds, err := credentials.NewServerTLSFromFile(cfg.Cert, cfg.Key)
+		keyPair, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to generate credentials %v", err)
 		}
-		opts = []grpc.ServerOption{grpc.Creds(creds)}
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{keyPair}}
+
+		// Require clients to give a certificate signed by one of the
+		// client certificate authorities.
+		if cfg.ClientCA != "" {
+			tlsConfig.ClientCAs, err = clientCAPool(cfg.ClientCA)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read client CA %v", err)
+			}
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		opts = []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}
 	}
 
 	rpc := Server{
-		rpcSrv: grpc.NewServer(opts...), // Create the underlying RPC server.
+		rpcSrv:  grpc.NewServer(opts...), // Create the underlying RPC server.
+		users:   make(map[string]*User),
+		clients: new(int32),
 	}
 	//pb.RegisterBmdServer(rpc.rpcSrv, &rpc)
 
+	// The admin and limited users given by name and password have their
+	// passwords hashed like those of the other users.
+	users := cfg.Users
 	if cfg.User != "" && cfg.Pass != "" {
-		login := base64.StdEncoding.EncodeToString([]byte(cfg.User + ":" +
-			cfg.Pass))
-		rpc.authsha = sha256.Sum256([]byte("Basic " + login))
+		user, err := NewUser(cfg.User, cfg.Pass, []string{PermissionAdmin}, 0)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
 	}
 	if cfg.LimitUser != "" && cfg.LimitPass != "" {
-		login := base64.StdEncoding.EncodeToString([]byte(cfg.LimitUser + ":" +
-			cfg.LimitPass))
-		rpc.limitauthsha = sha256.Sum256([]byte("Basic " + login))
+		user, err := NewUser(cfg.LimitUser, cfg.LimitPass,
+			[]string{"read", "send"}, 0)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	for _, user := range users {
+		if _, ok := rpc.users[user.Name]; ok {
+			return nil, fmt.Errorf("RPC user %s is given more than once", user.Name)
+		}
+		rpc.users[user.Name] = user
+		rpcLog.Debugf("RPC user %s may call %v", user.Name, user.Permissions())
 	}
 
 	ipv4ListenAddrs, ipv6ListenAddrs, err := ParseListeners(cfg.Listeners)
@@ -188,6 +272,10 @@ func NewRPCServer(cfg *Config) (*Server, error) {
 		return nil, errors.New("RPC: No valid listen address")
 	}
 
+	for i, listener := range listeners {
+		listeners[i] = &clientListener{Listener: listener, clients: rpc.clients}
+	}
+
 	rpc.listeners = listeners
 	return &rpc, nil
 }
@@ -0,0 +1,337 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// PermissionAdmin allows a user to call every method.
+	PermissionAdmin = "admin"
+
+	// NoPassword is given in place of the hash of a password for users who
+	// can only authenticate with a client certificate.
+	NoPassword = "-"
+
+	// passwordCacheTTL is how long a password which was checked against the
+	// bcrypt hash of a user is accepted without checking it again.
+	passwordCacheTTL = 5 * time.Minute
+)
+
+var (
+	// dummyHash is the hash which passwords given for unknown users are
+	// checked against, so that checking them takes as long as for known
+	// users. It is created when it is first needed.
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// permissionGroups are the names which may be given as permissions in place
+// of the methods they allow.
+var permissionGroups = map[string][]string{
+	"send": {"SendObject", "ScheduleObject"},
+	"read": {"GetIdentity", "GetObjects", "GetObjectHeaders", "FetchObject",
+		"GetExpiredObjects", "Subscribe"},
+	"pow": {"DoPow"},
+	"peers": {"ListPeers", "AddPeer", "RemovePeer", "BanPeer", "UnbanPeer",
+		"ListBans", "DisconnectPeer"},
+}
+
+// sendMethods are the methods which may be rate limited for each user.
+var sendMethods = map[string]struct{}{
+	"SendObject":     {},
+	"ScheduleObject": {},
+}
+
+// isMethod returns whether the name is that of a method in some group.
+func isMethod(name string) bool {
+	for _, methods := range permissionGroups {
+		for _, method := range methods {
+			if method == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendLimit limits the rate at which a user may send objects. It allows up to
+// perMinute objects at once and then one every 1/perMinute minutes.
+type sendLimit struct {
+	sync.Mutex
+	perMinute uint32
+	allowance float64
+	last      time.Time
+}
+
+// allow returns whether another object may be sent at the given time.
+func (l *sendLimit) allow(now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	l.allowance += now.Sub(l.last).Minutes() * float64(l.perMinute)
+	if l.allowance > float64(l.perMinute) {
+		l.allowance = float64(l.perMinute)
+	}
+	l.last = now
+
+	if l.allowance < 1 {
+		return false
+	}
+	l.allowance--
+	return true
+}
+
+// passwordCache holds the digests of the passwords which were recently found
+// to be a user's, so that bcrypt, which is slow on purpose, is not run on
+// every call a client makes. Only correct passwords are added, so that it
+// stays small.
+type passwordCache struct {
+	sync.Mutex
+	verified map[[sha256.Size]byte]time.Time
+}
+
+// check returns whether the password was found to be correct within the last
+// passwordCacheTTL.
+func (c *passwordCache) check(digest [sha256.Size]byte, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	expires, ok := c.verified[digest]
+	return ok && now.Before(expires)
+}
+
+// add records that the password was found to be correct.
+func (c *passwordCache) add(digest [sha256.Size]byte, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.verified == nil {
+		c.verified = make(map[[sha256.Size]byte]time.Time)
+	}
+	for d, expires := range c.verified {
+		if !now.Before(expires) {
+			delete(c.verified, d)
+		}
+	}
+	c.verified[digest] = now.Add(passwordCacheTTL)
+}
+
+// User is a client of the RPC server, identified by a name and a password or
+// a client certificate whose subject has the name, who may call some set of
+// methods.
+type User struct {
+	Name string
+
+	// hash is the bcrypt hash of the password, or nil if the user has none,
+	// and verified are the passwords recently checked against it.
+	hash     []byte
+	verified passwordCache
+	admin    bool
+	methods  map[string]struct{}
+	limit    *sendLimit
+}
+
+// HashPassword returns the salted bcrypt hash of the password in the form
+// used for users in the configuration.
+func HashPassword(pass string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// NewUser creates a user with the given password and permissions. sendLimit
+// is the number of objects per minute that the user may send, or 0 for no
+// limit.
+func NewUser(name, pass string, permissions []string, sendLimit uint32) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &User{
+		Name: name,
+		hash: hash,
+	}
+	if err = u.setPermissions(permissions, sendLimit); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ParseUser parses a user from an entry of the form
+// name:hash:permissions[:sendlimit], where hash is the bcrypt hash of the
+// password, permissions is a comma separated list of method names and groups
+// of methods (admin, send, read, pow and peers), and sendlimit is the number
+// of objects per minute the user may send. If hash is given as -, the user has
+// no password and can only authenticate with a client certificate.
+func ParseUser(entry string) (*User, error) {
+	fields := strings.Split(entry, ":")
+	if len(fields) != 3 && len(fields) != 4 {
+		return nil, errors.New("user must be given as name:hash:permissions[:sendlimit]")
+	}
+
+	if fields[0] == "" {
+		return nil, errors.New("empty user name")
+	}
+	u := &User{Name: fields[0]}
+
+	var err error
+	if fields[1] != NoPassword {
+		if err = u.setHash(fields[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	var limit uint64
+	if len(fields) == 4 {
+		limit, err = strconv.ParseUint(fields[3], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: invalid send limit %s", u.Name, fields[3])
+		}
+	}
+
+	if err = u.setPermissions(strings.Split(fields[2], ","), uint32(limit)); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// setHash sets the password hash of the user from a bcrypt hash.
+func (u *User) setHash(hash string) error {
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return fmt.Errorf("user %s: invalid password hash: %v", u.Name, err)
+	}
+	u.hash = []byte(hash)
+	return nil
+}
+
+// setPermissions sets the methods the user may call and the rate at which it
+// may send objects.
+func (u *User) setPermissions(permissions []string, limit uint32) error {
+	u.methods = make(map[string]struct{})
+	for _, p := range permissions {
+		p = strings.TrimSpace(p)
+
+		if p == PermissionAdmin {
+			u.admin = true
+		} else if methods, ok := permissionGroups[p]; ok {
+			for _, method := range methods {
+				u.methods[method] = struct{}{}
+			}
+		} else if isMethod(p) {
+			u.methods[p] = struct{}{}
+		} else {
+			return fmt.Errorf("user %s: unknown permission %s", u.Name, p)
+		}
+	}
+
+	if !u.admin && len(u.methods) == 0 {
+		return fmt.Errorf("user %s: no permissions given", u.Name)
+	}
+
+	if limit > 0 {
+		u.limit = &sendLimit{
+			perMinute: limit,
+			allowance: float64(limit),
+			last:      time.Now(),
+		}
+	}
+	return nil
+}
+
+// checkPassword returns whether the password is the user's. If the user is
+// nil or has no password, the password is checked against a dummy hash all
+// the same, so that it cannot be told from the time taken whether the user
+// exists. A password which was found to be correct is accepted without
+// checking it again for passwordCacheTTL.
+func (u *User) checkPassword(pass string) bool {
+	if u == nil || u.hash == nil {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("-"),
+				bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+		return false
+	}
+
+	now := time.Now()
+	digest := sha256.Sum256([]byte(pass))
+	if u.verified.check(digest, now) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(pass)) != nil {
+		return false
+	}
+	u.verified.add(digest, now)
+	return true
+}
+
+// Allowed returns whether the user may call the method.
+func (u *User) Allowed(method string) bool {
+	if u.admin {
+		return true
+	}
+	_, ok := u.methods[method]
+	return ok
+}
+
+// Permissions returns the methods the user may call, or admin.
+func (u *User) Permissions() []string {
+	if u.admin {
+		return []string{PermissionAdmin}
+	}
+
+	methods := make([]string, 0, len(u.methods))
+	for method := range u.methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// ReadUsers reads users from a file with one entry of the form accepted by
+// ParseUser on each line. Empty lines and lines starting with # are ignored.
+func ReadUsers(path string) ([]*User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var users []*User
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		u, err := ParseUser(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		users = append(users, u)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
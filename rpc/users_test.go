@@ -0,0 +1,99 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func TestParseUser(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := ParseUser("agent:" + hash + ":read,SendObject:2")
+	if err != nil {
+		t.Fatalf("ParseUser: unexpected error %v", err)
+	}
+
+	if u.Name != "agent" {
+		t.Errorf("expected name agent, got %s", u.Name)
+	}
+	if !u.checkPassword("secret") || u.checkPassword("Secret") {
+		t.Error("password not checked correctly")
+	}
+
+	// The correct password is remembered for a while, and others are not.
+	now := time.Now()
+	if !u.verified.check(sha256.Sum256([]byte("secret")), now) {
+		t.Error("correct password not remembered")
+	}
+	if u.verified.check(sha256.Sum256([]byte("Secret")), now) {
+		t.Error("wrong password remembered")
+	}
+	if u.verified.check(sha256.Sum256([]byte("secret")), now.Add(passwordCacheTTL)) {
+		t.Error("password remembered for too long")
+	}
+	for _, method := range []string{"GetObjects", "Subscribe", "SendObject"} {
+		if !u.Allowed(method) {
+			t.Errorf("user should be allowed to call %s", method)
+		}
+	}
+	for _, method := range []string{"ListPeers", "Unknown"} {
+		if u.Allowed(method) {
+			t.Errorf("user should not be allowed to call %s", method)
+		}
+	}
+
+	// The user may send two objects at once and then one every 30 seconds.
+	now = time.Now()
+	if !u.limit.allow(now) || !u.limit.allow(now) || u.limit.allow(now) {
+		t.Error("send limit does not allow two objects at once")
+	}
+	if !u.limit.allow(now.Add(30*time.Second)) || u.limit.allow(now.Add(40*time.Second)) {
+		t.Error("send limit does not allow one object every 30 seconds")
+	}
+
+	admin, err := ParseUser("root:" + hash + ":admin")
+	if err != nil {
+		t.Fatalf("ParseUser: unexpected error %v", err)
+	}
+	if !admin.Allowed("ListPeers") || admin.limit != nil {
+		t.Error("admin should be allowed everything without limit")
+	}
+
+	// A user without a password can only authenticate with a certificate.
+	certOnly, err := ParseUser("remote:-:read")
+	if err != nil {
+		t.Fatalf("ParseUser: unexpected error %v", err)
+	}
+	if certOnly.checkPassword("") || certOnly.checkPassword("-") {
+		t.Error("user without a password should not accept any password")
+	}
+	var unknown *User
+	if unknown.checkPassword("") || unknown.checkPassword("-") {
+		t.Error("unknown user should not accept any password")
+	}
+
+	invalid := []string{
+		"",
+		"agent",
+		":" + hash + ":read",
+		"agent:secret:read",
+		"agent:" + hash[:10] + ":read",
+		"agent:" + hash + ":",
+		"agent:" + hash + ":write",
+		"agent:" + hash + ":send:-1",
+		"agent:" + hash + ":send:1:2",
+	}
+	for i, entry := range invalid {
+		if _, err := ParseUser(entry); err == nil {
+			t.Errorf("entry #%d: expected error for %s", i, entry)
+		}
+	}
+}
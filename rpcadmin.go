@@ -0,0 +1,178 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"time"
+
+	pb "github.com/DanielKrawisz/bmd/rpcproto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// ListPeers returns information about the peers which are currently connected.
+func (s *rpcServer) ListPeers(ctx context.Context, in *pb.ListPeersRequest) (*pb.ListPeersReply, error) {
+	if code := s.Restrict(ctx, "ListPeers"); code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+
+	peers := s.server.ConnectedPeers()
+	reply := &pb.ListPeersReply{
+		Peers: make([]*pb.PeerInfo, 0, len(peers)),
+	}
+	for _, p := range peers {
+		info := &pb.PeerInfo{
+			Address:          p.Addr().String(),
+			Inbound:          p.Inbound,
+			Persistent:       p.Persistent,
+			UserAgent:        p.UserAgent(),
+			Streams:          p.Streams(),
+			BytesSent:        p.BytesSent(),
+			BytesReceived:    p.BytesReceived(),
+			KnownInventory:   uint64(p.Inventory.NumKnown()),
+			RequestedObjects: p.Inventory.NumRequests(),
+			BanScore:         p.BanScore(),
+		}
+		latency := p.Latency()
+		info.Latency = uint64(latency.Average / time.Microsecond)
+		info.LastLatency = uint64(latency.Last / time.Microsecond)
+		info.MinLatency = uint64(latency.Min / time.Microsecond)
+		info.LatencySamples = latency.Samples
+		if t := p.LastSend(); !t.IsZero() {
+			info.LastSend = t.Unix()
+		}
+		if t := p.LastReceive(); !t.IsZero() {
+			info.LastReceive = t.Unix()
+		}
+		reply.Peers = append(reply.Peers, info)
+	}
+
+	return reply, nil
+}
+
+// AddPeer connects to a new peer, which is reconnected to if the connection is
+// lost if it is persistent.
+func (s *rpcServer) AddPeer(ctx context.Context, in *pb.AddPeerRequest) (*pb.AddPeerReply, error) {
+	if code := s.Restrict(ctx, "AddPeer"); code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+
+	if _, _, err := net.SplitHostPort(in.Address); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid address: %v", err)
+	}
+
+	stream, err := s.requestedStream(in.Stream)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.server.AddNewPeer(in.Address, stream, in.Persistent)
+	if err != nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "failed to add peer: %v", err)
+	}
+
+	return &pb.AddPeerReply{}, nil
+}
+
+// RemovePeer stops treating the given peer as persistent and disconnects it.
+func (s *rpcServer) RemovePeer(ctx context.Context, in *pb.RemovePeerRequest) (*pb.RemovePeerReply, error) {
+	if code := s.Restrict(ctx, "RemovePeer"); code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+
+	err := s.server.RemovePeer(in.Address)
+	if err == errPeerNotFound {
+		return nil, grpc.Errorf(codes.NotFound, "persistent peer not found")
+	} else if err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	return &pb.RemovePeerReply{}, nil
+}
+
+// BanPeer bans an IP address and disconnects any peers connected from it.
+func (s *rpcServer) BanPeer(ctx context.Context, in *pb.BanPeerRequest) (*pb.BanPeerReply, error) {
+	if code := s.Restrict(ctx, "BanPeer"); code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+
+	ip := net.ParseIP(in.Ip)
+	if ip == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid ip %s", in.Ip)
+	}
+	if in.Duration < 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "duration cannot be negative")
+	}
+
+	duration := time.Duration(in.Duration) * time.Second
+	if duration == 0 {
+		duration = cfg.BanDuration
+	}
+
+	if err := s.server.BanHost(ip.String(), duration); err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	return &pb.BanPeerReply{}, nil
+}
+
+// UnbanPeer lifts the ban on an IP address.
+func (s *rpcServer) UnbanPeer(ctx context.Context, in *pb.UnbanPeerRequest) (*pb.UnbanPeerReply, error) {
+	if code := s.Restrict(ctx, "UnbanPeer"); code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+
+	ip := net.ParseIP(in.Ip)
+	if ip == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid ip %s", in.Ip)
+	}
+
+	err := s.server.UnbanHost(ip.String())
+	if err == errNotBanned {
+		return nil, grpc.Errorf(codes.NotFound, "ip not banned")
+	} else if err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	return &pb.UnbanPeerReply{}, nil
+}
+
+// ListBans returns the IP addresses which are currently banned.
+func (s *rpcServer) ListBans(ctx context.Context, in *pb.ListBansRequest) (*pb.ListBansReply, error) {
+	if code := s.Restrict(ctx, "ListBans"); code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+
+	banned := s.server.BannedHosts()
+	reply := &pb.ListBansReply{
+		Bans: make([]*pb.Ban, 0, len(banned)),
+	}
+	for host, until := range banned {
+		reply.Bans = append(reply.Bans, &pb.Ban{
+			Ip:    host,
+			Until: until.Unix(),
+		})
+	}
+
+	return reply, nil
+}
+
+// DisconnectPeer disconnects from the given peer.
+func (s *rpcServer) DisconnectPeer(ctx context.Context, in *pb.DisconnectPeerRequest) (*pb.DisconnectPeerReply, error) {
+	if code := s.Restrict(ctx, "DisconnectPeer"); code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+
+	err := s.server.DisconnectPeerByAddr(in.Address)
+	if err == errPeerNotFound {
+		return nil, grpc.Errorf(codes.NotFound, "peer not found")
+	} else if err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	return &pb.DisconnectPeerReply{}, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	pb "github.com/DanielKrawisz/bmd/rpcproto"
+	"github.com/DanielKrawisz/bmutil/hash"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+// objectFilter decides which objects are sent to a client of Subscribe. An
+// empty set of tags, ripes or versions lets every object through.
+type objectFilter struct {
+	pubkeyTags     map[hash.Sha]struct{}
+	broadcastTags  map[hash.Sha]struct{}
+	getpubkeyRipes map[hash.Ripe]struct{}
+	getpubkeyTags  map[hash.Sha]struct{}
+	versions       map[uint64]struct{}
+	minTTL         time.Duration
+}
+
+// tagSet makes a set of tags from a list given over RPC.
+func tagSet(name string, tags [][]byte) (map[hash.Sha]struct{}, error) {
+	set := make(map[hash.Sha]struct{}, len(tags))
+	for _, b := range tags {
+		tag, err := hash.NewSha(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		set[*tag] = struct{}{}
+	}
+	return set, nil
+}
+
+// newObjectFilter creates an objectFilter from a subscription request.
+func newObjectFilter(in *pb.SubscribeRequest) (*objectFilter, error) {
+	var err error
+	f := &objectFilter{
+		getpubkeyRipes: make(map[hash.Ripe]struct{}, len(in.GetpubkeyRipes)),
+		versions:       make(map[uint64]struct{}, len(in.Versions)),
+		minTTL:         time.Duration(in.MinTtl) * time.Second,
+	}
+
+	if f.pubkeyTags, err = tagSet("pubkey tag", in.PubkeyTags); err != nil {
+		return nil, err
+	}
+	if f.broadcastTags, err = tagSet("broadcast tag", in.BroadcastTags); err != nil {
+		return nil, err
+	}
+	if f.getpubkeyTags, err = tagSet("getpubkey tag", in.GetpubkeyTags); err != nil {
+		return nil, err
+	}
+
+	for _, b := range in.GetpubkeyRipes {
+		ripe, err := hash.NewRipe(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid getpubkey ripe: %v", err)
+		}
+		f.getpubkeyRipes[*ripe] = struct{}{}
+	}
+
+	for _, version := range in.Versions {
+		f.versions[version] = struct{}{}
+	}
+
+	return f, nil
+}
+
+// hasTag returns whether the payload begins with one of the tags in the set.
+func hasTag(set map[hash.Sha]struct{}, payload []byte) bool {
+	if len(payload) < hash.ShaSize {
+		return false
+	}
+
+	var tag hash.Sha
+	copy(tag[:], payload)
+	_, ok := set[tag]
+	return ok
+}
+
+// match returns whether the object passes the filter at the given time.
+func (f *objectFilter) match(o obj.Object, now time.Time) bool {
+	header := o.Header()
+
+	if len(f.versions) > 0 {
+		if _, ok := f.versions[uint64(header.Version)]; !ok {
+			return false
+		}
+	}
+
+	if header.Expiration().Sub(now) < f.minTTL {
+		return false
+	}
+
+	switch header.ObjectType {
+	case wire.ObjectTypeGetPubKey:
+		if len(f.getpubkeyRipes) == 0 && len(f.getpubkeyTags) == 0 {
+			return true
+		}
+
+		data, headerSize, err := objectPayload(o)
+		if err != nil {
+			return false
+		}
+		payload := data[headerSize:]
+
+		// Getpubkeys request a tag from version 4 and a ripe before.
+		if header.Version >= obj.EncryptedPubKeyVersion {
+			return hasTag(f.getpubkeyTags, payload)
+		}
+		if len(payload) < hash.RipeSize {
+			return false
+		}
+		var ripe hash.Ripe
+		copy(ripe[:], payload)
+		_, ok := f.getpubkeyRipes[ripe]
+		return ok
+
+	case wire.ObjectTypePubKey:
+		if len(f.pubkeyTags) == 0 {
+			return true
+		}
+		if header.Version < obj.EncryptedPubKeyVersion {
+			return false
+		}
+
+		data, headerSize, err := objectPayload(o)
+		if err != nil {
+			return false
+		}
+		return hasTag(f.pubkeyTags, data[headerSize:])
+
+	case wire.ObjectTypeBroadcast:
+		if len(f.broadcastTags) == 0 {
+			return true
+		}
+		if header.Version < obj.TaggedBroadcastVersion {
+			return false
+		}
+
+		data, headerSize, err := objectPayload(o)
+		if err != nil {
+			return false
+		}
+		return hasTag(f.broadcastTags, data[headerSize:])
+	}
+
+	return true
+}
+
+// objectPayload returns the encoding of an object along with the size of its
+// header, after which the payload begins.
+func objectPayload(o obj.Object) ([]byte, int, error) {
+	data := wire.Encode(o)
+
+	r := bytes.NewReader(data)
+	if _, err := wire.DecodeObjectHeader(r); err != nil {
+		return nil, 0, err
+	}
+	return data, len(data) - r.Len(), nil
+}
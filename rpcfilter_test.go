@@ -0,0 +1,105 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/DanielKrawisz/bmd/rpcproto"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+)
+
+func TestObjectFilter(t *testing.T) {
+	// Version 4 getpubkeys carry a tag derived from the address.
+	data, headerSize, err := objectPayload(testObj[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	getpubkeyTag := data[headerSize:]
+
+	getpubkeyV3 := obj.NewGetPubKey(654, expires,
+		NewAddress(3, 1, &ripehash[1], nil)).MsgObject()
+
+	tests := []struct {
+		request *pb.SubscribeRequest
+		match   []obj.Object
+		reject  []obj.Object
+	}{
+		{
+			// No filters.
+			&pb.SubscribeRequest{},
+			testObj,
+			nil,
+		},
+		{
+			&pb.SubscribeRequest{PubkeyTags: [][]byte{shahash[1][:]}},
+			[]obj.Object{testObj[0], testObj[3], testObj[4], testObj[6]},
+			[]obj.Object{testObj[2]},
+		},
+		{
+			&pb.SubscribeRequest{BroadcastTags: [][]byte{shahash[0][:]}},
+			[]obj.Object{testObj[2], testObj[6]},
+			[]obj.Object{testObj[7]},
+		},
+		{
+			&pb.SubscribeRequest{GetpubkeyTags: [][]byte{getpubkeyTag}},
+			[]obj.Object{testObj[1], testObj[7]},
+			[]obj.Object{testObj[0], getpubkeyV3},
+		},
+		{
+			&pb.SubscribeRequest{GetpubkeyRipes: [][]byte{ripehash[1][:]}},
+			[]obj.Object{getpubkeyV3},
+			[]obj.Object{testObj[0], testObj[1]},
+		},
+		{
+			&pb.SubscribeRequest{Versions: []uint64{3}},
+			[]obj.Object{getpubkeyV3},
+			[]obj.Object{testObj[0], testObj[2]},
+		},
+		{
+			&pb.SubscribeRequest{MinTtl: 60},
+			testObj,
+			nil,
+		},
+		{
+			&pb.SubscribeRequest{MinTtl: 3600},
+			nil,
+			testObj,
+		},
+	}
+
+	now := time.Now()
+	for i, test := range tests {
+		f, err := newObjectFilter(test.request)
+		if err != nil {
+			t.Fatalf("test #%d: unexpected error %v", i, err)
+		}
+
+		for j, o := range test.match {
+			if !f.match(o, now) {
+				t.Errorf("test #%d: object #%d should have matched", i, j)
+			}
+		}
+		for j, o := range test.reject {
+			if f.match(o, now) {
+				t.Errorf("test #%d: object #%d should not have matched", i, j)
+			}
+		}
+	}
+
+	// Tags and ripes of the wrong size are rejected.
+	invalid := []*pb.SubscribeRequest{
+		&pb.SubscribeRequest{PubkeyTags: [][]byte{{1, 2, 3}}},
+		&pb.SubscribeRequest{BroadcastTags: [][]byte{shahash[0][:5]}},
+		&pb.SubscribeRequest{GetpubkeyTags: [][]byte{ripehash[0][:]}},
+		&pb.SubscribeRequest{GetpubkeyRipes: [][]byte{shahash[0][:]}},
+	}
+	for i, request := range invalid {
+		if _, err := newObjectFilter(request); err == nil {
+			t.Errorf("invalid request #%d: expected error", i)
+		}
+	}
+}
@@ -6,13 +6,16 @@
 package main
 
 import (
+	"encoding/binary"
 	"sync"
 	"time"
 
 	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmd/objmgr"
 	"github.com/DanielKrawisz/bmd/rpc"
 	pb "github.com/DanielKrawisz/bmd/rpcproto"
 	"github.com/DanielKrawisz/bmutil"
+	"github.com/DanielKrawisz/bmutil/hash"
 	"github.com/DanielKrawisz/bmutil/pow"
 	"github.com/DanielKrawisz/bmutil/wire"
 	"github.com/DanielKrawisz/bmutil/wire/obj"
@@ -26,21 +29,64 @@ const (
 	// will fetch per query to the database. This is used when a client requests
 	// subscription to an object type from a specified counter value.
 	rpcCounterObjectsSize = 100
+
+	// rpcCiphertextPrefixSize is the number of bytes of the encrypted payload
+	// of msgs and broadcasts sent by GetObjectHeaders. This covers the IV
+	// (16 bytes) and the ephemeral public key (2 bytes curve type, then
+	// 2 bytes length and 32 bytes for each of X and Y).
+	rpcCiphertextPrefixSize = 86
+
+	// rpcExpiredBufferSize is the number of expired objects which may wait
+	// to be sent to a client of GetExpiredObjects. A client which falls
+	// further behind than this has its stream closed.
+	rpcExpiredBufferSize = 1000
+
+	// rpcPowProgressInterval is how often the progress of the proof of work
+	// for an object is sent to a client of DoPow.
+	rpcPowProgressInterval = time.Second
 )
 
 type rpcServer struct {
 	rpc.Server
 	server *server
 
-	// Conds for notifying listening clients about pending objects. Key is the
+	// Notifiers for waking up streams waiting for new objects. Key is the
 	// string representation of the object type.
-	objConds map[string]*sync.Cond
+	objNotifiers map[string]*objectNotifier
+
+	// anyObject is notified along with the notifier for the type of every
+	// new object. It is used by streams of several object types at once.
+	anyObject *objectNotifier
+
+	// streamBuffer is the number of objects buffered for each stream and
+	// dropLaggards is whether to drop the buffered objects of a client
+	// which falls behind rather than to close its stream. sendTimeout is
+	// how long a single object may take to send before the stream is
+	// closed either way.
+	streamBuffer int
+	dropLaggards bool
+	lagTimeout   time.Duration
+	sendTimeout  time.Duration
+
+	// streams are the open streams of objects, by ID, and streamTotals
+	// counts the objects of all streams, open or closed.
+	streamsMtx   sync.Mutex
+	streams      map[uint64]*objectStream
+	nextStream   uint64
+	streamTotals streamTotals
+
+	// powQueue does the proof of work for clients of DoPow. It is nil if
+	// DoPow is disabled. localPow is the proof of work required of objects
+	// sent over RPC, which is done if a client gives no target.
+	powQueue *powQueue
+	localPow pow.Data
 }
 
 // NotifyObject is used to notify the RPC server of any new objects so that it
 // can send those onwards to the client.
 func (s *rpcServer) NotifyObject(objType wire.ObjectType) {
-	s.objConds[objType.String()].Broadcast()
+	s.objNotifiers[objType.String()].notify()
+	s.anyObject.notify()
 }
 
 // SendObject inserts the object into bmd's database and sends it out to the
@@ -48,7 +94,38 @@ func (s *rpcServer) NotifyObject(objType wire.ObjectType) {
 func (s *rpcServer) SendObject(ctx context.Context, in *pb.Object) (*pb.SendObjectReply, error) {
 	rpcLog.Trace("SendObject: object received to be sent out into the network.")
 
-	if code := s.RestrictAuth(ctx); code != codes.OK {
+	if code := s.Restrict(ctx, "SendObject"); code == codes.ResourceExhausted {
+		return nil, grpc.Errorf(code, "sending objects too quickly")
+	} else if code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+	if len(in.Contents) == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "contents must not be empty")
+	}
+
+	objMsg, err := wire.DecodeMsgObject(in.Contents)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "error decoding object: %v",
+			err)
+	}
+
+	counter, err := s.sendObject(objMsg, time.Time{}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SendObjectReply{
+		Counter: counter,
+	}, nil
+}
+
+// ScheduleObject puts the object into bmd's outbox, from which it is inserted
+// into the database and sent out to the Bitmessage network at the requested
+// time once enough peers are connected.
+func (s *rpcServer) ScheduleObject(ctx context.Context, in *pb.ScheduleObjectRequest) (*pb.SendObjectReply, error) {
+	if code := s.Restrict(ctx, "ScheduleObject"); code == codes.ResourceExhausted {
+		return nil, grpc.Errorf(code, "sending objects too quickly")
+	} else if code != codes.OK {
 		return nil, grpc.Errorf(code, "auth failure")
 	}
 	if len(in.Contents) == 0 {
@@ -61,47 +138,174 @@ func (s *rpcServer) SendObject(ctx context.Context, in *pb.Object) (*pb.SendObje
 			err)
 	}
 
+	var sendAt time.Time
+	if in.SendAt != 0 {
+		sendAt = time.Unix(in.SendAt, 0)
+		if !sendAt.Before(objMsg.Header().Expiration()) {
+			return nil, grpc.Errorf(codes.InvalidArgument,
+				"object expires before it is to be sent")
+		}
+	}
+
+	counter, err := s.sendObject(objMsg, sendAt, int(in.MinPeers))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SendObjectReply{
+		Counter: counter,
+	}, nil
+}
+
+// sendObject puts an object submitted by a client into bmd's outbox, from
+// which it is inserted into the database and sent out to the Bitmessage
+// network once the time is sendAt and at least minPeers peers are connected.
+// It returns the counter value of the inserted object, or 0 if it is waiting.
+func (s *rpcServer) sendObject(objMsg *wire.MsgObject, sendAt time.Time, minPeers int) (uint64, error) {
 	// Check if object is already in database.
 	exists, err := s.server.db.ExistsObject(obj.InventoryHash(objMsg))
 	if err != nil {
 		rpcLog.Errorf("ExistsObject, database error: %v", err)
-		return nil, grpc.Errorf(codes.Internal, "database error")
+		return 0, grpc.Errorf(codes.Internal, "database error")
 	}
 	if exists {
-		return nil, grpc.Errorf(codes.AlreadyExists, "object already in database")
+		return 0, grpc.Errorf(codes.AlreadyExists, "object already in database")
 	}
 
-	// Check whether the object is valid.
-	if time.Now().After(objMsg.Header().Expiration()) { // already expired
-		return nil, grpc.Errorf(codes.InvalidArgument, "object already expired")
-	}
-	if objMsg.Header().StreamNumber != 1 { // TODO improve
-		return nil, grpc.Errorf(codes.InvalidArgument, "invalid stream")
-	}
-
-	// Check whether the PoW is valid.
-	if !objMsg.CheckPow(pow.Default, time.Now()) {
-		return nil, grpc.Errorf(codes.InvalidArgument, "invalid proof of work")
+	// Check whether the object is acceptable.
+	if err = s.server.objectManager.Accept(objMsg, objmgr.SourceLocal); err != nil {
+		return 0, grpc.Errorf(codes.InvalidArgument, "%v", err)
 	}
 
 	rpcLog.Trace("SendObject: Object will be sent out into the network.")
 
 	// Relay object to object manager which will handle insertion and
 	// advertisement.
-	counter := s.server.objectManager.HandleInsert(objMsg)
-	if counter == 0 {
-		return nil, grpc.Errorf(codes.Internal, "failed to insert and advertise object")
+	counter, err := s.server.objectManager.Send(objMsg, sendAt, minPeers)
+	if err == objmgr.ErrInOutbox {
+		return 0, grpc.Errorf(codes.AlreadyExists, "%v", err)
+	} else if err != nil {
+		rpcLog.Errorf("Send: %v", err)
+		return 0, grpc.Errorf(codes.Internal, "failed to insert and advertise object")
 	}
 
-	return &pb.SendObjectReply{
-		Counter: counter,
-	}, nil
+	return counter, nil
+}
+
+// DoPow does the proof of work for an object on behalf of the client and
+// streams its progress until it is done. The object is then sent to the client
+// with its nonce and, if the client asks, sent out to the Bitmessage network.
+func (s *rpcServer) DoPow(in *pb.DoPowRequest, stream pb.Bmd_DoPowServer) error {
+	ctx := stream.Context()
+	if code := s.Restrict(ctx, "DoPow"); code != codes.OK {
+		return grpc.Errorf(code, "auth failure")
+	}
+	// Sending the object counts against the client's limit from the start
+	// so that no proof of work is done for an object which can't be sent.
+	if in.Send {
+		if code := s.Restrict(ctx, "SendObject"); code == codes.ResourceExhausted {
+			return grpc.Errorf(code, "sending objects too quickly")
+		} else if code != codes.OK {
+			return grpc.Errorf(code, "auth failure")
+		}
+	}
+	if s.powQueue == nil {
+		return grpc.Errorf(codes.Unavailable, "proof of work service disabled")
+	}
+
+	objMsg, err := wire.DecodeMsgObject(in.Contents)
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "error decoding object: %v",
+			err)
+	}
+
+	// The proof of work covers everything but the nonce, which is the first
+	// 8 bytes of the object.
+	contents := wire.Encode(objMsg)
+	target := in.Target
+	if target == 0 {
+		ttl := objMsg.Header().Expiration().Sub(time.Now())
+		if ttl <= 0 {
+			return grpc.Errorf(codes.InvalidArgument, "object has expired")
+		}
+		target = pow.CalculateTarget(uint64(len(contents)-8),
+			uint64(ttl.Seconds()), s.localPow)
+		if target == 0 {
+			return grpc.Errorf(codes.InvalidArgument, "proof of work too difficult")
+		}
+	}
+	expected := ^uint64(0) / target
+
+	job, err := s.powQueue.Submit(target, hash.Sha512(contents[8:]))
+	if err == errPowQueueFull {
+		return grpc.Errorf(codes.ResourceExhausted, "%v", err)
+	} else if err != nil {
+		return grpc.Errorf(codes.Unavailable, "%v", err)
+	}
+	defer job.Cancel()
+
+	ticker := time.NewTicker(rpcPowProgressInterval)
+	defer ticker.Stop()
+
+	for done := false; !done; {
+		position, trials := job.Status()
+		state := pb.PowState_RUNNING
+		if position > 0 {
+			state = pb.PowState_QUEUED
+		}
+		err := stream.Send(&pb.PowProgress{
+			State:          state,
+			QueuePosition:  uint32(position),
+			Trials:         trials,
+			ExpectedTrials: expected,
+		})
+		if err != nil {
+			return grpc.Errorf(codes.DataLoss, "failed to send progress: %v", err)
+		}
+
+		select {
+		case <-job.Done():
+			done = true
+		case <-ticker.C:
+		case <-ctx.Done():
+			return grpc.Errorf(codes.Canceled, "proof of work cancelled")
+		}
+	}
+
+	nonce, err := job.Result()
+	if err != nil {
+		return grpc.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	binary.BigEndian.PutUint64(contents, nonce)
+	_, trials := job.Status()
+	reply := &pb.PowProgress{
+		State:          pb.PowState_DONE,
+		Trials:         trials,
+		ExpectedTrials: expected,
+		Contents:       contents,
+	}
+
+	if in.Send {
+		objMsg, err = wire.DecodeMsgObject(contents)
+		if err != nil {
+			return grpc.Errorf(codes.Internal, "error decoding object: %v", err)
+		}
+		if reply.Counter, err = s.sendObject(objMsg, time.Time{}, 0); err != nil {
+			return err
+		}
+	}
+
+	if err = stream.Send(reply); err != nil {
+		return grpc.Errorf(codes.DataLoss, "failed to send object: %v", err)
+	}
+	return nil
 }
 
 // GetIdentity returns the stored public key associated with the given
 // Bitmessage address.
 func (s *rpcServer) GetIdentity(ctx context.Context, in *pb.GetIdentityRequest) (*pb.GetIdentityReply, error) {
-	if code := s.RestrictAuth(ctx); code != codes.OK {
+	if code := s.Restrict(ctx, "GetIdentity"); code != codes.OK {
 		return nil, grpc.Errorf(code, "auth failure")
 	}
 
@@ -132,8 +336,106 @@ func (s *rpcServer) GetIdentity(ctx context.Context, in *pb.GetIdentityRequest)
 // GetObjects retrieves objects of a particular type starting from a particular
 // counter value from the database and streams them to the client.
 func (s *rpcServer) GetObjects(in *pb.GetObjectsRequest, stream pb.Bmd_GetObjectsServer) error {
-	ctx := stream.Context()
-	if code := s.RestrictAuth(ctx); code != codes.OK {
+	return s.streamObjects(stream.Context(), "GetObjects", in, func(object *database.ObjectWithCounter) error {
+		return stream.Send(&pb.Object{
+			Contents: wire.Encode(object.Object),
+			Counter:  object.Counter,
+		})
+	})
+}
+
+// GetObjectHeaders works like GetObjects but only streams object headers to
+// the client, along with the tag or the start of the ciphertext.
+func (s *rpcServer) GetObjectHeaders(in *pb.GetObjectsRequest, stream pb.Bmd_GetObjectHeadersServer) error {
+	return s.streamObjects(stream.Context(), "GetObjectHeaders", in, func(object *database.ObjectWithCounter) error {
+		header, err := objectHeader(object)
+		if err != nil {
+			rpcLog.Errorf("GetObjectHeaders, failed to decode object header: %v", err)
+			return nil // Skip it.
+		}
+		return stream.Send(header)
+	})
+}
+
+// FetchObject returns the object with the given inventory hash.
+func (s *rpcServer) FetchObject(ctx context.Context, in *pb.FetchObjectRequest) (*pb.Object, error) {
+	if code := s.Restrict(ctx, "FetchObject"); code != codes.OK {
+		return nil, grpc.Errorf(code, "auth failure")
+	}
+
+	invHash, err := hash.NewSha(in.Hash)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid hash: %v", err)
+	}
+
+	object, err := s.server.db.FetchObjectByHash(invHash)
+	if err == database.ErrNonexistentObject {
+		return nil, grpc.Errorf(codes.NotFound, "object not found")
+	} else if err != nil {
+		rpcLog.Errorf("FetchObjectByHash, database error: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "database error")
+	}
+
+	return &pb.Object{
+		Contents: wire.Encode(object),
+	}, nil
+}
+
+// GetExpiredObjects streams the objects which are removed from the database
+// as they expire.
+func (s *rpcServer) GetExpiredObjects(in *pb.GetExpiredObjectsRequest, stream pb.Bmd_GetExpiredObjectsServer) error {
+	if code := s.Restrict(stream.Context(), "GetExpiredObjects"); code != codes.OK {
+		return grpc.Errorf(code, "auth failure")
+	}
+
+	// The scheduler must not be held up by a slow client, so expired
+	// objects are buffered and the stream is closed if the buffer fills up.
+	expired := make(chan *database.ExpiredObject, rpcExpiredBufferSize)
+	overflow := make(chan struct{})
+	var once sync.Once
+	cancel := s.server.expiry.Subscribe(func(ex *database.ExpiredObject) {
+		select {
+		case expired <- ex:
+		default:
+			once.Do(func() { close(overflow) })
+		}
+	})
+	defer cancel()
+
+	for {
+		select {
+		case ex := <-expired:
+			objType := pb.ObjectType(ex.ObjectType)
+			if ex.ObjectType > wire.HighestKnownObjectType {
+				objType = pb.ObjectType_UNKNOWN
+			}
+
+			err := stream.Send(&pb.ExpiredObject{
+				Hash:       ex.Hash[:],
+				ObjectType: objType,
+				Stream:     ex.Stream,
+				Expiration: ex.Expiration.Unix(),
+			})
+			if err != nil {
+				return grpc.Errorf(codes.DataLoss, "failed to send expired object: %v", err)
+			}
+
+		case <-overflow:
+			return grpc.Errorf(codes.ResourceExhausted, "too many expired objects waiting to be sent")
+
+		case <-stream.Context().Done():
+			return grpc.Errorf(codes.Canceled, "stream closed")
+		}
+	}
+}
+
+// streamObjects retrieves objects of a particular type and stream starting
+// from a particular counter value from the database and passes them to send.
+// When there are no more objects, it waits for new ones to arrive. method is
+// the name of the method the client called.
+func (s *rpcServer) streamObjects(ctx context.Context, method string, in *pb.GetObjectsRequest,
+	send func(*database.ObjectWithCounter) error) error {
+	if code := s.Restrict(ctx, method); code != codes.OK {
 		return grpc.Errorf(code, "auth failure")
 	}
 
@@ -141,44 +443,129 @@ func (s *rpcServer) GetObjects(in *pb.GetObjectsRequest, stream pb.Bmd_GetObject
 		return grpc.Errorf(codes.InvalidArgument, "from_counter cannot be 0")
 	}
 
-	// fromCounter is updated after each iteration of the loop and set to the
-	// value of the last element+1.
-	fromCounter := in.FromCounter
-	objType := wire.ObjectType(in.ObjectType)
-	cond := s.objConds[objType.String()]
+	stream, err := s.requestedStream(in.Stream)
+	if err != nil {
+		return err
+	}
 
-	for {
-		objs, lastCount, err := s.server.db.FetchObjectsFromCounter(objType,
-			fromCounter, rpcCounterObjectsSize)
+	key := streamCounter{wire.ObjectType(in.ObjectType), stream}
+	st := s.newObjectStream(method, []streamCounter{key},
+		map[streamCounter]uint64{key: in.FromCounter}, nil)
+	return s.runStream(ctx, st, send)
+}
+
+// requestedStream returns the stream requested by a client, which is the first
+// stream bmd participates in if it is 0.
+func (s *rpcServer) requestedStream(stream uint32) (uint32, error) {
+	if stream == 0 {
+		return s.server.Streams()[0], nil
+	}
+	if !database.InStreams(uint64(stream), s.server.Streams()) {
+		return 0, grpc.Errorf(codes.InvalidArgument, "invalid stream %d", stream)
+	}
+	return stream, nil
+}
+
+// Subscribe streams the objects of the requested types and streams which pass
+// the filters in the request, starting from a counter value for each type in
+// each stream. When there are no more objects, it waits for new ones to
+// arrive.
+func (s *rpcServer) Subscribe(in *pb.SubscribeRequest, stream pb.Bmd_SubscribeServer) error {
+	if code := s.Restrict(stream.Context(), "Subscribe"); code != codes.OK {
+		return grpc.Errorf(code, "auth failure")
+	}
+
+	if len(in.Counters) == 0 {
+		return grpc.Errorf(codes.InvalidArgument, "no object types requested")
+	}
+
+	// fromCounters holds the counter from which to fetch the next objects
+	// of each requested type in each requested stream.
+	keys := make([]streamCounter, 0, len(in.Counters))
+	fromCounters := make(map[streamCounter]uint64, len(in.Counters))
+	for _, c := range in.Counters {
+		objType := wire.ObjectType(c.ObjectType)
+		if objType > wire.HighestKnownObjectType {
+			return grpc.Errorf(codes.InvalidArgument, "unknown object type %d", c.ObjectType)
+		}
+		stream, err := s.requestedStream(c.Stream)
 		if err != nil {
-			rpcLog.Errorf("FetchObjectsFromCounter, database error: %v", err)
-			return grpc.Errorf(codes.Internal, "database error")
+			return err
 		}
-
-		// We ran out of more objects to send to the client, so wait until we have
-		// more.
-		if len(objs) == 0 {
-			cond.L.Lock()
-			cond.Wait()
-			cond.L.Unlock()
-			continue
+		key := streamCounter{objType, stream}
+		if _, ok := fromCounters[key]; ok {
+			return grpc.Errorf(codes.InvalidArgument,
+				"object type %s requested twice in stream %d", objType, stream)
+		}
+		if c.FromCounter == 0 {
+			return grpc.Errorf(codes.InvalidArgument, "from_counter cannot be 0")
 		}
+		keys = append(keys, key)
+		fromCounters[key] = c.FromCounter
+	}
 
-		// For next iteration.
-		fromCounter = lastCount + 1
+	filter, err := newObjectFilter(in)
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
 
-		// Send objects to client.
-		for _, object := range objs {
-			out := &pb.Object{
-				Contents: wire.Encode(object.Object),
-				Counter:  object.Counter,
-			}
-			err = stream.Send(out)
-			if err != nil {
-				return grpc.Errorf(codes.DataLoss, "failed to send object: %v", err)
-			}
+	st := s.newObjectStream("Subscribe", keys, fromCounters,
+		func(object *database.ObjectWithCounter) bool {
+			return filter.match(object.Object, time.Now())
+		})
+	return s.runStream(stream.Context(), st, func(object *database.ObjectWithCounter) error {
+		return stream.Send(&pb.Object{
+			Contents: wire.Encode(object.Object),
+			Counter:  object.Counter,
+		})
+	})
+}
+
+// objectHeader constructs the header sent by GetObjectHeaders for the given
+// object.
+func objectHeader(object *database.ObjectWithCounter) (*pb.ObjectHeader, error) {
+	data, headerSize, err := objectPayload(object.Object)
+	if err != nil {
+		return nil, err
+	}
+	payload := data[headerSize:]
+
+	out := &pb.ObjectHeader{
+		Hash:    obj.InventoryHash(object.Object)[:],
+		Counter: object.Counter,
+		Header:  data[:headerSize],
+		Size:    uint64(len(data)),
+	}
+
+	// tag splits a tag off the front of the payload.
+	tag := func() {
+		if len(payload) < hash.ShaSize {
+			return
+		}
+		out.Tag = payload[:hash.ShaSize]
+		payload = payload[hash.ShaSize:]
+	}
+
+	header := object.Object.Header()
+	switch header.ObjectType {
+	case wire.ObjectTypeGetPubKey, wire.ObjectTypePubKey:
+		// Tags were introduced with version 4 of both getpubkeys and pubkeys.
+		if header.Version >= obj.EncryptedPubKeyVersion {
+			tag()
 		}
+	case wire.ObjectTypeBroadcast:
+		if header.Version >= obj.TaggedBroadcastVersion {
+			tag()
+		}
+		fallthrough
+	case wire.ObjectTypeMsg:
+		if len(payload) > rpcCiphertextPrefixSize {
+			payload = payload[:rpcCiphertextPrefixSize]
+		}
+		out.CiphertextPrefix = payload
 	}
+
+	return out, nil
 }
 
 // newRPCServer returns a new instance of the Server struct.
@@ -192,16 +579,43 @@ func newRPCServer(s *server, rpcCfg *rpc.Config) (*rpcServer, error) {
 	rpcServer := &rpcServer{
 		Server: *rpc,
 		server: s,
-		objConds: map[string]*sync.Cond{
-			wire.ObjectTypeGetPubKey.String(): sync.NewCond(&sync.Mutex{}),
-			wire.ObjectTypePubKey.String():    sync.NewCond(&sync.Mutex{}),
-			wire.ObjectTypeMsg.String():       sync.NewCond(&sync.Mutex{}),
-			wire.ObjectTypeBroadcast.String(): sync.NewCond(&sync.Mutex{}),
-			wire.ObjectType(999).String():     sync.NewCond(&sync.Mutex{}), // Unknown
+		objNotifiers: map[string]*objectNotifier{
+			wire.ObjectTypeGetPubKey.String(): &objectNotifier{},
+			wire.ObjectTypePubKey.String():    &objectNotifier{},
+			wire.ObjectTypeMsg.String():       &objectNotifier{},
+			wire.ObjectTypeBroadcast.String(): &objectNotifier{},
+			wire.ObjectType(999).String():     &objectNotifier{}, // Unknown
 		},
+		anyObject:    &objectNotifier{},
+		streamBuffer: cfg.RPCStreamBuffer,
+		dropLaggards: cfg.RPCLaggards == laggardsDrop,
+		lagTimeout:   rpcStreamLagTimeout,
+		sendTimeout:  rpcStreamSendTimeout,
+		streams:      make(map[uint64]*objectStream),
+		localPow:     cfg.localPow(),
+	}
+	if cfg.PowWorkers > 0 {
+		rpcServer.powQueue = newPowQueue(cfg.PowWorkers, cfg.PowQueue)
 	}
 
 	pb.RegisterBmdServer(rpc.GRPC(), rpcServer)
+	pb.RegisterAdminServer(rpc.GRPC(), rpcServer)
 
 	return rpcServer, nil
 }
+
+// Start starts the proof of work workers and the RPC server.
+func (s *rpcServer) Start() {
+	if s.powQueue != nil {
+		s.powQueue.Start()
+	}
+	s.Server.Start()
+}
+
+// Stop stops the proof of work workers and the RPC server.
+func (s *rpcServer) Stop() error {
+	if s.powQueue != nil {
+		s.powQueue.Stop()
+	}
+	return s.Server.Stop()
+}
@@ -13,7 +13,32 @@ It has these top-level messages:
 	GetIdentityReply
 	Object
 	SendObjectReply
+	ScheduleObjectRequest
 	GetObjectsRequest
+	ObjectHeader
+	FetchObjectRequest
+	GetExpiredObjectsRequest
+	ExpiredObject
+	SubscribeCounter
+	SubscribeRequest
+	DoPowRequest
+	PowProgress
+	ListPeersRequest
+	PeerInfo
+	ListPeersReply
+	AddPeerRequest
+	AddPeerReply
+	RemovePeerRequest
+	RemovePeerReply
+	BanPeerRequest
+	BanPeerReply
+	UnbanPeerRequest
+	UnbanPeerReply
+	ListBansRequest
+	Ban
+	ListBansReply
+	DisconnectPeerRequest
+	DisconnectPeerReply
 */
 package rpcproto
 
@@ -68,6 +93,31 @@ func (x ObjectType) String() string {
 }
 func (ObjectType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
+// PowState is the state of a proof of work done with DoPow.
+type PowState int32
+
+const (
+	PowState_QUEUED  PowState = 0
+	PowState_RUNNING PowState = 1
+	PowState_DONE    PowState = 2
+)
+
+var PowState_name = map[int32]string{
+	0: "QUEUED",
+	1: "RUNNING",
+	2: "DONE",
+}
+var PowState_value = map[string]int32{
+	"QUEUED":  0,
+	"RUNNING": 1,
+	"DONE":    2,
+}
+
+func (x PowState) String() string {
+	return proto.EnumName(PowState_name, int32(x))
+}
+func (PowState) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
 type GetIdentityRequest struct {
 	// A properly formatted Bitmessage address.
 	Address string `protobuf:"bytes,1,opt,name=address" json:"address,omitempty"`
@@ -99,8 +149,8 @@ type Object struct {
 	// Properly serialized object bytes. It includes the object header (but not
 	// the Bitmessage message header).
 	Contents []byte `protobuf:"bytes,1,opt,name=contents,proto3" json:"contents,omitempty"`
-	// Counter value of the object, as in bmd's database. It is ignored with
-	// SendObject.
+	// Counter value of the object within its type and stream, as in bmd's
+	// database. It is ignored with SendObject.
 	Counter uint64 `protobuf:"varint,2,opt,name=counter" json:"counter,omitempty"`
 }
 
@@ -110,7 +160,8 @@ func (*Object) ProtoMessage()               {}
 func (*Object) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
 type SendObjectReply struct {
-	// Counter value of the object, as inserted in bmd's database.
+	// Counter value of the object within its type and stream, as inserted in
+	// bmd's database.
 	Counter uint64 `protobuf:"varint,1,opt,name=counter" json:"counter,omitempty"`
 }
 
@@ -119,25 +170,405 @@ func (m *SendObjectReply) String() string            { return proto.CompactTextS
 func (*SendObjectReply) ProtoMessage()               {}
 func (*SendObjectReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
+type ScheduleObjectRequest struct {
+	// Properly serialized object bytes, as for SendObject.
+	Contents []byte `protobuf:"bytes,1,opt,name=contents,proto3" json:"contents,omitempty"`
+	// Unix time at which to send the object. It is sent at once if this is 0
+	// or has passed.
+	SendAt int64 `protobuf:"varint,2,opt,name=send_at,json=sendAt" json:"send_at,omitempty"`
+	// Number of peers which must be connected before the object is sent.
+	MinPeers uint32 `protobuf:"varint,3,opt,name=min_peers,json=minPeers" json:"min_peers,omitempty"`
+}
+
+func (m *ScheduleObjectRequest) Reset()                    { *m = ScheduleObjectRequest{} }
+func (m *ScheduleObjectRequest) String() string            { return proto.CompactTextString(m) }
+func (*ScheduleObjectRequest) ProtoMessage()               {}
+func (*ScheduleObjectRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
 type GetObjectsRequest struct {
 	// Type of object the client wants to receive.
 	ObjectType ObjectType `protobuf:"varint,1,opt,name=object_type,json=objectType,enum=ObjectType" json:"object_type,omitempty"`
 	// Counter value the server should start sending object messages from.
 	FromCounter uint64 `protobuf:"varint,2,opt,name=from_counter,json=fromCounter" json:"from_counter,omitempty"`
+	// The stream of the objects. Each stream has its own counters. The first
+	// stream bmd participates in is used if this is 0.
+	Stream uint32 `protobuf:"varint,3,opt,name=stream" json:"stream,omitempty"`
 }
 
 func (m *GetObjectsRequest) Reset()                    { *m = GetObjectsRequest{} }
 func (m *GetObjectsRequest) String() string            { return proto.CompactTextString(m) }
 func (*GetObjectsRequest) ProtoMessage()               {}
-func (*GetObjectsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (*GetObjectsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type ObjectHeader struct {
+	// Inventory hash of the object.
+	Hash []byte `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	// Counter value of the object within its type and stream, as in bmd's
+	// database.
+	Counter uint64 `protobuf:"varint,2,opt,name=counter" json:"counter,omitempty"`
+	// Properly serialized object header: nonce, expiration, object type,
+	// version and stream number.
+	Header []byte `protobuf:"bytes,3,opt,name=header,proto3" json:"header,omitempty"`
+	// The tag of the object, for objects which have one (v4 getpubkeys, v4
+	// pubkeys and v5 broadcasts). It is empty otherwise.
+	Tag []byte `protobuf:"bytes,4,opt,name=tag,proto3" json:"tag,omitempty"`
+	// The first bytes of the encrypted payload of msgs and broadcasts. This
+	// includes the IV and the ephemeral public key. It is empty for other
+	// object types.
+	CiphertextPrefix []byte `protobuf:"bytes,5,opt,name=ciphertext_prefix,json=ciphertextPrefix,proto3" json:"ciphertext_prefix,omitempty"`
+	// Total size of the serialized object in bytes.
+	Size uint64 `protobuf:"varint,6,opt,name=size" json:"size,omitempty"`
+}
+
+func (m *ObjectHeader) Reset()                    { *m = ObjectHeader{} }
+func (m *ObjectHeader) String() string            { return proto.CompactTextString(m) }
+func (*ObjectHeader) ProtoMessage()               {}
+func (*ObjectHeader) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+type FetchObjectRequest struct {
+	// Inventory hash of the object.
+	Hash []byte `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+}
+
+func (m *FetchObjectRequest) Reset()                    { *m = FetchObjectRequest{} }
+func (m *FetchObjectRequest) String() string            { return proto.CompactTextString(m) }
+func (*FetchObjectRequest) ProtoMessage()               {}
+func (*FetchObjectRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+type GetExpiredObjectsRequest struct {
+}
+
+func (m *GetExpiredObjectsRequest) Reset()                    { *m = GetExpiredObjectsRequest{} }
+func (m *GetExpiredObjectsRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetExpiredObjectsRequest) ProtoMessage()               {}
+func (*GetExpiredObjectsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+type ExpiredObject struct {
+	// Inventory hash of the object.
+	Hash       []byte     `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	ObjectType ObjectType `protobuf:"varint,2,opt,name=object_type,json=objectType,enum=ObjectType" json:"object_type,omitempty"`
+	Stream     uint64     `protobuf:"varint,3,opt,name=stream" json:"stream,omitempty"`
+	// Unix time at which the object expired.
+	Expiration int64 `protobuf:"varint,4,opt,name=expiration" json:"expiration,omitempty"`
+}
+
+func (m *ExpiredObject) Reset()                    { *m = ExpiredObject{} }
+func (m *ExpiredObject) String() string            { return proto.CompactTextString(m) }
+func (*ExpiredObject) ProtoMessage()               {}
+func (*ExpiredObject) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+type SubscribeCounter struct {
+	// Type of object the client wants to receive.
+	ObjectType ObjectType `protobuf:"varint,1,opt,name=object_type,json=objectType,enum=ObjectType" json:"object_type,omitempty"`
+	// Counter value the server should start sending objects of this type from.
+	FromCounter uint64 `protobuf:"varint,2,opt,name=from_counter,json=fromCounter" json:"from_counter,omitempty"`
+	// The stream of the objects. Each stream has its own counters. The first
+	// stream bmd participates in is used if this is 0.
+	Stream uint32 `protobuf:"varint,3,opt,name=stream" json:"stream,omitempty"`
+}
+
+func (m *SubscribeCounter) Reset()                    { *m = SubscribeCounter{} }
+func (m *SubscribeCounter) String() string            { return proto.CompactTextString(m) }
+func (*SubscribeCounter) ProtoMessage()               {}
+func (*SubscribeCounter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+type SubscribeRequest struct {
+	// The object types and streams to receive and the counter values to start
+	// from. Only getpubkeys, pubkeys, msgs and broadcasts may be subscribed to.
+	Counters []*SubscribeCounter `protobuf:"bytes,1,rep,name=counters" json:"counters,omitempty"`
+	// Tags (32 bytes) of v4 and v5 pubkeys to receive. If any are given, no
+	// other pubkeys are sent.
+	PubkeyTags [][]byte `protobuf:"bytes,2,rep,name=pubkey_tags,json=pubkeyTags,proto3" json:"pubkey_tags,omitempty"`
+	// Tags (32 bytes) of v5 broadcasts to receive. If any are given, no other
+	// broadcasts are sent, including v4 broadcasts, which have no tag.
+	BroadcastTags [][]byte `protobuf:"bytes,3,rep,name=broadcast_tags,json=broadcastTags,proto3" json:"broadcast_tags,omitempty"`
+	// Ripe hashes (20 bytes) requested by v2 and v3 getpubkeys to receive.
+	GetpubkeyRipes [][]byte `protobuf:"bytes,4,rep,name=getpubkey_ripes,json=getpubkeyRipes,proto3" json:"getpubkey_ripes,omitempty"`
+	// Tags (32 bytes) of v4 getpubkeys to receive. If any ripes or tags are
+	// given, no other getpubkeys are sent.
+	GetpubkeyTags [][]byte `protobuf:"bytes,5,rep,name=getpubkey_tags,json=getpubkeyTags,proto3" json:"getpubkey_tags,omitempty"`
+	// Object versions to receive. All versions are sent if none are given.
+	Versions []uint64 `protobuf:"varint,6,rep,packed,name=versions" json:"versions,omitempty"`
+	// Objects which expire in less than this many seconds are not sent.
+	MinTtl int64 `protobuf:"varint,7,opt,name=min_ttl,json=minTtl" json:"min_ttl,omitempty"`
+}
+
+func (m *SubscribeRequest) Reset()                    { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string            { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()               {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *SubscribeRequest) GetCounters() []*SubscribeCounter {
+	if m != nil {
+		return m.Counters
+	}
+	return nil
+}
+
+type DoPowRequest struct {
+	// Properly serialized object bytes, as for SendObject. The nonce is
+	// ignored.
+	Contents []byte `protobuf:"bytes,1,opt,name=contents,proto3" json:"contents,omitempty"`
+	// The target which the proof of work must reach. If it is 0, the target
+	// that bmd requires of objects sent over RPC is used, given the size and
+	// time to live of the object.
+	Target uint64 `protobuf:"varint,2,opt,name=target" json:"target,omitempty"`
+	// Whether to send the object onto the network once the proof of work is
+	// done, as with SendObject.
+	Send bool `protobuf:"varint,3,opt,name=send" json:"send,omitempty"`
+}
+
+func (m *DoPowRequest) Reset()                    { *m = DoPowRequest{} }
+func (m *DoPowRequest) String() string            { return proto.CompactTextString(m) }
+func (*DoPowRequest) ProtoMessage()               {}
+func (*DoPowRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+type PowProgress struct {
+	State PowState `protobuf:"varint,1,opt,name=state,enum=PowState" json:"state,omitempty"`
+	// Position of the object in the queue, starting from 1. It is 0 once the
+	// proof of work has started.
+	QueuePosition uint32 `protobuf:"varint,2,opt,name=queue_position,json=queuePosition" json:"queue_position,omitempty"`
+	// Number of nonces tried so far.
+	Trials uint64 `protobuf:"varint,3,opt,name=trials" json:"trials,omitempty"`
+	// Average number of nonces which must be tried to reach the target.
+	ExpectedTrials uint64 `protobuf:"varint,4,opt,name=expected_trials,json=expectedTrials" json:"expected_trials,omitempty"`
+	// Properly serialized object bytes with the nonce found. It is only set
+	// once the proof of work is done.
+	Contents []byte `protobuf:"bytes,5,opt,name=contents,proto3" json:"contents,omitempty"`
+	// Counter value of the object, as inserted in bmd's database, if it was
+	// sent.
+	Counter uint64 `protobuf:"varint,6,opt,name=counter" json:"counter,omitempty"`
+}
+
+func (m *PowProgress) Reset()                    { *m = PowProgress{} }
+func (m *PowProgress) String() string            { return proto.CompactTextString(m) }
+func (*PowProgress) ProtoMessage()               {}
+func (*PowProgress) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+type ListPeersRequest struct {
+}
+
+func (m *ListPeersRequest) Reset()                    { *m = ListPeersRequest{} }
+func (m *ListPeersRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListPeersRequest) ProtoMessage()               {}
+func (*ListPeersRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+type PeerInfo struct {
+	// Address of the peer in the form host:port.
+	Address    string `protobuf:"bytes,1,opt,name=address" json:"address,omitempty"`
+	Inbound    bool   `protobuf:"varint,2,opt,name=inbound" json:"inbound,omitempty"`
+	Persistent bool   `protobuf:"varint,3,opt,name=persistent" json:"persistent,omitempty"`
+	UserAgent  string `protobuf:"bytes,4,opt,name=user_agent,json=userAgent" json:"user_agent,omitempty"`
+	// Streams which both bmd and the peer participate in.
+	Streams       []uint32 `protobuf:"varint,5,rep,packed,name=streams" json:"streams,omitempty"`
+	BytesSent     uint64   `protobuf:"varint,6,opt,name=bytes_sent,json=bytesSent" json:"bytes_sent,omitempty"`
+	BytesReceived uint64   `protobuf:"varint,7,opt,name=bytes_received,json=bytesReceived" json:"bytes_received,omitempty"`
+	// Unix time of the last message sent to the peer.
+	LastSend int64 `protobuf:"varint,8,opt,name=last_send,json=lastSend" json:"last_send,omitempty"`
+	// Unix time of the last message received from the peer.
+	LastReceive int64 `protobuf:"varint,9,opt,name=last_receive,json=lastReceive" json:"last_receive,omitempty"`
+	// Number of inventory hashes the peer is known to have.
+	KnownInventory uint64 `protobuf:"varint,10,opt,name=known_inventory,json=knownInventory" json:"known_inventory,omitempty"`
+	// Number of objects which are currently requested from the peer.
+	RequestedObjects uint32 `protobuf:"varint,11,opt,name=requested_objects,json=requestedObjects" json:"requested_objects,omitempty"`
+	// Current ban score of the peer.
+	BanScore uint32 `protobuf:"varint,12,opt,name=ban_score,json=banScore" json:"ban_score,omitempty"`
+	// Round trip time to the peer in microseconds, averaged over the recent
+	// round trips. It is 0 if no round trip has been measured.
+	Latency uint64 `protobuf:"varint,13,opt,name=latency" json:"latency,omitempty"`
+	// Last and lowest round trip times to the peer in microseconds.
+	LastLatency uint64 `protobuf:"varint,14,opt,name=last_latency,json=lastLatency" json:"last_latency,omitempty"`
+	MinLatency  uint64 `protobuf:"varint,15,opt,name=min_latency,json=minLatency" json:"min_latency,omitempty"`
+	// Number of round trips measured.
+	LatencySamples uint64 `protobuf:"varint,16,opt,name=latency_samples,json=latencySamples" json:"latency_samples,omitempty"`
+}
+
+func (m *PeerInfo) Reset()                    { *m = PeerInfo{} }
+func (m *PeerInfo) String() string            { return proto.CompactTextString(m) }
+func (*PeerInfo) ProtoMessage()               {}
+func (*PeerInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+type ListPeersReply struct {
+	Peers []*PeerInfo `protobuf:"bytes,1,rep,name=peers" json:"peers,omitempty"`
+}
+
+func (m *ListPeersReply) Reset()                    { *m = ListPeersReply{} }
+func (m *ListPeersReply) String() string            { return proto.CompactTextString(m) }
+func (*ListPeersReply) ProtoMessage()               {}
+func (*ListPeersReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+func (m *ListPeersReply) GetPeers() []*PeerInfo {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+type AddPeerRequest struct {
+	// Address of the peer in the form host:port.
+	Address    string `protobuf:"bytes,1,opt,name=address" json:"address,omitempty"`
+	Persistent bool   `protobuf:"varint,2,opt,name=persistent" json:"persistent,omitempty"`
+	// The stream to connect to the peer in. The first stream bmd participates
+	// in is used if this is 0.
+	Stream uint32 `protobuf:"varint,3,opt,name=stream" json:"stream,omitempty"`
+}
+
+func (m *AddPeerRequest) Reset()                    { *m = AddPeerRequest{} }
+func (m *AddPeerRequest) String() string            { return proto.CompactTextString(m) }
+func (*AddPeerRequest) ProtoMessage()               {}
+func (*AddPeerRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+type AddPeerReply struct {
+}
+
+func (m *AddPeerReply) Reset()                    { *m = AddPeerReply{} }
+func (m *AddPeerReply) String() string            { return proto.CompactTextString(m) }
+func (*AddPeerReply) ProtoMessage()               {}
+func (*AddPeerReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+type RemovePeerRequest struct {
+	// Address of the peer in the form host:port.
+	Address string `protobuf:"bytes,1,opt,name=address" json:"address,omitempty"`
+}
+
+func (m *RemovePeerRequest) Reset()                    { *m = RemovePeerRequest{} }
+func (m *RemovePeerRequest) String() string            { return proto.CompactTextString(m) }
+func (*RemovePeerRequest) ProtoMessage()               {}
+func (*RemovePeerRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+type RemovePeerReply struct {
+}
+
+func (m *RemovePeerReply) Reset()                    { *m = RemovePeerReply{} }
+func (m *RemovePeerReply) String() string            { return proto.CompactTextString(m) }
+func (*RemovePeerReply) ProtoMessage()               {}
+func (*RemovePeerReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+type BanPeerRequest struct {
+	// The IP address to ban.
+	Ip string `protobuf:"bytes,1,opt,name=ip" json:"ip,omitempty"`
+	// Duration of the ban in seconds. The configured ban duration is used if
+	// this is 0.
+	Duration int64 `protobuf:"varint,2,opt,name=duration" json:"duration,omitempty"`
+}
+
+func (m *BanPeerRequest) Reset()                    { *m = BanPeerRequest{} }
+func (m *BanPeerRequest) String() string            { return proto.CompactTextString(m) }
+func (*BanPeerRequest) ProtoMessage()               {}
+func (*BanPeerRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+type BanPeerReply struct {
+}
+
+func (m *BanPeerReply) Reset()                    { *m = BanPeerReply{} }
+func (m *BanPeerReply) String() string            { return proto.CompactTextString(m) }
+func (*BanPeerReply) ProtoMessage()               {}
+func (*BanPeerReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+type UnbanPeerRequest struct {
+	// The IP address to unban.
+	Ip string `protobuf:"bytes,1,opt,name=ip" json:"ip,omitempty"`
+}
+
+func (m *UnbanPeerRequest) Reset()                    { *m = UnbanPeerRequest{} }
+func (m *UnbanPeerRequest) String() string            { return proto.CompactTextString(m) }
+func (*UnbanPeerRequest) ProtoMessage()               {}
+func (*UnbanPeerRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
+
+type UnbanPeerReply struct {
+}
+
+func (m *UnbanPeerReply) Reset()                    { *m = UnbanPeerReply{} }
+func (m *UnbanPeerReply) String() string            { return proto.CompactTextString(m) }
+func (*UnbanPeerReply) ProtoMessage()               {}
+func (*UnbanPeerReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
+
+type ListBansRequest struct {
+}
+
+func (m *ListBansRequest) Reset()                    { *m = ListBansRequest{} }
+func (m *ListBansRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListBansRequest) ProtoMessage()               {}
+func (*ListBansRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
+
+type Ban struct {
+	Ip string `protobuf:"bytes,1,opt,name=ip" json:"ip,omitempty"`
+	// Unix time at which the ban ends.
+	Until int64 `protobuf:"varint,2,opt,name=until" json:"until,omitempty"`
+}
+
+func (m *Ban) Reset()                    { *m = Ban{} }
+func (m *Ban) String() string            { return proto.CompactTextString(m) }
+func (*Ban) ProtoMessage()               {}
+func (*Ban) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
+
+type ListBansReply struct {
+	Bans []*Ban `protobuf:"bytes,1,rep,name=bans" json:"bans,omitempty"`
+}
+
+func (m *ListBansReply) Reset()                    { *m = ListBansReply{} }
+func (m *ListBansReply) String() string            { return proto.CompactTextString(m) }
+func (*ListBansReply) ProtoMessage()               {}
+func (*ListBansReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
+
+func (m *ListBansReply) GetBans() []*Ban {
+	if m != nil {
+		return m.Bans
+	}
+	return nil
+}
+
+type DisconnectPeerRequest struct {
+	// Address of the peer in the form host:port.
+	Address string `protobuf:"bytes,1,opt,name=address" json:"address,omitempty"`
+}
+
+func (m *DisconnectPeerRequest) Reset()                    { *m = DisconnectPeerRequest{} }
+func (m *DisconnectPeerRequest) String() string            { return proto.CompactTextString(m) }
+func (*DisconnectPeerRequest) ProtoMessage()               {}
+func (*DisconnectPeerRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
+
+type DisconnectPeerReply struct {
+}
+
+func (m *DisconnectPeerReply) Reset()                    { *m = DisconnectPeerReply{} }
+func (m *DisconnectPeerReply) String() string            { return proto.CompactTextString(m) }
+func (*DisconnectPeerReply) ProtoMessage()               {}
+func (*DisconnectPeerReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
 
 func init() {
 	proto.RegisterType((*GetIdentityRequest)(nil), "GetIdentityRequest")
 	proto.RegisterType((*GetIdentityReply)(nil), "GetIdentityReply")
 	proto.RegisterType((*Object)(nil), "Object")
 	proto.RegisterType((*SendObjectReply)(nil), "SendObjectReply")
+	proto.RegisterType((*ScheduleObjectRequest)(nil), "ScheduleObjectRequest")
 	proto.RegisterType((*GetObjectsRequest)(nil), "GetObjectsRequest")
+	proto.RegisterType((*ObjectHeader)(nil), "ObjectHeader")
+	proto.RegisterType((*FetchObjectRequest)(nil), "FetchObjectRequest")
+	proto.RegisterType((*GetExpiredObjectsRequest)(nil), "GetExpiredObjectsRequest")
+	proto.RegisterType((*ExpiredObject)(nil), "ExpiredObject")
+	proto.RegisterType((*SubscribeCounter)(nil), "SubscribeCounter")
+	proto.RegisterType((*SubscribeRequest)(nil), "SubscribeRequest")
+	proto.RegisterType((*DoPowRequest)(nil), "DoPowRequest")
+	proto.RegisterType((*PowProgress)(nil), "PowProgress")
+	proto.RegisterType((*ListPeersRequest)(nil), "ListPeersRequest")
+	proto.RegisterType((*PeerInfo)(nil), "PeerInfo")
+	proto.RegisterType((*ListPeersReply)(nil), "ListPeersReply")
+	proto.RegisterType((*AddPeerRequest)(nil), "AddPeerRequest")
+	proto.RegisterType((*AddPeerReply)(nil), "AddPeerReply")
+	proto.RegisterType((*RemovePeerRequest)(nil), "RemovePeerRequest")
+	proto.RegisterType((*RemovePeerReply)(nil), "RemovePeerReply")
+	proto.RegisterType((*BanPeerRequest)(nil), "BanPeerRequest")
+	proto.RegisterType((*BanPeerReply)(nil), "BanPeerReply")
+	proto.RegisterType((*UnbanPeerRequest)(nil), "UnbanPeerRequest")
+	proto.RegisterType((*UnbanPeerReply)(nil), "UnbanPeerReply")
+	proto.RegisterType((*ListBansRequest)(nil), "ListBansRequest")
+	proto.RegisterType((*Ban)(nil), "Ban")
+	proto.RegisterType((*ListBansReply)(nil), "ListBansReply")
+	proto.RegisterType((*DisconnectPeerRequest)(nil), "DisconnectPeerRequest")
+	proto.RegisterType((*DisconnectPeerReply)(nil), "DisconnectPeerReply")
 	proto.RegisterEnum("ObjectType", ObjectType_name, ObjectType_value)
+	proto.RegisterEnum("PowState", PowState_name, PowState_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -163,6 +594,34 @@ type BmdClient interface {
 	// from what is specified. This method streams new objects until the stream
 	// is closed. Objects are guaranteed to be in ascending order.
 	GetObjects(ctx context.Context, in *GetObjectsRequest, opts ...grpc.CallOption) (Bmd_GetObjectsClient, error)
+	// Works like GetObjects, except that only the object headers are sent along
+	// with enough of the payload for the client to decide whether it is
+	// interested in the object. The full object can be retrieved with
+	// FetchObject.
+	GetObjectHeaders(ctx context.Context, in *GetObjectsRequest, opts ...grpc.CallOption) (Bmd_GetObjectHeadersClient, error)
+	// Retrieve the full object with the given inventory hash. If the object
+	// doesn't exist, an error is returned.
+	FetchObject(ctx context.Context, in *FetchObjectRequest, opts ...grpc.CallOption) (*Object, error)
+	// Streams the objects which expire and are removed from bmd's database
+	// from now on, so that the client can drop them as well. This method
+	// streams until the stream is closed.
+	GetExpiredObjects(ctx context.Context, in *GetExpiredObjectsRequest, opts ...grpc.CallOption) (Bmd_GetExpiredObjectsClient, error)
+	// Works like GetObjects for several object types at once, except that only
+	// the objects which match the given filters are sent. Objects of each type
+	// are in ascending order, but objects of different types may be mixed.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Bmd_SubscribeClient, error)
+	// Do the proof of work for an object on behalf of the client. The object
+	// waits in a queue until one of bmd's workers is free, and the progress of
+	// its proof of work is streamed until it is done, when the object with its
+	// nonce is sent and the stream ends. Closing the stream cancels the proof
+	// of work.
+	DoPow(ctx context.Context, in *DoPowRequest, opts ...grpc.CallOption) (Bmd_DoPowClient, error)
+	// Works like SendObject, except that the object waits in bmd's outbox until
+	// the given time and until enough peers are connected. Objects in the
+	// outbox, including those sent with SendObject, are kept across restarts
+	// and announced again periodically until enough peers have advertised them
+	// back to bmd. The counter value returned is 0 if the object is waiting.
+	ScheduleObject(ctx context.Context, in *ScheduleObjectRequest, opts ...grpc.CallOption) (*SendObjectReply, error)
 }
 
 type bmdClient struct {
@@ -223,6 +682,152 @@ func (x *bmdGetObjectsClient) Recv() (*Object, error) {
 	return m, nil
 }
 
+func (c *bmdClient) GetObjectHeaders(ctx context.Context, in *GetObjectsRequest, opts ...grpc.CallOption) (Bmd_GetObjectHeadersClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Bmd_serviceDesc.Streams[1], c.cc, "/Bmd/GetObjectHeaders", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bmdGetObjectHeadersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Bmd_GetObjectHeadersClient interface {
+	Recv() (*ObjectHeader, error)
+	grpc.ClientStream
+}
+
+type bmdGetObjectHeadersClient struct {
+	grpc.ClientStream
+}
+
+func (x *bmdGetObjectHeadersClient) Recv() (*ObjectHeader, error) {
+	m := new(ObjectHeader)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bmdClient) FetchObject(ctx context.Context, in *FetchObjectRequest, opts ...grpc.CallOption) (*Object, error) {
+	out := new(Object)
+	err := grpc.Invoke(ctx, "/Bmd/FetchObject", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bmdClient) GetExpiredObjects(ctx context.Context, in *GetExpiredObjectsRequest, opts ...grpc.CallOption) (Bmd_GetExpiredObjectsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Bmd_serviceDesc.Streams[2], c.cc, "/Bmd/GetExpiredObjects", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bmdGetExpiredObjectsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Bmd_GetExpiredObjectsClient interface {
+	Recv() (*ExpiredObject, error)
+	grpc.ClientStream
+}
+
+type bmdGetExpiredObjectsClient struct {
+	grpc.ClientStream
+}
+
+func (x *bmdGetExpiredObjectsClient) Recv() (*ExpiredObject, error) {
+	m := new(ExpiredObject)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bmdClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Bmd_SubscribeClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Bmd_serviceDesc.Streams[3], c.cc, "/Bmd/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bmdSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Bmd_SubscribeClient interface {
+	Recv() (*Object, error)
+	grpc.ClientStream
+}
+
+type bmdSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *bmdSubscribeClient) Recv() (*Object, error) {
+	m := new(Object)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bmdClient) DoPow(ctx context.Context, in *DoPowRequest, opts ...grpc.CallOption) (Bmd_DoPowClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Bmd_serviceDesc.Streams[4], c.cc, "/Bmd/DoPow", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bmdDoPowClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Bmd_DoPowClient interface {
+	Recv() (*PowProgress, error)
+	grpc.ClientStream
+}
+
+type bmdDoPowClient struct {
+	grpc.ClientStream
+}
+
+func (x *bmdDoPowClient) Recv() (*PowProgress, error) {
+	m := new(PowProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bmdClient) ScheduleObject(ctx context.Context, in *ScheduleObjectRequest, opts ...grpc.CallOption) (*SendObjectReply, error) {
+	out := new(SendObjectReply)
+	err := grpc.Invoke(ctx, "/Bmd/ScheduleObject", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Bmd service
 
 type BmdServer interface {
@@ -238,6 +843,34 @@ type BmdServer interface {
 	// from what is specified. This method streams new objects until the stream
 	// is closed. Objects are guaranteed to be in ascending order.
 	GetObjects(*GetObjectsRequest, Bmd_GetObjectsServer) error
+	// Works like GetObjects, except that only the object headers are sent along
+	// with enough of the payload for the client to decide whether it is
+	// interested in the object. The full object can be retrieved with
+	// FetchObject.
+	GetObjectHeaders(*GetObjectsRequest, Bmd_GetObjectHeadersServer) error
+	// Retrieve the full object with the given inventory hash. If the object
+	// doesn't exist, an error is returned.
+	FetchObject(context.Context, *FetchObjectRequest) (*Object, error)
+	// Streams the objects which expire and are removed from bmd's database
+	// from now on, so that the client can drop them as well. This method
+	// streams until the stream is closed.
+	GetExpiredObjects(*GetExpiredObjectsRequest, Bmd_GetExpiredObjectsServer) error
+	// Works like GetObjects for several object types at once, except that only
+	// the objects which match the given filters are sent. Objects of each type
+	// are in ascending order, but objects of different types may be mixed.
+	Subscribe(*SubscribeRequest, Bmd_SubscribeServer) error
+	// Do the proof of work for an object on behalf of the client. The object
+	// waits in a queue until one of bmd's workers is free, and the progress of
+	// its proof of work is streamed until it is done, when the object with its
+	// nonce is sent and the stream ends. Closing the stream cancels the proof
+	// of work.
+	DoPow(*DoPowRequest, Bmd_DoPowServer) error
+	// Works like SendObject, except that the object waits in bmd's outbox until
+	// the given time and until enough peers are connected. Objects in the
+	// outbox, including those sent with SendObject, are kept across restarts
+	// and announced again periodically until enough peers have advertised them
+	// back to bmd. The counter value returned is 0 if the object is waiting.
+	ScheduleObject(context.Context, *ScheduleObjectRequest) (*SendObjectReply, error)
 }
 
 func RegisterBmdServer(s *grpc.Server, srv BmdServer) {
@@ -301,6 +934,126 @@ func (x *bmdGetObjectsServer) Send(m *Object) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Bmd_GetObjectHeaders_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetObjectsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BmdServer).GetObjectHeaders(m, &bmdGetObjectHeadersServer{stream})
+}
+
+type Bmd_GetObjectHeadersServer interface {
+	Send(*ObjectHeader) error
+	grpc.ServerStream
+}
+
+type bmdGetObjectHeadersServer struct {
+	grpc.ServerStream
+}
+
+func (x *bmdGetObjectHeadersServer) Send(m *ObjectHeader) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Bmd_FetchObject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchObjectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BmdServer).FetchObject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Bmd/FetchObject",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BmdServer).FetchObject(ctx, req.(*FetchObjectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bmd_GetExpiredObjects_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetExpiredObjectsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BmdServer).GetExpiredObjects(m, &bmdGetExpiredObjectsServer{stream})
+}
+
+type Bmd_GetExpiredObjectsServer interface {
+	Send(*ExpiredObject) error
+	grpc.ServerStream
+}
+
+type bmdGetExpiredObjectsServer struct {
+	grpc.ServerStream
+}
+
+func (x *bmdGetExpiredObjectsServer) Send(m *ExpiredObject) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Bmd_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BmdServer).Subscribe(m, &bmdSubscribeServer{stream})
+}
+
+type Bmd_SubscribeServer interface {
+	Send(*Object) error
+	grpc.ServerStream
+}
+
+type bmdSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *bmdSubscribeServer) Send(m *Object) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Bmd_DoPow_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DoPowRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BmdServer).DoPow(m, &bmdDoPowServer{stream})
+}
+
+type Bmd_DoPowServer interface {
+	Send(*PowProgress) error
+	grpc.ServerStream
+}
+
+type bmdDoPowServer struct {
+	grpc.ServerStream
+}
+
+func (x *bmdDoPowServer) Send(m *PowProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Bmd_ScheduleObject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleObjectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BmdServer).ScheduleObject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Bmd/ScheduleObject",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BmdServer).ScheduleObject(ctx, req.(*ScheduleObjectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Bmd_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Bmd",
 	HandlerType: (*BmdServer)(nil),
@@ -313,6 +1066,14 @@ var _Bmd_serviceDesc = grpc.ServiceDesc{
 			MethodName: "SendObject",
 			Handler:    _Bmd_SendObject_Handler,
 		},
+		{
+			MethodName: "FetchObject",
+			Handler:    _Bmd_FetchObject_Handler,
+		},
+		{
+			MethodName: "ScheduleObject",
+			Handler:    _Bmd_ScheduleObject_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -320,39 +1081,412 @@ var _Bmd_serviceDesc = grpc.ServiceDesc{
 			Handler:       _Bmd_GetObjects_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetObjectHeaders",
+			Handler:       _Bmd_GetObjectHeaders_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetExpiredObjects",
+			Handler:       _Bmd_GetExpiredObjects_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Bmd_Subscribe_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DoPow",
+			Handler:       _Bmd_DoPow_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: fileDescriptor0,
+}
+
+// Client API for Admin service
+
+// Admin provides methods for managing a running bmd. It is only available to
+// the admin RPC user.
+type AdminClient interface {
+	// List the peers which are currently connected.
+	ListPeers(ctx context.Context, in *ListPeersRequest, opts ...grpc.CallOption) (*ListPeersReply, error)
+	// Connect to a new peer, optionally adding it as a persistent peer which
+	// is reconnected to if the connection is lost.
+	AddPeer(ctx context.Context, in *AddPeerRequest, opts ...grpc.CallOption) (*AddPeerReply, error)
+	// Stop treating the given peer as persistent and disconnect from it.
+	RemovePeer(ctx context.Context, in *RemovePeerRequest, opts ...grpc.CallOption) (*RemovePeerReply, error)
+	// Ban an IP address for the given duration. Any peers connected from the
+	// address are disconnected.
+	BanPeer(ctx context.Context, in *BanPeerRequest, opts ...grpc.CallOption) (*BanPeerReply, error)
+	// Lift the ban on an IP address.
+	UnbanPeer(ctx context.Context, in *UnbanPeerRequest, opts ...grpc.CallOption) (*UnbanPeerReply, error)
+	// List the IP addresses which are currently banned.
+	ListBans(ctx context.Context, in *ListBansRequest, opts ...grpc.CallOption) (*ListBansReply, error)
+	// Disconnect from the given peer.
+	DisconnectPeer(ctx context.Context, in *DisconnectPeerRequest, opts ...grpc.CallOption) (*DisconnectPeerReply, error)
+}
+
+type adminClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAdminClient(cc *grpc.ClientConn) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListPeers(ctx context.Context, in *ListPeersRequest, opts ...grpc.CallOption) (*ListPeersReply, error) {
+	out := new(ListPeersReply)
+	err := grpc.Invoke(ctx, "/Admin/ListPeers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AddPeer(ctx context.Context, in *AddPeerRequest, opts ...grpc.CallOption) (*AddPeerReply, error) {
+	out := new(AddPeerReply)
+	err := grpc.Invoke(ctx, "/Admin/AddPeer", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemovePeer(ctx context.Context, in *RemovePeerRequest, opts ...grpc.CallOption) (*RemovePeerReply, error) {
+	out := new(RemovePeerReply)
+	err := grpc.Invoke(ctx, "/Admin/RemovePeer", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) BanPeer(ctx context.Context, in *BanPeerRequest, opts ...grpc.CallOption) (*BanPeerReply, error) {
+	out := new(BanPeerReply)
+	err := grpc.Invoke(ctx, "/Admin/BanPeer", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) UnbanPeer(ctx context.Context, in *UnbanPeerRequest, opts ...grpc.CallOption) (*UnbanPeerReply, error) {
+	out := new(UnbanPeerReply)
+	err := grpc.Invoke(ctx, "/Admin/UnbanPeer", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListBans(ctx context.Context, in *ListBansRequest, opts ...grpc.CallOption) (*ListBansReply, error) {
+	out := new(ListBansReply)
+	err := grpc.Invoke(ctx, "/Admin/ListBans", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DisconnectPeer(ctx context.Context, in *DisconnectPeerRequest, opts ...grpc.CallOption) (*DisconnectPeerReply, error) {
+	out := new(DisconnectPeerReply)
+	err := grpc.Invoke(ctx, "/Admin/DisconnectPeer", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Admin service
+
+// Admin provides methods for managing a running bmd. It is only available to
+// the admin RPC user.
+type AdminServer interface {
+	// List the peers which are currently connected.
+	ListPeers(context.Context, *ListPeersRequest) (*ListPeersReply, error)
+	// Connect to a new peer, optionally adding it as a persistent peer which
+	// is reconnected to if the connection is lost.
+	AddPeer(context.Context, *AddPeerRequest) (*AddPeerReply, error)
+	// Stop treating the given peer as persistent and disconnect from it.
+	RemovePeer(context.Context, *RemovePeerRequest) (*RemovePeerReply, error)
+	// Ban an IP address for the given duration. Any peers connected from the
+	// address are disconnected.
+	BanPeer(context.Context, *BanPeerRequest) (*BanPeerReply, error)
+	// Lift the ban on an IP address.
+	UnbanPeer(context.Context, *UnbanPeerRequest) (*UnbanPeerReply, error)
+	// List the IP addresses which are currently banned.
+	ListBans(context.Context, *ListBansRequest) (*ListBansReply, error)
+	// Disconnect from the given peer.
+	DisconnectPeer(context.Context, *DisconnectPeerRequest) (*DisconnectPeerReply, error)
+}
+
+func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
+	s.RegisterService(&_Admin_serviceDesc, srv)
+}
+
+func _Admin_ListPeers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPeersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListPeers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Admin/ListPeers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListPeers(ctx, req.(*ListPeersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_AddPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddPeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Admin/AddPeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddPeer(ctx, req.(*AddPeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemovePeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemovePeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemovePeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Admin/RemovePeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemovePeer(ctx, req.(*RemovePeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_BanPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BanPeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).BanPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Admin/BanPeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).BanPeer(ctx, req.(*BanPeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_UnbanPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnbanPeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).UnbanPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Admin/UnbanPeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).UnbanPeer(ctx, req.(*UnbanPeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListBans_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBansRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListBans(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Admin/ListBans",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListBans(ctx, req.(*ListBansRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_DisconnectPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisconnectPeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).DisconnectPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Admin/DisconnectPeer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).DisconnectPeer(ctx, req.(*DisconnectPeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Admin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListPeers",
+			Handler:    _Admin_ListPeers_Handler,
+		},
+		{
+			MethodName: "AddPeer",
+			Handler:    _Admin_AddPeer_Handler,
+		},
+		{
+			MethodName: "RemovePeer",
+			Handler:    _Admin_RemovePeer_Handler,
+		},
+		{
+			MethodName: "BanPeer",
+			Handler:    _Admin_BanPeer_Handler,
+		},
+		{
+			MethodName: "UnbanPeer",
+			Handler:    _Admin_UnbanPeer_Handler,
+		},
+		{
+			MethodName: "ListBans",
+			Handler:    _Admin_ListBans_Handler,
+		},
+		{
+			MethodName: "DisconnectPeer",
+			Handler:    _Admin_DisconnectPeer_Handler,
+		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: fileDescriptor0,
 }
 
 func init() { proto.RegisterFile("rpc.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 419 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x64, 0x92, 0xdf, 0x8e, 0x93, 0x40,
-	0x14, 0x87, 0x65, 0xbb, 0xb6, 0xdb, 0x43, 0xbb, 0xb2, 0xe3, 0x0d, 0xe1, 0x46, 0x25, 0x31, 0x1a,
-	0xd7, 0x10, 0xb3, 0xc6, 0x5b, 0x93, 0x52, 0x49, 0x63, 0x1a, 0xdb, 0x66, 0xa0, 0x31, 0x7a, 0x43,
-	0xf8, 0x33, 0x56, 0xb4, 0x9d, 0xc1, 0x61, 0x6a, 0xe4, 0x35, 0x7c, 0x1c, 0x9f, 0x4e, 0x66, 0x80,
-	0xd2, 0x6e, 0xaf, 0x98, 0xf3, 0xf1, 0x1d, 0x32, 0xe7, 0xc7, 0x81, 0x21, 0xcf, 0x13, 0x27, 0xe7,
-	0x4c, 0x30, 0xdb, 0x01, 0x34, 0x23, 0xe2, 0x63, 0x4a, 0xa8, 0xc8, 0x44, 0x89, 0xc9, 0xaf, 0x3d,
-	0x29, 0x04, 0x32, 0x61, 0x10, 0xa5, 0x29, 0x27, 0x45, 0x61, 0x6a, 0x4f, 0xb5, 0x97, 0x43, 0xdc,
-	0x96, 0xf6, 0x3f, 0x0d, 0x8c, 0x93, 0x86, 0x7c, 0x5b, 0xa2, 0x67, 0x30, 0xa2, 0x8c, 0x26, 0x24,
-	0x14, 0x3c, 0x8b, 0xb6, 0x75, 0xcf, 0x25, 0xd6, 0x15, 0x0b, 0x14, 0x42, 0x4f, 0x40, 0x27, 0x7f,
-	0x04, 0x8f, 0xc2, 0xb8, 0x14, 0xa4, 0x30, 0x2f, 0x94, 0x01, 0x0a, 0xb9, 0x92, 0x48, 0xa1, 0xc8,
-	0x36, 0x34, 0xa3, 0x9b, 0xf0, 0x27, 0x29, 0xcd, 0x5e, 0x25, 0x8c, 0x30, 0x34, 0x68, 0x4e, 0x4a,
-	0xf4, 0x1c, 0xae, 0x09, 0x4d, 0x78, 0x99, 0x8b, 0x8c, 0x51, 0xe5, 0x5c, 0x2a, 0x67, 0xdc, 0x51,
-	0xa9, 0x59, 0x70, 0x15, 0x93, 0xef, 0xd1, 0xef, 0x8c, 0x71, 0xf3, 0x61, 0x25, 0x8c, 0xf1, 0xa1,
-	0xb6, 0xdf, 0x43, 0x7f, 0x19, 0xff, 0x20, 0x89, 0x90, 0x56, 0xc2, 0xa8, 0xa8, 0x86, 0xa8, 0x6f,
-	0x3b, 0xc2, 0x87, 0x5a, 0x0e, 0x9f, 0xb0, 0x7d, 0x55, 0xf0, 0xe6, 0x9a, 0x6d, 0x69, 0xdf, 0xc2,
-	0x23, 0x9f, 0xd0, 0xb4, 0xfe, 0x46, 0x3d, 0xfa, 0x91, 0xac, 0x9d, 0xca, 0x29, 0xdc, 0x54, 0x41,
-	0xd5, 0x6e, 0xd1, 0x06, 0xfb, 0x1a, 0x74, 0xa6, 0x48, 0x28, 0xca, 0x9c, 0xa8, 0x96, 0xeb, 0x3b,
-	0xdd, 0xa9, 0xad, 0xa0, 0x42, 0x18, 0xd8, 0xe1, 0x2c, 0x73, 0xfd, 0xc6, 0xd9, 0x2e, 0x3c, 0xbd,
-	0x8e, 0x2e, 0xd9, 0xb4, 0x46, 0xaf, 0x56, 0x00, 0x5d, 0x33, 0x1a, 0xc3, 0x70, 0xe6, 0x05, 0xab,
-	0xb5, 0x3b, 0xf7, 0xbe, 0x18, 0x0f, 0x10, 0x40, 0xbf, 0x39, 0x6b, 0x48, 0x87, 0xc1, 0x27, 0xcf,
-	0xf7, 0x27, 0x33, 0xcf, 0xb8, 0x90, 0x9e, 0x8b, 0x97, 0x93, 0x0f, 0xd3, 0x89, 0x1f, 0x18, 0x3d,
-	0xf9, 0x6e, 0xbd, 0x98, 0x2f, 0x96, 0x9f, 0x17, 0x46, 0x72, 0xf7, 0x57, 0x83, 0x9e, 0xbb, 0x4b,
-	0xd1, 0x3b, 0xd0, 0x8f, 0x7e, 0x34, 0x7a, 0xec, 0x9c, 0xef, 0x89, 0x75, 0xe3, 0x9c, 0xed, 0xc2,
-	0x0b, 0x80, 0x2e, 0x23, 0x34, 0x68, 0x46, 0xb3, 0x0c, 0xe7, 0x7e, 0x72, 0xb7, 0x00, 0x5d, 0x3e,
-	0x08, 0x39, 0x67, 0x61, 0x59, 0x6d, 0xf3, 0x1b, 0xcd, 0x85, 0xaf, 0x57, 0xd5, 0xce, 0xaa, 0x95,
-	0x8d, 0xfb, 0xea, 0xf1, 0xf6, 0x7f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x79, 0x31, 0xe4, 0xb8, 0xc6,
-	0x02, 0x00, 0x00,
+	// 1536 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xbc, 0x57, 0xdf, 0x72, 0xdb, 0x44,
+	0x17, 0xaf, 0xff, 0xc6, 0x3e, 0xfe, 0x27, 0x6f, 0xdb, 0x7c, 0xfa, 0xfc, 0xcd, 0x47, 0x83, 0x66,
+	0xa0, 0x69, 0xd3, 0x2e, 0x69, 0x18, 0x86, 0x19, 0x86, 0xe9, 0x10, 0x37, 0x26, 0x64, 0x5a, 0x12,
+	0x23, 0x27, 0x30, 0x70, 0xa3, 0x91, 0xa5, 0xad, 0x23, 0x6a, 0xaf, 0x54, 0x69, 0x9d, 0xc4, 0xc0,
+	0x0b, 0x70, 0xc3, 0x2d, 0x8f, 0xc0, 0x3b, 0xf0, 0x06, 0xcc, 0xf0, 0x12, 0xbc, 0x09, 0x73, 0x56,
+	0x2b, 0x59, 0xb2, 0x93, 0xd2, 0x2b, 0xae, 0xac, 0xf3, 0xdb, 0xdf, 0x39, 0xbb, 0x7b, 0xfe, 0xed,
+	0x31, 0xd4, 0xc3, 0xc0, 0xa1, 0x41, 0xe8, 0x0b, 0xdf, 0xa0, 0x40, 0x0e, 0x99, 0x38, 0x72, 0x19,
+	0x17, 0x9e, 0x58, 0x98, 0xec, 0xf5, 0x9c, 0x45, 0x82, 0xe8, 0xb0, 0x61, 0xbb, 0x6e, 0xc8, 0xa2,
+	0x48, 0x2f, 0x6c, 0x15, 0xb6, 0xeb, 0x66, 0x22, 0x1a, 0xbf, 0x17, 0x40, 0xcb, 0x29, 0x04, 0xd3,
+	0x05, 0x79, 0x17, 0x9a, 0xdc, 0xe7, 0x0e, 0xb3, 0x44, 0xe8, 0xd9, 0xd3, 0x58, 0xa7, 0x6c, 0x36,
+	0x24, 0x76, 0x2a, 0x21, 0x72, 0x0f, 0x1a, 0xec, 0x4a, 0x84, 0xb6, 0x35, 0x5e, 0x08, 0x16, 0xe9,
+	0x45, 0xc9, 0x00, 0x09, 0xf5, 0x11, 0x41, 0x42, 0xe4, 0x4d, 0xb8, 0xc7, 0x27, 0xd6, 0x2b, 0xb6,
+	0xd0, 0x4b, 0x5b, 0x85, 0xed, 0xa6, 0x09, 0x0a, 0x7a, 0xce, 0x16, 0xe4, 0x3d, 0x68, 0x33, 0xee,
+	0x84, 0x8b, 0x40, 0x78, 0x3e, 0x97, 0x9c, 0xb2, 0xe4, 0xb4, 0x96, 0x28, 0xd2, 0x7a, 0x50, 0x1b,
+	0xb3, 0x73, 0xfb, 0xc2, 0xf3, 0x43, 0xbd, 0xb2, 0x55, 0xd8, 0x6e, 0x99, 0xa9, 0x6c, 0x3c, 0x85,
+	0xea, 0xc9, 0xf8, 0x7b, 0xe6, 0x08, 0x64, 0x39, 0x3e, 0x17, 0x8c, 0x8b, 0xf8, 0xb4, 0x4d, 0x33,
+	0x95, 0xf1, 0xf2, 0x8e, 0x3f, 0xe7, 0x82, 0x85, 0xea, 0x98, 0x89, 0x68, 0xec, 0x40, 0x67, 0xc4,
+	0xb8, 0x1b, 0xdb, 0x88, 0xaf, 0x9e, 0x21, 0x17, 0xf2, 0x64, 0x0f, 0xee, 0x8e, 0x9c, 0x73, 0xe6,
+	0xce, 0xa7, 0x2c, 0x51, 0x88, 0x9d, 0xfb, 0xa6, 0xbd, 0xff, 0x03, 0x1b, 0x11, 0xe3, 0xae, 0x65,
+	0x0b, 0xb9, 0x77, 0xc9, 0xac, 0xa2, 0xb8, 0x2f, 0xc8, 0xff, 0xa0, 0x3e, 0xf3, 0xb8, 0x15, 0x30,
+	0x16, 0x46, 0xd2, 0x39, 0x2d, 0xb3, 0x36, 0xf3, 0xf8, 0x10, 0x65, 0xe3, 0x27, 0xe8, 0x1e, 0x32,
+	0x11, 0xef, 0x12, 0x25, 0xdb, 0x3c, 0x82, 0x86, 0x2f, 0x11, 0x4b, 0x2c, 0x02, 0x26, 0x77, 0x6a,
+	0xef, 0x35, 0x68, 0xcc, 0x3a, 0x5d, 0x04, 0xcc, 0x04, 0x3f, 0xfd, 0xc6, 0x10, 0xbe, 0x0c, 0xfd,
+	0x99, 0x95, 0xbf, 0x79, 0x03, 0xb1, 0x67, 0x31, 0x44, 0x36, 0xa1, 0x1a, 0x89, 0x90, 0xd9, 0x33,
+	0xb5, 0xbf, 0x92, 0x8c, 0xdf, 0x0a, 0xd0, 0x8c, 0xad, 0x7e, 0xc1, 0x6c, 0x97, 0x85, 0x84, 0x40,
+	0xf9, 0xdc, 0x8e, 0xce, 0xd5, 0xe5, 0xe4, 0xf7, 0xcd, 0x4e, 0x45, 0xb3, 0xe7, 0x52, 0x4f, 0xc5,
+	0x5c, 0x49, 0x44, 0x83, 0x92, 0xb0, 0x27, 0x2a, 0xc8, 0xf8, 0x49, 0x76, 0xa0, 0xeb, 0x78, 0xc1,
+	0x39, 0x0b, 0x05, 0xbb, 0x12, 0x56, 0x10, 0xb2, 0x97, 0xde, 0x95, 0x8c, 0x71, 0xd3, 0xd4, 0x96,
+	0x0b, 0x43, 0x89, 0xe3, 0x21, 0x22, 0xef, 0x07, 0xa6, 0x57, 0xe5, 0x6e, 0xf2, 0xdb, 0xd8, 0x06,
+	0xf2, 0x39, 0x13, 0xce, 0x79, 0x3e, 0x1e, 0xd7, 0x1c, 0xd7, 0xe8, 0x81, 0x7e, 0xc8, 0xc4, 0xe0,
+	0x2a, 0xf0, 0x42, 0xe6, 0xe6, 0x1d, 0x6b, 0xfc, 0x5c, 0x80, 0x56, 0x6e, 0xe5, 0xda, 0x0b, 0xaf,
+	0xb8, 0xbf, 0xf8, 0x66, 0xf7, 0xe7, 0x7d, 0x5b, 0x4e, 0x7c, 0x4b, 0xde, 0x01, 0x60, 0xb8, 0x95,
+	0x8d, 0xe9, 0x2d, 0x7d, 0x51, 0x32, 0x33, 0x88, 0xf1, 0x23, 0x68, 0xa3, 0xf9, 0x38, 0x72, 0x42,
+	0x6f, 0xcc, 0x92, 0x38, 0xfd, 0x6b, 0x81, 0xff, 0xa5, 0x98, 0xd9, 0x3d, 0xf1, 0xe6, 0x63, 0xcc,
+	0x6e, 0xa9, 0x87, 0xd9, 0x5d, 0xda, 0x6e, 0xec, 0x75, 0xe9, 0xea, 0x11, 0xcd, 0x94, 0x82, 0x65,
+	0x1f, 0xcc, 0xc7, 0xaf, 0xd8, 0xc2, 0x12, 0xf6, 0x04, 0xfb, 0x42, 0x09, 0xcb, 0x3e, 0x86, 0x4e,
+	0xed, 0x49, 0x84, 0x65, 0x3f, 0x0e, 0x7d, 0xdb, 0x75, 0xec, 0x48, 0xc4, 0x9c, 0x92, 0xe4, 0xb4,
+	0x52, 0x54, 0xd2, 0xee, 0x43, 0x67, 0xc2, 0x84, 0x32, 0x15, 0x7a, 0x01, 0x8b, 0xf4, 0xb2, 0xe4,
+	0xb5, 0x53, 0xd8, 0x44, 0x14, 0xed, 0x2d, 0x89, 0xd2, 0x5e, 0x25, 0xb6, 0x97, 0xa2, 0xd2, 0x5e,
+	0x0f, 0x6a, 0x17, 0x2c, 0x8c, 0x3c, 0x9f, 0x47, 0x7a, 0x75, 0xab, 0xb4, 0x5d, 0x36, 0x53, 0x19,
+	0x8b, 0x14, 0x6b, 0x51, 0x88, 0xa9, 0xbe, 0x11, 0x17, 0xe9, 0xcc, 0xe3, 0xa7, 0x62, 0x6a, 0x7c,
+	0x0d, 0xcd, 0x03, 0x7f, 0xe8, 0x5f, 0xbe, 0x4d, 0xa5, 0x6f, 0x42, 0x55, 0xd8, 0xe1, 0x84, 0x09,
+	0xe5, 0x71, 0x25, 0xc9, 0xbc, 0x65, 0xdc, 0x95, 0xae, 0xae, 0x99, 0xf2, 0xdb, 0xf8, 0xb3, 0x00,
+	0x8d, 0xa1, 0x7f, 0x39, 0x0c, 0xfd, 0x49, 0xc8, 0x22, 0x74, 0x5a, 0x25, 0x12, 0xb6, 0x48, 0x62,
+	0x5b, 0xa7, 0x43, 0xff, 0x72, 0x84, 0x80, 0x19, 0xe3, 0x78, 0xc9, 0xd7, 0x73, 0x36, 0x67, 0x56,
+	0xe0, 0x47, 0x9e, 0x4c, 0x9d, 0xa2, 0x8c, 0x5c, 0x4b, 0xa2, 0x43, 0x05, 0xca, 0x33, 0xc4, 0x1d,
+	0x5b, 0x65, 0x5d, 0x2c, 0xa1, 0x33, 0xd9, 0x55, 0xc0, 0x1c, 0xc1, 0xdc, 0xa4, 0xa5, 0x97, 0x25,
+	0xa1, 0x9d, 0xc0, 0xaa, 0xab, 0x67, 0x2f, 0x58, 0xb9, 0xb9, 0x8d, 0x56, 0xf3, 0x9d, 0x91, 0x80,
+	0xf6, 0xc2, 0x8b, 0x84, 0xec, 0x5d, 0x49, 0x51, 0xfd, 0x5a, 0x86, 0x1a, 0x02, 0x47, 0xfc, 0xa5,
+	0x7f, 0xf3, 0xf3, 0x83, 0x2b, 0x1e, 0x1f, 0xfb, 0x73, 0xee, 0xca, 0x1b, 0xd5, 0xcc, 0x44, 0xc4,
+	0x4a, 0x09, 0x30, 0x40, 0x11, 0xee, 0xae, 0xbc, 0x97, 0x41, 0xc8, 0xff, 0x01, 0xe6, 0x11, 0x0b,
+	0x2d, 0x7b, 0x82, 0xeb, 0x65, 0x69, 0xb6, 0x8e, 0xc8, 0x3e, 0x02, 0x68, 0x38, 0xce, 0xea, 0x38,
+	0x1f, 0x5a, 0x66, 0x22, 0xa2, 0xa2, 0x7c, 0xb3, 0xac, 0x08, 0x15, 0xe3, 0xab, 0xd4, 0x25, 0x32,
+	0x42, 0x45, 0xcc, 0x4f, 0xb9, 0x1c, 0x32, 0x87, 0x79, 0x17, 0xcc, 0x95, 0x39, 0x51, 0x36, 0x5b,
+	0x12, 0x35, 0x15, 0x88, 0xfd, 0x7b, 0x8a, 0x19, 0x2c, 0x63, 0x5b, 0x93, 0x59, 0x53, 0x43, 0x00,
+	0xdf, 0x13, 0xac, 0x41, 0xb9, 0xa8, 0x4c, 0xe8, 0x75, 0xb9, 0xde, 0x40, 0x4c, 0x19, 0xc0, 0x90,
+	0xbc, 0xe2, 0xfe, 0x25, 0xb7, 0x3c, 0x7e, 0xc1, 0xb8, 0xf0, 0xc3, 0x85, 0x0e, 0x71, 0x48, 0x24,
+	0x7c, 0x94, 0xa0, 0xd8, 0x24, 0xc3, 0xd8, 0xa7, 0xcc, 0xb5, 0xe2, 0x3a, 0x8f, 0xf4, 0x86, 0x8c,
+	0xbe, 0x96, 0x2e, 0xa8, 0x8e, 0x86, 0xa7, 0x1a, 0xdb, 0xdc, 0x8a, 0x1c, 0x3f, 0x64, 0x7a, 0x53,
+	0xbd, 0x96, 0x36, 0x1f, 0xa1, 0x8c, 0x2e, 0x99, 0xda, 0x82, 0x71, 0x67, 0xa1, 0xb7, 0xe2, 0x00,
+	0x2a, 0x31, 0x3d, 0x6f, 0xb2, 0xdc, 0x8e, 0x7b, 0x06, 0x62, 0x2f, 0x14, 0xe5, 0x1e, 0x34, 0xb0,
+	0x46, 0x12, 0x46, 0x47, 0x32, 0x60, 0xe6, 0xf1, 0x84, 0x70, 0x1f, 0x3a, 0x6a, 0xd1, 0x8a, 0xec,
+	0x59, 0x30, 0x65, 0x91, 0xae, 0xc5, 0x17, 0x52, 0xf0, 0x28, 0x46, 0x8d, 0x27, 0xd0, 0xce, 0x64,
+	0x0b, 0xbe, 0xb9, 0xf7, 0xa0, 0x12, 0xb0, 0x65, 0x7f, 0xa9, 0xd3, 0x24, 0x71, 0xcc, 0x18, 0x37,
+	0xc6, 0xd0, 0xde, 0x77, 0x5d, 0x44, 0xff, 0x71, 0xa0, 0x59, 0xc9, 0x9b, 0xe2, 0x5a, 0xde, 0xdc,
+	0xd4, 0xfc, 0xda, 0xd0, 0x4c, 0xf7, 0x08, 0xa6, 0x0b, 0xe3, 0x31, 0x74, 0x4d, 0x36, 0xf3, 0x2f,
+	0xd8, 0x5b, 0x6d, 0x6b, 0x74, 0xa1, 0x93, 0xa5, 0xa3, 0x85, 0x4f, 0xa1, 0xdd, 0xb7, 0x79, 0x56,
+	0xbd, 0x0d, 0x45, 0x2f, 0x50, 0x9a, 0x45, 0x2f, 0xc0, 0x72, 0x73, 0xe7, 0xea, 0x2d, 0x88, 0xc7,
+	0x83, 0x54, 0xc6, 0xf3, 0xa4, 0xda, 0x68, 0xcd, 0x00, 0xed, 0x8c, 0x8f, 0xdf, 0x68, 0xcf, 0xd0,
+	0xa0, 0x9d, 0xe1, 0xa0, 0x56, 0x17, 0x3a, 0xe8, 0xec, 0xbe, 0xcd, 0xd3, 0xca, 0xdc, 0x81, 0x52,
+	0xdf, 0xe6, 0x6b, 0x67, 0xb9, 0x03, 0x95, 0x39, 0x17, 0xde, 0x54, 0x1d, 0x24, 0x16, 0x8c, 0x07,
+	0xd0, 0x5a, 0xea, 0xc7, 0xf3, 0x51, 0x79, 0x6c, 0xf3, 0x24, 0x54, 0x65, 0xda, 0xb7, 0xb9, 0x29,
+	0x11, 0xe3, 0x09, 0xdc, 0x3d, 0xf0, 0x22, 0xc7, 0xe7, 0x9c, 0x39, 0xe2, 0xed, 0x9c, 0x76, 0x17,
+	0x6e, 0xaf, 0xaa, 0x04, 0xd3, 0xc5, 0xc3, 0x21, 0xc0, 0xf2, 0x71, 0x23, 0x2d, 0xa8, 0x1f, 0x0e,
+	0x4e, 0x87, 0x67, 0xfd, 0xe7, 0x83, 0x6f, 0xb5, 0x5b, 0x04, 0xa0, 0xaa, 0xbe, 0x0b, 0xa4, 0x01,
+	0x1b, 0x5f, 0x0e, 0x46, 0xa3, 0xfd, 0xc3, 0x81, 0x56, 0x44, 0x5e, 0xdf, 0x3c, 0xd9, 0x3f, 0x78,
+	0xb6, 0x3f, 0x3a, 0xd5, 0x4a, 0xb8, 0x76, 0x76, 0xfc, 0xfc, 0xf8, 0xe4, 0x9b, 0x63, 0xcd, 0x79,
+	0xf8, 0x18, 0x6a, 0x49, 0x4b, 0x45, 0x03, 0x5f, 0x9d, 0x0d, 0xce, 0x06, 0x07, 0xda, 0x2d, 0x24,
+	0x99, 0x67, 0xc7, 0xc7, 0x47, 0xc7, 0x87, 0x5a, 0x81, 0xd4, 0xa0, 0x7c, 0x70, 0x72, 0x3c, 0xd0,
+	0x8a, 0x7b, 0x7f, 0x94, 0xa0, 0xd4, 0x9f, 0xb9, 0xe4, 0x23, 0x68, 0x64, 0x66, 0x63, 0x72, 0x9b,
+	0xae, 0x8f, 0xd6, 0xbd, 0x2e, 0x5d, 0x1b, 0x9f, 0xef, 0x03, 0x2c, 0xc7, 0x4a, 0xb2, 0xa1, 0x5e,
+	0xea, 0x9e, 0x46, 0x57, 0x87, 0xcd, 0x1d, 0x80, 0xe5, 0x9c, 0x47, 0x08, 0x5d, 0x1b, 0xfa, 0x7a,
+	0x89, 0xf2, 0x6e, 0x81, 0x7c, 0x2c, 0x07, 0xf5, 0xec, 0x60, 0x76, 0xbd, 0x4a, 0x8b, 0x66, 0x39,
+	0xbb, 0x05, 0xb2, 0x03, 0x8d, 0xcc, 0x94, 0x44, 0x6e, 0xd3, 0xf5, 0x99, 0x29, 0xdd, 0x87, 0x7c,
+	0x26, 0x47, 0xcf, 0xfc, 0xa0, 0x44, 0xfe, 0x4b, 0x6f, 0x1a, 0x9e, 0x7a, 0x6d, 0x9a, 0xc3, 0x77,
+	0x0b, 0xe4, 0x01, 0xd4, 0xd3, 0xf9, 0x80, 0x64, 0x66, 0x85, 0x6b, 0xae, 0xf4, 0x3e, 0x54, 0xe4,
+	0xfb, 0x4a, 0x5a, 0x34, 0xfb, 0xce, 0xf6, 0x9a, 0x34, 0xf3, 0x3a, 0xee, 0x16, 0xc8, 0x27, 0xd0,
+	0xce, 0x8f, 0xde, 0x64, 0x93, 0x5e, 0x3b, 0x8b, 0xaf, 0xfb, 0x78, 0xef, 0xaf, 0x22, 0x54, 0xf6,
+	0xdd, 0x99, 0xc7, 0xc9, 0x07, 0x50, 0x4f, 0x1b, 0x0f, 0xe9, 0xd2, 0xd5, 0x27, 0xab, 0xd7, 0xa1,
+	0x2b, 0x7d, 0xe9, 0x01, 0x6c, 0xa8, 0x96, 0x40, 0x3a, 0x34, 0xdf, 0x80, 0x7a, 0x2d, 0x9a, 0xed,
+	0x16, 0x64, 0x0f, 0x60, 0x59, 0xfe, 0x84, 0xd0, 0xb5, 0xd6, 0xd1, 0xd3, 0xe8, 0x4a, 0x7f, 0x40,
+	0xf3, 0xaa, 0xc2, 0x49, 0x87, 0xe6, 0x3b, 0x45, 0xaf, 0x45, 0xb3, 0xc5, 0x8f, 0x47, 0x4f, 0x0b,
+	0x9b, 0x74, 0xe9, 0x6a, 0x23, 0xe8, 0x75, 0x68, 0xbe, 0xee, 0xc9, 0x23, 0xa8, 0x25, 0x75, 0x4b,
+	0x34, 0xba, 0xd2, 0x02, 0x7a, 0x6d, 0x9a, 0x2f, 0xea, 0xa7, 0xd0, 0xce, 0xd7, 0x21, 0xd9, 0xa4,
+	0xd7, 0xd6, 0x72, 0xef, 0x0e, 0xbd, 0xa6, 0x60, 0xfb, 0xf0, 0x5d, 0x2d, 0x0c, 0x1c, 0xf9, 0x07,
+	0x74, 0x5c, 0x95, 0x3f, 0x1f, 0xfe, 0x3d, 0x00, 0xd6, 0x43, 0x3b, 0xb8, 0x94, 0x0e, 0x00, 0x00,
 }
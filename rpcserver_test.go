@@ -9,9 +9,12 @@ import (
 	"bytes"
 	"net"
 	"testing"
+	"time"
 
+	"github.com/DanielKrawisz/bmd/database"
 	"github.com/DanielKrawisz/bmd/peer"
 	"github.com/DanielKrawisz/bmd/objmgr/stats"
+	"github.com/DanielKrawisz/bmd/rpc"
 	pb "github.com/DanielKrawisz/bmd/rpcproto"
 	"github.com/DanielKrawisz/bmutil/wire"
 	"github.com/DanielKrawisz/bmutil/wire/obj"
@@ -28,6 +31,10 @@ const (
 
 	rpcLimitUser = "limit"
 	rpcLimitPass = "limit"
+
+	// rpcSendUser may only send one object per minute.
+	rpcSendUser = "send"
+	rpcSendPass = "send"
 )
 
 func rpcTests(t *testing.T, s *server) {
@@ -45,6 +52,15 @@ func rpcTests(t *testing.T, s *server) {
 
 	testRPCSendObject(s, c, t)
 	testRPCGetObjects(c, t)
+	testRPCGetObjectHeaders(c, t)
+	testRPCFetchObject(c, t)
+	testRPCGetExpiredObjects(s, c, t)
+	testRPCSubscribe(s, c, t)
+	testRPCDoPow(s, c, t)
+	testRPCScheduleObject(s, c, t)
+
+	admin := pb.NewAdminClient(conn)
+	testRPCAdmin(admin, t)
 }
 
 // testRPCAuth tests authentication failures for all RPC methods.
@@ -68,9 +84,89 @@ func testRPCAuth(t *testing.T) {
 	c = pb.NewBmdClient(conn)
 
 	testRPCAuthFailure(c, t, codes.PermissionDenied)
+	testRPCAdminAuthFailure(pb.NewAdminClient(conn), t, codes.PermissionDenied)
+	conn.Close()
+
+	// Try accessing admin methods with limited credentials.
+	conn, err = grpc.Dial(rpcLoc, grpc.WithInsecure(),
+		grpc.WithPerRPCCredentials(pb.NewBasicAuthCredentials(rpcLimitUser, rpcLimitPass)))
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+
+	testRPCAdminAuthFailure(pb.NewAdminClient(conn), t, codes.PermissionDenied)
+	conn.Close()
+
+	// Try accessing methods other than SendObject with send-only
+	// credentials, and sending too many objects.
+	conn, err = grpc.Dial(rpcLoc, grpc.WithInsecure(),
+		grpc.WithPerRPCCredentials(pb.NewBasicAuthCredentials(rpcSendUser, rpcSendPass)))
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	c = pb.NewBmdClient(conn)
+
+	_, err = c.GetIdentity(context.Background(), &pb.GetIdentityRequest{})
+	if grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected code %d, got unexpected error %v", codes.PermissionDenied, err)
+	}
+
+	_, err = c.SendObject(context.Background(), &pb.Object{})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected code %d, got unexpected error %v", codes.InvalidArgument, err)
+	}
+
+	_, err = c.SendObject(context.Background(), &pb.Object{})
+	if grpc.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected code %d, got unexpected error %v", codes.ResourceExhausted, err)
+	}
+
+	_, err = c.ScheduleObject(context.Background(), &pb.ScheduleObjectRequest{})
+	if grpc.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected code %d, got unexpected error %v", codes.ResourceExhausted, err)
+	}
+
+	testRPCAdminAuthFailure(pb.NewAdminClient(conn), t, codes.PermissionDenied)
 	conn.Close()
 }
 
+func testRPCAdminAuthFailure(c pb.AdminClient, t *testing.T, expectedCode codes.Code) {
+	_, err := c.ListPeers(context.Background(), &pb.ListPeersRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	_, err = c.AddPeer(context.Background(), &pb.AddPeerRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	_, err = c.RemovePeer(context.Background(), &pb.RemovePeerRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	_, err = c.BanPeer(context.Background(), &pb.BanPeerRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	_, err = c.UnbanPeer(context.Background(), &pb.UnbanPeerRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	_, err = c.ListBans(context.Background(), &pb.ListBansRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	_, err = c.DisconnectPeer(context.Background(), &pb.DisconnectPeerRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+}
+
 func testRPCAuthFailure(c pb.BmdClient, t *testing.T, expectedCode codes.Code) {
 	_, err := c.SendObject(context.Background(), &pb.Object{})
 	if grpc.Code(err) != expectedCode {
@@ -91,6 +187,56 @@ func testRPCAuthFailure(c pb.BmdClient, t *testing.T, expectedCode codes.Code) {
 	if grpc.Code(err) != expectedCode {
 		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
 	}
+
+	headerStream, err := c.GetObjectHeaders(context.Background(), &pb.GetObjectsRequest{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = headerStream.Recv()
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	_, err = c.FetchObject(context.Background(), &pb.FetchObjectRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	expiredStream, err := c.GetExpiredObjects(context.Background(), &pb.GetExpiredObjectsRequest{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = expiredStream.Recv()
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	subscribeStream, err := c.Subscribe(context.Background(), &pb.SubscribeRequest{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = subscribeStream.Recv()
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	powStream, err := c.DoPow(context.Background(), &pb.DoPowRequest{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = powStream.Recv()
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
+
+	_, err = c.ScheduleObject(context.Background(), &pb.ScheduleObjectRequest{})
+	if grpc.Code(err) != expectedCode {
+		t.Errorf("Expected code %d, got unexpected error %v", expectedCode, err)
+	}
 }
 
 // Test SendObject.
@@ -188,6 +334,376 @@ func testRPCGetObjects(c pb.BmdClient, t *testing.T) {
 	}
 }
 
+func testRPCGetObjectHeaders(c pb.BmdClient, t *testing.T) {
+	// Receive the getpubkeys inserted in previous tests.
+	stream, err := c.GetObjectHeaders(context.Background(), &pb.GetObjectsRequest{
+		ObjectType:  pb.ObjectType_GETPUBKEY,
+		FromCounter: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		header, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := wire.Encode(testObj[i]) // getpubkey
+		if header.Counter != uint64(i+1) {
+			t.Errorf("header #%d: expected counter %d got %d", i, i+1, header.Counter)
+		}
+		if !bytes.Equal(header.Hash, obj.InventoryHash(testObj[i])[:]) {
+			t.Errorf("header #%d: invalid inventory hash %v", i, header.Hash)
+		}
+		if header.Size != uint64(len(data)) {
+			t.Errorf("header #%d: expected size %d got %d", i, len(data), header.Size)
+		}
+		if !bytes.HasPrefix(data, header.Header) || len(header.Header) == 0 {
+			t.Errorf("header #%d: invalid header bytes %v", i, header.Header)
+		}
+
+		// Version 4 getpubkeys consist of only a tag.
+		if !bytes.Equal(data[len(header.Header):], header.Tag) {
+			t.Errorf("header #%d: expected tag %v got %v", i,
+				data[len(header.Header):], header.Tag)
+		}
+		if len(header.CiphertextPrefix) != 0 {
+			t.Errorf("header #%d: unexpected ciphertext %v", i, header.CiphertextPrefix)
+		}
+	}
+}
+
+func testRPCFetchObject(c pb.BmdClient, t *testing.T) {
+	_, err := c.FetchObject(context.Background(), &pb.FetchObjectRequest{
+		Hash: []byte{1, 2, 3},
+	})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	_, err = c.FetchObject(context.Background(), &pb.FetchObjectRequest{
+		Hash: obj.InventoryHash(testObj[2])[:],
+	})
+	if grpc.Code(err) != codes.NotFound {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	object, err := c.FetchObject(context.Background(), &pb.FetchObjectRequest{
+		Hash: obj.InventoryHash(testObj[0])[:],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := wire.Encode(testObj[0]) // getpubkey
+	if !bytes.Equal(data, object.Contents) {
+		t.Errorf("invalid getpubkey bytes, expected %v got %v", data, object.Contents)
+	}
+}
+
+func testRPCGetExpiredObjects(serv *server, c pb.BmdClient, t *testing.T) {
+	stream, err := c.GetExpiredObjects(context.Background(), &pb.GetExpiredObjectsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the server time to subscribe before the object expires.
+	time.Sleep(200 * time.Millisecond)
+
+	// Insert an object which is removed shortly after.
+	exp := time.Now().Add(database.ExpiredCacheTime).Add(100 * time.Millisecond)
+	msg := wire.NewMsgObject(wire.NewObjectHeader(123, exp, wire.ObjectTypeMsg, 1, 1),
+		[]byte{1, 2, 3})
+	if _, err = serv.db.InsertObject(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expired.Hash, obj.InventoryHash(msg)[:]) {
+		t.Errorf("invalid inventory hash %v", expired.Hash)
+	}
+	if expired.ObjectType != pb.ObjectType_MESSAGE || expired.Stream != 1 {
+		t.Errorf("unexpected object type %v or stream %d", expired.ObjectType,
+			expired.Stream)
+	}
+	if expired.Expiration != exp.Unix() {
+		t.Errorf("expected expiration %d got %d", exp.Unix(), expired.Expiration)
+	}
+}
+
+func testRPCSubscribe(serv *server, c pb.BmdClient, t *testing.T) {
+	errorTests := []*pb.SubscribeRequest{
+		&pb.SubscribeRequest{}, // no counters
+		&pb.SubscribeRequest{ // counter is 0
+			Counters: []*pb.SubscribeCounter{{ObjectType: pb.ObjectType_BROADCAST}},
+		},
+		&pb.SubscribeRequest{ // duplicate type
+			Counters: []*pb.SubscribeCounter{
+				{ObjectType: pb.ObjectType_BROADCAST, FromCounter: 1},
+				{ObjectType: pb.ObjectType_BROADCAST, FromCounter: 2},
+			},
+		},
+		&pb.SubscribeRequest{ // stream bmd does not participate in
+			Counters: []*pb.SubscribeCounter{
+				{ObjectType: pb.ObjectType_BROADCAST, Stream: 5, FromCounter: 1},
+			},
+		},
+		&pb.SubscribeRequest{ // invalid tag
+			Counters:      []*pb.SubscribeCounter{{ObjectType: pb.ObjectType_BROADCAST, FromCounter: 1}},
+			BroadcastTags: [][]byte{{1, 2, 3}},
+		},
+	}
+
+	for i, test := range errorTests {
+		stream, err := c.Subscribe(context.Background(), test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stream.Recv()
+		if grpc.Code(err) != codes.InvalidArgument {
+			t.Errorf("for case #%d got unexpected error %v", i, err)
+		}
+	}
+
+	for _, o := range testObj[6:8] { // broadcasts
+		if _, err := serv.db.InsertObject(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the second broadcast and the getpubkeys inserted in previous
+	// tests should be sent.
+	stream, err := c.Subscribe(context.Background(), &pb.SubscribeRequest{
+		Counters: []*pb.SubscribeCounter{
+			{ObjectType: pb.ObjectType_GETPUBKEY, FromCounter: 1},
+			{ObjectType: pb.ObjectType_BROADCAST, FromCounter: 1},
+		},
+		BroadcastTags: [][]byte{shahash[1][:]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]uint64{
+		string(wire.Encode(testObj[0])): 1,
+		string(wire.Encode(testObj[1])): 2,
+		string(wire.Encode(testObj[7])): 2,
+	}
+	for n := len(expected); n > 0; n-- {
+		objMsg, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counter, ok := expected[string(objMsg.Contents)]
+		if !ok {
+			t.Fatalf("unexpected object %v", objMsg.Contents)
+		}
+		if counter != objMsg.Counter {
+			t.Errorf("expected counter %d got %d", counter, objMsg.Counter)
+		}
+		delete(expected, string(objMsg.Contents))
+	}
+}
+
+func testRPCDoPow(serv *server, c pb.BmdClient, t *testing.T) {
+	stream, err := c.DoPow(context.Background(), &pb.DoPowRequest{
+		Contents: []byte{0x00, 0x00, 0x00, 0x00}, // invalid object
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	// Do the proof of work for an object without a nonce and send it.
+	o := wire.NewMsgObject(wire.NewObjectHeader(0, expires, wire.ObjectTypeMsg,
+		1, 1), []byte{21, 22, 23, 24, 25, 26, 27, 28})
+	stream, err = c.DoPow(context.Background(), &pb.DoPowRequest{
+		Contents: wire.Encode(o),
+		Send:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress *pb.PowProgress
+	for progress == nil || progress.State != pb.PowState_DONE {
+		if progress, err = stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if progress.Counter == 0 {
+		t.Error("object was not sent")
+	}
+
+	o, err = wire.DecodeMsgObject(progress.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := serv.objectManager.HaveInventory((*wire.InvVect)(obj.InventoryHash(o))); !ok {
+		t.Error("server doesn't have new object in inventory, error:", err)
+	}
+}
+
+func testRPCScheduleObject(serv *server, c pb.BmdClient, t *testing.T) {
+	// Have the server do the proof of work for a new object.
+	o := wire.NewMsgObject(wire.NewObjectHeader(0, expires, wire.ObjectTypeMsg,
+		1, 1), []byte{31, 32, 33, 34, 35, 36, 37, 38})
+	stream, err := c.DoPow(context.Background(), &pb.DoPowRequest{
+		Contents: wire.Encode(o),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress *pb.PowProgress
+	for progress == nil || progress.State != pb.PowState_DONE {
+		if progress, err = stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An object can't be scheduled to be sent after it expires.
+	_, err = c.ScheduleObject(context.Background(), &pb.ScheduleObjectRequest{
+		Contents: progress.Contents,
+		SendAt:   expires.Add(time.Minute).Unix(),
+	})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	// The server has no peers, so the object waits in the outbox.
+	reply, err := c.ScheduleObject(context.Background(), &pb.ScheduleObjectRequest{
+		Contents: progress.Contents,
+		MinPeers: 1,
+	})
+	if err != nil {
+		t.Fatalf("for valid ScheduleObject got error %v", err)
+	}
+	if reply.Counter != 0 {
+		t.Errorf("expected counter 0, got %d", reply.Counter)
+	}
+
+	o, err = wire.DecodeMsgObject(progress.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := serv.objectManager.HaveInventory((*wire.InvVect)(obj.InventoryHash(o))); ok {
+		t.Error("scheduled object inserted before it was due")
+	}
+	if status := serv.objectManager.Status(); status == nil || status.Scheduled != 1 {
+		t.Errorf("expected 1 scheduled object, got status %+v", status)
+	}
+
+	_, err = c.ScheduleObject(context.Background(), &pb.ScheduleObjectRequest{
+		Contents: progress.Contents,
+	})
+	if grpc.Code(err) != codes.AlreadyExists {
+		t.Errorf("got unexpected error %v", err)
+	}
+}
+
+func testRPCAdmin(c pb.AdminClient, t *testing.T) {
+	peers, err := c.ListPeers(context.Background(), &pb.ListPeersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers.Peers) != 0 {
+		t.Errorf("expected no peers, got %d", len(peers.Peers))
+	}
+
+	_, err = c.AddPeer(context.Background(), &pb.AddPeerRequest{
+		Address: "127.0.0.1",
+	})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	_, err = c.AddPeer(context.Background(), &pb.AddPeerRequest{
+		Address: "127.0.0.1:8444",
+		Stream:  5,
+	})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	_, err = c.RemovePeer(context.Background(), &pb.RemovePeerRequest{
+		Address: "127.0.0.1:8444",
+	})
+	if grpc.Code(err) != codes.NotFound {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	_, err = c.DisconnectPeer(context.Background(), &pb.DisconnectPeerRequest{
+		Address: "127.0.0.1:8444",
+	})
+	if grpc.Code(err) != codes.NotFound {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	_, err = c.BanPeer(context.Background(), &pb.BanPeerRequest{
+		Ip: "not an ip",
+	})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	_, err = c.BanPeer(context.Background(), &pb.BanPeerRequest{
+		Ip:       "10.0.0.1",
+		Duration: -1,
+	})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	_, err = c.BanPeer(context.Background(), &pb.BanPeerRequest{
+		Ip:       "10.0.0.1",
+		Duration: 3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bans, err := c.ListBans(context.Background(), &pb.ListBansRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans.Bans) != 1 || bans.Bans[0].Ip != "10.0.0.1" {
+		t.Errorf("expected 10.0.0.1 to be banned, got %v", bans.Bans)
+	}
+
+	_, err = c.UnbanPeer(context.Background(), &pb.UnbanPeerRequest{
+		Ip: "10.0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.UnbanPeer(context.Background(), &pb.UnbanPeerRequest{
+		Ip: "10.0.0.1",
+	})
+	if grpc.Code(err) != codes.NotFound {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	bans, err = c.ListBans(context.Background(), &pb.ListBansRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans.Bans) != 0 {
+		t.Errorf("expected no bans, got %v", bans.Bans)
+	}
+}
+
 func TestRPCConnection(t *testing.T) {
 
 	// Address for mock listener to pass to server. The server
@@ -203,6 +719,12 @@ func TestRPCConnection(t *testing.T) {
 	cfg.EnableRPC = true
 	cfg.DisableTLS = true
 	cfg.RPCMaxClients = 1
+
+	sendHash, err := rpc.HashPassword(rpcSendPass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RPCUserEntries = []string{rpcSendUser + ":" + sendHash + ":send:1"}
 	defer resetCfg(cfg)()
 
 	// Set RPC listener.
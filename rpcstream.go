@@ -0,0 +1,466 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// laggardsDrop is the policy of dropping the objects buffered for a
+	// client which has fallen behind, to be fetched again from the database
+	// once it catches up.
+	laggardsDrop = "drop"
+
+	// laggardsDisconnect is the policy of closing the stream of a client
+	// which has fallen behind.
+	laggardsDisconnect = "disconnect"
+
+	// rpcStreamLagTimeout is how long the buffer of a stream may stay full
+	// before its client is considered to have fallen behind.
+	rpcStreamLagTimeout = 30 * time.Second
+
+	// rpcStreamSendTimeout is how long sending a single object to a client
+	// may take before the client is considered to have stopped reading and
+	// its stream is closed, whatever the policy for clients which fall
+	// behind. It is longer than rpcStreamLagTimeout so that a client whose
+	// buffer was dropped has time to catch up.
+	rpcStreamSendTimeout = 2 * rpcStreamLagTimeout
+)
+
+// objectNotifier wakes up the streams which are waiting for new objects.
+type objectNotifier struct {
+	mtx sync.Mutex
+	ch  chan struct{}
+}
+
+// wait returns a channel which is closed on the next call to notify.
+func (n *objectNotifier) wait() <-chan struct{} {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+// notify wakes up everything waiting on the notifier.
+func (n *objectNotifier) notify() {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// streamTotals counts the objects of all the streams to RPC clients since bmd
+// was started. It is only used atomically.
+type streamTotals struct {
+	sent    uint64
+	dropped uint64
+}
+
+// streamStats describes a stream of objects to an RPC client.
+type streamStats struct {
+	ID      uint64
+	Method  string
+	Started time.Time
+
+	// Sent is the number of objects sent to the client.
+	Sent uint64
+
+	// Dropped is the number of objects dropped from the buffer of the
+	// stream while the client was behind.
+	Dropped uint64
+
+	// Buffered is the number of objects waiting to be sent.
+	Buffered int
+
+	// Lagging is whether the client has fallen behind and the stream is
+	// waiting for it to catch up.
+	Lagging bool
+}
+
+// streamCounter identifies the counter of an object type in a stream.
+type streamCounter struct {
+	objType wire.ObjectType
+	stream  uint32
+}
+
+// objectStream streams objects of some types and streams from the database to
+// an RPC client, starting from a counter value for each type in each stream.
+// Objects are fetched by a goroutine of their own into a bounded buffer, from
+// which they are sent to the client.
+type objectStream struct {
+	id      uint64
+	method  string
+	started time.Time
+
+	// keys are the object types and streams to stream and counters are
+	// the counters from which to fetch the next objects of each. counters
+	// may only be used by the fill goroutine.
+	keys     []streamCounter
+	counters map[streamCounter]uint64
+
+	// filter returns whether an object is to be sent. If it is nil, every
+	// object is sent.
+	filter func(*database.ObjectWithCounter) bool
+
+	// wake is notified of new objects of the types in the stream.
+	wake *objectNotifier
+
+	buf         chan *database.ObjectWithCounter
+	drained     chan struct{}
+	drop        bool
+	lagTimeout  time.Duration
+	sendTimeout time.Duration
+
+	sent    uint64 // atomic
+	dropped uint64 // atomic
+	lagging int32  // atomic
+	sending int32  // atomic
+
+	// totals is added to along with sent and dropped.
+	totals *streamTotals
+}
+
+// newObjectStream creates a stream of the objects of the given types and
+// streams starting at the given counters.
+func (s *rpcServer) newObjectStream(method string, keys []streamCounter,
+	counters map[streamCounter]uint64,
+	filter func(*database.ObjectWithCounter) bool) *objectStream {
+
+	wake := s.anyObject
+	if len(keys) == 1 {
+		wake = s.objNotifiers[keys[0].objType.String()]
+	}
+
+	return &objectStream{
+		method:      method,
+		started:     time.Now(),
+		keys:        keys,
+		counters:    counters,
+		filter:      filter,
+		wake:        wake,
+		buf:         make(chan *database.ObjectWithCounter, s.streamBuffer),
+		drained:     make(chan struct{}, 1),
+		drop:        s.dropLaggards,
+		lagTimeout:  s.lagTimeout,
+		sendTimeout: s.sendTimeout,
+		totals:      &s.streamTotals,
+	}
+}
+
+// addDropped counts an object dropped from the buffer.
+func (st *objectStream) addDropped() {
+	atomic.AddUint64(&st.dropped, 1)
+	atomic.AddUint64(&st.totals.dropped, 1)
+}
+
+// Stats returns the current stats of the stream.
+func (st *objectStream) Stats() *streamStats {
+	return &streamStats{
+		ID:       st.id,
+		Method:   st.method,
+		Started:  st.started,
+		Sent:     atomic.LoadUint64(&st.sent),
+		Dropped:  atomic.LoadUint64(&st.dropped),
+		Buffered: len(st.buf),
+		Lagging:  atomic.LoadInt32(&st.lagging) == 1,
+	}
+}
+
+// push adds an object to the buffer, waiting up to lagTimeout for room. It
+// returns false if there was none.
+func (st *objectStream) push(ctx context.Context, object *database.ObjectWithCounter) bool {
+	select {
+	case st.buf <- object:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(st.lagTimeout)
+	defer timer.Stop()
+
+	select {
+	case st.buf <- object:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// fetch fetches the next objects of each type and stream into the buffer,
+// taking turns between them so that none of them holds up the others. It
+// returns whether there were any objects, and whether the buffer filled up.
+func (st *objectStream) fetch(ctx context.Context, db *database.Db) (fetched, full bool, err error) {
+	for _, key := range st.keys {
+		objs, lastCount, err := db.FetchObjectsFromCounter(key.objType,
+			key.stream, st.counters[key], rpcCounterObjectsSize)
+		if err != nil {
+			return fetched, false, err
+		}
+		if len(objs) == 0 {
+			continue
+		}
+		fetched = true
+
+		for i := range objs {
+			object := &objs[i]
+			if (st.filter == nil || st.filter(object)) && !st.push(ctx, object) {
+				// Continue from the object which did not fit.
+				st.addDropped()
+				return fetched, true, nil
+			}
+			st.counters[key] = object.Counter + 1
+		}
+		st.counters[key] = lastCount + 1
+	}
+
+	return fetched, false, nil
+}
+
+// dropBuffered empties the buffer and rewinds the counters so that the
+// objects which were in it are fetched again.
+func (st *objectStream) dropBuffered() {
+	for {
+		select {
+		case object := <-st.buf:
+			header := object.Object.Header()
+			key := streamCounter{header.ObjectType, uint32(header.StreamNumber)}
+			if object.Counter < st.counters[key] {
+				st.counters[key] = object.Counter
+			}
+			st.addDropped()
+		default:
+			return
+		}
+	}
+}
+
+// fill keeps the buffer filled with objects until the context is done. When
+// there are no more objects, it waits for new ones to arrive. It must be run
+// as a goroutine.
+func (st *objectStream) fill(ctx context.Context, db *database.Db) error {
+	for {
+		// Wait for notifications before fetching so that none are missed
+		// in between.
+		wake := st.wake.wait()
+
+		fetched, full, err := st.fetch(ctx, db)
+		if err != nil {
+			rpcLog.Errorf("FetchObjectsFromCounter, database error: %v", err)
+			return grpc.Errorf(codes.Internal, "database error")
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if full {
+			if !st.drop {
+				return grpc.Errorf(codes.ResourceExhausted, "client fell behind")
+			}
+
+			// Drop what the client has not received and wait for it to
+			// catch up before fetching the objects again. If it is not
+			// in the middle of receiving an object, it already has.
+			rpcLog.Debugf("RPC %s stream %d fell behind, dropping buffered objects.",
+				st.method, st.id)
+			atomic.StoreInt32(&st.lagging, 1)
+			select {
+			case <-st.drained:
+			default:
+			}
+			st.dropBuffered()
+			if atomic.LoadInt32(&st.sending) == 1 {
+				select {
+				case <-st.drained:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			atomic.StoreInt32(&st.lagging, 0)
+			continue
+		}
+
+		if fetched {
+			continue
+		}
+
+		// We ran out of more objects to send to the client, so wait until
+		// we have more.
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// sendObject sends an object to the client with send on a goroutine of its
+// own. It gives up once the send has taken sendTimeout or the context is done.
+// A send which is given up on fails once the stream is torn down, which it is
+// when runStream returns, and no other send is begun in the meantime.
+func (st *objectStream) sendObject(ctx context.Context,
+	send func(*database.ObjectWithCounter) error,
+	object *database.ObjectWithCounter) (bool, error) {
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(object)
+	}()
+
+	timer := time.NewTimer(st.sendTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			return false, grpc.Errorf(codes.DataLoss, "failed to send object: %v", err)
+		}
+		return true, nil
+	case <-timer.C:
+		rpcLog.Debugf("RPC %s stream %d stopped reading, closing.",
+			st.method, st.id)
+		return false, grpc.Errorf(codes.ResourceExhausted, "client stopped reading")
+	case <-ctx.Done():
+		return false, nil
+	}
+}
+
+// drain sends the objects in the buffer to the client with send until the
+// context is done or a send fails.
+func (st *objectStream) drain(ctx context.Context,
+	send func(*database.ObjectWithCounter) error) error {
+
+	for {
+		select {
+		case object := <-st.buf:
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			atomic.StoreInt32(&st.sending, 1)
+			ok, err := st.sendObject(ctx, send, object)
+			atomic.StoreInt32(&st.sending, 0)
+			if !ok {
+				return err
+			}
+			atomic.AddUint64(&st.sent, 1)
+			atomic.AddUint64(&st.totals.sent, 1)
+
+			// Let the fill goroutine know if it is waiting for the
+			// client to catch up.
+			if len(st.buf) == 0 {
+				select {
+				case st.drained <- struct{}{}:
+				default:
+				}
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// runStream sends the objects of the stream to the client with send until
+// the client goes away or the stream fails. The objects are fetched by a
+// goroutine of their own and sent one at a time, none of them once runStream
+// has returned. A send to a client which has stopped reading is given up on
+// after sendTimeout, or as soon as the stream fails, so that runStream returns
+// and the stream is torn down, which ends the send.
+func (s *rpcServer) runStream(ctx context.Context, st *objectStream,
+	send func(*database.ObjectWithCounter) error) error {
+
+	s.addStream(st)
+	defer s.removeStream(st)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Once the fill goroutine stops, there is nothing more to send.
+	filled := make(chan error, 1)
+	go func() {
+		err := st.fill(ctx, s.server.db)
+		cancel()
+		filled <- err
+	}()
+
+	err := st.drain(ctx, send)
+
+	// Wait for the fill goroutine, which returns as soon as the context is
+	// done, so that nothing is left behind.
+	cancel()
+	if ferr := <-filled; err == nil {
+		err = ferr
+	}
+	if err == nil {
+		err = grpc.Errorf(codes.Canceled, "stream closed")
+	}
+	return err
+}
+
+// addStream registers a stream so that its stats are reported.
+func (s *rpcServer) addStream(st *objectStream) {
+	s.streamsMtx.Lock()
+	defer s.streamsMtx.Unlock()
+
+	s.nextStream++
+	st.id = s.nextStream
+	s.streams[st.id] = st
+}
+
+// removeStream unregisters a stream which has ended.
+func (s *rpcServer) removeStream(st *objectStream) {
+	s.streamsMtx.Lock()
+	delete(s.streams, st.id)
+	s.streamsMtx.Unlock()
+
+	stats := st.Stats()
+	rpcLog.Debugf("RPC %s stream %d closed after %s: %d objects sent, %d dropped.",
+		stats.Method, stats.ID, time.Since(stats.Started), stats.Sent, stats.Dropped)
+}
+
+// StreamTotals returns the number of objects sent over all streams since bmd
+// was started and the number dropped from their buffers.
+func (s *rpcServer) StreamTotals() (sent, dropped uint64) {
+	return atomic.LoadUint64(&s.streamTotals.sent),
+		atomic.LoadUint64(&s.streamTotals.dropped)
+}
+
+// StreamStats returns the stats of the open streams of objects, ordered by
+// ID.
+func (s *rpcServer) StreamStats() []*streamStats {
+	s.streamsMtx.Lock()
+	defer s.streamsMtx.Unlock()
+
+	stats := make([]*streamStats, 0, len(s.streams))
+	for _, st := range s.streams {
+		stats = append(stats, st.Stats())
+	}
+	sort.Sort(streamStatsByID(stats))
+	return stats
+}
+
+// streamStatsByID implements sort.Interface to sort stream stats by ID.
+type streamStatsByID []*streamStats
+
+func (s streamStatsByID) Len() int           { return len(s) }
+func (s streamStatsByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s streamStatsByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,236 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DanielKrawisz/bmd/database"
+	"github.com/DanielKrawisz/bmutil/wire"
+	"github.com/DanielKrawisz/bmutil/wire/obj"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// newTestStreamServer returns an rpcServer which streams the objects in
+// testObj with a buffer of two objects.
+func newTestStreamServer(dropLaggards bool) *rpcServer {
+	return &rpcServer{
+		server: &server{db: getMemDb(testObj)},
+		objNotifiers: map[string]*objectNotifier{
+			wire.ObjectTypeGetPubKey.String(): &objectNotifier{},
+			wire.ObjectTypePubKey.String():    &objectNotifier{},
+			wire.ObjectTypeMsg.String():       &objectNotifier{},
+			wire.ObjectTypeBroadcast.String(): &objectNotifier{},
+			wire.ObjectType(999).String():     &objectNotifier{},
+		},
+		anyObject:    &objectNotifier{},
+		streamBuffer: 2,
+		dropLaggards: dropLaggards,
+		lagTimeout:   50 * time.Millisecond,
+		sendTimeout:  time.Second,
+		streams:      make(map[uint64]*objectStream),
+	}
+}
+
+// newTestStream returns a stream of the known types of objects in testObj.
+func newTestStream(s *rpcServer) *objectStream {
+	types := []wire.ObjectType{wire.ObjectTypeGetPubKey, wire.ObjectTypePubKey,
+		wire.ObjectTypeMsg, wire.ObjectTypeBroadcast}
+	keys := make([]streamCounter, 0, len(types))
+	counters := make(map[streamCounter]uint64)
+	for _, objType := range types {
+		key := streamCounter{objType, 1}
+		keys = append(keys, key)
+		counters[key] = 1
+	}
+	return s.newObjectStream("Test", keys, counters, nil)
+}
+
+func TestObjectStreamDrop(t *testing.T) {
+	s := newTestStreamServer(true)
+	st := newTestStream(s)
+
+	// The client doesn't read anything until it is released.
+	release := make(chan struct{})
+	received := make(chan *database.ObjectWithCounter, len(testObj))
+	send := func(object *database.ObjectWithCounter) error {
+		<-release
+		received <- object
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- s.runStream(ctx, st, send)
+	}()
+
+	// Wait for the client to fall behind and its buffer to be dropped.
+	for i := 0; ; i++ {
+		stats := s.StreamStats()
+		if len(stats) == 1 && stats[0].Lagging && stats[0].Buffered == 0 &&
+			stats[0].Dropped > 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("client did not fall behind: %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Once the client catches up, it receives every object exactly once.
+	close(release)
+	seen := make(map[string]struct{})
+	for i := 0; i < 8; i++ {
+		select {
+		case object := <-received:
+			hash := obj.InventoryHash(object.Object).String()
+			if _, ok := seen[hash]; ok {
+				t.Errorf("object %s received twice", hash)
+			}
+			seen[hash] = struct{}{}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only received %d objects", i)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if grpc.Code(err) != codes.Canceled {
+			t.Errorf("expected code %d, got unexpected error %v", codes.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end when the client went away")
+	}
+
+	if stats := s.StreamStats(); len(stats) != 0 {
+		t.Errorf("stream still open after it ended: %+v", stats)
+	}
+
+	// The objects of closed streams are still counted.
+	if sent, dropped := s.StreamTotals(); sent != 8 || dropped == 0 {
+		t.Errorf("expected 8 objects sent and some dropped, got %d and %d",
+			sent, dropped)
+	}
+}
+
+func TestObjectStreamDisconnect(t *testing.T) {
+	s := newTestStreamServer(false)
+	st := newTestStream(s)
+
+	release := make(chan struct{})
+	sending := make(chan struct{}, len(testObj))
+	send := func(object *database.ObjectWithCounter) error {
+		sending <- struct{}{}
+		<-release
+		return nil
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- s.runStream(context.Background(), st, send)
+	}()
+
+	// The stream is closed while the send is still blocked.
+	<-sending
+	select {
+	case err := <-result:
+		if grpc.Code(err) != codes.ResourceExhausted {
+			t.Errorf("expected code %d, got unexpected error %v",
+				codes.ResourceExhausted, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream of client which fell behind was not closed")
+	}
+
+	// Nothing more is sent once the blocked send returns.
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(sending); n != 0 {
+		t.Errorf("%d objects sent after the stream failed", n)
+	}
+}
+
+func TestObjectStreamStoppedReading(t *testing.T) {
+	s := newTestStreamServer(true)
+	s.sendTimeout = 200 * time.Millisecond
+	st := newTestStream(s)
+
+	// The client never reads anything, and its buffer is dropped.
+	block := make(chan struct{})
+	defer close(block)
+	send := func(object *database.ObjectWithCounter) error {
+		<-block
+		return nil
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- s.runStream(context.Background(), st, send)
+	}()
+
+	// The stream is closed once the send has taken too long.
+	select {
+	case err := <-result:
+		if grpc.Code(err) != codes.ResourceExhausted {
+			t.Errorf("expected code %d, got unexpected error %v",
+				codes.ResourceExhausted, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream of client which stopped reading was not closed")
+	}
+	if stats := s.StreamStats(); len(stats) != 0 {
+		t.Errorf("stream still open after it ended: %+v", stats)
+	}
+}
+
+func TestObjectStreamWait(t *testing.T) {
+	s := newTestStreamServer(true)
+	s.server.db = getMemDb(nil)
+
+	key := streamCounter{wire.ObjectTypeMsg, 1}
+	st := s.newObjectStream("Test", []streamCounter{key},
+		map[streamCounter]uint64{key: 1}, nil)
+
+	received := make(chan *database.ObjectWithCounter, 1)
+	send := func(object *database.ObjectWithCounter) error {
+		received <- object
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- s.runStream(ctx, st, send)
+	}()
+
+	// A new object is sent once the stream is notified of it.
+	time.Sleep(50 * time.Millisecond)
+	if _, err := s.server.db.InsertObject(testObj[4]); err != nil {
+		t.Fatal(err)
+	}
+	s.NotifyObject(objType)
+
+	select {
+	case object := <-received:
+		if object.Counter != 1 {
+			t.Errorf("expected counter 1, got %d", object.Counter)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("new object was not sent")
+	}
+
+	// A stream waiting for new objects ends when the client goes away.
+	cancel()
+	select {
+	case <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting stream did not end when the client went away")
+	}
+}
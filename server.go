@@ -15,6 +15,7 @@ import (
 	"math"
 	mrand "math/rand"
 	"net"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"sync"
@@ -79,6 +80,7 @@ type peerState struct {
 	persistentPeers  map[*peer.Peer]reconnectionAttempts
 	banned           map[string]time.Time
 	outboundGroups   map[string]int
+	outboundStreams  map[uint32]int
 	maxOutboundPeers int
 }
 
@@ -98,6 +100,17 @@ func (p *peerState) NeedMoreOutbound() bool {
 		p.Count() < cfg.MaxPeers
 }
 
+// NeedMoreOutboundInStream returns whether more outbound peers are needed in
+// the given stream. The outbound peers are shared evenly between the streams
+// we participate in.
+func (p *peerState) NeedMoreOutboundInStream(stream uint32, streams int) bool {
+	share := p.maxOutboundPeers / streams
+	if share == 0 {
+		share = 1
+	}
+	return p.outboundStreams[stream] < share
+}
+
 // forAllOutboundPeers is a helper function that runs closure on all outbound
 // peers known to peerState
 func (p *peerState) forAllOutboundPeers(closure func(p *peer.Peer)) {
@@ -125,6 +138,7 @@ func newPeerState(maxOutbound int) *peerState {
 		outboundPeers:    make(map[*peer.Peer]struct{}),
 		banned:           make(map[string]time.Time),
 		outboundGroups:   make(map[string]int),
+		outboundStreams:  make(map[uint32]int),
 		maxOutboundPeers: maxOutbound,
 	}
 }
@@ -132,7 +146,13 @@ func newPeerState(maxOutbound int) *peerState {
 // server provides a bitmssage server for handling communications to and from
 // bitmessage peers. It satisfies the peer.server and objmgr.server interfaces.
 type server struct {
+	bytesReceived uint64 // from peers which have disconnected; peerHandler only.
+	bytesSent     uint64 // from peers which have disconnected; peerHandler only.
 	nonce         uint64
+	streams       []uint32
+	banFile       string
+	quotaFile     string
+	bandwidth     *peer.Bandwidth
 	listeners     []peer.Listener
 	permanent     []string
 	started       int32 // atomic
@@ -145,11 +165,15 @@ type server struct {
 	donePeers     chan *peer.Peer
 	banPeers      chan *peer.Peer
 	disconPeers   chan *peer.Peer
+	queries       chan interface{}
 	wakeup        chan struct{}
 	wg            sync.WaitGroup
 	quit          chan struct{}
 	db            *database.Db
+	expiry        *database.ExpiryScheduler
+	syncMemory    *peer.SyncMemory
 	rpcServer     *rpcServer
+	metrics       *metricsServer
 	nat           NAT
 }
 
@@ -158,6 +182,22 @@ func (s *server) Nonce() uint64 {
 	return s.nonce
 }
 
+// Streams returns the streams that the server participates in. The first is
+// the stream of the addresses we advertise. Part of the peer.server and
+// objmgr.server interfaces.
+func (s *server) Streams() []uint32 {
+	return s.streams
+}
+
+// BanThreshold returns the ban score above which peers are banned, or zero if
+// banning is disabled. Part of the peer.server interface.
+func (s *server) BanThreshold() uint32 {
+	if cfg.DisableBanning {
+		return 0
+	}
+	return cfg.BanThreshold
+}
+
 // AddrManager returns a pointer to the address manager. Part of the peer.server interface.
 func (s *server) AddrManager() *addrmgr.AddrManager {
 	return s.addrManager
@@ -174,6 +214,12 @@ func (s *server) Db() *database.Db {
 	return s.db
 }
 
+// SyncMemory returns the record of how much inventory has been advertised to
+// recently connected outbound peers. Part of the peer.server interface.
+func (s *server) SyncMemory() *peer.SyncMemory {
+	return s.syncMemory
+}
+
 // DonePeer tells the server that a peer has disconnected and can be removed.
 // Part of the peer.server interface.
 func (s *server) DonePeer(p *peer.Peer) {
@@ -247,6 +293,7 @@ func (s *server) handleAddPeerMsg(p *peer.Peer, retries reconnectionAttempts) bo
 		s.state.peers[p] = struct{}{}
 	} else {
 		s.state.outboundGroups[addrmgr.GroupKey(na)]++
+		s.state.outboundStreams[na.Stream]++
 		if p.Persistent {
 			s.state.persistentPeers[p] = retries
 		} else {
@@ -286,12 +333,15 @@ func (s *server) handleAddPeerMsg(p *peer.Peer, retries reconnectionAttempts) bo
 // invoked from the peerHandler goroutine.
 func (s *server) handleDonePeerMsg(p *peer.Peer) {
 	serverLog.Trace("handleDonePeerMsg for ", p.Addr())
+	s.bytesReceived += p.BytesReceived()
+	s.bytesSent += p.BytesSent()
 	na := p.NetAddress()
 	if p.Persistent {
 		list := s.state.persistentPeers
 		retries := list[p] + 1
 		delete(list, p)
 		s.state.outboundGroups[addrmgr.GroupKey(na)]--
+		s.state.outboundStreams[na.Stream]--
 		peerLog.Info(p.PrependAddr("Removed from server. "), len(list), " persistent peers remain.")
 
 		if !p.Inbound && atomic.LoadInt32(&s.shutdown) == 0 {
@@ -306,6 +356,7 @@ func (s *server) handleDonePeerMsg(p *peer.Peer) {
 	} else {
 		delete(s.state.outboundPeers, p)
 		s.state.outboundGroups[addrmgr.GroupKey(na)]--
+		s.state.outboundStreams[na.Stream]--
 		peerLog.Info(p.PrependAddr("Removed from server. "),
 			len(s.state.outboundPeers), " outbound peers remain.")
 	}
@@ -315,6 +366,23 @@ type getConnCountMsg struct {
 	reply chan int32
 }
 
+type getPeersMsg struct {
+	reply chan []*peer.Peer
+}
+
+// peerTraffic is the number of connected peers and the total number of bytes
+// exchanged with all peers, connected or not, at one moment.
+type peerTraffic struct {
+	inbound       int
+	outbound      int
+	bytesReceived uint64
+	bytesSent     uint64
+}
+
+type getTrafficMsg struct {
+	reply chan peerTraffic
+}
+
 type addNodeMsg struct {
 	addr      string
 	stream    uint32
@@ -327,29 +395,306 @@ type delNodeMsg struct {
 	reply chan error
 }
 
+type disconnectNodeMsg struct {
+	addr  string
+	reply chan error
+}
+
 type getAddedNodesMsg struct {
 	reply chan []*peer.Peer
 }
 
+type banHostMsg struct {
+	host  string
+	until time.Time
+	reply chan error
+}
+
+type unbanHostMsg struct {
+	host  string
+	reply chan error
+}
+
+type getBannedMsg struct {
+	reply chan map[string]time.Time
+}
+
+var (
+	// errServerShutdown is returned by queries to the peer handler which
+	// are made while the server is shutting down.
+	errServerShutdown = errors.New("server is shutting down")
+
+	// errPeerNotFound is returned when a query refers to a peer which is not
+	// connected.
+	errPeerNotFound = errors.New("peer not found")
+
+	// errNotBanned is returned when trying to unban a host which is not
+	// banned.
+	errNotBanned = errors.New("host not banned")
+)
+
+// handleQuery handles queries to the peer handler from other goroutines. It
+// is invoked from the peerHandler goroutine.
+func (s *server) handleQuery(querymsg interface{}) {
+	switch msg := querymsg.(type) {
+	case getConnCountMsg:
+		msg.reply <- int32(s.state.Count())
+
+	case getPeersMsg:
+		peers := make([]*peer.Peer, 0, s.state.Count())
+		s.state.forAllPeers(func(p *peer.Peer) {
+			peers = append(peers, p)
+		})
+		msg.reply <- peers
+
+	// The counters of the peers which are still connected are added to
+	// those of peers which are gone. Both change only on this goroutine,
+	// so that no peer is counted twice or not at all.
+	case getTrafficMsg:
+		traffic := peerTraffic{
+			bytesReceived: s.bytesReceived,
+			bytesSent:     s.bytesSent,
+		}
+		s.state.forAllPeers(func(p *peer.Peer) {
+			if p.Inbound {
+				traffic.inbound++
+			} else {
+				traffic.outbound++
+			}
+			traffic.bytesReceived += p.BytesReceived()
+			traffic.bytesSent += p.BytesSent()
+		})
+		msg.reply <- traffic
+
+	case getAddedNodesMsg:
+		peers := make([]*peer.Peer, 0, len(s.state.persistentPeers))
+		for p := range s.state.persistentPeers {
+			peers = append(peers, p)
+		}
+		msg.reply <- peers
+
+	case addNodeMsg:
+		// XXX(oga) duplicate oneshots?
+		if msg.permanent {
+			for p := range s.state.persistentPeers {
+				if p.Addr().String() == msg.addr {
+					msg.reply <- errors.New("peer already connected")
+					return
+				}
+			}
+		}
+		// TODO(oga) if too many, nuke a non-perm peer.
+		if !s.handleAddPeerMsg(NewOutboundPeer(msg.addr, s, msg.stream, msg.permanent), 0) {
+			msg.reply <- errors.New("failed to add peer")
+			return
+		}
+		msg.reply <- nil
+
+	case delNodeMsg:
+		for p := range s.state.persistentPeers {
+			if p.Addr().String() != msg.addr {
+				continue
+			}
+
+			// Turn the peer into an ordinary outbound peer so that it is not
+			// reconnected to when it is disconnected.
+			delete(s.state.persistentPeers, p)
+			p.Persistent = false
+			s.state.outboundPeers[p] = struct{}{}
+			p.Disconnect()
+
+			msg.reply <- nil
+			return
+		}
+		msg.reply <- errPeerNotFound
+
+	case disconnectNodeMsg:
+		found := false
+		s.state.forAllPeers(func(p *peer.Peer) {
+			if p.Addr().String() == msg.addr {
+				found = true
+				p.Disconnect()
+			}
+		})
+		if !found {
+			msg.reply <- errPeerNotFound
+			return
+		}
+		msg.reply <- nil
+
+	case banHostMsg:
+		s.banHost(msg.host, msg.until)
+		msg.reply <- nil
+
+	case unbanHostMsg:
+		if _, ok := s.state.banned[msg.host]; !ok {
+			msg.reply <- errNotBanned
+			return
+		}
+		delete(s.state.banned, msg.host)
+		s.saveBans()
+		msg.reply <- nil
+
+	case getBannedMsg:
+		now := time.Now()
+		banned := make(map[string]time.Time)
+		for host, banEnd := range s.state.banned {
+			if now.Before(banEnd) {
+				banned[host] = banEnd
+			} else {
+				delete(s.state.banned, host)
+			}
+		}
+		msg.reply <- banned
+	}
+}
+
+// banHost bans the given host until the given time and disconnects any peers
+// connected from it. It is invoked from the peerHandler goroutine.
+func (s *server) banHost(host string, until time.Time) {
+	serverLog.Infof("Banning %s until %s", host, until)
+	s.state.banned[host] = until
+	s.saveBans()
+
+	s.state.forAllPeers(func(p *peer.Peer) {
+		h, _, err := net.SplitHostPort(p.Addr().String())
+		if err == nil && h == host {
+			p.Disconnect()
+		}
+	})
+}
+
+// handleBanPeerMsg deals with banning peers. It is invoked from the
+// peerHandler goroutine.
+func (s *server) handleBanPeerMsg(p *peer.Peer) {
+	host, _, err := net.SplitHostPort(p.Addr().String())
+	if err != nil {
+		serverLog.Debugf("can't split ban peer %s: %v", p.Addr(), err)
+		return
+	}
+	s.banHost(host, time.Now().Add(cfg.BanDuration))
+}
+
+// loadBans loads the saved ban list into the peer state. It is invoked from
+// the peerHandler goroutine.
+func (s *server) loadBans() {
+	banned, err := loadBanList(s.banFile)
+	if err != nil {
+		serverLog.Errorf("Failed to load ban list: %v", err)
+		return
+	}
+	for host, banEnd := range banned {
+		s.state.banned[host] = banEnd
+	}
+	serverLog.Infof("Loaded %d bans from file '%s'", len(banned), s.banFile)
+}
+
+// saveBans saves the ban list so that bans survive a restart. It is invoked
+// from the peerHandler goroutine.
+func (s *server) saveBans() {
+	err := saveBanList(s.banFile, s.state.banned)
+	if err != nil {
+		serverLog.Errorf("Failed to save ban list: %v", err)
+	}
+}
+
+// saveQuota saves the use of the upload quota so that it survives a restart.
+// It is invoked from the peerHandler goroutine.
+func (s *server) saveQuota() {
+	err := saveUploadQuota(s.quotaFile, s.bandwidth.Quota)
+	if err != nil {
+		serverLog.Errorf("Failed to save upload quota: %v", err)
+	}
+}
+
+// query sends a query to the peer handler. It returns false if the server is
+// shutting down, in which case the query will not be answered.
+func (s *server) query(msg interface{}) bool {
+	select {
+	case s.queries <- msg:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
+
 // AddNewPeer adds an ip address to the peer handler and adds permanent connections
 // to the set of persistant peers.
 func (s *server) AddNewPeer(addr string, stream uint32, permanent bool) error {
 	serverLog.Debug("Creating peer at ", addr, ", stream: ", stream)
 
-	// XXX(oga) duplicate oneshots?
-	if permanent {
-		for p := range s.state.persistentPeers {
-			if p.Addr().String() == addr {
-				return errors.New("peer already connected")
-			}
-		}
+	reply := make(chan error, 1)
+	if !s.query(addNodeMsg{addr: addr, stream: stream, permanent: permanent, reply: reply}) {
+		return errServerShutdown
 	}
-	// TODO(oga) if too many, nuke a non-perm peer.
-	if !s.handleAddPeerMsg(NewOutboundPeer(addr, s, stream, permanent), 0) {
-		return errors.New("failed to add peer")
+	return <-reply
+}
+
+// RemovePeer stops treating the persistent peer with the given address as
+// persistent and disconnects from it.
+func (s *server) RemovePeer(addr string) error {
+	reply := make(chan error, 1)
+	if !s.query(delNodeMsg{addr: addr, reply: reply}) {
+		return errServerShutdown
 	}
+	return <-reply
+}
 
-	return nil
+// DisconnectPeerByAddr disconnects from the peer with the given address.
+func (s *server) DisconnectPeerByAddr(addr string) error {
+	reply := make(chan error, 1)
+	if !s.query(disconnectNodeMsg{addr: addr, reply: reply}) {
+		return errServerShutdown
+	}
+	return <-reply
+}
+
+// ConnectedPeers returns all peers known to the server.
+func (s *server) ConnectedPeers() []*peer.Peer {
+	reply := make(chan []*peer.Peer, 1)
+	if !s.query(getPeersMsg{reply: reply}) {
+		return nil
+	}
+	return <-reply
+}
+
+// traffic returns the number of connected peers and the total number of bytes
+// exchanged with peers since bmd was started.
+func (s *server) traffic() peerTraffic {
+	reply := make(chan peerTraffic, 1)
+	if !s.query(getTrafficMsg{reply: reply}) {
+		return peerTraffic{}
+	}
+	return <-reply
+}
+
+// BanHost bans the given host for the given duration and disconnects any
+// peers connected from it.
+func (s *server) BanHost(host string, duration time.Duration) error {
+	reply := make(chan error, 1)
+	if !s.query(banHostMsg{host: host, until: time.Now().Add(duration), reply: reply}) {
+		return errServerShutdown
+	}
+	return <-reply
+}
+
+// UnbanHost lifts the ban on the given host.
+func (s *server) UnbanHost(host string) error {
+	reply := make(chan error, 1)
+	if !s.query(unbanHostMsg{host: host, reply: reply}) {
+		return errServerShutdown
+	}
+	return <-reply
+}
+
+// BannedHosts returns the hosts which are currently banned along with the
+// times at which their bans end.
+func (s *server) BannedHosts() map[string]time.Time {
+	reply := make(chan map[string]time.Time, 1)
+	if !s.query(getBannedMsg{reply: reply}) {
+		return nil
+	}
+	return <-reply
 }
 
 // listenHandler is the main listener which accepts incoming connections for the
@@ -426,6 +771,8 @@ func (s *server) peerHandler(persistentPeers []string) {
 	// them in this handler.
 	s.addrManager.Start()
 	s.objectManager.Start()
+	s.expiry.Start()
+	s.loadBans()
 
 	if cfg.MaxPeers < s.state.maxOutboundPeers {
 		s.state.maxOutboundPeers = cfg.MaxPeers
@@ -439,7 +786,7 @@ func (s *server) peerHandler(persistentPeers []string) {
 	go func(persistentPeers []string) {
 		if persistentPeers != nil {
 			for _, addr := range persistentPeers {
-				s.AddNewPeer(addr, 1, true)
+				s.AddNewPeer(addr, s.streams[0], true)
 			}
 		}
 	}(persistentPeers)
@@ -447,19 +794,30 @@ func (s *server) peerHandler(persistentPeers []string) {
 	// if nothing else happens, wake us up soon.
 	time.AfterFunc(10*time.Second, func() { s.wakeup <- struct{}{} })
 
+	quotaTick := time.NewTicker(quotaSaveInterval)
+
 	for {
 		select {
 		// Shutdown the peer handler.
 		case <-s.quit:
+			quotaTick.Stop()
 			// Shutdown peers.
 			s.state.forAllPeers(func(p *peer.Peer) {
 				p.Disconnect()
 			})
 			s.addrManager.Stop()
+			s.expiry.Stop()
 			s.objectManager.Stop()
+			s.saveBans()
+			s.saveQuota()
 			s.wg.Done()
 			return
 
+		// Save the use of the upload quota now and then so that little
+		// of it is forgotten if bmd does not shut down cleanly.
+		case <-quotaTick.C:
+			s.saveQuota()
+
 		// New peers connected to the server.
 		case p := <-s.newPeers:
 			s.handleAddPeerMsg(p, 0)
@@ -477,6 +835,14 @@ func (s *server) peerHandler(persistentPeers []string) {
 		case p := <-s.disconPeers:
 			p.Disconnect()
 
+		// Ban a peer.
+		case p := <-s.banPeers:
+			s.handleBanPeerMsg(p)
+
+		// Queries from other goroutines, such as the RPC server.
+		case q := <-s.queries:
+			s.handleQuery(q)
+
 		// Used by timers below to wake us back up.
 		case <-s.wakeup:
 			// left intentionally blank
@@ -521,6 +887,24 @@ func (s *server) peerHandler(persistentPeers []string) {
 				break
 			}
 
+			// Only connect to peers in our streams, and don't let one
+			// stream take up all our outbound connections.
+			if !database.InStreams(uint64(na.Stream), s.streams) ||
+				!s.state.NeedMoreOutboundInStream(na.Stream, len(s.streams)) {
+				continue
+			}
+
+			// Skip hidden services if we can't connect to them.
+			if addrmgr.IsOnionCatTor(na) && !cfg.onionReachable() {
+				continue
+			}
+
+			// Prefer hidden services if we are running over tor,
+			// unless we can't find any.
+			if cfg.torOnly() && !addrmgr.IsOnionCatTor(na) && tries < 30 {
+				continue
+			}
+
 			// XXX if we have limited that address skip
 
 			// only allow recent nodes (10mins) after we failed 30
@@ -537,10 +921,7 @@ func (s *server) peerHandler(persistentPeers []string) {
 			tries = 0
 			// any failure will be due to banned peers etc. we have
 			// already checked that we have room for more peers.
-			s.handleAddPeerMsg(
-				// Stream number 1 is hard-coded in here. Will have to handle
-				// this more gracefully when we support streams.
-				NewOutboundPeer(addrStr, s, 1, false), 0)
+			s.handleAddPeerMsg(NewOutboundPeer(addrStr, s, na.Stream, false), 0)
 		}
 
 		// We need more peers, wake up in ten seconds and try again.
@@ -579,7 +960,12 @@ func (s *server) Start() {
 
 	if s.nat != nil {
 		s.wg.Add(1)
-		go s.upnpUpdateThread()
+		go s.natUpdateThread()
+	}
+
+	if cfg.TorControl != "" && len(s.listeners) > 0 {
+		s.wg.Add(1)
+		go s.torControlThread()
 	}
 
 	// Start RPC server.
@@ -587,6 +973,11 @@ func (s *server) Start() {
 		s.wg.Add(1)
 		s.rpcServer.Start()
 	}
+
+	// Start metrics server.
+	if s.metrics != nil {
+		s.metrics.Start()
+	}
 }
 
 // Stop gracefully shuts down the server by stopping and disconnecting all
@@ -618,6 +1009,13 @@ func (s *server) Stop() error {
 		}
 	}
 
+	// Stop metrics server.
+	if s.metrics != nil {
+		if err := s.metrics.Stop(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -626,9 +1024,67 @@ func (s *server) WaitForShutdown() {
 	s.wg.Wait()
 }
 
-// upnpUpdateThread renews the port mapping lease from the router after every
-// 15 minutes. Must be run as a go routine.
-func (s *server) upnpUpdateThread() {
+// torControlThread creates a hidden service for our listener through the Tor
+// control port and advertises it until the server shuts down, when the hidden
+// service is removed again. Must be run as a go routine.
+func (s *server) torControlThread() {
+	defer s.wg.Done()
+
+	// Tor forwards connections to the first listener. If it listens on
+	// every interface, tor reaches it through the loopback interface.
+	addr, ok := s.listeners[0].Addr().(*net.TCPAddr)
+	if !ok {
+		serverLog.Warnf("can't create hidden service for listener %s",
+			s.listeners[0].Addr())
+		return
+	}
+	ip := addr.IP
+	if ip == nil || ip.IsUnspecified() {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	target := net.JoinHostPort(ip.String(), strconv.Itoa(addr.Port))
+
+	c, err := dialTorControl(cfg.TorControl, cfg.TorControlPass)
+	if err != nil {
+		serverLog.Warnf("can't connect to tor control port: %v", err)
+		return
+	}
+	defer c.Close()
+
+	serviceID, err := c.addOnion(defaultPort, target)
+	if err != nil {
+		serverLog.Warnf("can't create hidden service: %v", err)
+		return
+	}
+
+	na, err := s.addrManager.HostToNetAddress(serviceID+".onion",
+		uint16(defaultPort), s.streams[0], wire.SFNodeNetwork)
+	if err == nil {
+		err = s.addrManager.AddLocalAddress(na, addrmgr.ManualPrio)
+	}
+	if err != nil {
+		serverLog.Warnf("can't advertise hidden service %s.onion: %v",
+			serviceID, err)
+		c.delOnion(serviceID)
+		return
+	}
+	serverLog.Infof("Created hidden service %s", addrmgr.NetAddressKey(na))
+
+	<-s.quit
+
+	s.addrManager.RemoveLocalAddress(na)
+	err = c.delOnion(serviceID)
+	if err != nil {
+		serverLog.Warnf("unable to remove hidden service: %v", err)
+	} else {
+		serverLog.Debugf("successfully removed hidden service %s.onion", serviceID)
+	}
+}
+
+// natUpdateThread renews the port mapping lease from the router after every
+// 15 minutes, or sooner if the router granted a shorter lease. Must be run as
+// a go routine.
+func (s *server) natUpdateThread() {
 	// Go off immediately to prevent code duplication, thereafter we renew
 	// lease every 15 minutes.
 	timer := time.NewTimer(0 * time.Second)
@@ -645,26 +1101,32 @@ out:
 			listenPort, err := s.nat.AddPortMapping("tcp", defaultPort,
 				defaultPort, "bmd listen port", 20*60)
 			if err != nil {
-				serverLog.Warnf("can't add UPnP port mapping: %v", err)
+				serverLog.Warnf("can't add port mapping: %v", err)
 			}
 			if first && err == nil {
 				// TODO(oga): look this up periodically to see if upnp domain changed
 				// and so did ip.
 				externalip, err := s.nat.GetExternalAddress()
 				if err != nil {
-					serverLog.Warnf("UPnP can't get external address: %v", err)
+					serverLog.Warnf("can't get external address: %v", err)
 					continue out
 				}
 				na := wire.NewNetAddressIPPort(externalip, uint16(listenPort),
-					1, wire.SFNodeNetwork)
+					s.streams[0], wire.SFNodeNetwork)
 				err = s.addrManager.AddLocalAddress(na, addrmgr.UpnpPrio)
 				if err != nil {
 					// XXX DeletePortMapping?
 				}
-				serverLog.Warnf("Successfully bound via UPnP to %s", addrmgr.NetAddressKey(na))
+				serverLog.Warnf("Successfully bound via port mapping to %s", addrmgr.NetAddressKey(na))
 				first = false
 			}
-			timer.Reset(time.Minute * 15)
+
+			renew := time.Minute * 15
+			if l, ok := s.nat.(leasedNAT); ok && err == nil &&
+				l.Lifetime()/2 < renew && l.Lifetime() > 0 {
+				renew = l.Lifetime() / 2
+			}
+			timer.Reset(renew)
 		case <-s.quit:
 			break out
 		}
@@ -674,9 +1136,9 @@ out:
 
 	err := s.nat.DeletePortMapping("tcp", defaultPort, defaultPort)
 	if err != nil {
-		serverLog.Warnf("unable to remove UPnP port mapping: %v", err)
+		serverLog.Warnf("unable to remove port mapping: %v", err)
 	} else {
-		serverLog.Debugf("succesfully disestablished UPnP port mapping")
+		serverLog.Debugf("succesfully disestablished port mapping")
 	}
 
 	s.wg.Done()
@@ -699,15 +1161,34 @@ func newDefaultServer(listenAddrs []string, db *database.Db, z stats.Stats) (*se
 // newServer returns a new bmd Server configured to listen on addr for the
 // bitmessage network. Use start to begin accepting connections from peers.
 func newServer(listenAddrs []string, db *database.Db,
-	listen func(string, string) (peer.Listener, error), persistentPeers []string, z stats.Stats) (*server, error) {
+	listen func(string, string, int64, int64, *peer.Bandwidth) (peer.Listener, error),
+	persistentPeers []string, z stats.Stats) (*server, error) {
 
 	nonce, err := wire.RandomUint64()
 	if err != nil {
 		return nil, err
 	}
 
+	// The bandwidth limits are shared by all connections, so they must be
+	// set up before any are made.
+	quotaFile := filepath.Join(cfg.DataDir, quotaFilename)
+	// A quota file which cannot be read must not keep bmd from starting.
+	quota, err := loadUploadQuota(quotaFile, uint64(cfg.UploadQuota))
+	if err != nil {
+		serverLog.Warnf("Failed to load upload quota; counting it from "+
+			"zero: %v", err)
+	}
+	bandwidth := &peer.Bandwidth{
+		Up:    peer.NewRateLimit(int64(cfg.MaxUpTotal)),
+		Down:  peer.NewRateLimit(int64(cfg.MaxDownTotal)),
+		Quota: quota,
+	}
+
 	amgr := addrmgr.New(cfg.DataDir, bmdLookup)
 
+	// Addresses we advertise are given the first of our streams.
+	stream := cfg.Streams[0]
+
 	if persistentPeers != nil {
 		for _, node := range persistentPeers {
 			amgr.AddAddressByIP(node)
@@ -722,7 +1203,23 @@ func newServer(listenAddrs []string, db *database.Db,
 			return nil, err
 		}
 		listeners = make([]peer.Listener, 0, len(ipv4Addrs)+len(ipv6Addrs))
-		discover := true
+
+		// Don't give away our ip address if we are running over tor.
+		discover := !cfg.torOnly()
+
+		// Advertise our hidden service.
+		if cfg.ExternalOnion != "" {
+			host, portstr, _ := net.SplitHostPort(cfg.ExternalOnion)
+			port, _ := strconv.ParseUint(portstr, 10, 16)
+			na, err := amgr.HostToNetAddress(host, uint16(port), stream, wire.SFNodeNetwork)
+			if err == nil {
+				err = amgr.AddLocalAddress(na, addrmgr.ManualPrio)
+			}
+			if err != nil {
+				serverLog.Warnf("Not advertising %s as externalonion: %v",
+					cfg.ExternalOnion, err)
+			}
+		}
 
 		if len(cfg.ExternalIPs) != 0 {
 			discover = false
@@ -742,8 +1239,7 @@ func newServer(listenAddrs []string, db *database.Db,
 					}
 					eport = uint16(port)
 				}
-				// Stream 1 is hardcoded here.
-				na, err := amgr.HostToNetAddress(host, eport, 1, wire.SFNodeNetwork)
+				na, err := amgr.HostToNetAddress(host, eport, stream, wire.SFNodeNetwork)
 				if err != nil {
 					serverLog.Warnf("Not adding %s as externalip: %v", sip, err)
 					continue
@@ -755,11 +1251,11 @@ func newServer(listenAddrs []string, db *database.Db,
 				}
 			}
 		} else if discover && cfg.Upnp {
-			nat, err = Discover()
+			nat, err = discoverNAT()
 			if err != nil {
-				serverLog.Warnf("Can't discover upnp: %v", err)
+				serverLog.Warnf("Can't discover UPnP, PCP or NAT-PMP: %v", err)
 			}
-			// nil nat here is fine, just means no upnp on network.
+			// nil nat here is fine, just means no port mapping on network.
 		}
 
 		for _, addr := range ipv4Addrs {
@@ -776,10 +1272,8 @@ func newServer(listenAddrs []string, db *database.Db,
 					if err != nil {
 						continue
 					}
-					// Stream number 1 is hard-coded in here. When we support
-					// streams, this will need to be handled properly.
 					na := wire.NewNetAddressIPPort(ip,
-						uint16(port), 1, wire.SFNodeNetwork)
+						uint16(port), stream, wire.SFNodeNetwork)
 					if discover {
 						err = amgr.AddLocalAddress(na, addrmgr.InterfacePrio)
 						if err != nil {
@@ -789,7 +1283,8 @@ func newServer(listenAddrs []string, db *database.Db,
 				}
 			}
 
-			listener, err := listen("tcp4", addr)
+			listener, err := listen("tcp4", addr, int64(cfg.MaxDownPerPeer),
+				int64(cfg.MaxUpPerPeer), bandwidth)
 			if err != nil {
 				continue
 			}
@@ -797,6 +1292,7 @@ func newServer(listenAddrs []string, db *database.Db,
 
 			if discover {
 				if na, err := amgr.DeserializeNetAddress(addr); err == nil {
+					na.Stream = stream
 					err = amgr.AddLocalAddress(na, addrmgr.BoundPrio)
 					if err != nil {
 						addrmgrLog.Debugf("Skipping bound address: %v", err)
@@ -806,13 +1302,15 @@ func newServer(listenAddrs []string, db *database.Db,
 		}
 
 		for _, addr := range ipv6Addrs {
-			listener, err := listen("tcp6", addr)
+			listener, err := listen("tcp6", addr, int64(cfg.MaxDownPerPeer),
+				int64(cfg.MaxUpPerPeer), bandwidth)
 			if err != nil {
 				continue
 			}
 			listeners = append(listeners, listener)
 			if discover {
 				if na, err := amgr.DeserializeNetAddress(addr); err == nil {
+					na.Stream = stream
 					err = amgr.AddLocalAddress(na, addrmgr.BoundPrio)
 					if err != nil {
 						addrmgrLog.Debugf("Skipping bound address: %v", err)
@@ -828,6 +1326,10 @@ func newServer(listenAddrs []string, db *database.Db,
 
 	s := server{
 		nonce:       nonce,
+		streams:     cfg.Streams,
+		banFile:     filepath.Join(cfg.DataDir, banListFilename),
+		quotaFile:   quotaFile,
+		bandwidth:   bandwidth,
 		listeners:   listeners,
 		permanent:   persistentPeers,
 		addrManager: amgr,
@@ -836,12 +1338,30 @@ func newServer(listenAddrs []string, db *database.Db,
 		donePeers:   make(chan *peer.Peer, cfg.MaxPeers),
 		banPeers:    make(chan *peer.Peer, cfg.MaxPeers),
 		disconPeers: make(chan *peer.Peer, cfg.MaxPeers),
+		queries:     make(chan interface{}),
 		wakeup:      make(chan struct{}),
 		quit:        make(chan struct{}),
 		db:          db,
+		syncMemory:  peer.NewSyncMemory(),
 		nat:         nat,
 	}
-	s.objectManager = objmgr.NewObjectManager(&s, s.db, cfg.RequestExpire, cfg.CleanupInterval, z)
+
+	// The expiry scheduler must be created before anything inserts objects
+	// into the database so that no object misses being scheduled.
+	s.expiry, err = database.NewExpiryScheduler(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	outbox, err := objmgr.NewOutbox(cfg.OutboxConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	s.objectManager = objmgr.NewObjectManager(&s, s.db,
+		objmgr.NewPolicy(cfg.PolicyConfig()), outbox, cfg.RequestExpire,
+		cfg.VerifyWorkers, z)
+	s.expiry.Subscribe(s.objectManager.ExpireObject)
 
 	if cfg.EnableRPC {
 		s.rpcServer, err = newRPCServer(&s, cfg.RPCConfig())
@@ -850,5 +1370,12 @@ func newServer(listenAddrs []string, db *database.Db,
 		}
 	}
 
+	if cfg.MetricsListen != "" {
+		s.metrics, err = newMetricsServer(&s, cfg.MetricsListen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &s, nil
 }
@@ -63,10 +63,10 @@ func NewMockListener(localAddr net.Addr, incoming chan peer.Connection, disconne
 }
 
 // MockListen returns a mock listener
-func MockListen(listeners []*MockListener) func(string, string) (peer.Listener, error) {
+func MockListen(listeners []*MockListener) func(string, string, int64, int64, *peer.Bandwidth) (peer.Listener, error) {
 	i := 0
 
-	return func(service, addr string) (peer.Listener, error) {
+	return func(service, addr string, maxDown, maxUp int64, bw *peer.Bandwidth) (peer.Listener, error) {
 		i++
 		if i > len(listeners) {
 			return nil, errors.New("No mock listeners remaining.")
@@ -0,0 +1,204 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// torControlTimeout is how long we wait for the Tor control port to
+	// respond to a command.
+	torControlTimeout = 30 * time.Second
+)
+
+var (
+	// ErrTorControlNoAuth indicates that the Tor control port does not
+	// offer an authentication method that we can use.
+	ErrTorControlNoAuth = errors.New("no usable tor control authentication method")
+
+	// ErrTorControlInvalidResponse indicates that the Tor control port
+	// returned a reply in an unexpected format.
+	ErrTorControlInvalidResponse = errors.New("invalid tor control response")
+)
+
+// torController is a connection to the control port of a Tor node, which is
+// used to create a hidden service for our listener.
+type torController struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// command sends a command to the control port and returns the lines of the
+// reply with the status codes removed. An error is returned if the reply
+// does not have status 250.
+func (c *torController) command(cmd string) ([]string, error) {
+	c.conn.SetDeadline(time.Now().Add(torControlTimeout))
+
+	_, err := c.conn.Write([]byte(cmd + "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, ErrTorControlInvalidResponse
+		}
+
+		status, sep, text := line[:3], line[3], line[4:]
+		if status != "250" {
+			return nil, fmt.Errorf("tor control: %s", line)
+		}
+
+		switch sep {
+		case ' ':
+			return append(lines, text), nil
+		case '-':
+			lines = append(lines, text)
+		case '+':
+			// The data follows on separate lines, ending with a
+			// line containing only a period.
+			for {
+				data, err := c.readLine()
+				if err != nil {
+					return nil, err
+				}
+				if data == "." {
+					break
+				}
+				text += "\n" + data
+			}
+			lines = append(lines, text)
+		default:
+			return nil, ErrTorControlInvalidResponse
+		}
+	}
+}
+
+// readLine reads a line from the control port without the line ending.
+func (c *torController) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// authenticate authenticates with the control port using the password if one
+// is given, and otherwise using the cookie file or no authentication,
+// whichever the control port allows.
+func (c *torController) authenticate(password string) error {
+	lines, err := c.command("PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+
+	methods := make(map[string]struct{})
+	var cookieFile string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+
+		for _, field := range strings.Fields(line[5:]) {
+			switch {
+			case strings.HasPrefix(field, "METHODS="):
+				for _, m := range strings.Split(field[8:], ",") {
+					methods[m] = struct{}{}
+				}
+			case strings.HasPrefix(field, "COOKIEFILE="):
+				cookieFile, err = strconv.Unquote(field[11:])
+				if err != nil {
+					return ErrTorControlInvalidResponse
+				}
+			}
+		}
+	}
+
+	var auth string
+	if _, ok := methods["HASHEDPASSWORD"]; ok && password != "" {
+		auth = strconv.Quote(password)
+	} else if _, ok := methods["COOKIE"]; ok && cookieFile != "" {
+		cookie, err := ioutil.ReadFile(cookieFile)
+		if err != nil {
+			return err
+		}
+		auth = hex.EncodeToString(cookie)
+	} else if _, ok := methods["NULL"]; !ok {
+		return ErrTorControlNoAuth
+	}
+
+	_, err = c.command(strings.TrimSpace("AUTHENTICATE " + auth))
+	return err
+}
+
+// addOnion creates a hidden service which forwards the given virtual port to
+// the target address and returns its service id, which is the onion address
+// without the ".onion". The service lasts until it is deleted or the
+// connection to the control port is closed.
+//
+// Only version 2 onion addresses fit in a bitmessage address message, so
+// an RSA1024 key is requested. Versions of Tor which no longer support
+// version 2 hidden services will refuse.
+func (c *torController) addOnion(port int, target string) (string, error) {
+	lines, err := c.command(fmt.Sprintf(
+		"ADD_ONION NEW:RSA1024 Flags=DiscardPK Port=%d,%s", port, target))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "ServiceID=") {
+			return line[10:], nil
+		}
+	}
+	return "", ErrTorControlInvalidResponse
+}
+
+// delOnion removes a hidden service created with addOnion.
+func (c *torController) delOnion(serviceID string) error {
+	_, err := c.command("DEL_ONION " + serviceID)
+	return err
+}
+
+// Close closes the connection to the control port.
+func (c *torController) Close() error {
+	return c.conn.Close()
+}
+
+// dialTorControl connects to the Tor control port at the given address and
+// authenticates.
+func dialTorControl(addr, password string) (*torController, error) {
+	conn, err := net.DialTimeout("tcp", addr, torControlTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &torController{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	err = c.authenticate(password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
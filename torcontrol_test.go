@@ -0,0 +1,170 @@
+// Copyright 2016 Daniel Krawisz.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeTorControl runs a fake tor control port which accepts one connection
+// and answers each command with the reply returned by answer. The commands
+// received are sent over the returned channel, which is closed when the
+// connection ends.
+func fakeTorControl(t *testing.T, answer func(cmd string) string) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := make(chan string, 10)
+	go func() {
+		defer close(commands)
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimRight(line, "\r\n")
+			commands <- cmd
+			conn.Write([]byte(answer(cmd)))
+		}
+	}()
+
+	return l.Addr().String(), commands
+}
+
+// torControlAnswer returns a function which answers commands like a tor
+// control port that offers the given authentication methods and accepts the
+// given AUTHENTICATE command.
+func torControlAnswer(methods, cookieFile, auth string) func(string) string {
+	return func(cmd string) string {
+		switch {
+		case cmd == "PROTOCOLINFO 1":
+			reply := "250-PROTOCOLINFO 1\r\n250-AUTH METHODS=" + methods
+			if cookieFile != "" {
+				reply += " COOKIEFILE=" + strconv.Quote(cookieFile)
+			}
+			return reply + "\r\n250-VERSION Tor=\"0.2.9.10\"\r\n250 OK\r\n"
+		case strings.HasPrefix(cmd, "AUTHENTICATE"):
+			if cmd != auth {
+				return "515 Authentication failed\r\n"
+			}
+			return "250 OK\r\n"
+		case strings.HasPrefix(cmd, "ADD_ONION "):
+			return "250-ServiceID=abcdefghijklmnop\r\n250 OK\r\n"
+		case strings.HasPrefix(cmd, "DEL_ONION "):
+			return "250 OK\r\n"
+		default:
+			return "510 Unrecognized command\r\n"
+		}
+	}
+}
+
+func TestTorControlPassword(t *testing.T) {
+	addr, commands := fakeTorControl(t, torControlAnswer(
+		"COOKIE,HASHEDPASSWORD", "", `AUTHENTICATE "secret"`))
+
+	c, err := dialTorControl(addr, "secret")
+	if err != nil {
+		t.Fatalf("dialTorControl: unexpected error %v", err)
+	}
+
+	serviceID, err := c.addOnion(8444, "127.0.0.1:8445")
+	if err != nil {
+		t.Fatalf("addOnion: unexpected error %v", err)
+	}
+	if serviceID != "abcdefghijklmnop" {
+		t.Errorf("addOnion: expected service id abcdefghijklmnop, got %s", serviceID)
+	}
+
+	if err = c.delOnion(serviceID); err != nil {
+		t.Errorf("delOnion: unexpected error %v", err)
+	}
+	c.Close()
+
+	expected := []string{
+		"PROTOCOLINFO 1",
+		`AUTHENTICATE "secret"`,
+		"ADD_ONION NEW:RSA1024 Flags=DiscardPK Port=8444,127.0.0.1:8445",
+		"DEL_ONION abcdefghijklmnop",
+	}
+	i := 0
+	for cmd := range commands {
+		if i >= len(expected) {
+			t.Errorf("unexpected command %s", cmd)
+			continue
+		}
+		if cmd != expected[i] {
+			t.Errorf("command %d: expected %s, got %s", i, expected[i], cmd)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("expected %d commands, got %d", len(expected), i)
+	}
+}
+
+func TestTorControlCookie(t *testing.T) {
+	f, err := ioutil.TempFile("", "control_auth_cookie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte{0xde, 0xad, 0xbe, 0xef})
+	f.Close()
+
+	addr, _ := fakeTorControl(t, torControlAnswer(
+		"COOKIE,SAFECOOKIE", f.Name(), "AUTHENTICATE deadbeef"))
+
+	c, err := dialTorControl(addr, "")
+	if err != nil {
+		t.Fatalf("dialTorControl: unexpected error %v", err)
+	}
+	c.Close()
+}
+
+func TestTorControlAuthFailure(t *testing.T) {
+	tests := []struct {
+		methods  string
+		password string
+		err      error
+	}{
+		// Wrong password.
+		{"HASHEDPASSWORD", "wrong", nil},
+		// No password given.
+		{"HASHEDPASSWORD", "", ErrTorControlNoAuth},
+		// Only unsupported methods.
+		{"SAFECOOKIE", "", ErrTorControlNoAuth},
+	}
+
+	for i, test := range tests {
+		addr, _ := fakeTorControl(t, torControlAnswer(test.methods, "",
+			`AUTHENTICATE "secret"`))
+
+		_, err := dialTorControl(addr, test.password)
+		if err == nil {
+			t.Errorf("test %d: expected error", i)
+			continue
+		}
+		if test.err != nil && err != test.err {
+			t.Errorf("test %d: expected error %v, got %v", i, test.err, err)
+		}
+	}
+}